@@ -0,0 +1,275 @@
+package ChainService
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// ledger actions
+const (
+	ActionUpload  = "upload"
+	ActionSell    = "sell"
+	ActionStop    = "stop"
+	ActionPrice   = "price"
+	ActionConfirm = "confirm"
+	ActionCancel  = "cancel"
+)
+
+// Block is one record of the ledger. Every block keeps the hash of the previous one,
+// so any change of the history breaks the chain.
+type Block struct {
+	Index       int64     `json:"index"`
+	ChainId     string    `json:"chainId"`
+	Action      string    `json:"action"`
+	Buyer       string    `json:"buyer"`
+	Price       string    `json:"price"`
+	ProductType int       `json:"productType"`
+	Time        time.Time `json:"time"`
+	PrevHash    string    `json:"prevHash"`
+	Hash        string    `json:"hash"`
+}
+
+// the results which the listener fails to apply are delivered again after the delay, which is doubled after
+// each failure up to the max delay
+const (
+	retryDelay    = 10 * time.Second
+	maxRetryDelay = time.Hour
+)
+
+// LocalLedger is an append-only, hash-linked ledger
+type LocalLedger struct {
+	Blocks        BlockRepository
+	Notifications NotificationRepository
+	Logger        log.Logger
+	mutex         sync.Mutex
+	listener      Listener
+}
+
+// NewLocalLedger create the ledger on the block repository, the results are kept in the notification repository
+// until the listener accepts them
+func NewLocalLedger(blocks BlockRepository, notifications NotificationRepository, logger log.Logger) *LocalLedger {
+	return &LocalLedger{Blocks: blocks, Notifications: notifications, Logger: logger}
+}
+
+// SetListener set the receiver of the transaction results
+func (l *LocalLedger) SetListener(listener Listener) {
+	l.listener = listener
+}
+
+func (l *LocalLedger) UploadProduct(productId string) (string, error) {
+	chainId := NSUtil.UniqueID()
+	_, err := l.append(Block{ChainId: chainId, Action: ActionUpload})
+	return chainId, err
+}
+
+func (l *LocalLedger) StartToSell(chainId string, price string, productType int) error {
+	_, err := l.append(Block{ChainId: chainId, Action: ActionSell, Price: price, ProductType: productType})
+	return err
+}
+
+func (l *LocalLedger) StopSelling(chainId string) error {
+	_, err := l.append(Block{ChainId: chainId, Action: ActionStop})
+	return err
+}
+
+func (l *LocalLedger) ConfirmOrder(chainId string) error {
+	block, err := l.append(Block{ChainId: chainId, Action: ActionConfirm})
+	if err != nil {
+		return err
+	}
+
+	l.notify(block)
+	return nil
+}
+
+func (l *LocalLedger) CancelOrder(chainId string) error {
+	block, err := l.append(Block{ChainId: chainId, Action: ActionCancel})
+	if err != nil {
+		return err
+	}
+
+	l.notify(block)
+	return nil
+}
+
+func (l *LocalLedger) UpdatePrice(chainId string, buyer string, price string) error {
+	_, err := l.append(Block{ChainId: chainId, Action: ActionPrice, Buyer: buyer, Price: price})
+	return err
+}
+
+// notify report the result to the listener in background, like a real chain does.
+// The block hash is used as the event id.
+func (l *LocalLedger) notify(block Block) {
+	notification := Notification{ID: block.Hash, ChainId: block.ChainId, Action: block.Action,
+		Next: time.Now().Add(retryDelay)}
+	err := l.Notifications.Insert(notification)
+	if err != nil {
+		level.Error(l.Logger).Log("API", "Notifications.Insert", "ChainId", block.ChainId, "Info", err)
+	}
+
+	go l.deliver(notification)
+}
+
+// Start deliver the failed results again in background until the context is done
+func (l *LocalLedger) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(retryDelay)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.Redeliver()
+			}
+		}
+	}()
+}
+
+// Redeliver deliver the due results again, and return how many of them are accepted
+func (l *LocalLedger) Redeliver() int {
+	notifications, err := l.Notifications.FindDue(time.Now())
+	if err != nil {
+		level.Error(l.Logger).Log("API", "Notifications.FindDue", "Info", err)
+		return 0
+	}
+
+	delivered := 0
+	for _, notification := range notifications {
+		if l.deliver(notification) {
+			delivered++
+		}
+	}
+
+	return delivered
+}
+
+// deliver report the result to the listener. The result is removed once it's accepted, otherwise it's
+// delivered again later, and the listener drops the result if it has been applied.
+func (l *LocalLedger) deliver(notification Notification) bool {
+	listener := l.listener
+	if listener == nil {
+		return false
+	}
+
+	var err error
+	if notification.Action == ActionConfirm {
+		err = listener.ApplyConfirmFromChain(notification.ChainId, notification.ID, "success")
+	} else {
+		err = listener.ApplyCancelFromChain(notification.ChainId, notification.ID, "success")
+	}
+
+	if err == nil {
+		err = l.Notifications.Remove(notification.ID)
+		if err != nil && err != NSUtil.ErrNotFound {
+			level.Error(l.Logger).Log("API", "Notifications.Remove", "ChainId", notification.ChainId, "Info", err)
+		}
+		return true
+	}
+
+	attempts := notification.Attempts + 1
+	level.Error(l.Logger).Log("API", "Listener", "Action", notification.Action, "ChainId", notification.ChainId,
+		"Attempts", attempts, "Info", err)
+
+	err = l.Notifications.Retry(notification.ID, attempts, time.Now().Add(redeliveryDelay(attempts)))
+	if err != nil && err != NSUtil.ErrNotFound {
+		level.Error(l.Logger).Log("API", "Notifications.Retry", "ChainId", notification.ChainId, "Info", err)
+	}
+	return false
+}
+
+// redeliveryDelay return the delay before the next delivery after the failed attempts
+func redeliveryDelay(attempts int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// append link the block to the last one. The repository rejects the block if another
+// server has appended in between, and the block is linked again.
+func (l *LocalLedger) append(block Block) (Block, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for retry := 0; retry < 5; retry++ {
+		last, err := l.Blocks.Last()
+		if err != nil && err != NSUtil.ErrNotFound {
+			level.Error(l.Logger).Log("API", "Blocks.Last", "Info", err)
+			return block, err
+		}
+
+		block.Index = last.Index + 1
+		block.PrevHash = last.Hash
+		block.Time = time.Now().UTC().Truncate(time.Millisecond)
+		block.Hash = blockHash(block)
+
+		err = l.Blocks.Insert(block)
+		if err == nil {
+			return block, nil
+		}
+
+		if err != NSUtil.ErrDuplicated {
+			level.Error(l.Logger).Log("API", "Blocks.Insert", "Info", err)
+			return block, err
+		}
+	}
+
+	return block, errors.New("ledger is busy")
+}
+
+// History return all the blocks of a chain id
+func (l *LocalLedger) History(chainId string) ([]Block, error) {
+	return l.Blocks.FindByChainId(chainId)
+}
+
+// Verify check the whole ledger hasn't been changed
+func (l *LocalLedger) Verify() error {
+	blocks, err := l.Blocks.FindAll()
+	if err != nil {
+		return err
+	}
+
+	return verifyBlocks(blocks)
+}
+
+func verifyBlocks(blocks []Block) error {
+	prevHash := ""
+	for i, block := range blocks {
+		if block.Index != int64(i+1) {
+			return errors.New("block " + strconv.Itoa(i+1) + " is missing")
+		}
+
+		if block.PrevHash != prevHash || block.Hash != blockHash(block) {
+			return errors.New("block " + strconv.Itoa(i+1) + " has been changed")
+		}
+
+		prevHash = block.Hash
+	}
+
+	return nil
+}
+
+func blockHash(block Block) string {
+	h := sha256.New()
+	h.Write([]byte(strconv.FormatInt(block.Index, 10) + "|" + block.ChainId + "|" + block.Action + "|" +
+		block.Buyer + "|" + block.Price + "|" + strconv.Itoa(block.ProductType) + "|" +
+		block.Time.UTC().Format(time.RFC3339Nano) + "|" + block.PrevHash))
+	return hex.EncodeToString(h.Sum(nil))
+}
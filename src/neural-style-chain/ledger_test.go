@@ -0,0 +1,103 @@
+package ChainService
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestVerifyBlocks(t *testing.T) {
+	var blocks []Block
+	prevHash := ""
+	for i, action := range []string{ActionUpload, ActionSell, ActionConfirm} {
+		block := Block{Index: int64(i + 1), ChainId: "chain", Action: action, PrevHash: prevHash,
+			Time: time.Date(2018, 5, 1, 0, i, 0, 0, time.UTC)}
+		block.Hash = blockHash(block)
+		prevHash = block.Hash
+		blocks = append(blocks, block)
+	}
+
+	if err := verifyBlocks(blocks); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	blocks[1].Price = "100"
+	if err := verifyBlocks(blocks); err == nil {
+		t.Errorf("expected the changed block to be detected")
+	}
+
+	blocks[1].Price = ""
+	if err := verifyBlocks(append(blocks[:1], blocks[2])); err == nil {
+		t.Errorf("expected the missing block to be detected")
+	}
+}
+
+// flakyListener fail the first results it receives
+type flakyListener struct {
+	mutex    sync.Mutex
+	failures int
+	applied  []string
+}
+
+func (l *flakyListener) ApplyConfirmFromChain(chainId string, eventId string, result string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.failures > 0 {
+		l.failures--
+		return errors.New("the order is busy")
+	}
+
+	l.applied = append(l.applied, eventId)
+	return nil
+}
+
+func (l *flakyListener) ApplyCancelFromChain(chainId string, eventId string, result string) error {
+	return l.ApplyConfirmFromChain(chainId, eventId, result)
+}
+
+func TestLedgerDeliversFailedResultAgain(t *testing.T) {
+	notifications := NewMemoryNotificationRepository()
+	ledger := NewLocalLedger(NewMemoryBlockRepository(), notifications, log.NewNopLogger())
+	listener := &flakyListener{failures: 1}
+	ledger.SetListener(listener)
+
+	if err := ledger.ConfirmOrder("chain"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first delivery fails, and the next one is pushed back
+	var pending []Notification
+	for i := 0; i < 100 && (len(pending) == 0 || pending[0].Attempts == 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+		pending, _ = notifications.FindDue(time.Now().Add(maxRetryDelay))
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 || !pending[0].Next.After(time.Now()) {
+		t.Fatalf("unexpected pending results %+v", pending)
+	}
+
+	if delivered := ledger.Redeliver(); delivered != 0 {
+		t.Errorf("expected the result to wait for the delay, got %d delivered", delivered)
+	}
+
+	notifications.Retry(pending[0].ID, pending[0].Attempts, time.Now())
+	if delivered := ledger.Redeliver(); delivered != 1 || len(listener.applied) != 1 {
+		t.Fatalf("expected the result delivered again, got %d delivered", delivered)
+	}
+
+	if due, _ := notifications.FindDue(time.Now().Add(maxRetryDelay)); len(due) != 0 {
+		t.Errorf("expected the accepted result removed, got %+v", due)
+	}
+}
+
+func TestRedeliveryDelay(t *testing.T) {
+	for attempts, delay := range map[int]time.Duration{1: retryDelay, 2: 2 * retryDelay, 3: 4 * retryDelay,
+		100: maxRetryDelay} {
+		if got := redeliveryDelay(attempts); got != delay {
+			t.Errorf("attempts %d: delay %v, expected %v", attempts, got, delay)
+		}
+	}
+}
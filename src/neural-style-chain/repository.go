@@ -0,0 +1,266 @@
+package ChainService
+
+import (
+	"sync"
+	"time"
+
+	"neural-style-util"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BlockRepository define the data access of the ledger blocks
+type BlockRepository interface {
+	// Last return NSUtil.ErrNotFound for an empty ledger
+	Last() (Block, error)
+	// Insert return NSUtil.ErrDuplicated if the block index exists
+	Insert(block Block) error
+	// FindByChainId return the blocks of a chain id by index
+	FindByChainId(chainId string) ([]Block, error)
+	// FindAll return all the blocks by index
+	FindAll() ([]Block, error)
+}
+
+type mgoBlockRepository struct {
+	session *mgo.Session
+}
+
+// NewMongoBlockRepository create the block repository on the "ledger" collection
+func NewMongoBlockRepository(session *mgo.Session) BlockRepository {
+	return &mgoBlockRepository{session: session}
+}
+
+func (repo *mgoBlockRepository) Last() (Block, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var last Block
+	err := session.DB("store").C("ledger").Find(bson.M{}).Sort("-index").One(&last)
+	if err == mgo.ErrNotFound {
+		return last, NSUtil.ErrNotFound
+	}
+
+	return last, err
+}
+
+// Insert rely on the unique index on "index"
+func (repo *mgoBlockRepository) Insert(block Block) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("ledger").Insert(block)
+	if mgo.IsDup(err) {
+		return NSUtil.ErrDuplicated
+	}
+
+	return err
+}
+
+func (repo *mgoBlockRepository) FindByChainId(chainId string) ([]Block, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var blocks []Block
+	err := session.DB("store").C("ledger").Find(bson.M{"chainid": chainId}).Sort("index").All(&blocks)
+	return blocks, err
+}
+
+func (repo *mgoBlockRepository) FindAll() ([]Block, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var blocks []Block
+	err := session.DB("store").C("ledger").Find(bson.M{}).Sort("index").All(&blocks)
+	return blocks, err
+}
+
+type memoryBlockRepository struct {
+	mutex  sync.RWMutex
+	blocks []Block
+}
+
+// NewMemoryBlockRepository create the block repository in memory
+func NewMemoryBlockRepository() BlockRepository {
+	return &memoryBlockRepository{}
+}
+
+func (repo *memoryBlockRepository) Last() (Block, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	if len(repo.blocks) == 0 {
+		return Block{}, NSUtil.ErrNotFound
+	}
+
+	return repo.blocks[len(repo.blocks)-1], nil
+}
+
+func (repo *memoryBlockRepository) Insert(block Block) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if block.Index != int64(len(repo.blocks)+1) {
+		return NSUtil.ErrDuplicated
+	}
+
+	repo.blocks = append(repo.blocks, block)
+	return nil
+}
+
+func (repo *memoryBlockRepository) FindByChainId(chainId string) ([]Block, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var blocks []Block
+	for _, block := range repo.blocks {
+		if block.ChainId == chainId {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks, nil
+}
+
+func (repo *memoryBlockRepository) FindAll() ([]Block, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	return append([]Block(nil), repo.blocks...), nil
+}
+
+// Notification is a transaction result which the listener hasn't accepted yet. The id is the hash of the block,
+// which is the event id of the result.
+type Notification struct {
+	ID       string    `bson:"_id"`
+	ChainId  string    `bson:"chainid"`
+	Action   string    `bson:"action"`
+	Attempts int       `bson:"attempts"`
+	Next     time.Time `bson:"next"`
+}
+
+// NotificationRepository keep the results until the listener accepts them, so they are delivered again after
+// a failure or a restart
+type NotificationRepository interface {
+	// Insert keep the new result
+	Insert(notification Notification) error
+	// FindDue return the results to deliver again at the time
+	FindDue(now time.Time) ([]Notification, error)
+	// Retry push back the next delivery of the failed result
+	Retry(id string, attempts int, next time.Time) error
+	// Remove drop the accepted result
+	Remove(id string) error
+}
+
+type mgoNotificationRepository struct {
+	session *mgo.Session
+}
+
+// NewMongoNotificationRepository create the notification repository on the "chain_notifications" collection
+func NewMongoNotificationRepository(session *mgo.Session) NotificationRepository {
+	return &mgoNotificationRepository{session: session}
+}
+
+func (repo *mgoNotificationRepository) Insert(notification Notification) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("chain_notifications").Insert(notification)
+}
+
+func (repo *mgoNotificationRepository) FindDue(now time.Time) ([]Notification, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var notifications []Notification
+	err := session.DB("store").C("chain_notifications").Find(bson.M{"next": bson.M{"$lte": now}}).All(&notifications)
+	return notifications, err
+}
+
+func (repo *mgoNotificationRepository) Retry(id string, attempts int, next time.Time) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("chain_notifications").UpdateId(id,
+		bson.M{"$set": bson.M{"attempts": attempts, "next": next}})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoNotificationRepository) Remove(id string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("chain_notifications").RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+type memoryNotificationRepository struct {
+	mutex         sync.Mutex
+	notifications map[string]Notification
+}
+
+// NewMemoryNotificationRepository create the notification repository in memory
+func NewMemoryNotificationRepository() NotificationRepository {
+	return &memoryNotificationRepository{notifications: make(map[string]Notification)}
+}
+
+func (repo *memoryNotificationRepository) Insert(notification Notification) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.notifications[notification.ID]; ok {
+		return NSUtil.ErrDuplicated
+	}
+
+	repo.notifications[notification.ID] = notification
+	return nil
+}
+
+func (repo *memoryNotificationRepository) FindDue(now time.Time) ([]Notification, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	var notifications []Notification
+	for _, notification := range repo.notifications {
+		if !notification.Next.After(now) {
+			notifications = append(notifications, notification)
+		}
+	}
+
+	return notifications, nil
+}
+
+func (repo *memoryNotificationRepository) Retry(id string, attempts int, next time.Time) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	notification, ok := repo.notifications[id]
+	if !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	notification.Attempts = attempts
+	notification.Next = next
+	repo.notifications[id] = notification
+	return nil
+}
+
+func (repo *memoryNotificationRepository) Remove(id string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.notifications[id]; !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	delete(repo.notifications, id)
+	return nil
+}
@@ -1,27 +1,52 @@
 package ChainService
 
-// return chain id
-func UploadProduct(productId string) (string, error) {
+// Listener receive the transaction results from the chain. The event id is unique for
+// each result, so the listener can drop the redelivered ones.
+type Listener interface {
+	ApplyConfirmFromChain(chainId string, eventId string, result string) error
+	ApplyCancelFromChain(chainId string, eventId string, result string) error
+}
+
+// Chain define the operations of an order on the block chain. The results of ConfirmOrder
+// and CancelOrder are reported to the listener asynchronously.
+type Chain interface {
+	// return chain id
+	UploadProduct(productId string) (string, error)
+	StartToSell(chainId string, price string, productType int) error
+	StopSelling(chainId string) error
+	ConfirmOrder(chainId string) error
+	CancelOrder(chainId string) error
+	// for auction
+	UpdatePrice(chainId string, buyer string, price string) error
+	SetListener(listener Listener)
+}
+
+// NoopChain accept all the operations without recording them. The results have to be sent
+// back by the chain callback APIs of the order service.
+type NoopChain struct{}
+
+func (NoopChain) UploadProduct(productId string) (string, error) {
 	return "", nil
 }
 
-func StartToSell(chainId string, price string, prodcutType int) (error) {
+func (NoopChain) StartToSell(chainId string, price string, productType int) error {
 	return nil
 }
 
-func StopSelling(chainId string) (error) {
+func (NoopChain) StopSelling(chainId string) error {
 	return nil
 }
 
-func ConfirmOrder(chainId string) (error) {
+func (NoopChain) ConfirmOrder(chainId string) error {
 	return nil
 }
 
-func CancelOrder(chainId string) (error) {
+func (NoopChain) CancelOrder(chainId string) error {
 	return nil
 }
 
-// for auction
-func UpdatePrice(chainId string, buyer string, price string) (error) {
+func (NoopChain) UpdatePrice(chainId string, buyer string, price string) error {
 	return nil
-}
\ No newline at end of file
+}
+
+func (NoopChain) SetListener(listener Listener) {}
@@ -12,6 +12,9 @@ import (
 	"syscall"
 	"time"
 
+	"neural-style-user"
+	"neural-style-util"
+
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/rs/cors"
@@ -29,6 +32,8 @@ var (
 	storageServerPort       = flag.String("storagePort", "5000", "Storage Server Port")
 	storageServerSaveRouter = flag.String("saveRouter", "/api/v1/storage/save", "URL router for save")
 	storageServerFindRouter = flag.String("findRouter", "/api/v1/storage/find", "URL router for find")
+	storageDeleteRouter     = flag.String("deleteRouter", "/api/v1/storage", "URL router for delete")
+	storageUploadRouter     = flag.String("uploadRouter", "/api/v1/storage/uploads", "URL router for resumable uploads")
 	cacheServer             = flag.String("cacheHost", "www.elforce.net", "memcached host")
 	cacheGetRouter          = flag.String("cacheGetURL", "/api/v1/cache/get", "Cache Get Router")
 	localDev                = flag.Bool("local", false, "Disable Cloud Storage and local Memcached")
@@ -36,6 +41,24 @@ var (
 	previewNetworkPath      = flag.String("previewNetwork", "", "neural network preview model path")
 	outputPath              = flag.String("outputdir", "./", "neural style transfer output directory")
 	productsRouter          = flag.String("productsRouter", "/api/products", "URL router for products")
+	duplicateDistance       = flag.Int("duplicateDistance", 10, "max dHash distance of the similar style pictures")
+	duplicateAction         = flag.String("duplicates", "reject", "similar style pictures: reject, flag or off")
+	bidIncrement            = flag.Float64("bidIncrement", 1, "minimal increment between two auction bids")
+	dueInterval             = flag.Duration("dueInterval", time.Minute, "interval for scanning the expired orders")
+	chainType               = flag.String("chain", "ledger", "chain for orders: ledger or none")
+	storeType               = flag.String("store", "mongo", "data store: mongo or memory")
+	transferBackend         = flag.String("transferBackend", "python", "style transfer backend: python, remote or filter")
+	transferServer          = flag.String("transferServer", "http://0.0.0.0:9090", "transfer server url for the remote backend")
+	transferWorkers         = flag.Int("transferWorkers", 2, "concurrent style transfer jobs")
+	transferQueue           = flag.Int("transferQueue", 100, "max waiting style transfer jobs")
+	accessTokenTTL          = flag.Duration("accessTokenTTL", UserService.DefaultAccessTTL, "lifetime of the access tokens")
+	refreshTokenTTL         = flag.Duration("refreshTokenTTL", UserService.DefaultRefreshTTL, "lifetime of the refresh tokens")
+	keyDir                  = flag.String("keyDir", "", "directory of the RS256 or ES256 token keys, the tokens are signed by HS256 with TOKEN_KEY if it's empty")
+	keyAlg                  = flag.String("keyAlg", "ES256", "algorithm of the generated token keys: RS256 or ES256")
+	keyRotation             = flag.Duration("keyRotation", 0, "interval of generating a new token key, 0 disables the rotation")
+	keyRetention            = flag.Duration("keyRetention", 24*time.Hour, "the retired token keys verify the tokens for this long")
+	passwordMinLength       = flag.Int("passwordMinLength", 8, "min characters of the new passwords")
+	passwordClasses         = flag.String("passwordClasses", "letter,digit", "character classes of the new passwords: letter, upper, digit and symbol")
 )
 
 func ensureIndex(s *mgo.Session) {
@@ -68,14 +91,128 @@ func ensureIndex(s *mgo.Session) {
 	if err != nil {
 		panic(err)
 	}
-}
 
-func main() {
-	flag.Parse()
+	bids := session.DB("store").C("bids")
+	index = mgo.Index{
+		Key:        []string{"orderid"},
+		Unique:     false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = bids.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
 
-	ctx := context.Background()
-	errChan := make(chan error)
+	orderDue := session.DB("store").C("orderdue")
+	index = mgo.Index{
+		Key:        []string{"orderid"},
+		Unique:     true,
+		Background: true,
+		Sparse:     true,
+	}
+	err = orderDue.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
+
+	ledger := session.DB("store").C("ledger")
+	index = mgo.Index{
+		Key:        []string{"index"},
+		Unique:     true,
+		Background: true,
+		Sparse:     true,
+	}
+	err = ledger.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
+
+	index = mgo.Index{
+		Key:        []string{"chainid"},
+		Unique:     false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = ledger.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
+
+	chainEvents := session.DB("store").C("chainevents")
+	index = mgo.Index{
+		Key:        []string{"eventid"},
+		Unique:     true,
+		Background: true,
+		Sparse:     true,
+	}
+	err = chainEvents.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
 
+	orderEvents := session.DB("store").C("order_events")
+	index = mgo.Index{
+		Key:        []string{"orderid", "time"},
+		Unique:     false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = orderEvents.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
+
+	artists := session.DB("store").C("artists")
+	index = mgo.Index{
+		Key:        []string{"name"},
+		Unique:     true,
+		Background: true,
+		Sparse:     true,
+	}
+	err = artists.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
+
+	transferJobs := session.DB("store").C("transferjobs")
+	index = mgo.Index{
+		Key:        []string{"id"},
+		Unique:     true,
+		Background: true,
+		Sparse:     true,
+	}
+	err = transferJobs.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
+
+	// the expired tokens are removed by the TTL indexes
+	refreshTokens := session.DB("store").C("refresh_tokens")
+	for _, index = range []mgo.Index{
+		{Key: []string{"family"}, Background: true},
+		{Key: []string{"user"}, Background: true},
+		{Key: []string{"expiresAt"}, Background: true, ExpireAfter: time.Second},
+	} {
+		err = refreshTokens.EnsureIndex(index)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	revokedTokens := session.DB("store").C("revoked_tokens")
+	index = mgo.Index{
+		Key:         []string{"expires"},
+		Background:  true,
+		ExpireAfter: time.Second,
+	}
+	err = revokedTokens.EnsureIndex(index)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func dialDB() (*mgo.Session, error) {
 	dbAddr := *dbServerURL + ":" + *dbServerPort
 	if *localDev {
 		dbAddr = "0.0.0.0:9000"
@@ -95,15 +232,18 @@ func main() {
 		}
 	}
 
-	session, err := mgo.DialWithInfo(dialInfo)
-	if err != nil {
-		fmt.Println("Db connection fails: " + err.Error())
+	return mgo.DialWithInfo(dialInfo)
+}
+
+func main() {
+	flag.Parse()
+	if _, err := UserService.ParseClasses(*passwordClasses); err != nil {
+		fmt.Println("Invalid password classes: " + err.Error())
 		return
 	}
 
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	ensureIndex(session)
+	ctx := context.Background()
+	errChan := make(chan error)
 
 	// Logging domain.
 	var logger log.Logger
@@ -113,7 +253,39 @@ func main() {
 		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 	}
 
-	r := makeHTTPHandler(ctx, session, logger)
+	if *keyDir != "" {
+		keys, err := NSUtil.OpenKeyDir(*keyDir, *keyAlg)
+		if err != nil {
+			fmt.Println("Token keys fail: " + err.Error())
+			return
+		}
+
+		NSUtil.Keys = keys
+		if *keyRotation > 0 {
+			keys.StartRotation(ctx, *keyAlg, *keyRotation, *keyRetention, log.With(logger, "component", "keys"))
+		}
+	}
+
+	var repos repositories
+	if *storeType == NSUtil.MemoryStore {
+		repos = newMemoryRepositories()
+	} else {
+		session, err := dialDB()
+		if err != nil {
+			fmt.Println("Db connection fails: " + err.Error())
+			return
+		}
+
+		defer session.Close()
+		session.SetMode(mgo.Monotonic, true)
+		ensureIndex(session)
+
+		repos = newMongoRepositories(session)
+	}
+
+	seedArtists(repos.products, logger)
+
+	r := makeHTTPHandler(ctx, repos, logger)
 	r = cors.AllowAll().Handler(r)
 
 	// HTTP transport
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"neural-style-util"
+	"time"
 
 	"neural-style-products"
 
@@ -14,20 +15,24 @@ import (
 
 	"neural-style-order"
 
+	"neural-style-chain"
+
 	"github.com/go-kit/kit/log"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
-	mgo "gopkg.in/mgo.v2"
 )
 
 func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("context-type", "application/json,charset=utf8")
+	if sc, ok := err.(httptransport.StatusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"error": err.Error(),
 	})
 }
 
-func makeHTTPHandler(ctx context.Context, dbSession *mgo.Session, logger log.Logger) http.Handler {
+func makeHTTPHandler(ctx context.Context, repos repositories, logger log.Logger) http.Handler {
 	r := mux.NewRouter()
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorLogger(logger),
@@ -35,39 +40,80 @@ func makeHTTPHandler(ctx context.Context, dbSession *mgo.Session, logger log.Log
 		httptransport.ServerBefore(NSUtil.ParseToken),
 	}
 
-	authMiddleware := NSUtil.AuthMiddleware(logger)
+	// the other services verify the tokens by the public keys
+	if NSUtil.Keys != nil {
+		r.Methods("GET").Path("/.well-known/jwks.json").Handler(NSUtil.AccessControl(NSUtil.JWKSHandler(NSUtil.Keys)))
+	}
+
+	authMiddleware := NSUtil.AuthMiddleware(logger, repos.revoked)
+	adminMiddleware := NSUtil.AdminMiddleware(logger, repos.revoked)
+	viewerMiddleware := NSUtil.ViewerMiddleware(logger, repos.revoked)
 	// Style Service
-	styleTransferService := StyleService.NewNeuralTransferSVC(*networkPath, *previewNetworkPath,
-		*outputPath, *serverURL, *serverPort)
+	var backend StyleService.StyleBackend
+	switch *transferBackend {
+	case StyleService.RemoteBackendType:
+		backend = StyleService.NewRemoteBackend(*transferServer, 10*time.Minute)
+	case StyleService.FilterBackendType:
+		backend = StyleService.NewFilterBackend()
+	default:
+		backend = StyleService.NewPythonBackend(*networkPath, *previewNetworkPath, "")
+	}
+
+	styleTransferService := StyleService.NewNeuralTransferSVC(backend, artistModels{repos.products}, *outputPath, *serverURL, *serverPort,
+		repos.jobs, *transferWorkers, *transferQueue, log.With(logger, "component", "transfer"))
+	styleTransferService.Jobs.Start(ctx)
 	r = StyleService.MakeHTTPHandler(ctx, r, authMiddleware, styleTransferService, options...)
 
 	// Product service
 	storageServiceURL := "http://" + *storageServerURL + ":" + *storageServerPort
 	storageSaveURL := storageServiceURL + *storageServerSaveRouter
 	storageFindURL := storageServiceURL + *storageServerFindRouter
+	storageDeleteURL := storageServiceURL + *storageDeleteRouter
+	storageUploadURL := storageServiceURL + *storageUploadRouter
 
 	cacheServiceURL := "http://" + *cacheServer
 	cacheGetURL := cacheServiceURL + *cacheGetRouter
 
-	var prods ProductService.Service
-	prods = ProductService.NewProductSVC(*outputPath, *serverURL, *serverPort,
-		storageSaveURL, storageFindURL, cacheGetURL, *localDev, logger, dbSession)
+	productService := ProductService.NewProductSVC(*outputPath, *serverURL, *serverPort,
+		storageSaveURL, storageFindURL, storageDeleteURL, storageUploadURL, cacheGetURL, *localDev, logger,
+		repos.products)
+	productService.Duplicates = ProductService.DuplicatePolicy{Threshold: *duplicateDistance, Action: *duplicateAction}
 
+	var prods ProductService.Service = productService
 	prods = ProductService.NewLoggingService(log.With(logger, "component", "product"), prods)
-	r = ProductService.MakeHTTPHandler(ctx, r, authMiddleware, prods, options...)
+	r = ProductService.MakeHTTPHandler(ctx, r, authMiddleware, adminMiddleware, viewerMiddleware, prods,
+		options...)
 
 	// User service
-	var users UserService.Service
-	users = UserService.NewUserSVC(*serverURL, *serverPort, logger, dbSession)
+	userService := UserService.NewUserSVC(*serverURL, *serverPort, logger, repos.users, repos.tokens,
+		repos.revoked)
+	userService.AccessTTL = *accessTokenTTL
+	userService.RefreshTTL = *refreshTokenTTL
+	classes, _ := UserService.ParseClasses(*passwordClasses)
+	userService.Policy = UserService.PasswordPolicy{MinLength: *passwordMinLength, Classes: classes}
+
+	var users UserService.Service = userService
 	users = UserService.NewLoggingService(log.With(logger, "component", "user"), users)
 	r = UserService.MakeHTTPHandler(ctx, r, authMiddleware, users, options...)
 
 	// Order service
 	productsURL := "http://" + *serverURL + ":" + *serverPort + *productsRouter
+	var chain ChainService.Chain
+	if *chainType == "none" {
+		chain = ChainService.NoopChain{}
+	} else {
+		ledger := ChainService.NewLocalLedger(repos.blocks, repos.notified, log.With(logger, "component", "chain"))
+		ledger.Start(ctx)
+		chain = ledger
+	}
+
+	orderSVC := OrderService.NewOrderSVC(*serverURL, *serverPort, logger, repos.orders, productsURL, *bidIncrement, chain)
+	orderSVC.Products = productReserves{repos.products}
+	OrderService.NewScheduler(orderSVC, *dueInterval, log.With(logger, "component", "scheduler")).Start(ctx)
+
 	var orders OrderService.Service
-	orders = OrderService.NewOrderSVC(*serverURL, *serverPort, logger, dbSession, productsURL)
-	orders = OrderService.NewLoggingService(log.With(logger, "component", "order"), orders)
-	r = OrderService.MakeHTTPHandler(ctx, r, authMiddleware, orders, options...)
+	orders = OrderService.NewLoggingService(log.With(logger, "component", "order"), orderSVC)
+	r = OrderService.MakeHTTPHandler(ctx, r, authMiddleware, viewerMiddleware, orders, options...)
 
 	return r
 }
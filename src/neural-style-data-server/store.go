@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"neural-style-chain"
+	"neural-style-order"
+	"neural-style-products"
+	"neural-style-transfer"
+	"neural-style-user"
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	mgo "gopkg.in/mgo.v2"
+)
+
+// artistsFile is the artist list served under /masters
+const artistsFile = "data/masters/artist.json"
+
+// repositories define the data access of all the services in the data server
+type repositories struct {
+	products ProductService.Repository
+	users    UserService.Repository
+	tokens   UserService.RefreshTokenRepository
+	revoked  NSUtil.RevocationList
+	orders   OrderService.Store
+	blocks   ChainService.BlockRepository
+	notified ChainService.NotificationRepository
+	jobs     StyleService.JobRepository
+}
+
+func newMongoRepositories(session *mgo.Session) repositories {
+	return repositories{
+		products: ProductService.NewMongoRepository(session),
+		users:    UserService.NewMongoRepository(session),
+		tokens:   UserService.NewMongoTokenRepository(session),
+		revoked:  NSUtil.NewMongoRevocationList(session),
+		orders:   OrderService.NewMongoStore(session),
+		blocks:   ChainService.NewMongoBlockRepository(session),
+		notified: ChainService.NewMongoNotificationRepository(session),
+		jobs:     StyleService.NewMongoJobRepository(session),
+	}
+}
+
+// newMemoryRepositories keep all the data in memory, which is lost after the server stops
+func newMemoryRepositories() repositories {
+	return repositories{
+		products: ProductService.NewMemoryRepository(),
+		users:    UserService.NewMemoryRepository(),
+		tokens:   UserService.NewMemoryTokenRepository(),
+		revoked:  NSUtil.NewMemoryRevocationList(),
+		orders:   OrderService.NewMemoryStore(),
+		blocks:   ChainService.NewMemoryBlockRepository(),
+		notified: ChainService.NewMemoryNotificationRepository(),
+		jobs:     StyleService.NewMemoryJobRepository(),
+	}
+}
+
+// seedArtists insert the artists in artistsFile, the existing artists and their retired status are kept
+func seedArtists(products ProductService.Repository, logger log.Logger) {
+	var artists []ProductService.Artist
+	artistsData, err := os.Open(artistsFile)
+	if err == nil {
+		defer artistsData.Close()
+		err = json.NewDecoder(artistsData).Decode(&artists)
+	}
+
+	if err != nil {
+		level.Error(logger).Log("API", "seedArtists", "info", "no artists loaded", "error", err)
+		return
+	}
+
+	for _, artist := range artists {
+		err = products.InsertArtist(artist)
+		if err != nil && err != NSUtil.ErrDuplicated {
+			level.Error(logger).Log("API", "seedArtists", "artist", artist.Name, "error", err)
+		}
+	}
+}
+
+// artistModels resolve the transfer models from the artists of the products repository
+type artistModels struct {
+	products ProductService.Repository
+}
+
+func (models artistModels) FindModel(name string) (StyleService.ArtistModel, error) {
+	artist, err := models.products.FindArtist(name)
+	if err != nil {
+		return StyleService.ArtistModel{}, err
+	}
+
+	if artist.Retired {
+		return StyleService.ArtistModel{}, NSUtil.ErrNotFound
+	}
+
+	return StyleService.ArtistModel{Name: artist.Name, Masterpiece: artist.Masterpiece, ModelName: artist.ModelName}, nil
+}
+
+// productReserves find the reserve prices of the orders from the products repository
+type productReserves struct {
+	products ProductService.Repository
+}
+
+func (reserves productReserves) ReservePrice(productID string) (string, error) {
+	product, err := reserves.products.FindByID(productID)
+	if err != nil {
+		return "", err
+	}
+
+	return product.Price.Reserve, nil
+}
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
@@ -28,17 +28,29 @@ type AzureImageStore struct {
 	StorageURL     string
 }
 
-// NewAzureImageStore create the default azure image storage
-func NewAzureImageStore() *AzureImageStore {
-	return &AzureImageStore{
-		StorageAccount: azStorageAccount,
-		StorageKey:     azStorageKey,
-		StorageURL:     azStorageURL,
+// NewAzureImageStores create the azure image storages of the accounts in AZURE_STORAGE_ACCOUNT. The accounts
+// and the keys in AZURE_STORAGE_KEY are separated by ';' in the same order.
+func NewAzureImageStores() ([]*AzureImageStore, error) {
+	accounts := strings.Split(azStorageAccount, ";")
+	keys := strings.Split(azStorageKey, ";")
+	if len(accounts) != len(keys) {
+		return nil, errors.New("AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY have different sizes")
 	}
+
+	var stores []*AzureImageStore
+	for index, account := range accounts {
+		stores = append(stores, &AzureImageStore{
+			StorageAccount: account,
+			StorageKey:     keys[index],
+			StorageURL:     azStorageURL,
+		})
+	}
+
+	return stores, nil
 }
 
 // Save image on azure storage
-func (svc *AzureImageStore) Save(img Image) (string, error) {
+func (svc *AzureImageStore) Save(ctx context.Context, img Image) (string, error) {
 	// Create a default request pipeline using your storage account name and account key.
 	credential := azblob.NewSharedKeyCredential(svc.StorageAccount, svc.StorageKey)
 	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
@@ -52,12 +64,10 @@ func (svc *AzureImageStore) Save(img Image) (string, error) {
 	containerURL := azblob.NewContainerURL(*URL, p)
 
 	// Create the container
-	ctx := context.Background() // This example uses a never-expiring context
-	var blobName string
+	blobName := img.storedName()
 	_, err := containerURL.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
 	if len(img.Location) != 0 {
 		// add the image file as a blob to the container
-		blobName := filepath.Base(img.Location)
 		imgBlobURL := containerURL.NewBlockBlobURL(blobName)
 		file, err := os.Open(img.Location)
 		if err != nil {
@@ -71,7 +81,6 @@ func (svc *AzureImageStore) Save(img Image) (string, error) {
 			BlockSize:   4 * 1024 * 1024,
 			Parallelism: 16})
 	} else {
-		blobName = img.ImageName
 		imgBlobURL := containerURL.NewBlockBlobURL(blobName)
 		// The high-level API UploadFileToBlockBlob function uploads blocks in parallel for optimal performance, and
 		// can handle large files as well.
@@ -182,3 +191,18 @@ func (svc *AzureImageStore) FindAllByUser(userID string) ([]string, error) {
 
 	return nil, errors.New("Unknow user")
 }
+
+// Delete remove the image blob from the container of the user
+func (svc *AzureImageStore) Delete(userID, fileName string) error {
+	credential := azblob.NewSharedKeyCredential(svc.StorageAccount, svc.StorageKey)
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+
+	blobURL := "https://" + "%s" + svc.StorageURL + "/%s"
+	blobURL = fmt.Sprintf(blobURL, svc.StorageAccount, userID)
+	URL, _ := url.Parse(blobURL)
+
+	containerURL := azblob.NewContainerURL(*URL, p)
+	_, err := containerURL.NewBlobURL(fileName).Delete(context.Background(), azblob.DeleteSnapshotsOptionNone,
+		azblob.BlobAccessConditions{})
+	return err
+}
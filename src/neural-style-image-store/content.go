@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// blobContainer keep the content addressed blobs, it's the container on azure, the object prefix on S3
+// and the directory of the local backend
+const blobContainer = "sha256"
+
+// imageContent define the content of an image, MimeType is sniffed from the data
+type imageContent struct {
+	SHA256   string
+	Size     int64
+	MimeType string
+}
+
+// contentOf hash the decoded image data, or the image file if its Location is set
+func contentOf(img Image) (imageContent, error) {
+	var reader io.Reader = bytes.NewReader(img.ImageData)
+	if len(img.Location) != 0 {
+		file, err := os.Open(img.Location)
+		if err != nil {
+			return imageContent{}, err
+		}
+		defer file.Close()
+
+		reader = file
+	}
+
+	// http.DetectContentType reads at most 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return imageContent{}, err
+	}
+
+	hash := sha256.New()
+	hash.Write(head[:n])
+	size, err := io.Copy(hash, reader)
+	if err != nil {
+		return imageContent{}, err
+	}
+
+	return imageContent{
+		SHA256:   hex.EncodeToString(hash.Sum(nil)),
+		Size:     size + int64(n),
+		MimeType: http.DetectContentType(head[:n]),
+	}, nil
+}
+
+// blobName is the sha256 of the content with the extension of the image, so the stores keep the content type
+func blobName(sha string, imgName string) string {
+	return sha + strings.ToLower(filepath.Ext(imgName))
+}
+
+// blobLocks serialize the reference changes and the file operations on each blob in this instance, so the
+// file of an unreferenced blob isn't deleted after the same content is saved again with the same name
+type blobLocks struct {
+	mutex sync.Mutex
+	locks map[string]*blobLock
+}
+
+type blobLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock lock the blob, and return the function which unlocks it
+func (l *blobLocks) lock(sha string) func() {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*blobLock)
+	}
+	lock, ok := l.locks[sha]
+	if !ok {
+		lock = &blobLock{}
+		l.locks[sha] = lock
+	}
+	lock.users++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mutex.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, sha)
+		}
+		l.mutex.Unlock()
+	}
+}
+
+// storeBlob refer to the blob of the content, and upload the image as the blob if it doesn't exist
+func (svc *StorageService) storeBlob(jobID string, img Image, content imageContent) error {
+	defer svc.blobLocks.lock(content.SHA256)()
+
+	_, err := svc.blobs.AddRef(content.SHA256, 1)
+	if err == nil {
+		level.Debug(svc.logger).Log("API", "Save", "job", jobID, "sha256", content.SHA256, "info", "reuse the blob")
+		return nil
+	}
+
+	if err != NSUtil.ErrNotFound {
+		return err
+	}
+
+	result, err := svc.upload(jobID, img, blobName(content.SHA256, img.ImageName))
+	if err != nil {
+		return err
+	}
+
+	blob := Blob{
+		SHA256:   content.SHA256,
+		Name:     result.Name,
+		Size:     content.Size,
+		MimeType: content.MimeType,
+		Backend:  result.Backend,
+		Account:  result.StorageAccount,
+		Bucket:   result.Bucket,
+		Refs:     1,
+	}
+
+	err = svc.blobs.Insert(blob)
+	if err == NSUtil.ErrDuplicated {
+		// the same content is saved at the same time, keep the blob inserted first
+		existing, err := svc.blobs.AddRef(content.SHA256, 1)
+		if err != nil {
+			return err
+		}
+
+		if existing.location().storeKey() != blob.location().storeKey() || existing.Name != blob.Name {
+			svc.deleteBlobFile(blob)
+		}
+
+		return nil
+	}
+
+	if err != nil {
+		level.Error(svc.logger).Log("API", "blobs.Insert", "job", jobID, "info", err)
+		svc.deleteBlobFile(blob)
+		return err
+	}
+
+	svc.addUsage(blob.location().storeKey(), blob.Size, 1)
+	return nil
+}
+
+// releaseBlob drop a reference of the blob, and delete the blob when no image refers to it.
+// The error is only logged, an unreferenced blob can be found by its Refs.
+func (svc *StorageService) releaseBlob(sha string) {
+	defer svc.blobLocks.lock(sha)()
+
+	blob, err := svc.blobs.AddRef(sha, -1)
+	if err != nil {
+		level.Error(svc.logger).Log("API", "blobs.AddRef", "sha256", sha, "info", err)
+		return
+	}
+
+	if blob.Refs > 0 {
+		return
+	}
+
+	err = svc.blobs.RemoveUnreferenced(sha)
+	if err == NSUtil.ErrNotFound {
+		// the blob is referred again
+		return
+	}
+
+	if err != nil {
+		level.Error(svc.logger).Log("API", "blobs.RemoveUnreferenced", "sha256", sha, "info", err)
+		return
+	}
+
+	if svc.deleteBlobFile(blob) {
+		svc.addUsage(blob.location().storeKey(), -blob.Size, -1)
+	}
+}
+
+func (svc *StorageService) deleteBlobFile(blob Blob) bool {
+	store, ok := svc.dispatcher.Stores[blob.location().storeKey()]
+	if !ok {
+		level.Error(svc.logger).Log("API", "deleteBlob", "sha256", blob.SHA256,
+			"info", "The storage "+blob.location().storeKey()+" is unavailable")
+		return false
+	}
+
+	err := store.Delete(blobContainer, blob.Name)
+	if err != nil {
+		level.Error(svc.logger).Log("API", "Store.Delete", "sha256", blob.SHA256, "info", err)
+		return false
+	}
+
+	return true
+}
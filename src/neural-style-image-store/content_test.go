@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSameContentSharesBlob(t *testing.T) {
+	root, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	store := NewLocalImageStore(root, server.URL, []byte("key"))
+	mux.Handle(localFilesRouter, store)
+	entries := []StoreEntry{{Location: StorageInfo{Backend: localBackend}, Store: store}}
+	placement, _ := NewPlacement(roundRobinPolicy, entries, nil)
+	dispatcher := NewDispatcher(1, 1, entries, placement, RetryPolicy{}, newMemoryDeadLetterRepository(),
+		log.NewNopLogger())
+	dispatcher.Run()
+
+	storage := newMemoryStorageRepository()
+	blobs := newMemoryBlobRepository()
+	svc := NewStorageService(storage, newMemoryUsageRepository(), newMemoryDeadLetterRepository(), blobs,
+		dispatcher, nil, time.Second, log.NewNopLogger())
+
+	png := []byte("\x89PNG\r\n\x1a\nimage")
+	for _, user := range []string{"alice", "bob"} {
+		if err := svc.Save(user, "a.png", png); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sha := checksum(string(png))
+	blob, err := blobs.FindBySHA(sha)
+	if err != nil || blob.Refs != 2 || blob.MimeType != "image/png" {
+		t.Fatalf("expected a blob referred by 2 images, got %+v %v", blob, err)
+	}
+
+	info, _ := storage.FindByKey("boba.png")
+	if info.SHA256 != sha || info.Size != int64(len(png)) {
+		t.Errorf("unexpected storage record %+v", info)
+	}
+
+	blobPath := filepath.Join(root, blobContainer, sha+".png")
+	svc.Delete("alice", "a.png")
+	if _, err := os.Stat(blobPath); err != nil {
+		t.Errorf("expected the blob to be kept for bob, got %v", err)
+	}
+
+	verifier := &Verifier{Blobs: blobs, Stores: dispatcher.Stores, Client: server.Client(), Logger: log.NewNopLogger()}
+	ioutil.WriteFile(blobPath, []byte("\x89PNG\r\n\x1a\nimagf"), 0644)
+	report, err := verifier.Run()
+	if err != nil || report.Checked != 1 || len(report.Corrupted) != 1 {
+		t.Errorf("expected the corrupted blob to be reported, got %+v %v", report, err)
+	}
+
+	svc.Delete("bob", "a.png")
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Errorf("expected the unreferenced blob to be deleted, got %v", err)
+	}
+
+	if _, err := blobs.FindBySHA(sha); err == nil {
+		t.Errorf("expected the blob record to be removed")
+	}
+}
+
+// racingBlobRepository runs onRemove right after the unreferenced blob is removed
+type racingBlobRepository struct {
+	BlobRepository
+	onRemove func()
+}
+
+func (repo *racingBlobRepository) RemoveUnreferenced(sha string) error {
+	err := repo.BlobRepository.RemoveUnreferenced(sha)
+	if err == nil && repo.onRemove != nil {
+		repo.onRemove()
+	}
+	return err
+}
+
+func TestBlobFileIsKeptWhenSavedWhileReleased(t *testing.T) {
+	root, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	store := NewLocalImageStore(root, "http://127.0.0.1:0", []byte("key"))
+	entries := []StoreEntry{{Location: StorageInfo{Backend: localBackend}, Store: store}}
+	placement, _ := NewPlacement(roundRobinPolicy, entries, nil)
+	dispatcher := NewDispatcher(2, 2, entries, placement, RetryPolicy{}, newMemoryDeadLetterRepository(),
+		log.NewNopLogger())
+	dispatcher.Run()
+
+	blobs := &racingBlobRepository{BlobRepository: newMemoryBlobRepository()}
+	svc := NewStorageService(newMemoryStorageRepository(), newMemoryUsageRepository(),
+		newMemoryDeadLetterRepository(), blobs, dispatcher, nil, time.Second, log.NewNopLogger())
+
+	png := []byte("\x89PNG\r\n\x1a\nimage")
+	if err := svc.Save("alice", "a.png", png); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob saves the same content between the removal of the blob record and the deletion of its file
+	var wait sync.WaitGroup
+	blobs.onRemove = func() {
+		blobs.onRemove = nil
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if err := svc.Save("bob", "a.png", png); err != nil {
+				t.Error(err)
+			}
+		}()
+		time.Sleep(100 * time.Millisecond)
+	}
+	svc.Delete("alice", "a.png")
+	wait.Wait()
+
+	blobPath := filepath.Join(root, blobContainer, checksum(string(png))+".png")
+	if _, err := os.Stat(blobPath); err != nil {
+		t.Fatalf("the blob of bob is deleted: %v", err)
+	}
+	if blob, err := blobs.FindBySHA(checksum(string(png))); err != nil || blob.Refs != 1 {
+		t.Errorf("expected the blob referred by bob, got %+v %v", blob, err)
+	}
+}
@@ -3,32 +3,37 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 
+	"neural-style-util"
+
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
-	mgo "gopkg.in/mgo.v2"
 )
 
 func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("context-type", "application/json,charset=utf8")
+	writeErrorStatus(w, err)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"error": err.Error(),
 	})
 }
 
-// makeHTTPHandler generate the http handler for storage service
-func makeHTTPHandler(ctx context.Context, dbSession *mgo.Session, logger log.Logger) http.Handler {
+// makeHTTPHandler generate the http handler for storage service, the dead letters are protected by admin
+func makeHTTPHandler(ctx context.Context, storageService *StorageService, files http.Handler,
+	admin endpoint.Middleware, logger log.Logger) http.Handler {
 	r := mux.NewRouter()
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorLogger(logger),
 		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerBefore(NSUtil.ParseToken),
 	}
 
 	var svc Service
-	svc = NewStorageService(dbSession, logger)
+	svc = storageService
 	svc = NewLoggingService(log.With(logger, "component", "storage"), svc)
 
 	//POST /api/v1/storage/save/{userid}/{imageid}
@@ -49,6 +54,74 @@ func makeHTTPHandler(ctx context.Context, dbSession *mgo.Session, logger log.Log
 			options...,
 		))
 
+	// DELETE /api/v1/storage/{userid}/{imageid}
+	r.Methods("DELETE").Path("/api/v1/storage/{userid}/{imageid}").Handler(
+		httptransport.NewServer(
+			MakeNSDeleteEndpoint(svc),
+			decodeNSDeleteRequest,
+			encodeNSDeleteResponse,
+			options...,
+		))
+
+	// GET /api/v1/storage/deadletters
+	r.Methods("GET").Path("/api/v1/storage/deadletters").Handler(
+		httptransport.NewServer(
+			admin(MakeNSDeadLettersEndpoint(svc)),
+			decodeNSDeadLettersRequest,
+			encodeNSDeadLettersResponse,
+			options...,
+		))
+
+	// POST /api/v1/storage/deadletters/{id}/replay
+	r.Methods("POST").Path("/api/v1/storage/deadletters/{id}/replay").Handler(
+		httptransport.NewServer(
+			admin(MakeNSReplayEndpoint(svc)),
+			decodeNSReplayRequest,
+			encodeNSReplayResponse,
+			options...,
+		))
+
+	// POST /api/v1/storage/uploads?userid={userid}&imageid={imageid}
+	r.Methods("POST").Path("/api/v1/storage/uploads").Queries("userid", "{userid}", "imageid", "{imageid}").Handler(
+		httptransport.NewServer(
+			MakeNSCreateUploadEndpoint(svc),
+			decodeNSCreateUploadRequest,
+			encodeNSUploadResponse,
+			options...,
+		))
+
+	// GET /api/v1/storage/uploads/{id}
+	r.Methods("GET").Path("/api/v1/storage/uploads/{id}").Handler(
+		httptransport.NewServer(
+			MakeNSFindUploadEndpoint(svc),
+			decodeNSFindUploadRequest,
+			encodeNSUploadResponse,
+			options...,
+		))
+
+	// PUT /api/v1/storage/uploads/{id}/chunks/{index}
+	r.Methods("PUT").Path("/api/v1/storage/uploads/{id}/chunks/{index}").Handler(
+		httptransport.NewServer(
+			MakeNSUploadChunkEndpoint(svc),
+			decodeNSUploadChunkRequest,
+			encodeNSUploadResponse,
+			options...,
+		))
+
+	// POST /api/v1/storage/uploads/{id}/commit
+	r.Methods("POST").Path("/api/v1/storage/uploads/{id}/commit").Handler(
+		httptransport.NewServer(
+			MakeNSCommitUploadEndpoint(svc),
+			decodeNSCommitUploadRequest,
+			encodeNSUploadResponse,
+			options...,
+		))
+
+	// GET /api/v1/storage/files/{userid}/{imageid}?expires=&signature=
+	if files != nil {
+		r.Methods("GET").PathPrefix(localFilesRouter).Handler(files)
+	}
+
 	return r
 }
 
@@ -93,3 +166,129 @@ func MakeNSFindEndpoint(svc Service) endpoint.Endpoint {
 		return NSFindResponse{URL: url, FindError: err}, err
 	}
 }
+
+// NSDeleteRequest define the image to be deleted
+type NSDeleteRequest struct {
+	UserID  string
+	ImageID string
+}
+
+// NSDeleteResponse return the error of the deletion
+type NSDeleteResponse struct {
+	DeleteError error `json:"error"`
+}
+
+// MakeNSDeleteEndpoint delete the image file and its storage record
+func MakeNSDeleteEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSDeleteRequest)
+		err := svc.Delete(req.UserID, req.ImageID)
+		return NSDeleteResponse{DeleteError: err}, nil
+	}
+}
+
+// NSDeadLettersResponse return the failed upload jobs
+type NSDeadLettersResponse struct {
+	DeadLetters []DeadLetter `json:"deadLetters"`
+	Error       error        `json:"error"`
+}
+
+// MakeNSDeadLettersEndpoint list the upload jobs which fail after the retries
+func MakeNSDeadLettersEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		letters, err := svc.DeadLetters()
+		return NSDeadLettersResponse{DeadLetters: letters, Error: err}, nil
+	}
+}
+
+// NSReplayRequest define the dead letter to be replayed
+type NSReplayRequest struct {
+	ID string
+}
+
+// NSReplayResponse return the error of the replay
+type NSReplayResponse struct {
+	ReplayError error `json:"error"`
+}
+
+// MakeNSReplayEndpoint upload the image of the dead letter again
+func MakeNSReplayEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSReplayRequest)
+		err := svc.Replay(req.ID)
+		return NSReplayResponse{ReplayError: err}, nil
+	}
+}
+
+// NSCreateUploadRequest define the image of the new upload
+type NSCreateUploadRequest struct {
+	UserID  string
+	ImageID string
+}
+
+// NSUploadRequest define the upload to be found
+type NSUploadRequest struct {
+	ID string
+}
+
+// NSUploadChunkRequest define a chunk of the upload, the data is read from the request body
+type NSUploadChunkRequest struct {
+	ID       string
+	Index    int
+	Checksum string
+	Data     io.Reader
+}
+
+// NSCommitUploadRequest define the chunk count and the sha256 of the whole image
+type NSCommitUploadRequest struct {
+	ID       string
+	Chunks   int    `json:"chunks"`
+	Checksum string `json:"sha256"`
+}
+
+// NSUploadResponse return the upload session and the error
+type NSUploadResponse struct {
+	Session UploadSession
+	Err     error
+}
+
+// MakeNSCreateUploadEndpoint start a resumable upload
+func MakeNSCreateUploadEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSCreateUploadRequest)
+		session, err := svc.CreateUpload(req.UserID, req.ImageID)
+		return NSUploadResponse{Session: session, Err: err}, nil
+	}
+}
+
+// MakeNSFindUploadEndpoint return the received chunks of the upload
+func MakeNSFindUploadEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSUploadRequest)
+		session, err := svc.FindUpload(req.ID)
+		return NSUploadResponse{Session: session, Err: err}, nil
+	}
+}
+
+// MakeNSUploadChunkEndpoint save a chunk of the upload
+func MakeNSUploadChunkEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSUploadChunkRequest)
+		err := svc.UploadChunk(req.ID, req.Index, req.Checksum, req.Data)
+		if err != nil {
+			return NSUploadResponse{Err: err}, nil
+		}
+
+		session, err := svc.FindUpload(req.ID)
+		return NSUploadResponse{Session: session, Err: err}, nil
+	}
+}
+
+// MakeNSCommitUploadEndpoint save the uploaded image to the cloud storage
+func MakeNSCommitUploadEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSCommitUploadRequest)
+		session, err := svc.CommitUpload(req.ID, req.Chunks, req.Checksum)
+		return NSUploadResponse{Session: session, Err: err}, nil
+	}
+}
@@ -18,6 +18,15 @@ type Image struct {
 	Location  string
 }
 
+// storedName is the name of the image in the store, the file name of Location is used if ImageName is empty
+func (img Image) storedName() string {
+	if img.ImageName == "" && len(img.Location) != 0 {
+		return filepath.Base(img.Location)
+	}
+
+	return img.ImageName
+}
+
 // UploadResult define the basic inforation after the image is uploaded to the cloud storage
 // The StorageAccount and Storage Key is special for the Azure cloud storage, No such conception for AWS S3 now.
 // Todo: How to the store the two value safely in the database
@@ -25,7 +34,9 @@ type UploadResult struct {
 	UserID         string
 	Name           string
 	Location       string
+	Backend        string
 	StorageAccount string
+	Bucket         string
 	UploadError    error
 }
 
@@ -1,8 +1,12 @@
 package main
 
+import "context"
+
 // ImageStore define the basic interface for a ImageStore
 type ImageStore interface {
-	Save(image Image) (string, error)
+	// Save stop the upload when the context is done
+	Save(ctx context.Context, image Image) (string, error)
 	Find(userID, imgName string) (string, error)
 	FindAllByUser(userID string) ([]string, error)
+	Delete(userID, imgName string) error
 }
@@ -1,176 +1,400 @@
 package main
 
 import (
-	"os"
-	"path/filepath"
-	"strconv"
-)
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"neural-style-util"
 
-var (
-	// MaxWorker define the size of work
-	MaxWorker = os.Getenv("MAX_WORKERS")
-	// MaxQueue define the size of the cache queue
-	MaxQueue = os.Getenv("MAX_QUEUE")
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	minio "github.com/minio/minio-go"
 )
 
-// ImageJob define the azure job upload job
+// ImageJob define the azure job upload job, the upload stops when the context of the job is done
 type ImageJob struct {
+	ID            string
+	Context       context.Context
 	UploadImage   Image
 	ResultChannel chan UploadResult
-}
-
-// JobQueue A buffered channel that we can send work requests on.
-var JobQueue chan ImageJob
+	// Blob is the content addressed name of the image, the image is saved as the blob if it isn't empty
+	Blob string
 
-// Stores define group of image stores services
-// Azure storage support multiple parallel  store account
-var Stores map[string](*AzureImageStore)
+	// sequence identify the job in the dispatcher, the replayed jobs may have the same ID
+	sequence uint64
+}
 
-// Done closed channel
-var Done chan interface{}
+// RetryPolicy define how many times a transient failed upload is retried, the delay doubles
+// after each attempt up to MaxDelay
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
 
-func init() {
-	queueSize, err := strconv.Atoi(MaxQueue)
-	if err != nil {
-		queueSize = 2
+// backoff return the delay before the next attempt, attempt starts from 1
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && delay < policy.MaxDelay; i++ {
+		delay *= 2
 	}
 
-	workerSize, err := strconv.Atoi(MaxWorker)
-	if err != nil {
-		workerSize = 2
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
 	}
 
-	Stores = make(map[string]*AzureImageStore)
-	JobQueue = make(chan ImageJob, queueSize)
-	Done = make(chan interface{})
-
-	storeDispatcher := NewDispatcher(workerSize)
-	storeDispatcher.Run()
+	return delay
 }
 
+// abandonWait is how long the cancelled jobs have to be saved in the dead letters after the grace period
+const abandonWait = 5 * time.Second
+
+var errDraining = NSUtil.NewErrorWithStatus(http.StatusServiceUnavailable,
+	"The storage service is shutting down, please try it later")
+
 // Worker represents the worker that executes the job
 type Worker struct {
 	// WorkerPool define the worker load
 	WorkerPool chan chan ImageJob
 	// JobChannel define the job cache channel
 	JobChannel chan ImageJob
-	quit       chan bool
+	quit       <-chan struct{}
 
-	// ImageStore service
-	Store *AzureImageStore
+	dispatcher *Dispatcher
 }
 
-// NewWorker generate the new worker
-func NewWorker(workerPool chan chan ImageJob) Worker {
-	storeWorker := Worker{
-		WorkerPool: workerPool,
+// NewWorker generate the new worker of the dispatcher
+func NewWorker(dispatcher *Dispatcher) Worker {
+	return Worker{
+		WorkerPool: dispatcher.WorkerPool,
 		JobChannel: make(chan ImageJob),
-		quit:       make(chan bool),
-		Store:      NewAzureImageStore(),
+		quit:       dispatcher.quit,
+		dispatcher: dispatcher,
 	}
-
-	Stores[storeWorker.Store.StorageAccount] = storeWorker.Store
-	return storeWorker
 }
 
-// Start method starts the run loop for the worker, listening for a quit channel in
-// case we need to stop it
+// Start method starts the run loop for the worker, the worker stops when the dispatcher is drained
 func (w Worker) Start() {
 	go func() {
 		for {
 			// register the current worker into the worker queue.
-			w.WorkerPool <- w.JobChannel
+			select {
+			case w.WorkerPool <- w.JobChannel:
+			case <-w.quit:
+				return
+			}
 
 			select {
 			case imgJob := <-w.JobChannel:
-				// we have received a work request.
-				var fileURL string
-				fileURL, err := w.Store.Save(imgJob.UploadImage)
-				fileName := imgJob.UploadImage.ImageName
-				if len(imgJob.UploadImage.Location) != 0 {
-					fileName = filepath.Base(imgJob.UploadImage.Location)
-				}
-
-				if err != nil {
-					// Todo: log the failed operation
-					imgJob.ResultChannel <- UploadResult{
-						UserID:         imgJob.UploadImage.UserID,
-						Name:           fileName,
-						Location:       "",
-						UploadError:    err,
-						StorageAccount: w.Store.StorageAccount,
-					}
-				} else {
-					imgJob.ResultChannel <- UploadResult{
-						UserID:         imgJob.UploadImage.UserID,
-						Name:           fileName,
-						Location:       fileURL,
-						UploadError:    nil,
-						StorageAccount: w.Store.StorageAccount,
-					}
-				}
+				// we have received a work request, the result channel is buffered so the
+				// worker isn't blocked if the caller has stopped waiting
+				imgJob.ResultChannel <- w.process(imgJob)
+				w.dispatcher.finish(imgJob)
 
 			case <-w.quit:
 				// we have received a signal to stop
-				close(w.JobChannel)
 				return
 			}
 		}
 	}()
 }
 
-// Stop signals the worker to stop listening for work requests.
-func (w Worker) Stop() {
-	go func() {
-		w.quit <- true
-	}()
+// process save the image, and retry the transient errors before the job is expired
+func (w Worker) process(imgJob ImageJob) UploadResult {
+	stored := imgJob.UploadImage
+	if imgJob.Blob != "" {
+		stored.UserID = blobContainer
+		stored.ImageName = imgJob.Blob
+	}
+
+	result := UploadResult{UserID: stored.UserID, Name: stored.storedName()}
+	ctx := imgJob.Context
+
+	// the caller has given up while the job is in the queue
+	if err := ctx.Err(); err != nil {
+		level.Error(w.dispatcher.logger).Log("API", "Worker", "job", imgJob.ID, "info", "expired in the queue")
+		w.deadLetter(imgJob, 0, err)
+		result.UploadError = err
+		return result
+	}
+
+	entry, err := w.dispatcher.placement.Place(stored)
+	if err != nil {
+		level.Error(w.dispatcher.logger).Log("API", "Placement.Place", "job", imgJob.ID, "info", err)
+		result.UploadError = err
+		return result
+	}
+
+	result.Backend = entry.Location.Backend
+	result.StorageAccount = entry.Location.Account
+	result.Bucket = entry.Location.Bucket
+
+	for attempt := 1; ; attempt++ {
+		result.Location, result.UploadError = entry.Store.Save(ctx, stored)
+		err = result.UploadError
+		if err == nil {
+			return result
+		}
+
+		level.Error(w.dispatcher.logger).Log("API", "Store.Save", "job", imgJob.ID, "store", entry.Location.storeKey(),
+			"attempt", attempt, "info", err)
+
+		// the job is expired or abandoned at shutdown
+		if ctx.Err() != nil {
+			w.deadLetter(imgJob, attempt, err)
+			result.UploadError = ctx.Err()
+			return result
+		}
+
+		if !isTransient(err) {
+			return result
+		}
+
+		if attempt > w.dispatcher.retry.MaxRetries {
+			w.deadLetter(imgJob, attempt, err)
+			return result
+		}
+
+		select {
+		case <-time.After(w.dispatcher.retry.backoff(attempt)):
+		case <-ctx.Done():
+			w.deadLetter(imgJob, attempt, ctx.Err())
+			result.UploadError = ctx.Err()
+			return result
+		}
+	}
+}
+
+// deadLetter keep the failed job, so it can be replayed later. A replayed job has the id of its dead letter,
+// so the letter is replaced if it fails again.
+func (w Worker) deadLetter(imgJob ImageJob, attempts int, cause error) {
+	letter := DeadLetter{
+		ID:        imgJob.ID,
+		UserID:    imgJob.UploadImage.UserID,
+		ImageName: imgJob.UploadImage.ImageName,
+		ImageData: imgJob.UploadImage.ImageData,
+		Location:  imgJob.UploadImage.Location,
+		Attempts:  attempts,
+		Error:     cause.Error(),
+		Time:      time.Now(),
+	}
+
+	err := w.dispatcher.deadLetters.Save(letter)
+	if err != nil {
+		level.Error(w.dispatcher.logger).Log("API", "DeadLetters.Save", "job", imgJob.ID, "info", err)
+		return
+	}
+
+	level.Info(w.dispatcher.logger).Log("API", "Worker", "job", imgJob.ID, "info", "saved in the dead letters")
+}
+
+// isTransient check whether the upload may succeed if it's retried: the network errors, the unexpected
+// end of the response and the throttled or 5xx responses of S3 and Azure.
+func isTransient(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	if err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return true
+	}
+
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+
+	if s3Err, ok := err.(minio.ErrorResponse); ok {
+		return transientStatus(s3Err.StatusCode)
+	}
+
+	// azblob.StorageError
+	if azErr, ok := err.(interface{ Response() *http.Response }); ok && azErr.Response() != nil {
+		return transientStatus(azErr.Response().StatusCode)
+	}
+
+	return false
+}
+
+func transientStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
 }
 
 // Dispatcher job schedule
 type Dispatcher struct {
 	// A pool of workers channels that are registered with the dispatcher
 	WorkerPool chan chan ImageJob
-	maxWorker  int
-	workers    []Worker
+	// JobQueue A buffered channel that we can send work requests on.
+	JobQueue chan ImageJob
+	// Stores define group of image stores services keyed by StorageInfo.storeKey
+	// Azure storage support multiple parallel  store account
+	Stores map[string]ImageStore
+
+	maxWorker   int
+	placement   Placement
+	retry       RetryPolicy
+	deadLetters DeadLetterRepository
+	logger      log.Logger
+
+	quit     chan struct{}
+	pending  sync.WaitGroup
+	mutex    sync.Mutex
+	draining bool
+	sequence uint64
+	inflight map[uint64]inflightJob
 }
 
-// NewDispatcher configure the size of Dispatcher
-func NewDispatcher(maxWorkerSize int) *Dispatcher {
-	pool := make(chan chan ImageJob, maxWorkerSize)
-	return &Dispatcher{WorkerPool: pool, maxWorker: maxWorkerSize}
+// inflightJob is a submitted job which isn't finished, the cancel stops it when it's abandoned
+type inflightJob struct {
+	job    ImageJob
+	cancel context.CancelFunc
+}
+
+// NewDispatcher configure the size of Dispatcher, the images are saved in the stores of the entries chosen
+// by the placement. The jobs which still fail after the retries are saved in the dead letters.
+func NewDispatcher(maxWorkerSize, queueSize int, entries []StoreEntry, placement Placement, retry RetryPolicy,
+	deadLetters DeadLetterRepository, logger log.Logger) *Dispatcher {
+	stores := make(map[string]ImageStore)
+	for _, entry := range entries {
+		stores[entry.Location.storeKey()] = entry.Store
+	}
+
+	return &Dispatcher{
+		WorkerPool:  make(chan chan ImageJob, maxWorkerSize),
+		JobQueue:    make(chan ImageJob, queueSize),
+		Stores:      stores,
+		maxWorker:   maxWorkerSize,
+		placement:   placement,
+		retry:       retry,
+		deadLetters: deadLetters,
+		logger:      logger,
+		quit:        make(chan struct{}),
+		inflight:    make(map[uint64]inflightJob),
+	}
 }
 
 // Run generate the dispatcher
 func (d *Dispatcher) Run() {
 	// starting n number of workers
 	for i := 0; i < d.maxWorker; i++ {
-		worker := NewWorker(d.WorkerPool)
-		worker.Start()
-		d.workers = append(d.workers, worker)
+		NewWorker(d).Start()
 	}
 
 	go d.dispatch()
 }
 
+// Submit queue the job until its context is done, and return errDraining if the dispatcher is drained
+func (d *Dispatcher) Submit(job ImageJob) error {
+	ctx, cancel := context.WithCancel(job.Context)
+	job.Context = ctx
+
+	d.mutex.Lock()
+	if d.draining {
+		d.mutex.Unlock()
+		cancel()
+		return errDraining
+	}
+
+	d.sequence++
+	job.sequence = d.sequence
+	d.inflight[job.sequence] = inflightJob{job: job, cancel: cancel}
+	d.pending.Add(1)
+	d.mutex.Unlock()
+
+	select {
+	case d.JobQueue <- job:
+		return nil
+	case <-ctx.Done():
+		d.finish(job)
+		return ctx.Err()
+	}
+}
+
+// Drain stop accepting the new jobs and wait for the submitted jobs during the grace period. The jobs which
+// aren't finished are cancelled, saved in the dead letters by the workers, and returned. The workers stop at last.
+func (d *Dispatcher) Drain(grace time.Duration) []ImageJob {
+	d.mutex.Lock()
+	d.draining = true
+	d.mutex.Unlock()
+
+	var abandoned []ImageJob
+	if !d.wait(grace) {
+		d.mutex.Lock()
+		for _, item := range d.inflight {
+			abandoned = append(abandoned, item.job)
+			item.cancel()
+		}
+		d.mutex.Unlock()
+
+		if !d.wait(abandonWait) {
+			level.Error(d.logger).Log("API", "Drain", "info", "the abandoned jobs aren't all saved in the dead letters")
+		}
+	}
+
+	close(d.quit)
+	return abandoned
+}
+
+// wait return false if the submitted jobs aren't finished in the timeout
+func (d *Dispatcher) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// finish remove the job from the inflight jobs, it's safe to finish a job twice
+func (d *Dispatcher) finish(job ImageJob) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	item, ok := d.inflight[job.sequence]
+	if !ok {
+		return
+	}
+
+	item.cancel()
+	delete(d.inflight, job.sequence)
+	d.pending.Done()
+}
+
 func (d *Dispatcher) dispatch() {
 	for {
 		select {
-		case <-Done:
-			// Stop the worker
-			for _, w := range d.workers {
-				w.Stop()
-			}
-
-			close(JobQueue)
+		case <-d.quit:
 			return
-		case img := <-JobQueue:
+		case img := <-d.JobQueue:
 			// a job request has been received
 			go func(job ImageJob) {
 				// try to obtain a worker job channel that is available.
 				// this will block until a worker is idle
-				jobChannel := <-d.WorkerPool
-				// dispatch the job to the worker job channel
-				jobChannel <- job
+				select {
+				case jobChannel := <-d.WorkerPool:
+					// dispatch the job to the worker job channel
+					select {
+					case jobChannel <- job:
+					case <-d.quit:
+						d.finish(job)
+					}
+				case <-d.quit:
+					d.finish(job)
+				}
 			}(img)
 		}
 	}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type flakyStore struct {
+	ImageStore
+	failures int
+	saves    int
+	err      error
+}
+
+func (store *flakyStore) Save(ctx context.Context, img Image) (string, error) {
+	store.saves++
+	if store.saves <= store.failures {
+		return "", store.err
+	}
+
+	return "url", nil
+}
+
+// blockingStore save the image when it's released, or fail when the upload is cancelled
+type blockingStore struct {
+	ImageStore
+	release chan struct{}
+}
+
+func (store *blockingStore) Save(ctx context.Context, img Image) (string, error) {
+	select {
+	case <-store.release:
+		return "url", nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func newTestDispatcher(store ImageStore, deadLetters DeadLetterRepository) *Dispatcher {
+	entries := []StoreEntry{{Location: StorageInfo{Backend: azureBackend, Account: "a"}, Store: store}}
+	placement, _ := NewPlacement(roundRobinPolicy, entries, nil)
+	retry := RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	return NewDispatcher(1, 2, entries, placement, retry, deadLetters, log.NewNopLogger())
+}
+
+func newTestWorker(store ImageStore, deadLetters DeadLetterRepository) Worker {
+	return NewWorker(newTestDispatcher(store, deadLetters))
+}
+
+func TestWorkerRetriesTransientErrors(t *testing.T) {
+	store := &flakyStore{failures: 2, err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	deadLetters := newMemoryDeadLetterRepository()
+	worker := newTestWorker(store, deadLetters)
+
+	result := worker.process(ImageJob{ID: "job", Context: context.Background(), UploadImage: Image{UserID: "user"}})
+	if result.UploadError != nil || result.Location != "url" || store.saves != 3 {
+		t.Fatalf("expected the upload to succeed at the 3rd attempt, got %d %v", store.saves, result.UploadError)
+	}
+
+	if letters, _ := deadLetters.FindAll(); len(letters) != 0 {
+		t.Errorf("expected no dead letter, got %v", letters)
+	}
+}
+
+func TestWorkerSavesDeadLetter(t *testing.T) {
+	store := &flakyStore{failures: 10, err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	deadLetters := newMemoryDeadLetterRepository()
+	worker := newTestWorker(store, deadLetters)
+
+	result := worker.process(ImageJob{ID: "job", Context: context.Background(),
+		UploadImage: Image{UserID: "user", ImageName: "a.png", ImageData: []byte("png")}})
+	if result.UploadError == nil || store.saves != 3 {
+		t.Fatalf("expected the upload to fail after 2 retries, got %d attempts", store.saves)
+	}
+
+	letter, err := deadLetters.FindByID("job")
+	if err != nil || letter.Attempts != 3 || string(letter.ImageData) != "png" {
+		t.Errorf("unexpected dead letter %+v %v", letter, err)
+	}
+}
+
+func TestWorkerDoesNotRetryPermanentErrors(t *testing.T) {
+	store := &flakyStore{failures: 10, err: errors.New("bad image name")}
+	deadLetters := newMemoryDeadLetterRepository()
+	worker := newTestWorker(store, deadLetters)
+
+	result := worker.process(ImageJob{ID: "job", Context: context.Background(), UploadImage: Image{UserID: "user"}})
+	if result.UploadError == nil || store.saves != 1 {
+		t.Fatalf("expected a single attempt, got %d", store.saves)
+	}
+
+	if _, err := deadLetters.FindByID("job"); err == nil {
+		t.Errorf("expected no dead letter for a permanent error")
+	}
+}
+
+func TestDrainWaitsForQueuedJobs(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{})}
+	dispatcher := newTestDispatcher(store, newMemoryDeadLetterRepository())
+	dispatcher.Run()
+
+	results := make(chan UploadResult, 1)
+	err := dispatcher.Submit(ImageJob{ID: "job", Context: context.Background(), UploadImage: Image{UserID: "user"},
+		ResultChannel: results})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(store.release)
+	}()
+
+	if abandoned := dispatcher.Drain(time.Second); len(abandoned) != 0 {
+		t.Errorf("expected no abandoned job, got %d", len(abandoned))
+	}
+
+	if result := <-results; result.UploadError != nil {
+		t.Errorf("expected the queued job to be saved, got %v", result.UploadError)
+	}
+
+	err = dispatcher.Submit(ImageJob{ID: "late", Context: context.Background(), ResultChannel: results})
+	if err != errDraining {
+		t.Errorf("expected the new job to be rejected, got %v", err)
+	}
+}
+
+func TestDrainAbandonsJobsAfterGracePeriod(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{})}
+	deadLetters := newMemoryDeadLetterRepository()
+	dispatcher := newTestDispatcher(store, deadLetters)
+	dispatcher.Run()
+
+	for _, id := range []string{"running", "queued"} {
+		dispatcher.Submit(ImageJob{ID: id, Context: context.Background(), UploadImage: Image{UserID: "user"},
+			ResultChannel: make(chan UploadResult, 1)})
+	}
+
+	abandoned := dispatcher.Drain(20 * time.Millisecond)
+	if len(abandoned) != 2 {
+		t.Fatalf("expected 2 abandoned jobs, got %d", len(abandoned))
+	}
+
+	if letters, _ := deadLetters.FindAll(); len(letters) != 2 {
+		t.Errorf("expected the abandoned jobs in the dead letters, got %d", len(letters))
+	}
+}
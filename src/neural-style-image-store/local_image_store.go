@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localFilesRouter serves the images of the local image store
+const localFilesRouter = "/api/v1/storage/files/"
+
+var errBadSignature = errors.New("The url is expired or the signature is invalid")
+
+// LocalImageStore store the image under Root/{userID}/{imageName} on the local disk. The urls are signed
+// by HMAC-SHA256 and expire like the Azure SAS urls, and the files are served by ServeHTTP.
+type LocalImageStore struct {
+	Root    string
+	BaseURL string
+	Key     []byte
+}
+
+// NewLocalImageStore create the image store under the root, the urls start with the baseURL of the storage server
+func NewLocalImageStore(root, baseURL string, key []byte) *LocalImageStore {
+	return &LocalImageStore{Root: root, BaseURL: strings.TrimSuffix(baseURL, "/"), Key: key}
+}
+
+// Save image on the local disk
+func (svc *LocalImageStore) Save(ctx context.Context, img Image) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	imgName := img.storedName()
+
+	imgPath, err := svc.imagePath(img.UserID, imgName)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(imgPath), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	if len(img.Location) != 0 {
+		err = copyFile(img.Location, imgPath)
+	} else {
+		err = ioutil.WriteFile(imgPath, img.ImageData, 0644)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return svc.SignURL(img.UserID, imgName, time.Now().Add(48*time.Hour)), nil
+}
+
+// Find the selected image from id
+func (svc *LocalImageStore) Find(userID, fileName string) (string, error) {
+	imgPath, err := svc.imagePath(userID, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(imgPath); err != nil {
+		return "", errors.New(fileName + "doesn't exist")
+	}
+
+	return svc.SignURL(userID, fileName, time.Now().Add(5*time.Minute)), nil
+}
+
+// FindAllByUser return all the image for a selected user
+func (svc *LocalImageStore) FindAllByUser(userID string) ([]string, error) {
+	userPath, err := svc.imagePath(userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(userPath)
+	if err != nil || len(files) == 0 {
+		return nil, errors.New("Unknow user")
+	}
+
+	var urls []string
+	expiry := time.Now().Add(5 * time.Minute)
+	for _, file := range files {
+		if !file.IsDir() {
+			urls = append(urls, svc.SignURL(userID, file.Name(), expiry))
+		}
+	}
+
+	return urls, nil
+}
+
+// Delete remove the image file
+func (svc *LocalImageStore) Delete(userID, fileName string) error {
+	imgPath, err := svc.imagePath(userID, fileName)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(imgPath)
+}
+
+// SignURL return the url of the image which is valid until the expiry
+func (svc *LocalImageStore) SignURL(userID, imgName string, expiry time.Time) string {
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", svc.signature(userID, imgName, expires))
+
+	return svc.BaseURL + localFilesRouter + url.PathEscape(userID) + "/" + url.PathEscape(imgName) + "?" + query.Encode()
+}
+
+// Verify check the signature and the expiry of the image url
+func (svc *LocalImageStore) Verify(userID, imgName, expires, signature string, now time.Time) error {
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return errBadSignature
+	}
+
+	expected := svc.signature(userID, imgName, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errBadSignature
+	}
+
+	return nil
+}
+
+// ServeHTTP serve GET {localFilesRouter}{userID}/{imageName}?expires=...&signature=...
+func (svc *LocalImageStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, localFilesRouter), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	err := svc.Verify(parts[0], parts[1], query.Get("expires"), query.Get("signature"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	imgPath, err := svc.imagePath(parts[0], parts[1])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, imgPath)
+}
+
+func (svc *LocalImageStore) signature(userID, imgName, expires string) string {
+	mac := hmac.New(sha256.New, svc.Key)
+	mac.Write([]byte(userID + "/" + imgName + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// imagePath reject the names which may escape the root directory
+func (svc *LocalImageStore) imagePath(userID, imgName string) (string, error) {
+	for _, name := range []string{userID, imgName} {
+		if name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			return "", errors.New("Invalid name " + name)
+		}
+	}
+
+	if userID == "" {
+		return "", errors.New("Invalid user")
+	}
+
+	return filepath.Join(svc.Root, userID, imgName), nil
+}
+
+func copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	_, err = io.Copy(dstFile, srcFile)
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalImageStoreServesSignedURL(t *testing.T) {
+	root, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	store := NewLocalImageStore(root, "http://storage", []byte("key"))
+	imgURL, err := store.Save(context.Background(), Image{UserID: "user", ImageName: "a.png", ImageData: []byte("png")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := strings.TrimPrefix(imgURL, "http://storage")
+	res := httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+	if res.Code != http.StatusOK || res.Body.String() != "png" {
+		t.Errorf("expected the image to be served, got %d %q", res.Code, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", strings.Replace(path, "a.png", "b.png", 1), nil))
+	if res.Code != http.StatusForbidden {
+		t.Errorf("expected the signature to be bound to the image, got %d", res.Code)
+	}
+
+	expired := strings.TrimPrefix(store.SignURL("user", "a.png", time.Now().Add(-time.Minute)), "http://storage")
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", expired, nil))
+	if res.Code != http.StatusForbidden {
+		t.Errorf("expected the expired url to be rejected, got %d", res.Code)
+	}
+
+	if _, err := store.Save(context.Background(), Image{UserID: "..", ImageName: "a.png"}); err == nil {
+		t.Errorf("expected the name out of the root to be rejected")
+	}
+}
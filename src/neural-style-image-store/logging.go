@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -33,3 +34,61 @@ func (svc *loggingService) Find(userID, imgName string) (url string, err error)
 
 	return svc.storeService.Find(userID, imgName)
 }
+
+func (svc *loggingService) Delete(userID, imgName string) (err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "Delete", "user", userID,
+			"image", imgName, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.storeService.Delete(userID, imgName)
+}
+
+func (svc *loggingService) DeadLetters() (letters []DeadLetter, err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "DeadLetters", "count", len(letters), "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.storeService.DeadLetters()
+}
+
+func (svc *loggingService) Replay(id string) (err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "Replay", "job", id, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.storeService.Replay(id)
+}
+
+func (svc *loggingService) CreateUpload(userID, imgName string) (session UploadSession, err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "CreateUpload", "user", userID, "image", imgName, "upload", session.ID,
+			"took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.storeService.CreateUpload(userID, imgName)
+}
+
+func (svc *loggingService) FindUpload(id string) (session UploadSession, err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "FindUpload", "upload", id, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.storeService.FindUpload(id)
+}
+
+func (svc *loggingService) UploadChunk(id string, index int, checksum string, data io.Reader) (err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "UploadChunk", "upload", id, "chunk", index, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.storeService.UploadChunk(id, index, checksum, data)
+}
+
+func (svc *loggingService) CommitUpload(id string, chunks int, checksum string) (session UploadSession, err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "CommitUpload", "upload", id, "chunks", chunks, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.storeService.CommitUpload(id, chunks, checksum)
+}
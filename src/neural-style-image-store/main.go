@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -9,21 +10,51 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"neural-style-util"
+
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	mgo "gopkg.in/mgo.v2"
 )
 
 var (
-	serverURL    = flag.String("host", "0.0.0.0", "neural style server url")
-	serverPort   = flag.String("port", "5000", "neural style server port")
-	dbServerURL  = flag.String("dbserver", "apc-chain.documents.azure.com", "Mongodb server host")
-	dbServerPort = flag.String("dbport", "10255", "Mongodb server port")
-	dbUser       = flag.String("dbUser", "", "Mongodb user")
-	dbKey        = flag.String("dbPassword", "", "Mongodb password")
+	serverURL       = flag.String("host", "0.0.0.0", "neural style server url")
+	serverPort      = flag.String("port", "5000", "neural style server port")
+	dbServerURL     = flag.String("dbserver", "apc-chain.documents.azure.com", "Mongodb server host")
+	dbServerPort    = flag.String("dbport", "10255", "Mongodb server port")
+	dbUser          = flag.String("dbUser", "", "Mongodb user")
+	dbKey           = flag.String("dbPassword", "", "Mongodb password")
+	storeType       = flag.String("store", "mongo", "data store: mongo or memory")
+	backend         = flag.String("backend", "azure", "image storage backend: azure, s3 or local")
+	localRoot       = flag.String("localRoot", "./data/images", "image directory of the local backend")
+	placementPolicy = flag.String("placement", "roundrobin", "store placement of the new images: roundrobin, leastused or hash")
+	rebalance       = flag.Bool("rebalance", false, "move the images between the stores by the placement, and exit")
+	verify          = flag.Bool("verify", false, "hash the saved blobs again and report the corrupted blobs, and exit")
+	publicURL       = flag.String("publicURL", "", "url prefix of the local backend images, default is http://host:port")
+	saveTimeout     = flag.Duration("saveTimeout", 2*time.Minute, "deadline of saving an image including the retries")
+	saveRetries     = flag.Int("saveRetries", 3, "retries of the transient failed uploads")
+	retryDelay      = flag.Duration("retryDelay", time.Second, "delay before the first retry, doubled after each retry")
+	uploadDir       = flag.String("uploadDir", "./data/uploads", "directory of the chunks of the resumable uploads")
+	maxChunkSize    = flag.Int64("maxChunkSize", 8<<20, "max bytes of an upload chunk")
+	uploadExpiry    = flag.Duration("uploadExpiry", 24*time.Hour, "the uploads which aren't committed are removed after it")
+	drainTimeout    = flag.Duration("drainTimeout", 30*time.Second, "grace period of the saving images at shutdown")
+	jwksURL         = flag.String("jwks", "", "JWKS url of the data server, the dead letters need an admin token if it's set")
+	revocationDB    = flag.String("revocationDB", "", "mongodb url of the revoked tokens of the data server, default is "+
+		"the mongo store. With the memory store and no url, a revoked token is accepted until it expires")
+)
+
+var (
+	// localStorageKey sign the urls of the local backend images
+	localStorageKey = os.Getenv("LOCAL_STORAGE_KEY")
+	// maxWorker define the size of work
+	maxWorker = os.Getenv("MAX_WORKERS")
+	// maxQueue define the size of the cache queue
+	maxQueue = os.Getenv("MAX_QUEUE")
 )
 
 func main() {
@@ -31,45 +62,170 @@ func main() {
 	errChan := make(chan error)
 
 	ctx := context.Background()
-	dbAddr := *dbServerURL + ":" + *dbServerPort
 
-	dialInfo := &mgo.DialInfo{
-		Addrs:    []string{dbAddr},
-		Timeout:  10 * time.Second,
-		Database: "store",
+	// Logging domain.
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		level.NewFilter(logger, level.AllowDebug())
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 	}
 
-	dialInfo.Username = *dbUser
-	dialInfo.Password = *dbKey
-	dialInfo.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
-		return tls.Dial("tcp", addr.String(), &tls.Config{})
+	var storage StorageRepository
+	var usage UsageRepository
+	var deadLetters DeadLetterRepository
+	var blobs BlobRepository
+	var revocations NSUtil.RevocationList
+	if *storeType == NSUtil.MemoryStore {
+		storage = newMemoryStorageRepository()
+		usage = newMemoryUsageRepository()
+		deadLetters = newMemoryDeadLetterRepository()
+		blobs = newMemoryBlobRepository()
+	} else {
+		dbAddr := *dbServerURL + ":" + *dbServerPort
+
+		dialInfo := &mgo.DialInfo{
+			Addrs:    []string{dbAddr},
+			Timeout:  10 * time.Second,
+			Database: "store",
+		}
+
+		dialInfo.Username = *dbUser
+		dialInfo.Password = *dbKey
+		dialInfo.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.Dial("tcp", addr.String(), &tls.Config{})
+		}
+
+		session, err := mgo.DialWithInfo(dialInfo)
+
+		if err != nil {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+			errChan <- fmt.Errorf("%s", <-c)
+		}
+		defer session.Close()
+		session.SetMode(mgo.Monotonic, true)
+
+		storage = &mgoStorageRepository{session: session}
+		usage = &mgoUsageRepository{session: session}
+		deadLetters = &mgoDeadLetterRepository{session: session}
+		blobs = &mgoBlobRepository{session: session}
+		revocations = NSUtil.NewReadOnlyRevocationList(session)
+
+		// a blob is inserted once for the same content
+		err = session.DB("store").C("storage_blobs").EnsureIndex(mgo.Index{Key: []string{"sha256"}, Unique: true})
+		if err != nil {
+			level.Error(logger).Log("API", "EnsureIndex", "info", err)
+			return
+		}
 	}
+	if *revocationDB != "" {
+		session, err := mgo.Dial(*revocationDB)
+		if err != nil {
+			level.Error(logger).Log("API", "mgo.Dial", "info", err)
+			return
+		}
+		defer session.Close()
+		session.SetMode(mgo.Monotonic, true)
+		revocations = NSUtil.NewReadOnlyRevocationList(session)
+	}
+
+	var files http.Handler
+	var entries []StoreEntry
+	switch *backend {
+	case localBackend:
+		local := newLocalStore(logger)
+		files = local
+		entries = append(entries, StoreEntry{Location: StorageInfo{Backend: localBackend}, Store: local})
+	case s3Backend:
+		store, err := NewS3ImageStore()
+		if err != nil {
+			level.Error(logger).Log("API", "NewS3ImageStore", "info", err)
+			return
+		}
 
-	session, err := mgo.DialWithInfo(dialInfo)
+		entries = append(entries, StoreEntry{Location: StorageInfo{Backend: s3Backend, Bucket: store.Bucket}, Store: store})
+	default:
+		stores, err := NewAzureImageStores()
+		if err != nil {
+			level.Error(logger).Log("API", "NewAzureImageStores", "info", err)
+			return
+		}
 
+		for _, store := range stores {
+			entries = append(entries, StoreEntry{
+				Location: StorageInfo{Backend: azureBackend, Account: store.StorageAccount},
+				Store:    store,
+			})
+		}
+	}
+
+	placement, err := NewPlacement(*placementPolicy, entries, usage)
 	if err != nil {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		errChan <- fmt.Errorf("%s", <-c)
+		level.Error(logger).Log("API", "NewPlacement", "info", err)
+		return
 	}
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
 
-	// Logging domain.
-	var logger log.Logger
-	{
-		logger = log.NewLogfmtLogger(os.Stderr)
-		level.NewFilter(logger, level.AllowDebug())
-		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	if *rebalance {
+		rebalancer := &Rebalancer{Storage: storage, Blobs: blobs, Usage: usage, Entries: entries,
+			Placement: placement, Client: &http.Client{Timeout: 10 * time.Minute}, Logger: logger}
+		moved, err := rebalancer.Run()
+		level.Info(logger).Log("API", "Rebalance", "moved", moved, "err", err)
+		return
+	}
+
+	if *verify {
+		stores := make(map[string]ImageStore)
+		for _, entry := range entries {
+			stores[entry.Location.storeKey()] = entry.Store
+		}
+
+		verifier := &Verifier{Blobs: blobs, Stores: stores, Client: &http.Client{Timeout: 10 * time.Minute},
+			Logger: logger}
+		report, err := verifier.Run()
+		level.Info(logger).Log("API", "Verify", "checked", report.Checked, "corrupted", len(report.Corrupted),
+			"missing", len(report.Missing), "failed", len(report.Failed), "err", err)
+		return
+	}
+
+	queueSize, err := strconv.Atoi(maxQueue)
+	if err != nil {
+		queueSize = 2
+	}
+
+	workerSize, err := strconv.Atoi(maxWorker)
+	if err != nil {
+		workerSize = 2
+	}
+
+	retry := RetryPolicy{MaxRetries: *saveRetries, BaseDelay: *retryDelay, MaxDelay: 30 * time.Second}
+	dispatcher := NewDispatcher(workerSize, queueSize, entries, placement, retry, deadLetters,
+		log.With(logger, "component", "dispatcher"))
+	dispatcher.Run()
+
+	uploads := NewUploads(*uploadDir, *maxChunkSize)
+	go func() {
+		for range time.Tick(time.Hour) {
+			removed := uploads.Expire(time.Now().Add(-*uploadExpiry))
+			level.Debug(logger).Log("API", "Uploads.Expire", "removed", removed)
+		}
+	}()
+
+	storageService := NewStorageService(storage, usage, deadLetters, blobs, dispatcher, uploads, *saveTimeout, logger)
+	// the admin tokens are verified by the public keys of the data server, and checked in its revoked tokens
+	admin := func(next endpoint.Endpoint) endpoint.Endpoint { return next }
+	if *jwksURL != "" {
+		NSUtil.Keys = NSUtil.NewRemoteKeySet(*jwksURL)
+		admin = NSUtil.AdminMiddleware(logger, revocations)
 	}
 
-	r := makeHTTPHandler(ctx, session, logger)
+	r := makeHTTPHandler(ctx, storageService, files, admin, logger)
 
 	// HTTP transport
+	server := &http.Server{Addr: *serverURL + ":" + *serverPort, Handler: r}
 	go func() {
 		level.Debug(logger).Log("info", "Starting server at "+*serverURL+":"+*serverPort)
-		handler := r
-		errChan <- http.ListenAndServe(*serverURL+":"+*serverPort, handler)
+		errChan <- server.ListenAndServe()
 	}()
 
 	go func() {
@@ -79,4 +235,34 @@ func main() {
 	}()
 	errinfo := <-errChan
 	level.Debug(logger).Log("info", "crash info: "+errinfo.Error())
+
+	// the new images are rejected by 503 while the saving images are drained
+	level.Info(logger).Log("info", "draining the saving images", "grace", *drainTimeout)
+	abandoned := storageService.Shutdown(*drainTimeout)
+	for _, job := range abandoned {
+		level.Error(logger).Log("API", "Shutdown", "job", job.ID, "user", job.UploadImage.UserID,
+			"image", job.UploadImage.ImageName, "info", "abandoned and kept in the dead letters")
+	}
+	level.Info(logger).Log("info", "storage service stopped", "abandoned", len(abandoned))
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+}
+
+func newLocalStore(logger log.Logger) *LocalImageStore {
+	baseURL := *publicURL
+	if baseURL == "" {
+		baseURL = "http://" + *serverURL + ":" + *serverPort
+	}
+
+	key := []byte(localStorageKey)
+	if len(key) == 0 {
+		// the urls signed before the restart become invalid
+		key = make([]byte, 32)
+		rand.Read(key)
+		level.Info(logger).Log("info", "LOCAL_STORAGE_KEY is empty, use a random key")
+	}
+
+	return NewLocalImageStore(*localRoot, baseURL, key)
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// placement policy
+const (
+	roundRobinPolicy = "roundrobin"
+	leastUsedPolicy  = "leastused"
+	hashPolicy       = "hash"
+)
+
+// hashReplicas is the virtual nodes of each store on the consistent hash ring
+const hashReplicas = 100
+
+// StoreEntry define an image store and where it saves the images
+type StoreEntry struct {
+	Location StorageInfo
+	Store    ImageStore
+}
+
+// Placement choose the image store for a new image
+type Placement interface {
+	Place(img Image) (StoreEntry, error)
+}
+
+// NewPlacement create the placement policy over the stores, the least used policy reads the usage of the stores
+func NewPlacement(policy string, entries []StoreEntry, usage UsageRepository) (Placement, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("No image store")
+	}
+
+	switch policy {
+	case roundRobinPolicy:
+		return &roundRobinPlacement{entries: entries}, nil
+	case leastUsedPolicy:
+		return &leastUsedPlacement{entries: entries, usage: usage}, nil
+	case hashPolicy:
+		return newHashPlacement(entries), nil
+	}
+
+	return nil, errors.New("Unknown placement policy " + policy)
+}
+
+type roundRobinPlacement struct {
+	entries []StoreEntry
+	next    uint32
+}
+
+func (p *roundRobinPlacement) Place(img Image) (StoreEntry, error) {
+	index := atomic.AddUint32(&p.next, 1) - 1
+	return p.entries[int(index)%len(p.entries)], nil
+}
+
+// leastUsedPlacement choose the store with the least bytes
+type leastUsedPlacement struct {
+	entries []StoreEntry
+	usage   UsageRepository
+}
+
+func (p *leastUsedPlacement) Place(img Image) (StoreEntry, error) {
+	usages, err := p.usage.FindAll()
+	if err != nil {
+		return StoreEntry{}, err
+	}
+
+	bytes := make(map[string]int64)
+	for _, usage := range usages {
+		bytes[usage.Store] = usage.Bytes
+	}
+
+	selected := p.entries[0]
+	for _, entry := range p.entries[1:] {
+		if bytes[entry.Location.storeKey()] < bytes[selected.Location.storeKey()] {
+			selected = entry
+		}
+	}
+
+	return selected, nil
+}
+
+// hashPlacement keep all the images of a user in one store, and adding a store only moves about 1/N users.
+// The blobs are shared by the users, so they are placed by their names.
+type hashPlacement struct {
+	ring    []uint32
+	entries map[uint32]StoreEntry
+}
+
+func newHashPlacement(entries []StoreEntry) *hashPlacement {
+	p := &hashPlacement{entries: make(map[uint32]StoreEntry)}
+	for _, entry := range entries {
+		for i := 0; i < hashReplicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(entry.Location.storeKey() + "#" + strconv.Itoa(i)))
+			p.ring = append(p.ring, hash)
+			p.entries[hash] = entry
+		}
+	}
+
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i] < p.ring[j] })
+	return p
+}
+
+func (p *hashPlacement) Place(img Image) (StoreEntry, error) {
+	key := img.UserID
+	if img.UserID == blobContainer {
+		key = img.ImageName
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	index := sort.Search(len(p.ring), func(i int) bool { return p.ring[i] >= hash })
+	if index == len(p.ring) {
+		index = 0
+	}
+
+	return p.entries[p.ring[index]], nil
+}
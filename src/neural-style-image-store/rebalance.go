@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+var errFileMissing = errors.New("The file doesn't exist")
+
+// Rebalancer move the images between the stores. With the hash placement each image is moved to the
+// store owning its user, otherwise the images are moved from the stores above the average bytes to the
+// least used store. A blob is moved as one image with all the images referring to it.
+type Rebalancer struct {
+	Storage   StorageRepository
+	Blobs     BlobRepository
+	Usage     UsageRepository
+	Entries   []StoreEntry
+	Placement Placement
+	Client    *http.Client
+	Logger    log.Logger
+}
+
+// Run move the images, and return how many images are moved
+func (r *Rebalancer) Run() (int, error) {
+	if _, ok := r.Placement.(*hashPlacement); ok {
+		return r.moveToOwners()
+	}
+
+	return r.level()
+}
+
+func (r *Rebalancer) moveToOwners() (int, error) {
+	moved := 0
+	for _, src := range r.Entries {
+		items, err := r.items(src)
+		if err != nil {
+			return moved, err
+		}
+
+		for _, item := range items {
+			info := item.info
+			dst, err := r.Placement.Place(Image{UserID: info.UserID, ImageName: info.Name})
+			if err != nil {
+				return moved, err
+			}
+
+			if dst.Location.storeKey() == src.Location.storeKey() {
+				continue
+			}
+
+			if r.move(item, src, dst) {
+				moved++
+			}
+		}
+	}
+
+	return moved, nil
+}
+
+func (r *Rebalancer) level() (int, error) {
+	usages, err := r.Usage.FindAll()
+	if err != nil {
+		return 0, err
+	}
+
+	bytes := make(map[string]int64)
+	for _, usage := range usages {
+		bytes[usage.Store] = usage.Bytes
+	}
+
+	var total int64
+	for _, entry := range r.Entries {
+		total += bytes[entry.Location.storeKey()]
+	}
+	average := total / int64(len(r.Entries))
+
+	moved := 0
+	for _, src := range r.Entries {
+		srcKey := src.Location.storeKey()
+		if bytes[srcKey] <= average {
+			continue
+		}
+
+		items, err := r.items(src)
+		if err != nil {
+			return moved, err
+		}
+
+		for _, item := range items {
+			info := item.info
+			if bytes[srcKey] <= average {
+				break
+			}
+
+			dst := r.Entries[0]
+			for _, entry := range r.Entries[1:] {
+				if bytes[entry.Location.storeKey()] < bytes[dst.Location.storeKey()] {
+					dst = entry
+				}
+			}
+
+			// moving the image doesn't make the stores closer to the average
+			if info.Size == 0 || bytes[dst.Location.storeKey()]+info.Size > average {
+				continue
+			}
+
+			if r.move(item, src, dst) {
+				bytes[srcKey] -= info.Size
+				bytes[dst.Location.storeKey()] += info.Size
+				moved++
+			}
+		}
+	}
+
+	return moved, nil
+}
+
+// rebalanceItem is a file to move, update records the new location of the file
+type rebalanceItem struct {
+	info   StorageInfo
+	update func(location StorageInfo) error
+}
+
+// items return the images and the blobs saved in the store
+func (r *Rebalancer) items(src StoreEntry) ([]rebalanceItem, error) {
+	infos, err := r.Storage.FindByStore(src.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []rebalanceItem
+	for _, info := range infos {
+		info := info
+		items = append(items, rebalanceItem{info: info, update: func(location StorageInfo) error {
+			moved := info
+			moved.Backend = location.Backend
+			moved.Account = location.Account
+			moved.Bucket = location.Bucket
+			return r.Storage.Update(moved)
+		}})
+	}
+
+	blobs, err := r.Blobs.FindByStore(src.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blob := range blobs {
+		blob := blob
+		info := StorageInfo{Key: blob.SHA256, UserID: blobContainer, Name: blob.Name, Size: blob.Size}
+		items = append(items, rebalanceItem{info: info, update: func(location StorageInfo) error {
+			moved := blob
+			moved.Backend = location.Backend
+			moved.Account = location.Account
+			moved.Bucket = location.Bucket
+			return r.Blobs.Update(moved)
+		}})
+	}
+
+	return items, nil
+}
+
+// move copy the image to the destination store, update the record and then delete the source image.
+// The failed image is logged and skipped.
+func (r *Rebalancer) move(item rebalanceItem, src, dst StoreEntry) bool {
+	info := item.info
+	if info.UserID == "" || info.Name == "" {
+		level.Error(r.Logger).Log("API", "Rebalance", "key", info.Key, "info", "no user and name recorded")
+		return false
+	}
+
+	data, err := r.download(src, info)
+	if err == nil {
+		_, err = dst.Store.Save(context.Background(), Image{UserID: info.UserID, ImageName: info.Name, ImageData: data})
+	}
+
+	if err != nil {
+		level.Error(r.Logger).Log("API", "Rebalance", "key", info.Key, "from", src.Location.storeKey(),
+			"to", dst.Location.storeKey(), "info", err)
+		return false
+	}
+
+	err = item.update(dst.Location)
+	if err != nil {
+		level.Error(r.Logger).Log("API", "Rebalance", "key", info.Key, "info", err)
+		dst.Store.Delete(info.UserID, info.Name)
+		return false
+	}
+
+	err = src.Store.Delete(info.UserID, info.Name)
+	if err != nil {
+		level.Error(r.Logger).Log("API", "Rebalance", "key", info.Key, "info", "source isn't deleted", "err", err)
+	}
+
+	r.Usage.Add(src.Location.storeKey(), -info.Size, -1)
+	r.Usage.Add(dst.Location.storeKey(), info.Size, 1)
+	level.Debug(r.Logger).Log("API", "Rebalance", "key", info.Key, "from", src.Location.storeKey(),
+		"to", dst.Location.storeKey())
+	return true
+}
+
+func (r *Rebalancer) download(src StoreEntry, info StorageInfo) ([]byte, error) {
+	body, err := openFile(r.Client, src.Store, info.UserID, info.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+// openFile download the file from the store, it returns errFileMissing if the store doesn't have the file
+func openFile(client *http.Client, store ImageStore, userID, name string) (io.ReadCloser, error) {
+	url, err := store.Find(userID, name)
+	if err != nil {
+		return nil, errFileMissing
+	}
+
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errFileMissing
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errors.New("download fails: " + res.Status)
+	}
+
+	return res.Body, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRebalancerLevelsStores(t *testing.T) {
+	root, err := ioutil.TempDir("", "rebalance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var entries []StoreEntry
+	for _, account := range []string{"a", "b"} {
+		store := NewLocalImageStore(root+"/"+account, server.URL+"/"+account, []byte("key"))
+		mux.Handle("/"+account+localFilesRouter, http.StripPrefix("/"+account, store))
+		entries = append(entries, StoreEntry{Location: StorageInfo{Backend: azureBackend, Account: account}, Store: store})
+	}
+
+	storage := newMemoryStorageRepository()
+	usage := newMemoryUsageRepository()
+	for _, name := range []string{"1.png", "2.png", "3.png", "4.png"} {
+		entries[0].Store.Save(context.Background(), Image{UserID: "user", ImageName: name, ImageData: []byte("data")})
+		storage.Insert(StorageInfo{Key: "user" + name, UserID: "user", Name: name, Size: 4,
+			Backend: azureBackend, Account: "a"})
+		usage.Add(entries[0].Location.storeKey(), 4, 1)
+	}
+
+	placement, _ := NewPlacement(leastUsedPolicy, entries, usage)
+	rebalancer := &Rebalancer{Storage: storage, Blobs: newMemoryBlobRepository(), Usage: usage, Entries: entries, Placement: placement,
+		Client: server.Client(), Logger: log.NewNopLogger()}
+
+	moved, err := rebalancer.Run()
+	if err != nil || moved != 2 {
+		t.Fatalf("expected 2 images to be moved, got %d %v", moved, err)
+	}
+
+	infos, _ := storage.FindByStore(entries[1].Location)
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 records on the account b, got %d", len(infos))
+	}
+
+	for _, info := range infos {
+		if _, err := entries[1].Store.Find(info.UserID, info.Name); err != nil {
+			t.Errorf("expected %s to be copied to the account b", info.Name)
+		}
+
+		if _, err := entries[0].Store.Find(info.UserID, info.Name); err == nil {
+			t.Errorf("expected %s to be deleted from the account a", info.Name)
+		}
+	}
+}
+
+func TestHashPlacementIsStable(t *testing.T) {
+	var entries []StoreEntry
+	for _, account := range []string{"a", "b", "c"} {
+		entries = append(entries, StoreEntry{Location: StorageInfo{Backend: azureBackend, Account: account}})
+	}
+
+	placement, _ := NewPlacement(hashPolicy, entries, nil)
+	first, _ := placement.Place(Image{UserID: "user"})
+	for i := 0; i < 10; i++ {
+		entry, _ := placement.Place(Image{UserID: "user"})
+		if entry.Location.Account != first.Location.Account {
+			t.Fatalf("expected the images of a user in the same account")
+		}
+	}
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"neural-style-util"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// StorageRepository define the data access of the storage accounts of the images
+type StorageRepository interface {
+	Insert(info StorageInfo) error
+	// FindByKey return NSUtil.ErrNotFound if the image isn't saved
+	FindByKey(key string) (StorageInfo, error)
+	// Remove return NSUtil.ErrNotFound if the image isn't saved
+	Remove(key string) error
+	// FindByStore return the images saved in the store of the location
+	FindByStore(location StorageInfo) ([]StorageInfo, error)
+	// Update replace the record with the same key
+	Update(info StorageInfo) error
+}
+
+// StorageUsage define the bytes and the count of the images in an image store
+type StorageUsage struct {
+	Store string
+	Bytes int64
+	Count int64
+}
+
+// UsageRepository track the usage of the image stores keyed by StorageInfo.storeKey
+type UsageRepository interface {
+	Add(store string, bytes, count int64) error
+	FindAll() ([]StorageUsage, error)
+}
+
+// Blob define the content shared by the images with the same SHA-256, it's saved as Name in blobContainer.
+// Refs is how many images refer to the blob, the blob is deleted when no image refers to it.
+type Blob struct {
+	SHA256   string
+	Name     string
+	Size     int64
+	MimeType string
+	Backend  string
+	Account  string
+	Bucket   string
+	Refs     int64
+}
+
+// location return where the blob is saved
+func (blob Blob) location() StorageInfo {
+	return StorageInfo{Backend: blob.Backend, Account: blob.Account, Bucket: blob.Bucket}
+}
+
+// BlobRepository define the data access of the content addressed blobs keyed by their SHA-256
+type BlobRepository interface {
+	// Insert return NSUtil.ErrDuplicated if the blob exists
+	Insert(blob Blob) error
+	// FindBySHA return NSUtil.ErrNotFound if the blob doesn't exist
+	FindBySHA(sha string) (Blob, error)
+	// AddRef add delta to the references, and return the blob after the change or NSUtil.ErrNotFound
+	AddRef(sha string, delta int64) (Blob, error)
+	// RemoveUnreferenced remove the blob only if no image refers to it, and return NSUtil.ErrNotFound otherwise
+	RemoveUnreferenced(sha string) error
+	// FindByStore return the blobs saved in the store of the location
+	FindByStore(location StorageInfo) ([]Blob, error)
+	FindAll() ([]Blob, error)
+	// Update replace the blob with the same SHA-256
+	Update(blob Blob) error
+}
+
+// DeadLetter keep the upload job which fails after the retries, the image data isn't listed
+type DeadLetter struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	ImageName string    `json:"imageName"`
+	ImageData []byte    `json:"-"`
+	Location  string    `json:"location"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	Time      time.Time `json:"time"`
+}
+
+// DeadLetterRepository define the data access of the failed upload jobs
+type DeadLetterRepository interface {
+	// Save replace the dead letter with the same id
+	Save(letter DeadLetter) error
+	// FindAll return the dead letters in time order
+	FindAll() ([]DeadLetter, error)
+	// FindByID return NSUtil.ErrNotFound if the dead letter doesn't exist
+	FindByID(id string) (DeadLetter, error)
+	// Remove return NSUtil.ErrNotFound if the dead letter doesn't exist
+	Remove(id string) error
+}
+
+type mgoStorageRepository struct {
+	session *mgo.Session
+}
+
+func (repo *mgoStorageRepository) Insert(info StorageInfo) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("storage").Insert(info)
+}
+
+func (repo *mgoStorageRepository) FindByKey(key string) (StorageInfo, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var info StorageInfo
+	err := session.DB("store").C("storage").Find(bson.M{"key": key}).One(&info)
+	if err == mgo.ErrNotFound {
+		return info, NSUtil.ErrNotFound
+	}
+
+	return info, err
+}
+
+func (repo *mgoStorageRepository) Remove(key string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("storage").Remove(bson.M{"key": key})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoStorageRepository) FindByStore(location StorageInfo) ([]StorageInfo, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	query := bson.M{"backend": location.Backend, "account": location.Account, "bucket": location.Bucket}
+	if location.Backend == azureBackend {
+		// the images saved before the backend is recorded are on azure
+		query = bson.M{"backend": bson.M{"$in": []interface{}{azureBackend, "", nil}}, "account": location.Account}
+	}
+
+	// the images saved as the blobs are found by the blobs
+	query["sha256"] = bson.M{"$in": []interface{}{"", nil}}
+
+	var infos []StorageInfo
+	err := session.DB("store").C("storage").Find(query).All(&infos)
+	return infos, err
+}
+
+func (repo *mgoStorageRepository) Update(info StorageInfo) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("storage").Update(bson.M{"key": info.Key}, info)
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+type mgoUsageRepository struct {
+	session *mgo.Session
+}
+
+func (repo *mgoUsageRepository) Add(store string, bytes, count int64) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB("store").C("storage_usage").Upsert(bson.M{"store": store},
+		bson.M{"$inc": bson.M{"bytes": bytes, "count": count}})
+	return err
+}
+
+func (repo *mgoUsageRepository) FindAll() ([]StorageUsage, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var usages []StorageUsage
+	err := session.DB("store").C("storage_usage").Find(nil).All(&usages)
+	return usages, err
+}
+
+type mgoBlobRepository struct {
+	session *mgo.Session
+}
+
+func (repo *mgoBlobRepository) Insert(blob Blob) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("storage_blobs").Insert(blob)
+	if mgo.IsDup(err) {
+		return NSUtil.ErrDuplicated
+	}
+
+	return err
+}
+
+func (repo *mgoBlobRepository) FindBySHA(sha string) (Blob, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var blob Blob
+	err := session.DB("store").C("storage_blobs").Find(bson.M{"sha256": sha}).One(&blob)
+	if err == mgo.ErrNotFound {
+		return blob, NSUtil.ErrNotFound
+	}
+
+	return blob, err
+}
+
+func (repo *mgoBlobRepository) AddRef(sha string, delta int64) (Blob, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var blob Blob
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"refs": delta}}, ReturnNew: true}
+	_, err := session.DB("store").C("storage_blobs").Find(bson.M{"sha256": sha}).Apply(change, &blob)
+	if err == mgo.ErrNotFound {
+		return blob, NSUtil.ErrNotFound
+	}
+
+	return blob, err
+}
+
+func (repo *mgoBlobRepository) RemoveUnreferenced(sha string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("storage_blobs").Remove(bson.M{"sha256": sha, "refs": bson.M{"$lte": 0}})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoBlobRepository) FindByStore(location StorageInfo) ([]Blob, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	query := bson.M{"backend": location.Backend, "account": location.Account, "bucket": location.Bucket}
+	var blobs []Blob
+	err := session.DB("store").C("storage_blobs").Find(query).All(&blobs)
+	return blobs, err
+}
+
+func (repo *mgoBlobRepository) FindAll() ([]Blob, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var blobs []Blob
+	err := session.DB("store").C("storage_blobs").Find(nil).All(&blobs)
+	return blobs, err
+}
+
+func (repo *mgoBlobRepository) Update(blob Blob) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("storage_blobs").Update(bson.M{"sha256": blob.SHA256}, blob)
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+type mgoDeadLetterRepository struct {
+	session *mgo.Session
+}
+
+func (repo *mgoDeadLetterRepository) Save(letter DeadLetter) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB("store").C("storage_deadletters").Upsert(bson.M{"id": letter.ID}, letter)
+	return err
+}
+
+func (repo *mgoDeadLetterRepository) FindAll() ([]DeadLetter, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var letters []DeadLetter
+	err := session.DB("store").C("storage_deadletters").Find(nil).Select(bson.M{"imagedata": 0}).
+		Sort("time").All(&letters)
+	return letters, err
+}
+
+func (repo *mgoDeadLetterRepository) FindByID(id string) (DeadLetter, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var letter DeadLetter
+	err := session.DB("store").C("storage_deadletters").Find(bson.M{"id": id}).One(&letter)
+	if err == mgo.ErrNotFound {
+		return letter, NSUtil.ErrNotFound
+	}
+
+	return letter, err
+}
+
+func (repo *mgoDeadLetterRepository) Remove(id string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("storage_deadletters").Remove(bson.M{"id": id})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+type memoryStorageRepository struct {
+	mutex sync.RWMutex
+	infos map[string]StorageInfo
+}
+
+func newMemoryStorageRepository() *memoryStorageRepository {
+	return &memoryStorageRepository{infos: make(map[string]StorageInfo)}
+}
+
+func (repo *memoryStorageRepository) Insert(info StorageInfo) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.infos[info.Key] = info
+	return nil
+}
+
+func (repo *memoryStorageRepository) FindByKey(key string) (StorageInfo, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	info, ok := repo.infos[key]
+	if !ok {
+		return info, NSUtil.ErrNotFound
+	}
+
+	return info, nil
+}
+
+func (repo *memoryStorageRepository) Remove(key string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.infos[key]; !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	delete(repo.infos, key)
+	return nil
+}
+
+func (repo *memoryStorageRepository) FindByStore(location StorageInfo) ([]StorageInfo, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var infos []StorageInfo
+	for _, info := range repo.infos {
+		if info.SHA256 == "" && info.storeKey() == location.storeKey() {
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+func (repo *memoryStorageRepository) Update(info StorageInfo) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.infos[info.Key]; !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	repo.infos[info.Key] = info
+	return nil
+}
+
+type memoryUsageRepository struct {
+	mutex  sync.RWMutex
+	usages map[string]StorageUsage
+}
+
+func newMemoryUsageRepository() *memoryUsageRepository {
+	return &memoryUsageRepository{usages: make(map[string]StorageUsage)}
+}
+
+func (repo *memoryUsageRepository) Add(store string, bytes, count int64) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	usage := repo.usages[store]
+	usage.Store = store
+	usage.Bytes += bytes
+	usage.Count += count
+	repo.usages[store] = usage
+	return nil
+}
+
+func (repo *memoryUsageRepository) FindAll() ([]StorageUsage, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var usages []StorageUsage
+	for _, usage := range repo.usages {
+		usages = append(usages, usage)
+	}
+
+	return usages, nil
+}
+
+type memoryBlobRepository struct {
+	mutex sync.RWMutex
+	blobs map[string]Blob
+}
+
+func newMemoryBlobRepository() *memoryBlobRepository {
+	return &memoryBlobRepository{blobs: make(map[string]Blob)}
+}
+
+func (repo *memoryBlobRepository) Insert(blob Blob) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.blobs[blob.SHA256]; ok {
+		return NSUtil.ErrDuplicated
+	}
+
+	repo.blobs[blob.SHA256] = blob
+	return nil
+}
+
+func (repo *memoryBlobRepository) FindBySHA(sha string) (Blob, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	blob, ok := repo.blobs[sha]
+	if !ok {
+		return blob, NSUtil.ErrNotFound
+	}
+
+	return blob, nil
+}
+
+func (repo *memoryBlobRepository) AddRef(sha string, delta int64) (Blob, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	blob, ok := repo.blobs[sha]
+	if !ok {
+		return blob, NSUtil.ErrNotFound
+	}
+
+	blob.Refs += delta
+	repo.blobs[sha] = blob
+	return blob, nil
+}
+
+func (repo *memoryBlobRepository) RemoveUnreferenced(sha string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	blob, ok := repo.blobs[sha]
+	if !ok || blob.Refs > 0 {
+		return NSUtil.ErrNotFound
+	}
+
+	delete(repo.blobs, sha)
+	return nil
+}
+
+func (repo *memoryBlobRepository) FindByStore(location StorageInfo) ([]Blob, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var blobs []Blob
+	for _, blob := range repo.blobs {
+		if blob.location().storeKey() == location.storeKey() {
+			blobs = append(blobs, blob)
+		}
+	}
+
+	return blobs, nil
+}
+
+func (repo *memoryBlobRepository) FindAll() ([]Blob, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var blobs []Blob
+	for _, blob := range repo.blobs {
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, nil
+}
+
+func (repo *memoryBlobRepository) Update(blob Blob) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.blobs[blob.SHA256]; !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	repo.blobs[blob.SHA256] = blob
+	return nil
+}
+
+type memoryDeadLetterRepository struct {
+	mutex   sync.RWMutex
+	letters map[string]DeadLetter
+}
+
+func newMemoryDeadLetterRepository() *memoryDeadLetterRepository {
+	return &memoryDeadLetterRepository{letters: make(map[string]DeadLetter)}
+}
+
+func (repo *memoryDeadLetterRepository) Save(letter DeadLetter) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.letters[letter.ID] = letter
+	return nil
+}
+
+func (repo *memoryDeadLetterRepository) FindAll() ([]DeadLetter, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var letters []DeadLetter
+	for _, letter := range repo.letters {
+		letters = append(letters, letter)
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].Time.Before(letters[j].Time)
+	})
+
+	return letters, nil
+}
+
+func (repo *memoryDeadLetterRepository) FindByID(id string) (DeadLetter, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	letter, ok := repo.letters[id]
+	if !ok {
+		return letter, NSUtil.ErrNotFound
+	}
+
+	return letter, nil
+}
+
+func (repo *memoryDeadLetterRepository) Remove(id string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.letters[id]; !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	delete(repo.letters, id)
+	return nil
+}
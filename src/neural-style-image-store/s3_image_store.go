@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+var (
+	s3Endpoint  = os.Getenv("S3_ENDPOINT")
+	s3AccessKey = os.Getenv("S3_ACCESS_KEY")
+	s3SecretKey = os.Getenv("S3_SECRET_KEY")
+	s3Bucket    = os.Getenv("S3_BUCKET")
+	s3Secure    = os.Getenv("S3_SECURE")
+)
+
+// S3ImageStore store the image as the object {userID}/{imageName} in the bucket of
+// a S3 compatible storage, e.g. AWS S3 or MinIO
+type S3ImageStore struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// NewS3ImageStore create the S3 image storage from the environments, and create the bucket if it doesn't exist
+func NewS3ImageStore() (*S3ImageStore, error) {
+	secure, err := strconv.ParseBool(s3Secure)
+	if err != nil {
+		secure = true
+	}
+
+	client, err := minio.New(s3Endpoint, s3AccessKey, s3SecretKey, secure)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(s3Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		err = client.MakeBucket(s3Bucket, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3ImageStore{Client: client, Bucket: s3Bucket}, nil
+}
+
+// Save image as a S3 object
+func (svc *S3ImageStore) Save(ctx context.Context, img Image) (string, error) {
+	var err error
+	objectName := img.UserID + "/" + img.storedName()
+	if len(img.Location) != 0 {
+		_, err = svc.Client.FPutObjectWithContext(ctx, svc.Bucket, objectName, img.Location, minio.PutObjectOptions{
+			ContentType: mime.TypeByExtension(filepath.Ext(img.storedName()))})
+	} else {
+		_, err = svc.Client.PutObjectWithContext(ctx, svc.Bucket, objectName, bytes.NewReader(img.ImageData),
+			int64(len(img.ImageData)), minio.PutObjectOptions{ContentType: mime.TypeByExtension(filepath.Ext(img.ImageName))})
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return svc.presign(objectName, 48*time.Hour)
+}
+
+// Find the selected image from id
+func (svc *S3ImageStore) Find(userID, fileName string) (string, error) {
+	objectName := userID + "/" + fileName
+	_, err := svc.Client.StatObject(svc.Bucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return "", errors.New(fileName + "doesn't exist")
+	}
+
+	return svc.presign(objectName, 5*time.Minute)
+}
+
+// FindAllByUser return all the image for a selected user, the objects are listed page by page
+func (svc *S3ImageStore) FindAllByUser(userID string) ([]string, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	var urls []string
+	for object := range svc.Client.ListObjectsV2(svc.Bucket, userID+"/", true, done) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		url, err := svc.presign(object.Key, 5*time.Minute)
+		if err != nil {
+			return nil, err
+		}
+
+		urls = append(urls, url)
+	}
+
+	if len(urls) != 0 {
+		return urls, nil
+	}
+
+	return nil, errors.New("Unknow user")
+}
+
+// Delete remove the S3 object of the image
+func (svc *S3ImageStore) Delete(userID, fileName string) error {
+	return svc.Client.RemoveObject(svc.Bucket, userID+"/"+fileName)
+}
+
+func (svc *S3ImageStore) presign(objectName string, expiry time.Duration) (string, error) {
+	url, err := svc.Client.PresignedGetObject(svc.Bucket, objectName, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return url.String(), nil
+}
@@ -1,32 +1,90 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"neural-style-util"
+
 	"github.com/go-kit/kit/log"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/go-kit/kit/log/level"
 )
 
 // Service define the basic interface for store the image to the cloud storage
 type Service interface {
 	Save(userID, imgName string, imgData []byte) error
 	Find(userID, imgName string) (string, error)
+	Delete(userID, imgName string) error
+	DeadLetters() ([]DeadLetter, error)
+	Replay(id string) error
+	CreateUpload(userID, imgName string) (UploadSession, error)
+	FindUpload(id string) (UploadSession, error)
+	UploadChunk(id string, index int, checksum string, data io.Reader) error
+	CommitUpload(id string, chunks int, checksum string) (UploadSession, error)
 }
 
 // StorageService define the basic storage service
 type StorageService struct {
-	dbSession *mgo.Session
-	logger    log.Logger
+	storage     StorageRepository
+	usage       UsageRepository
+	deadLetters DeadLetterRepository
+	blobs       BlobRepository
+	blobLocks   blobLocks
+	dispatcher  *Dispatcher
+	uploads     *Uploads
+	timeout     time.Duration
+	logger      log.Logger
 }
 
-// StorageInfo define the azure storage account information
+// storage backend type
+const (
+	azureBackend = "azure"
+	localBackend = "local"
+	s3Backend    = "s3"
+)
+
+// StorageInfo define where the image is saved, Account is the azure storage account and Bucket is the S3 bucket.
+// The image with SHA256 is saved as the blob of its content, and the blob records where it's saved.
 type StorageInfo struct {
-	Key     string
-	Account string
+	Key      string
+	UserID   string
+	Name     string
+	Size     int64
+	Backend  string
+	Account  string
+	Bucket   string
+	SHA256   string
+	MimeType string
+}
+
+// storeKey identify the image store in Dispatcher.Stores, the images saved before the backend is recorded are on azure
+func (info StorageInfo) storeKey() string {
+	backend := info.Backend
+	if backend == "" {
+		backend = azureBackend
+	}
+
+	return backend + "/" + info.Account + "/" + info.Bucket
 }
 
-// NewStorageService generate a new storage service
-func NewStorageService(session *mgo.Session, logger log.Logger) *StorageService {
-	return &StorageService{dbSession: session, logger: logger}
+var (
+	errSaveTimeout = NSUtil.NewErrorWithStatus(http.StatusGatewayTimeout,
+		"The image isn't saved in time, it will be kept in the dead letters")
+	errQueueFull = NSUtil.NewErrorWithStatus(http.StatusServiceUnavailable,
+		"The storage service is busy, please try it later")
+)
+
+// NewStorageService generate a new storage service which saves the images by the dispatcher, the images which
+// aren't saved in the timeout are kept in the dead letters. The images with the same content share a blob.
+// The large images are uploaded in chunks by the uploads.
+func NewStorageService(storage StorageRepository, usage UsageRepository, deadLetters DeadLetterRepository,
+	blobs BlobRepository, dispatcher *Dispatcher, uploads *Uploads, timeout time.Duration,
+	logger log.Logger) *StorageService {
+	return &StorageService{storage: storage, usage: usage, deadLetters: deadLetters, blobs: blobs,
+		dispatcher: dispatcher, uploads: uploads, timeout: timeout, logger: logger}
 }
 
 // Save store the target image file to cloud storage
@@ -37,53 +95,302 @@ func (svc *StorageService) Save(userID, imgName string, imgData []byte) error {
 		ImageData: imgData,
 	}
 
-	resultChannel := make(chan UploadResult)
-	defer close(resultChannel)
+	return svc.save(NSUtil.UniqueID(), img)
+}
+
+// save hash the image, and save it as the blob of its content. The blob is uploaded only if no image has
+// the same content. Saving the image again replaces the record and releases the previous content.
+func (svc *StorageService) save(jobID string, img Image) error {
+	content, err := contentOf(img)
+	if err != nil {
+		level.Error(svc.logger).Log("API", "contentOf", "job", jobID, "info", err)
+		return err
+	}
+
+	key := img.UserID + img.ImageName
+	old, err := svc.storage.FindByKey(key)
+	exists := err == nil
+	if err != nil && err != NSUtil.ErrNotFound {
+		return err
+	}
+
+	if exists && old.SHA256 == content.SHA256 {
+		return nil
+	}
+
+	err = svc.storeBlob(jobID, img, content)
+	if err != nil {
+		return err
+	}
+
+	info := StorageInfo{
+		Key:      key,
+		UserID:   img.UserID,
+		Name:     img.ImageName,
+		Size:     content.Size,
+		SHA256:   content.SHA256,
+		MimeType: content.MimeType,
+	}
+
+	if exists {
+		err = svc.storage.Update(info)
+	} else {
+		err = svc.storage.Insert(info)
+	}
+
+	if err != nil {
+		level.Error(svc.logger).Log("API", "storage.Insert", "job", jobID, "info", err)
+		svc.releaseBlob(content.SHA256)
+		return err
+	}
+
+	if exists {
+		svc.release(old)
+	}
+
+	return nil
+}
+
+// upload run the upload job, and wait for the result until the job is expired
+func (svc *StorageService) upload(jobID string, img Image, blob string) (UploadResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), svc.timeout)
+	defer cancel()
 
 	imgJob := ImageJob{
+		ID:            jobID,
+		Context:       ctx,
 		UploadImage:   img,
-		ResultChannel: resultChannel,
+		ResultChannel: make(chan UploadResult, 1),
+		Blob:          blob,
 	}
-	JobQueue <- imgJob
 
-	resultInfo := <-resultChannel
-	if resultInfo.UploadError != nil {
-		return resultInfo.UploadError
+	var resultInfo UploadResult
+	err := svc.dispatcher.Submit(imgJob)
+	if err == errDraining {
+		return resultInfo, err
 	}
 
-	// update the upload result to the database: {userID + Name : StorageAccount}
-	session := svc.dbSession.Copy()
-	defer session.Close()
+	if err != nil {
+		level.Error(svc.logger).Log("API", "Save", "job", jobID, "info", "the job queue is full")
+		return resultInfo, errQueueFull
+	}
 
-	c := session.DB("store").C("storage")
+	select {
+	case resultInfo = <-imgJob.ResultChannel:
+	case <-ctx.Done():
+		// the worker saves the job in the dead letters when it finds the job is expired
+		level.Error(svc.logger).Log("API", "Save", "job", jobID, "info", ctx.Err())
+		return resultInfo, errSaveTimeout
+	}
 
-	info := StorageInfo{
-		Key:     resultInfo.UserID + resultInfo.Name,
-		Account: resultInfo.StorageAccount,
+	switch resultInfo.UploadError {
+	case nil:
+		return resultInfo, nil
+	case context.DeadlineExceeded:
+		return resultInfo, errSaveTimeout
+	case context.Canceled:
+		// the job is abandoned at shutdown
+		return resultInfo, errDraining
+	default:
+		return resultInfo, resultInfo.UploadError
 	}
-	return c.Insert(info)
 }
 
 // Find return the public access url for downloading the image file during a limited time
 func (svc *StorageService) Find(userID, imgName string) (string, error) {
-	session := svc.dbSession.Copy()
-	defer session.Close()
-
 	key := userID + imgName
-	c := session.DB("store").C("storage")
 
-	var info StorageInfo
 	// find the StorageAccount for the key: userID + imgName from the database
-	err := c.Find(bson.M{"key": key}).One(&info)
+	info, err := svc.storage.FindByKey(key)
 	if err != nil {
 		return "", err
 	}
 
-	// get the shared access url from the azure storage
-	url, err := Stores[info.Account].Find(userID, imgName)
+	if info.SHA256 != "" {
+		blob, err := svc.blobs.FindBySHA(info.SHA256)
+		if err != nil {
+			return "", err
+		}
+
+		store, ok := svc.dispatcher.Stores[blob.location().storeKey()]
+		if !ok {
+			return "", errors.New("The storage " + blob.location().storeKey() + " is unavailable")
+		}
+
+		return store.Find(blobContainer, blob.Name)
+	}
+
+	store, ok := svc.dispatcher.Stores[info.storeKey()]
+	if !ok {
+		return "", errors.New("The storage " + info.storeKey() + " is unavailable")
+	}
+
+	// get the shared access url from the storage
+	url, err := store.Find(userID, imgName)
 	if err != nil {
 		return "", err
 	}
 
 	return url, err
 }
+
+// Delete remove the storage record of the image, and the image file if no other image has the same content
+func (svc *StorageService) Delete(userID, imgName string) error {
+	key := userID + imgName
+
+	info, err := svc.storage.FindByKey(key)
+	if err == NSUtil.ErrNotFound {
+		return NSUtil.NewErrorWithStatus(http.StatusNotFound, imgName+" doesn't exist")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.SHA256 == "" {
+		return svc.deleteFile(info)
+	}
+
+	err = svc.storage.Remove(key)
+	if err != nil {
+		return err
+	}
+
+	svc.releaseBlob(info.SHA256)
+	return nil
+}
+
+// deleteFile remove the image saved before the content addressed blobs
+func (svc *StorageService) deleteFile(info StorageInfo) error {
+	store, ok := svc.dispatcher.Stores[info.storeKey()]
+	if !ok {
+		return errors.New("The storage " + info.storeKey() + " is unavailable")
+	}
+
+	err := store.Delete(info.UserID, info.Name)
+	if err != nil {
+		return err
+	}
+
+	err = svc.storage.Remove(info.Key)
+	if err != nil {
+		return err
+	}
+
+	svc.addUsage(info.storeKey(), -info.Size, -1)
+	return nil
+}
+
+// release remove the content of the replaced image record, the error is only logged
+func (svc *StorageService) release(info StorageInfo) {
+	if info.SHA256 != "" {
+		svc.releaseBlob(info.SHA256)
+		return
+	}
+
+	store, ok := svc.dispatcher.Stores[info.storeKey()]
+	if !ok {
+		return
+	}
+
+	// the record is replaced, so only the file is deleted
+	err := store.Delete(info.UserID, info.Name)
+	if err != nil {
+		level.Error(svc.logger).Log("API", "Store.Delete", "key", info.Key, "info", err)
+		return
+	}
+
+	svc.addUsage(info.storeKey(), -info.Size, -1)
+}
+
+// DeadLetters list the upload jobs which fail after the retries
+func (svc *StorageService) DeadLetters() ([]DeadLetter, error) {
+	return svc.deadLetters.FindAll()
+}
+
+// Replay run the failed upload job again, and remove it from the dead letters if it succeeds
+func (svc *StorageService) Replay(id string) error {
+	letter, err := svc.deadLetters.FindByID(id)
+	if err == NSUtil.ErrNotFound {
+		return NSUtil.NewErrorWithStatus(http.StatusNotFound, "The dead letter "+id+" doesn't exist")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	img := Image{
+		UserID:    letter.UserID,
+		ImageName: letter.ImageName,
+		ImageData: letter.ImageData,
+		Location:  letter.Location,
+	}
+
+	err = svc.save(letter.ID, img)
+	if err != nil {
+		return err
+	}
+
+	err = svc.deadLetters.Remove(letter.ID)
+	if err != nil && err != NSUtil.ErrNotFound {
+		level.Error(svc.logger).Log("API", "deadLetters.Remove", "job", letter.ID, "info", err)
+	}
+
+	return nil
+}
+
+// CreateUpload start a resumable upload of a large image
+func (svc *StorageService) CreateUpload(userID, imgName string) (UploadSession, error) {
+	session, err := svc.uploads.Create(userID, imgName)
+	if err != nil {
+		level.Error(svc.logger).Log("API", "uploads.Create", "user", userID, "image", imgName, "info", err)
+	}
+
+	return session, err
+}
+
+// FindUpload return the upload with its received chunks
+func (svc *StorageService) FindUpload(id string) (UploadSession, error) {
+	return svc.uploads.Find(id)
+}
+
+// UploadChunk save a chunk of the upload, the checksum is the hex sha256 of the chunk
+func (svc *StorageService) UploadChunk(id string, index int, checksum string, data io.Reader) error {
+	return svc.uploads.WriteChunk(id, index, checksum, data)
+}
+
+// CommitUpload join the chunks and save the image to the cloud storage. The upload is kept if the image
+// isn't saved, so it can be committed again.
+func (svc *StorageService) CommitUpload(id string, chunks int, checksum string) (UploadSession, error) {
+	session, imgPath, err := svc.uploads.Assemble(id, chunks, checksum)
+	if err != nil {
+		return session, err
+	}
+
+	// the upload id is the job id, so the dead letter of the upload can be found
+	err = svc.save(id, Image{UserID: session.UserID, ImageName: session.ImageName, Location: imgPath})
+	if err != nil {
+		return session, err
+	}
+
+	err = svc.uploads.Remove(id)
+	if err != nil {
+		level.Error(svc.logger).Log("API", "uploads.Remove", "job", id, "info", err)
+	}
+
+	return session, nil
+}
+
+// Shutdown stop saving the new images, and wait for the saving images during the grace period.
+// The images which aren't saved are returned and kept in the dead letters.
+func (svc *StorageService) Shutdown(grace time.Duration) []ImageJob {
+	return svc.dispatcher.Drain(grace)
+}
+
+// addUsage only log the error, because the usage is only used for placing the new images
+func (svc *StorageService) addUsage(store string, bytes, count int64) {
+	err := svc.usage.Add(store, bytes, count)
+	if err != nil {
+		level.Error(svc.logger).Log("API", "usage.Add", "store", store, "info", err)
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
+	"neural-style-util"
+
+	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 )
 
@@ -25,11 +29,12 @@ func decodeNSSaveRequest(_ context.Context, r *http.Request) (interface{}, error
 func encodeNSSaveResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	saveRes := response.(NSSaveResponse)
 
+	w.Header().Set("context-type", "application/json, charset=utf8")
 	if saveRes.SaveError != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeErrorStatus(w, saveRes.SaveError)
+		return json.NewEncoder(w).Encode(map[string]string{"error": saveRes.SaveError.Error()})
 	}
 
-	w.Header().Set("context-type", "application/json, charset=utf8")
 	return json.NewEncoder(w).Encode(saveRes)
 }
 
@@ -52,3 +57,110 @@ func encodeNSFindResponse(ctx context.Context, w http.ResponseWriter, response i
 	url := map[string]string{"url": findRes.URL}
 	return json.NewEncoder(w).Encode(url)
 }
+
+func decodeNSDeleteRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	return NSDeleteRequest{UserID: vars["userid"], ImageID: vars["imageid"]}, nil
+}
+
+func encodeNSDeleteResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	deleteRes := response.(NSDeleteResponse)
+
+	w.Header().Set("context-type", "application/json, charset=utf8")
+	if deleteRes.DeleteError != nil {
+		writeErrorStatus(w, deleteRes.DeleteError)
+		return json.NewEncoder(w).Encode(map[string]string{"error": deleteRes.DeleteError.Error()})
+	}
+
+	return json.NewEncoder(w).Encode(map[string]string{})
+}
+
+func decodeNSDeadLettersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func encodeNSDeadLettersResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	lettersRes := response.(NSDeadLettersResponse)
+
+	w.Header().Set("context-type", "application/json, charset=utf8")
+	if lettersRes.Error != nil {
+		writeErrorStatus(w, lettersRes.Error)
+		return json.NewEncoder(w).Encode(map[string]string{"error": lettersRes.Error.Error()})
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{"deadLetters": lettersRes.DeadLetters})
+}
+
+func decodeNSReplayRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	return NSReplayRequest{ID: vars["id"]}, nil
+}
+
+func encodeNSReplayResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	replayRes := response.(NSReplayResponse)
+
+	w.Header().Set("context-type", "application/json, charset=utf8")
+	if replayRes.ReplayError != nil {
+		writeErrorStatus(w, replayRes.ReplayError)
+		return json.NewEncoder(w).Encode(map[string]string{"error": replayRes.ReplayError.Error()})
+	}
+
+	return json.NewEncoder(w).Encode(map[string]string{})
+}
+
+// checksumHeader is the hex sha256 of the uploaded chunk
+const checksumHeader = "X-Chunk-SHA256"
+
+func decodeNSCreateUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	return NSCreateUploadRequest{UserID: vars["userid"], ImageID: vars["imageid"]}, nil
+}
+
+func decodeNSFindUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	return NSUploadRequest{ID: vars["id"]}, nil
+}
+
+func decodeNSUploadChunkRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	index, err := strconv.Atoi(vars["index"])
+	if err != nil {
+		return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid chunk number")
+	}
+
+	// the chunk is streamed to the disk by the endpoint
+	return NSUploadChunkRequest{ID: vars["id"], Index: index, Checksum: r.Header.Get(checksumHeader),
+		Data: r.Body}, nil
+}
+
+func decodeNSCommitUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := NSCommitUploadRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid commit data")
+	}
+
+	req.ID = mux.Vars(r)["id"]
+	return req, nil
+}
+
+func encodeNSUploadResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	uploadRes := response.(NSUploadResponse)
+
+	w.Header().Set("context-type", "application/json, charset=utf8")
+	if uploadRes.Err != nil {
+		writeErrorStatus(w, uploadRes.Err)
+		return json.NewEncoder(w).Encode(map[string]string{"error": uploadRes.Err.Error()})
+	}
+
+	return json.NewEncoder(w).Encode(uploadRes.Session)
+}
+
+// writeErrorStatus write the status of the error, or 500 if the error has no status
+func writeErrorStatus(w http.ResponseWriter, err error) {
+	if sc, ok := err.(httptransport.StatusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
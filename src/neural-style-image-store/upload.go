@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"neural-style-util"
+)
+
+const (
+	sessionFile = "session.json"
+	chunkSuffix = ".part"
+	// imageDir keep the assembled image, so its name can't be the same as the chunks
+	imageDir = "image"
+)
+
+var (
+	errUploadNotFound = NSUtil.NewErrorWithStatus(http.StatusNotFound, "The upload doesn't exist or is expired")
+	errBadChecksum    = NSUtil.NewErrorWithStatus(http.StatusBadRequest, "The checksum doesn't match the data")
+	errBadUploadName  = NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid user or image name")
+)
+
+// UploadSession define a resumable upload of an image. Chunks are the numbers of the received chunks,
+// the client only sends the missing chunks after the connection is dropped.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	ImageName string    `json:"imageName"`
+	Created   time.Time `json:"created"`
+	Chunks    []int     `json:"chunks"`
+}
+
+// Uploads keep the upload sessions and their chunks under Dir/{id}, so an upload can be resumed
+// after the connection is dropped or the storage service restarts
+type Uploads struct {
+	Dir          string
+	MaxChunkSize int64
+}
+
+// NewUploads create the upload sessions under the directory, a chunk can't be larger than maxChunkSize
+func NewUploads(dir string, maxChunkSize int64) *Uploads {
+	return &Uploads{Dir: dir, MaxChunkSize: maxChunkSize}
+}
+
+// Create start a new upload session of the image
+func (u *Uploads) Create(userID, imgName string) (UploadSession, error) {
+	session := UploadSession{ID: NSUtil.UniqueID(), UserID: userID, ImageName: imgName, Created: time.Now()}
+	if !validName(userID) || !validName(imgName) {
+		return session, errBadUploadName
+	}
+
+	err := os.MkdirAll(filepath.Join(u.Dir, session.ID, imageDir), 0755)
+	if err != nil {
+		return session, err
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return session, err
+	}
+
+	return session, ioutil.WriteFile(filepath.Join(u.Dir, session.ID, sessionFile), data, 0644)
+}
+
+// Find return the upload session with the received chunks
+func (u *Uploads) Find(id string) (UploadSession, error) {
+	var session UploadSession
+	dir, err := u.sessionDir(id)
+	if err != nil {
+		return session, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, sessionFile))
+	if os.IsNotExist(err) {
+		return session, errUploadNotFound
+	}
+
+	if err != nil {
+		return session, err
+	}
+
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		return session, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return session, err
+	}
+
+	session.Chunks = []int{}
+	for _, file := range files {
+		index, err := strconv.Atoi(strings.TrimSuffix(file.Name(), chunkSuffix))
+		if err == nil && strings.HasSuffix(file.Name(), chunkSuffix) {
+			session.Chunks = append(session.Chunks, index)
+		}
+	}
+
+	sort.Ints(session.Chunks)
+	return session, nil
+}
+
+// WriteChunk stream the chunk to the disk, and keep it only if its sha256 is the hex checksum.
+// A chunk which is sent again replaces the previous one.
+func (u *Uploads) WriteChunk(id string, index int, checksum string, data io.Reader) error {
+	if index < 0 {
+		return NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid chunk number")
+	}
+
+	if _, err := u.Find(id); err != nil {
+		return err
+	}
+
+	dir, _ := u.sessionDir(id)
+	tmpFile, err := ioutil.TempFile(dir, "chunk")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), io.LimitReader(data, u.MaxChunkSize+1))
+	tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	if size > u.MaxChunkSize {
+		return NSUtil.NewErrorWithStatus(http.StatusRequestEntityTooLarge,
+			"The chunk is larger than "+strconv.FormatInt(u.MaxChunkSize, 10)+" bytes")
+	}
+
+	if !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), checksum) {
+		return errBadChecksum
+	}
+
+	return os.Rename(tmpFile.Name(), filepath.Join(dir, strconv.Itoa(index)+chunkSuffix))
+}
+
+// Assemble join the chunks 0 to chunks-1 into the image file, and check the sha256 of the image if the
+// checksum isn't empty. It returns the path of the image file.
+func (u *Uploads) Assemble(id string, chunks int, checksum string) (UploadSession, string, error) {
+	session, err := u.Find(id)
+	if err != nil {
+		return session, "", err
+	}
+
+	received := make(map[int]bool)
+	for _, index := range session.Chunks {
+		received[index] = true
+	}
+
+	if chunks <= 0 {
+		return session, "", NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid chunk count")
+	}
+
+	for index := 0; index < chunks; index++ {
+		if !received[index] {
+			return session, "", NSUtil.NewErrorWithStatus(http.StatusBadRequest,
+				"The chunk "+strconv.Itoa(index)+" is missing")
+		}
+	}
+
+	dir, _ := u.sessionDir(id)
+	imgPath := filepath.Join(dir, imageDir, session.ImageName)
+	imgFile, err := os.Create(imgPath)
+	if err != nil {
+		return session, "", err
+	}
+	defer imgFile.Close()
+
+	hash := sha256.New()
+	writer := io.MultiWriter(imgFile, hash)
+	for index := 0; index < chunks; index++ {
+		err = appendFile(writer, filepath.Join(dir, strconv.Itoa(index)+chunkSuffix))
+		if err != nil {
+			return session, "", err
+		}
+	}
+
+	if checksum != "" && !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), checksum) {
+		return session, "", errBadChecksum
+	}
+
+	return session, imgPath, nil
+}
+
+// Remove delete the upload session and its chunks
+func (u *Uploads) Remove(id string) error {
+	dir, err := u.sessionDir(id)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// Expire remove the upload sessions created before the time, and return how many sessions are removed
+func (u *Uploads) Expire(before time.Time) int {
+	dirs, err := ioutil.ReadDir(u.Dir)
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	for _, dir := range dirs {
+		session, err := u.Find(dir.Name())
+		if err == nil && session.Created.Before(before) {
+			if u.Remove(session.ID) == nil {
+				removed++
+			}
+		}
+	}
+
+	return removed
+}
+
+// sessionDir return the directory of the session, the id is generated by NSUtil.UniqueID
+func (u *Uploads) sessionDir(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return "", errUploadNotFound
+	}
+
+	return filepath.Join(u.Dir, id), nil
+}
+
+// validName reject the names which may escape the upload directory
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+func appendFile(writer io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(writer, file)
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestResumableUpload(t *testing.T) {
+	root, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	store := NewLocalImageStore(filepath.Join(root, "images"), "http://localhost", []byte("key"))
+	entries := []StoreEntry{{Location: StorageInfo{Backend: localBackend}, Store: store}}
+	placement, _ := NewPlacement(roundRobinPolicy, entries, nil)
+	dispatcher := NewDispatcher(1, 1, entries, placement, RetryPolicy{}, newMemoryDeadLetterRepository(),
+		log.NewNopLogger())
+	dispatcher.Run()
+
+	storage := newMemoryStorageRepository()
+	uploads := NewUploads(filepath.Join(root, "uploads"), 4)
+	svc := NewStorageService(storage, newMemoryUsageRepository(), newMemoryDeadLetterRepository(),
+		newMemoryBlobRepository(), dispatcher, uploads, time.Second, log.NewNopLogger())
+
+	session, err := svc.CreateUpload("user", "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection is dropped after the chunk 1 is sent
+	if err := svc.UploadChunk(session.ID, 1, checksum("5678"), strings.NewReader("5678")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.UploadChunk(session.ID, 0, checksum("1234"), strings.NewReader("12xx")); err != errBadChecksum {
+		t.Errorf("expected the corrupted chunk to be rejected, got %v", err)
+	}
+
+	if err := svc.UploadChunk(session.ID, 2, checksum("90abc"), strings.NewReader("90abc")); err == nil {
+		t.Errorf("expected the chunk larger than the limit to be rejected")
+	}
+
+	if _, err := svc.CommitUpload(session.ID, 3, ""); err == nil {
+		t.Errorf("expected the commit to fail with the missing chunks")
+	}
+
+	resumed, _ := svc.FindUpload(session.ID)
+	if len(resumed.Chunks) != 1 || resumed.Chunks[0] != 1 {
+		t.Fatalf("expected only the chunk 1 to be received, got %v", resumed.Chunks)
+	}
+
+	svc.UploadChunk(session.ID, 0, checksum("1234"), strings.NewReader("1234"))
+	svc.UploadChunk(session.ID, 2, checksum("90"), strings.NewReader("90"))
+	if _, err := svc.CommitUpload(session.ID, 3, checksum("1234567890")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "images", blobContainer, checksum("1234567890")+".png"))
+	if err != nil || !bytes.Equal(data, []byte("1234567890")) {
+		t.Errorf("unexpected saved image %q %v", data, err)
+	}
+
+	if info, err := storage.FindByKey("usera.png"); err != nil || info.Size != 10 {
+		t.Errorf("unexpected storage record %+v %v", info, err)
+	}
+
+	if _, err := svc.FindUpload(session.ID); err != errUploadNotFound {
+		t.Errorf("expected the committed upload to be removed, got %v", err)
+	}
+}
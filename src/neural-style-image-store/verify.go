@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Verifier download the blobs from the stores and hash them again, so the corrupted and missing blobs are found
+type Verifier struct {
+	Blobs  BlobRepository
+	Stores map[string]ImageStore
+	Client *http.Client
+	Logger log.Logger
+}
+
+// VerifyReport list the SHA-256 of the blobs which fail the verification. The blobs in Failed can't be
+// downloaded, so they aren't verified.
+type VerifyReport struct {
+	Checked   int
+	Corrupted []string
+	Missing   []string
+	Failed    []string
+}
+
+// Run verify all the blobs, the failed blob is logged and the verification goes on
+func (v *Verifier) Run() (VerifyReport, error) {
+	var report VerifyReport
+	blobs, err := v.Blobs.FindAll()
+	if err != nil {
+		return report, err
+	}
+
+	for _, blob := range blobs {
+		report.Checked++
+		err := v.verify(blob)
+		switch err {
+		case nil:
+			continue
+		case errFileMissing:
+			report.Missing = append(report.Missing, blob.SHA256)
+		case errBadChecksum:
+			report.Corrupted = append(report.Corrupted, blob.SHA256)
+		default:
+			report.Failed = append(report.Failed, blob.SHA256)
+		}
+
+		level.Error(v.Logger).Log("API", "Verify", "sha256", blob.SHA256, "store", blob.location().storeKey(),
+			"name", blob.Name, "info", err)
+	}
+
+	return report, nil
+}
+
+// verify return errBadChecksum if the size or the hash of the blob doesn't match the record
+func (v *Verifier) verify(blob Blob) error {
+	store, ok := v.Stores[blob.location().storeKey()]
+	if !ok {
+		return errors.New("The storage " + blob.location().storeKey() + " is unavailable")
+	}
+
+	body, err := openFile(v.Client, store, blobContainer, blob.Name)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return err
+	}
+
+	if size != blob.Size || hex.EncodeToString(hash.Sum(nil)) != blob.SHA256 {
+		return errBadChecksum
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package WaterMark
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// output formats
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	GIF  = "gif"
+)
+
+// The jpeg quality is searched between MinQuality and MaxQuality, and the image is downscaled when the
+// MinQuality is still over the budget, but not below MinWidth. The images with an invisible payload aren't
+// encoded below MinPayloadQuality, since the payload can't be detected in the lower qualities.
+const (
+	MinQuality        = 30
+	MinPayloadQuality = 50
+	MaxQuality        = 100
+	MinWidth          = 64
+)
+
+// ErrUnsupportedFormat is returned for the formats other than jpeg, png and gif
+var ErrUnsupportedFormat = errors.New("The watermark format should be jpeg, png or gif")
+
+// ErrOverBudget is returned if the image is over the byte budget even in the MinWidth
+var ErrOverBudget = errors.New("The image can't be encoded within the byte budget")
+
+// Encoding report how the image is encoded. The Quality is 0 for png and gif.
+type Encoding struct {
+	Format  string `json:"format"`
+	Quality int    `json:"quality"`
+	Size    int    `json:"size"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+// NormalizeFormat return the output format, the empty format is jpeg
+func NormalizeFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", "jpg", JPEG:
+		return JPEG, nil
+	case PNG:
+		return PNG, nil
+	case GIF:
+		return GIF, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Encode encode the image in the format within maxBytes. The jpeg quality is the highest one within the budget,
+// and the image is downscaled if it's over the budget in the MinQuality, or in png and gif. The png keeps the
+// alpha of the image. It returns the encoded image, which is smaller than img if it's downscaled.
+func Encode(img image.Image, format string, maxBytes int) ([]byte, image.Image, Encoding, error) {
+	return encode(img, format, maxBytes, MinQuality)
+}
+
+// encode is Encode with the min jpeg quality
+func encode(img image.Image, format string, maxBytes, minQuality int) ([]byte, image.Image, Encoding, error) {
+	format, err := NormalizeFormat(format)
+	if err != nil {
+		return nil, nil, Encoding{}, err
+	}
+	if maxBytes <= 0 {
+		maxBytes = ImageSizeLimitation
+	}
+
+	current := img
+	for {
+		data, quality, err := encodeWithin(current, format, maxBytes, minQuality)
+		if err != nil {
+			return nil, nil, Encoding{}, err
+		}
+
+		bounds := current.Bounds()
+		if len(data) <= maxBytes {
+			return data, current, Encoding{Format: format, Quality: quality, Size: len(data), Width: bounds.Dx(),
+				Height: bounds.Dy()}, nil
+		}
+
+		if bounds.Dx() <= MinWidth {
+			return nil, nil, Encoding{}, ErrOverBudget
+		}
+
+		// the size is about in proportion to the area
+		factor := math.Sqrt(float64(maxBytes)/float64(len(data))) * 0.95
+		factor = math.Max(0.5, math.Min(0.9, factor))
+		width := int(math.Max(MinWidth, float64(bounds.Dx())*factor))
+		current = downscale(current, width)
+	}
+}
+
+// encodeWithin encode the image in the highest jpeg quality within maxBytes, or the minQuality if none of them
+// is. The png and gif are encoded once.
+func encodeWithin(img image.Image, format string, maxBytes, minQuality int) ([]byte, int, error) {
+	switch format {
+	case PNG, GIF:
+		data, err := encodeBytes(img, format, 0)
+		return data, 0, err
+	}
+
+	data, err := encodeBytes(img, format, MaxQuality)
+	if err != nil || len(data) <= maxBytes {
+		return data, MaxQuality, err
+	}
+
+	// binary search the highest quality within the budget, the size grows with the quality
+	low, high := minQuality, MaxQuality-1
+	var best []byte
+	bestQuality := 0
+	for low <= high {
+		quality := (low + high) / 2
+		data, err = encodeBytes(img, format, quality)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(data) <= maxBytes {
+			best, bestQuality = data, quality
+			low = quality + 1
+		} else {
+			high = quality - 1
+		}
+	}
+
+	if best == nil {
+		// over the budget, the caller downscales it
+		return data, minQuality, nil
+	}
+	return best, bestQuality, nil
+}
+
+func encodeBytes(img image.Image, format string, quality int) ([]byte, error) {
+	var buffer bytes.Buffer
+	var err error
+	switch format {
+	case PNG:
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buffer, img)
+	case GIF:
+		err = gif.Encode(&buffer, img, &gif.Options{NumColors: 256})
+	default:
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality})
+	}
+
+	return buffer.Bytes(), err
+}
+
+// downscale resize the image to the width, the alpha is kept
+func downscale(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	height := int(math.Max(1, math.Round(float64(bounds.Dy())*float64(width)/float64(bounds.Dx()))))
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+	return resized
+}
@@ -0,0 +1,122 @@
+package WaterMark
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func noise(width, height int) *image.RGBA {
+	random := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	random.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+func TestEncodeSearchesQualityWithinBudget(t *testing.T) {
+	img := noise(400, 300)
+	data, _, encoding, err := Encode(img, "jpg", 120000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding.Format != JPEG || len(data) != encoding.Size || encoding.Size > 120000 {
+		t.Fatalf("unexpected encoding %+v", encoding)
+	}
+	if encoding.Quality <= MinQuality || encoding.Quality >= MaxQuality || encoding.Width != 400 {
+		t.Fatalf("expected a lower quality in the full size, got %+v", encoding)
+	}
+
+	// the next quality is over the budget
+	next, err := encodeBytes(img, JPEG, encoding.Quality+1)
+	if err != nil || len(next) <= 120000 {
+		t.Fatalf("quality %d isn't the highest within the budget", encoding.Quality)
+	}
+}
+
+func TestEncodeDownscalesOverBudget(t *testing.T) {
+	_, encoded, encoding, err := Encode(noise(400, 300), JPEG, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding.Size > 10000 || encoding.Width >= 400 || encoded.Bounds().Dx() != encoding.Width {
+		t.Fatalf("expected a downscaled image, got %+v", encoding)
+	}
+
+	if _, _, _, err := Encode(noise(400, 300), JPEG, 100); err != ErrOverBudget {
+		t.Fatalf("expected ErrOverBudget, got %v", err)
+	}
+	if _, _, _, err := Encode(noise(10, 10), "bmp", 0); err != ErrUnsupportedFormat {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestEncodePNGKeepsAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	img.SetNRGBA(10, 10, color.NRGBA{R: 255, A: 128})
+
+	data, _, encoding, err := Encode(img, PNG, 0)
+	if err != nil || encoding.Format != PNG || encoding.Quality != 0 {
+		t.Fatalf("unexpected encoding %+v, %v", encoding, err)
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := decoded.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("the transparent pixel has alpha %d", a)
+	}
+	if _, _, _, a := decoded.At(10, 10).RGBA(); a>>8 != 128 {
+		t.Errorf("the translucent pixel has alpha %d", a>>8)
+	}
+}
+
+func TestInvisibleWatermarkAfterDownscale(t *testing.T) {
+	payload := Payload{ProductID: "p1", Buyer: "b1"}
+	wm := Service{SourceImg: noise(800, 600), Invisible: &payload, MaxBytes: 60000}
+	var output bytes.Buffer
+	_, encoding, err := wm.CreateWaterMark(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding.Width >= 800 {
+		t.Fatalf("expected a downscaled image, got %+v", encoding)
+	}
+
+	decoded, _, err := image.Decode(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := Detect(decoded); found != payload {
+		t.Fatalf("payload %v isn't found in the downscaled image", found)
+	}
+}
+
+func TestInvisibleWatermarkWithinSmallBudget(t *testing.T) {
+	payload := Payload{ProductID: "5a1b2c3d4e5f60718293a4b5", Buyer: "buyer@example.com"}
+	for _, maxBytes := range []int{30000, 15000} {
+		wm := Service{Source: "./test.png", Invisible: &payload, MaxBytes: maxBytes}
+		var output bytes.Buffer
+		_, encoding, err := wm.CreateWaterMark(&output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output.Len() > maxBytes || encoding.Quality < MinPayloadQuality {
+			t.Fatalf("budget %d: %+v", maxBytes, encoding)
+		}
+
+		decoded, _, err := image.Decode(&output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found, confidence := Detect(decoded); found != payload {
+			t.Errorf("budget %d: payload %v with confidence %.2f in %+v", maxBytes, found, confidence, encoding)
+		}
+	}
+}
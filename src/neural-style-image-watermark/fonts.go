@@ -0,0 +1,32 @@
+package WaterMark
+
+import (
+	"errors"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+// builtinFonts is the TrueType fonts which are always available
+var builtinFonts = map[string][]byte{
+	"goregular": goregular.TTF,
+	"gobold":    gobold.TTF,
+	"gomono":    gomono.TTF,
+}
+
+// LoadFont parse a TrueType or OpenType font file
+func LoadFont(ttf []byte) (*opentype.Font, error) {
+	return opentype.Parse(ttf)
+}
+
+// BuiltinFont return the bundled font by name: goregular, gobold or gomono
+func BuiltinFont(name string) (*opentype.Font, error) {
+	ttf, ok := builtinFonts[name]
+	if !ok {
+		return nil, errors.New("Unknown font " + name)
+	}
+
+	return opentype.Parse(ttf)
+}
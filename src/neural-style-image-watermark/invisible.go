@@ -0,0 +1,252 @@
+package WaterMark
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"math"
+	"math/rand"
+
+	"golang.org/x/image/draw"
+)
+
+// Payload is hidden in the picture by the invisible watermark, to find the source of a leaked picture
+type Payload struct {
+	ProductID string `json:"productId"`
+	Buyer     string `json:"buyer"`
+}
+
+// ErrPayloadTooLong is returned if the product id and the buyer don't fit in the watermark
+var ErrPayloadTooLong = errors.New("The product id and the buyer are too long for the invisible watermark")
+
+// ErrImageTooSmall is returned if the picture hasn't enough blocks to carry the payload
+var ErrImageTooSmall = errors.New("The image is too small for the invisible watermark")
+
+// The payload is framed with the lengths of the product id and the buyer, padded to payloadBytes, and followed
+// by its crc32. Each bit is repeated in the DCT coefficients of many 8x8 blocks of the luma, spread by a fixed
+// key, so the bits are voted back after the picture is recompressed.
+const (
+	payloadBytes = 48
+	frameBits    = (payloadBytes + crc32.Size) * 8
+	minRepeats   = 8
+	forensicKey  = 0x4e53574d
+	blockSize    = 8
+
+	// quantization step of the coefficients. The jpeg quality 50 quantizes them by 10 to 14, and the votes of the
+	// repeated bits cover the rest of the errors.
+	qimStep = 24.0
+)
+
+// carriers are the low and middle frequency coefficients in every block, which survive the jpeg quantization
+var carriers = [][2]int{{0, 2}, {1, 1}, {2, 0}, {1, 2}, {2, 1}}
+
+// basis[u][x] is the 1-D orthonormal DCT-II basis
+var basis = func() (table [blockSize][blockSize]float64) {
+	for u := 0; u < blockSize; u++ {
+		scale := math.Sqrt(2.0 / blockSize)
+		if u == 0 {
+			scale = math.Sqrt(1.0 / blockSize)
+		}
+		for x := 0; x < blockSize; x++ {
+			table[u][x] = scale * math.Cos(float64((2*x+1)*u)*math.Pi/(2*blockSize))
+		}
+	}
+	return
+}()
+
+// EmbedPayload hide the payload in the luma of the image, the colors are changed by about two levels on average
+func EmbedPayload(src image.Image, payload Payload) (*image.RGBA, error) {
+	frame, err := payload.frame()
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), src, bounds.Min, draw.Src)
+
+	layout, err := newCarrierLayout(img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+
+	var luma, delta [blockSize][blockSize]float64
+	index := 0
+	layout.blocks(func(x0, y0 int) {
+		readLuma(img, x0, y0, &luma)
+		delta = [blockSize][blockSize]float64{}
+		for _, carrier := range carriers {
+			coefficient := dct(&luma, carrier)
+			bit := bitOf(frame, layout.bits[index])
+			change := quantize(coefficient, bit, layout.dithers[index]) - coefficient
+			for y := 0; y < blockSize; y++ {
+				for x := 0; x < blockSize; x++ {
+					delta[y][x] += change * basis[carrier[0]][x] * basis[carrier[1]][y]
+				}
+			}
+			index++
+		}
+		addLuma(img, x0, y0, &delta)
+	})
+
+	return img, nil
+}
+
+// Detect read the payload of the invisible watermark. The confidence is from 0 to 1, by how clearly the repeated
+// bits agree with each other, and it's 0 with an empty payload if the picture has no valid watermark.
+func Detect(img image.Image) (Payload, float64) {
+	bounds := img.Bounds()
+	layout, err := newCarrierLayout(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	if err != nil {
+		return Payload{}, 0
+	}
+
+	var votes, counts [frameBits]float64
+	var luma [blockSize][blockSize]float64
+	index := 0
+	layout.blocks(func(x0, y0 int) {
+		readLuma(img, bounds.Min.X+x0, bounds.Min.Y+y0, &luma)
+		for _, carrier := range carriers {
+			coefficient := dct(&luma, carrier)
+			// +1 on the lattice of bit 0, -1 on the lattice of bit 1
+			phase := (coefficient - layout.dithers[index]) / qimStep
+			votes[layout.bits[index]] += math.Cos(2 * math.Pi * phase)
+			counts[layout.bits[index]]++
+			index++
+		}
+	})
+
+	frame := make([]byte, frameBits/8)
+	confidence := 0.0
+	for i := 0; i < frameBits; i++ {
+		if votes[i] < 0 {
+			frame[i/8] |= 1 << uint(7-i%8)
+		}
+		confidence += math.Abs(votes[i]) / counts[i]
+	}
+
+	payload, ok := parseFrame(frame)
+	if !ok {
+		return Payload{}, 0
+	}
+
+	return payload, confidence / frameBits
+}
+
+// frame encode the payload with the lengths and the checksum
+func (payload Payload) frame() ([]byte, error) {
+	if len(payload.ProductID)+len(payload.Buyer)+2 > payloadBytes {
+		return nil, ErrPayloadTooLong
+	}
+
+	frame := make([]byte, payloadBytes+crc32.Size)
+	frame[0] = byte(len(payload.ProductID))
+	copy(frame[1:], payload.ProductID)
+	frame[1+len(payload.ProductID)] = byte(len(payload.Buyer))
+	copy(frame[2+len(payload.ProductID):], payload.Buyer)
+
+	binary.BigEndian.PutUint32(frame[payloadBytes:], crc32.ChecksumIEEE(frame[:payloadBytes]))
+	return frame, nil
+}
+
+func parseFrame(frame []byte) (Payload, bool) {
+	data := frame[:payloadBytes]
+	if binary.BigEndian.Uint32(frame[payloadBytes:]) != crc32.ChecksumIEEE(data) {
+		return Payload{}, false
+	}
+
+	productLen := int(data[0])
+	if productLen+2 > payloadBytes {
+		return Payload{}, false
+	}
+	buyerLen := int(data[1+productLen])
+	if productLen+buyerLen+2 > payloadBytes {
+		return Payload{}, false
+	}
+
+	return Payload{
+		ProductID: string(data[1 : 1+productLen]),
+		Buyer:     string(data[2+productLen : 2+productLen+buyerLen]),
+	}, true
+}
+
+func bitOf(frame []byte, i int) int {
+	return int(frame[i/8]>>uint(7-i%8)) & 1
+}
+
+// carrierLayout assign a frame bit and a dither to every carrier of the full blocks of the image
+type carrierLayout struct {
+	cols, rows int
+	bits       []int
+	dithers    []float64
+}
+
+func newCarrierLayout(bounds image.Rectangle) (*carrierLayout, error) {
+	layout := &carrierLayout{cols: bounds.Dx() / blockSize, rows: bounds.Dy() / blockSize}
+	count := layout.cols * layout.rows * len(carriers)
+	if count < frameBits*minRepeats {
+		return nil, ErrImageTooSmall
+	}
+
+	random := rand.New(rand.NewSource(forensicKey))
+	layout.bits = random.Perm(count)
+	layout.dithers = make([]float64, count)
+	for i := range layout.bits {
+		layout.bits[i] %= frameBits
+		layout.dithers[i] = random.Float64() * qimStep
+	}
+
+	return layout, nil
+}
+
+func (layout *carrierLayout) blocks(visit func(x0, y0 int)) {
+	for row := 0; row < layout.rows; row++ {
+		for col := 0; col < layout.cols; col++ {
+			visit(col*blockSize, row*blockSize)
+		}
+	}
+}
+
+// quantize move the coefficient to the closest point of the lattice of the bit
+func quantize(coefficient float64, bit int, dither float64) float64 {
+	offset := dither + float64(bit)*qimStep/2
+	return math.Round((coefficient-offset)/qimStep)*qimStep + offset
+}
+
+func dct(luma *[blockSize][blockSize]float64, carrier [2]int) float64 {
+	sum := 0.0
+	for y := 0; y < blockSize; y++ {
+		for x := 0; x < blockSize; x++ {
+			sum += luma[y][x] * basis[carrier[0]][x] * basis[carrier[1]][y]
+		}
+	}
+	return sum
+}
+
+func readLuma(img image.Image, x0, y0 int, luma *[blockSize][blockSize]float64) {
+	for y := 0; y < blockSize; y++ {
+		for x := 0; x < blockSize; x++ {
+			r, g, b, _ := img.At(x0+x, y0+y).RGBA()
+			luma[y][x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+		}
+	}
+}
+
+// addLuma add the same change to the red, green and blue, which changes only the luma
+func addLuma(img *image.RGBA, x0, y0 int, delta *[blockSize][blockSize]float64) {
+	for y := 0; y < blockSize; y++ {
+		for x := 0; x < blockSize; x++ {
+			pixel := img.RGBAAt(x0+x, y0+y)
+			pixel.R = addLevel(pixel.R, delta[y][x], pixel.A)
+			pixel.G = addLevel(pixel.G, delta[y][x], pixel.A)
+			pixel.B = addLevel(pixel.B, delta[y][x], pixel.A)
+			img.SetRGBA(x0+x, y0+y, pixel)
+		}
+	}
+}
+
+func addLevel(level uint8, delta float64, alpha uint8) uint8 {
+	value := math.Round(float64(level) + delta)
+	return uint8(math.Max(0, math.Min(float64(alpha), value)))
+}
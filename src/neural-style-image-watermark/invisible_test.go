@@ -0,0 +1,59 @@
+package WaterMark
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+func TestInvisibleWatermarkSurvivesJPEG(t *testing.T) {
+	file, err := os.Open("./test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	source, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload, confidence := Detect(source); payload != (Payload{}) || confidence != 0 {
+		t.Fatalf("unmarked image has payload %v with confidence %v", payload, confidence)
+	}
+
+	payload := Payload{ProductID: "5a1b2c3d4e5f60718293a4b5", Buyer: "buyer@example.com"}
+	wm := Service{SourceImg: source, Invisible: &payload}
+	var output bytes.Buffer
+	_, _, err = wm.CreateWaterMark(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bytes written by CreateWaterMark, then recompressed
+	marked, _, err := image.Decode(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, confidence := Detect(marked); found != payload {
+		t.Fatalf("written image: payload %v with confidence %.2f", found, confidence)
+	}
+
+	for _, quality := range []int{100, 75, 50} {
+		var compressed bytes.Buffer
+		if err := jpeg.Encode(&compressed, marked, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := jpeg.Decode(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, confidence := Detect(decoded)
+		if found != payload || confidence < 0.3 {
+			t.Errorf("quality %d: payload %v with confidence %.2f", quality, found, confidence)
+		}
+		t.Logf("quality %d: confidence %.2f", quality, confidence)
+	}
+}
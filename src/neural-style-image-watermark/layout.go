@@ -0,0 +1,231 @@
+package WaterMark
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// watermark positions
+const (
+	TopLeft     = "top-left"
+	Top         = "top"
+	TopRight    = "top-right"
+	Left        = "left"
+	Center      = "center"
+	Right       = "right"
+	BottomLeft  = "bottom-left"
+	Bottom      = "bottom"
+	BottomRight = "bottom-right"
+)
+
+// Positions is the supported positions, the empty position is BottomRight
+var Positions = []string{TopLeft, Top, TopRight, Left, Center, Right, BottomLeft, Bottom, BottomRight}
+
+// textSize is the pixel size of the text before it's scaled
+const textSize = 16
+
+// markImage render the logo or the text, and rotate it
+func (wm *Service) markImage() (image.Image, error) {
+	scale := wm.Scale
+	if scale <= 0 {
+		scale = 1
+	}
+
+	var mark image.Image
+	var err error
+	if wm.Logo != nil {
+		mark = scaleImage(wm.Logo, scale)
+	} else {
+		mark, err = wm.textImage(scale)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rotate(mark, wm.Rotation), nil
+}
+
+// textImage draw the text with a padding of a quarter of its height. The TrueType font is drawn in the scaled
+// size, and the inconsolata bitmap font is scaled after it's drawn.
+func (wm *Service) textImage(scale float64) (image.Image, error) {
+	textColor := wm.TextColor
+	if textColor == nil {
+		textColor = color.White
+	}
+
+	var face font.Face = inconsolata.Regular8x16
+	if wm.Font != nil {
+		var err error
+		face, err = opentype.NewFace(wm.Font, &opentype.FaceOptions{Size: textSize * scale, DPI: 72,
+			Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		defer face.Close()
+	}
+
+	metrics := face.Metrics()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	padding := height / 4
+	width := font.MeasureString(face, wm.Text).Ceil()
+	img := image.NewRGBA(image.Rect(0, 0, width+2*padding, height+2*padding))
+
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(textColor),
+		Face: face,
+		Dot:  fixed.Point26_6{X: fixed.I(padding), Y: fixed.I(padding) + metrics.Ascent},
+	}
+	d.DrawString(wm.Text)
+
+	if wm.Font != nil {
+		return img, nil
+	}
+
+	return scaleImage(img, scale), nil
+}
+
+// drawMark draw the mark at the position, or tile it diagonally over the image
+func (wm *Service) drawMark(dst *image.RGBA, mark image.Image) {
+	opacity := wm.Opacity
+	if opacity <= 0 || opacity > 1 {
+		opacity = 1
+	}
+	mask := image.NewUniform(color.Alpha16{A: uint16(opacity * 0xffff)})
+
+	bounds := dst.Bounds()
+	markBounds := mark.Bounds()
+	margin := int(wm.Margin * float64(minInt(bounds.Dx(), bounds.Dy())))
+
+	if !wm.Tile {
+		at := place(wm.Position, bounds, markBounds.Size(), margin)
+		draw.DrawMask(dst, markBounds.Add(at.Sub(markBounds.Min)), mark, markBounds.Min, mask, image.ZP, draw.Over)
+		return
+	}
+
+	// each row is shifted by half a mark, so the marks are on the diagonal lines
+	stepX := markBounds.Dx() + margin
+	stepY := markBounds.Dy() + margin
+	if stepX <= 0 || stepY <= 0 {
+		return
+	}
+
+	for row, y := 0, bounds.Min.Y; y < bounds.Max.Y; row, y = row+1, y+stepY {
+		shift := (row * stepX / 2) % stepX
+		for x := bounds.Min.X - stepX + shift; x < bounds.Max.X; x += stepX {
+			at := image.Pt(x, y)
+			draw.DrawMask(dst, markBounds.Add(at.Sub(markBounds.Min)), mark, markBounds.Min, mask, image.ZP, draw.Over)
+		}
+	}
+}
+
+// place return the top left point of the mark at the position, the mark is kept inside the image
+func place(position string, bounds image.Rectangle, size image.Point, margin int) image.Point {
+	left := bounds.Min.X + margin
+	right := bounds.Max.X - margin - size.X
+	top := bounds.Min.Y + margin
+	bottom := bounds.Max.Y - margin - size.Y
+	centerX := bounds.Min.X + (bounds.Dx()-size.X)/2
+	centerY := bounds.Min.Y + (bounds.Dy()-size.Y)/2
+
+	var at image.Point
+	switch position {
+	case TopLeft:
+		at = image.Pt(left, top)
+	case Top:
+		at = image.Pt(centerX, top)
+	case TopRight:
+		at = image.Pt(right, top)
+	case Left:
+		at = image.Pt(left, centerY)
+	case Center:
+		at = image.Pt(centerX, centerY)
+	case Right:
+		at = image.Pt(right, centerY)
+	case BottomLeft:
+		at = image.Pt(left, bottom)
+	case Bottom:
+		at = image.Pt(centerX, bottom)
+	default:
+		at = image.Pt(right, bottom)
+	}
+
+	// a mark larger than the image is aligned to the top left
+	at.X = maxInt(bounds.Min.X, minInt(at.X, bounds.Max.X-size.X))
+	at.Y = maxInt(bounds.Min.Y, minInt(at.Y, bounds.Max.Y-size.Y))
+	return at
+}
+
+// ValidPosition check the position is one of Positions or empty
+func ValidPosition(position string) error {
+	if position == "" {
+		return nil
+	}
+
+	for _, p := range Positions {
+		if p == position {
+			return nil
+		}
+	}
+
+	return errors.New("Unknown watermark position " + position)
+}
+
+func scaleImage(img image.Image, scale float64) image.Image {
+	bounds := img.Bounds()
+	if scale == 1 {
+		return img
+	}
+
+	width := maxInt(1, int(float64(bounds.Dx())*scale))
+	height := maxInt(1, int(float64(bounds.Dy())*scale))
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
+
+// rotate turn the image counter-clockwise in a canvas large enough for the rotated image
+func rotate(img image.Image, degrees float64) image.Image {
+	if math.Mod(degrees, 360) == 0 {
+		return img
+	}
+
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	bounds := img.Bounds()
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	rw := math.Abs(w*cos) + math.Abs(h*sin)
+	rh := math.Abs(w*sin) + math.Abs(h*cos)
+	rotated := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(rw)), int(math.Ceil(rh))))
+
+	// move the center of the image to the center of the canvas
+	x0, y0 := float64(bounds.Min.X)+w/2, float64(bounds.Min.Y)+h/2
+	transform := f64.Aff3{
+		cos, sin, rw/2 - cos*x0 - sin*y0,
+		-sin, cos, rh/2 + sin*x0 - cos*y0,
+	}
+	draw.BiLinear.Transform(rotated, transform, img, bounds, draw.Over, nil)
+	return rotated
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,26 +1,23 @@
 package WaterMark
 
 import (
-	"bytes"
-	"fmt"
 	"image"
 	"image/color"
-	"image/jpeg"
 	"io"
 	"os"
-	"strconv"
-
-	"golang.org/x/image/font"
-	"golang.org/x/image/font/inconsolata"
-	"golang.org/x/image/math/fixed"
 
 	"golang.org/x/image/draw"
+	"golang.org/x/image/font/opentype"
 )
 
-// ImageSizeLimitation equal to Memcached default size
+// ImageSizeLimitation equal to Memcached default size, it's the default MaxBytes
 const ImageSizeLimitation = 1024000
 
-// Service define the basic information for watermark
+// Service define the basic information for watermark. The mark is the Logo if it isn't nil, otherwise the Text
+// in the Font. Scale resizes the mark, and Margin is the part of the shorter side of the image between the mark
+// and the edges. An Opacity of 0 is the same as 1, Rotation is in degrees counter-clockwise, and Tile repeats
+// the mark over the whole image. The Invisible payload is hidden in the image after the visible mark, and the
+// visible mark is skipped if there is neither Text nor Logo.
 type Service struct {
 	SourceImg image.Image
 	Source    string
@@ -28,40 +25,35 @@ type Service struct {
 	TextColor color.Color
 	Scale     float64
 	Format    string
-}
+	MaxBytes  int
 
-// CreateWaterMark generate composed image
-func (wm *Service) CreateWaterMark(output io.Writer) (image.Image, error) {
-	var padding float64 = 2
-	w := 8 * (float64(len(wm.Text)) + (padding * 2))
-	h := 16 * padding
-	img := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
-	point := fixed.Point26_6{X: fixed.Int26_6(64 * padding), Y: fixed.Int26_6(h * 64)}
-
-	d := &font.Drawer{
-		Dst:  img,
-		Src:  image.NewUniform(wm.TextColor),
-		Face: inconsolata.Regular8x16,
-		Dot:  point,
-	}
-	d.DrawString(wm.Text)
+	Logo     image.Image
+	Font     *opentype.Font
+	Position string
+	Margin   float64
+	Opacity  float64
+	Rotation float64
+	Tile     bool
 
-	bounds := img.Bounds()
-	waterMarkImg := image.NewRGBA(image.Rect(0, 0, int(float64(bounds.Max.X)*wm.Scale), int(float64(bounds.Max.Y)*wm.Scale)))
-	draw.BiLinear.Scale(waterMarkImg, waterMarkImg.Bounds(), img, bounds, draw.Src, nil)
+	Invisible *Payload
+}
 
+// CreateWaterMark generate composed image, and write it in the Format within MaxBytes. It returns the encoded
+// image, which is downscaled if the quality alone can't meet the budget, and how it's encoded.
+func (wm *Service) CreateWaterMark(output io.Writer) (image.Image, Encoding, error) {
 	var err error
 	var source = wm.SourceImg
 	if len(wm.Source) != 0 {
 		// get the source file size
 		reader, err := os.Open(wm.Source)
-		defer reader.Close()
 		if err != nil {
-			return nil, err
+			return nil, Encoding{}, err
 		}
+		defer reader.Close()
+
 		source, _, err = image.Decode(reader)
 		if err != nil {
-			return nil, err
+			return nil, Encoding{}, err
 		}
 	}
 
@@ -69,38 +61,46 @@ func (wm *Service) CreateWaterMark(output io.Writer) (image.Image, error) {
 
 	// composited image
 	markedImage := image.NewRGBA(sourceBounds)
-	draw.Draw(markedImage, sourceBounds, source, image.ZP, draw.Src)
-
-	// horrizontal
-	watermarkBounds := waterMarkImg.Bounds()
-	offset := image.Point{X: sourceBounds.Max.X - watermarkBounds.Max.X + 25, Y: sourceBounds.Max.Y - watermarkBounds.Max.Y - 4}
-	draw.Draw(markedImage, watermarkBounds.Add(offset), waterMarkImg, image.ZP, draw.Over)
-
-	imgSize := len(markedImage.Pix)
-	markedBytes := make([]byte, 0)
-	markedBuffer := bytes.NewBuffer(markedBytes)
-	quality := jpeg.DefaultQuality
-
-	if imgSize > ImageSizeLimitation {
-		// downgrade the image below the 1M size
-		for {
-			err = jpeg.Encode(markedBuffer, markedImage, &jpeg.Options{Quality: quality})
-			imgSize = len(markedBuffer.Bytes())
-			if imgSize < ImageSizeLimitation {
-				break
-			}
-			markedBuffer.Reset()
-			fmt.Println("The image Size is " + strconv.Itoa(imgSize))
-			quality = quality - 10
+	draw.Draw(markedImage, sourceBounds, source, sourceBounds.Min, draw.Src)
+	if len(wm.Text) != 0 || wm.Logo != nil || wm.Invisible == nil {
+		mark, err := wm.markImage()
+		if err != nil {
+			return nil, Encoding{}, err
 		}
-		_, err = output.Write(markedBuffer.Bytes())
-	} else {
-		err = jpeg.Encode(output, markedImage, &jpeg.Options{Quality: 100})
+		wm.drawMark(markedImage, mark)
+	}
+
+	data, encodedImage, encoding, err := wm.encode(markedImage)
+	if err != nil {
+		return nil, Encoding{}, err
 	}
 
+	_, err = output.Write(data)
 	if err != nil {
-		return nil, err
+		return nil, Encoding{}, err
+	}
+
+	return encodedImage, encoding, nil
+}
+
+// encode hide the Invisible payload and encode the image. The payload is hidden again in the downscaled image,
+// since it's read from the blocks of the encoded size, and the image is downscaled rather than encoded below
+// MinPayloadQuality.
+func (wm *Service) encode(img image.Image) ([]byte, image.Image, Encoding, error) {
+	if wm.Invisible == nil {
+		return Encode(img, wm.Format, wm.MaxBytes)
 	}
 
-	return markedImage, err
+	for {
+		marked, err := EmbedPayload(img, *wm.Invisible)
+		if err != nil {
+			return nil, nil, Encoding{}, err
+		}
+
+		data, encodedImage, encoding, err := encode(marked, wm.Format, wm.MaxBytes, MinPayloadQuality)
+		if err != nil || encoding.Width == img.Bounds().Dx() {
+			return data, encodedImage, encoding, err
+		}
+		img = downscale(img, encoding.Width)
+	}
 }
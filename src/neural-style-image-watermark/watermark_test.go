@@ -1,9 +1,13 @@
 package WaterMark
 
 import (
+	"image"
 	"image/color"
+	"io/ioutil"
 	"os"
 	"testing"
+
+	"golang.org/x/image/draw"
 )
 
 func TestWaterMark(t *testing.T) {
@@ -18,9 +22,72 @@ func TestWaterMark(t *testing.T) {
 	outputFile, _ := os.Create("./mark_test.png")
 	defer outputFile.Close()
 
-	_, err := demoTest.CreateWaterMark(outputFile)
+	_, _, err := demoTest.CreateWaterMark(outputFile)
 
 	if err != nil {
 		t.Error(err.Error())
 	}
 }
+
+func TestPlaceKeepsMarkInside(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	size := image.Pt(50, 20)
+
+	cases := map[string]image.Point{
+		TopLeft:     image.Pt(10, 10),
+		Center:      image.Pt(75, 40),
+		BottomRight: image.Pt(140, 70),
+		"":          image.Pt(140, 70),
+	}
+
+	for position, expected := range cases {
+		if at := place(position, bounds, size, 10); at != expected {
+			t.Errorf("expected %v at %q, got %v", expected, position, at)
+		}
+	}
+
+	if at := place(BottomRight, bounds, image.Pt(300, 20), 10); at.X != 0 {
+		t.Errorf("expected the large mark to be aligned to the left, got %v", at)
+	}
+}
+
+func TestLogoWatermarkWithOpacityAndTiling(t *testing.T) {
+	source := image.NewRGBA(image.Rect(0, 0, 120, 80))
+	logo := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(logo, logo.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.ZP, draw.Src)
+
+	font, err := BuiltinFont("goregular")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wm := Service{SourceImg: source, Logo: logo, Font: font, Opacity: 0.5, Rotation: 45, Tile: true, Margin: 0.1}
+	marked, _, err := wm.CreateWaterMark(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	covered := 0
+	rgba := marked.(*image.RGBA)
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 120; x++ {
+			r, _, _, _ := rgba.At(x, y).RGBA()
+			if r > 0xffff*3/4 {
+				t.Fatalf("expected the half opaque logo, got red %d at %d,%d", r, x, y)
+			}
+
+			if r > 0 {
+				covered++
+			}
+		}
+	}
+
+	if covered < 120*80/10 {
+		t.Errorf("expected the logo to be tiled over the image, got %d pixels", covered)
+	}
+
+	text := Service{SourceImg: source, Text: "El-force", Font: font, Scale: 2, Position: Top}
+	if _, _, err := text.CreateWaterMark(ioutil.Discard); err != nil {
+		t.Errorf("expected the TrueType text to be drawn, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package OrderService
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// bid state
+const (
+	BidLeading = "leading" // current highest bid
+	BidOutbid  = "outbid"  // a higher bid has been placed
+	BidWon     = "won"     // the auction is closed and the bid wins
+	BidLost    = "lost"    // the auction is closed without this bid winning
+)
+
+// Bid define one offer of a buyer for an auction order
+type Bid struct {
+	ID              string    `json:"id"`
+	OrderID         string    `json:"orderId"`
+	Bidder          string    `json:"bidder"`
+	PriceValue      string    `json:"priceValue"`
+	StartTime       string    `json:"startTime"`
+	ServerStartTime time.Time `json:"serverStartTime"`
+	Status          string    `json:"status"`
+}
+
+// orderDueTime return the time when the selling or the auction of the order stops.
+// The order duration is counted in days and capped by maxDuration.
+func orderDueTime(order Order) time.Time {
+	days, err := strconv.Atoi(order.Duration)
+	if err != nil || days <= 0 || days > maxDuration {
+		days = maxDuration
+	}
+
+	return order.ServerStartTime.Add(time.Duration(days) * 24 * time.Hour)
+}
+
+func parsePrice(value string) (float64, error) {
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 {
+		return 0, errors.New("Bad price value " + value)
+	}
+
+	return price, nil
+}
+
+// minimalBid return the lowest acceptable price for the next bid of the order. It's counted from the highest
+// bid kept in the order, which is what the update of the bid compares, so a bid never passes by a stale price.
+func (svc *OrderService) minimalBid(order Order) (float64, error) {
+	if len(order.BuyInfo.PriceValue) == 0 {
+		// the first bid can start from the listed price
+		return parsePrice(order.Product.PriceValue)
+	}
+
+	current, err := parsePrice(order.BuyInfo.PriceValue)
+	if err != nil {
+		return 0, err
+	}
+
+	return current + svc.BidIncrement, nil
+}
+
+// PlaceBid add a new bid to an auction order
+func (svc *OrderService) PlaceBid(orderId string, bid Bid) error {
+	level.Debug(svc.Logger).Log("Input", "orderId", "Value", orderId, "Bidder", bid.Bidder)
+	order, err := svc.getOrderById(orderId)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "getOrderById", "Error", err)
+		return errors.New(generalErrorInfo)
+	}
+
+	if order.Product.PriceType != strconv.Itoa(NSUtil.Auction) {
+		level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Info", "isn't an auction")
+		return errors.New("The product isn't in auction.")
+	}
+
+	if _, err = nextStatus(order, EventBid); err != nil {
+		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be bid")
+		return errors.New("The auction has been closed. Please try the others.")
+	}
+
+	if time.Now().After(orderDueTime(order)) {
+		level.Error(svc.Logger).Log("Order", orderId, "Info", "auction is due")
+		return errors.New("The auction has been closed. Please try the others.")
+	}
+
+	if bid.Bidder == "" || bid.Bidder == order.Product.Owner {
+		level.Error(svc.Logger).Log("Bidder", bid.Bidder, "Info", "bad bidder")
+		return errors.New("The owner can't bid for the product.")
+	}
+
+	price, err := parsePrice(bid.PriceValue)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "parsePrice", "Error", err)
+		return errors.New("Please set right price")
+	}
+
+	minimal, err := svc.minimalBid(order)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "minimalBid", "Error", err)
+		return errors.New(generalErrorInfo)
+	}
+
+	if price < minimal {
+		level.Debug(svc.Logger).Log("Price", bid.PriceValue, "Minimal", minimal, "Info", "bid is too low")
+		return errors.New("The bid must be at least " + strconv.FormatFloat(minimal, 'f', -1, 64))
+	}
+
+	bid.ID = NSUtil.UniqueID()
+	bid.OrderID = orderId
+	bid.ServerStartTime = time.Now()
+	bid.Status = BidLeading
+
+	// the order keeps the current highest bid. Only update it when nobody has bid in between,
+	// so the earlier higher bid is never overwritten by a lower one.
+	buyInfo := BuyInfo{Buyer: bid.Bidder, PriceValue: bid.PriceValue,
+		StartTime: bid.StartTime, ServerStartTime: bid.ServerStartTime}
+	_, err = svc.transit(order, EventBid, bid.Bidder, "", func(order *Order) {
+		order.BuyInfo = buyInfo
+	})
+	if err != nil {
+		if err == errStatusChanged {
+			level.Debug(svc.Logger).Log("Order", orderId, "Info", "outbid by a concurrent bid")
+			return errors.New("A higher bid has been placed. Please try again.")
+		}
+
+		return err
+	}
+
+	err = svc.Bids.Add(bid)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Bids.Add", "Info", err)
+		return errors.New(generalErrorInfo)
+	}
+
+	err = svc.Chain.UpdatePrice(order.ChainId, bid.Bidder, bid.PriceValue)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Chain.UpdatePrice", "Info", err)
+		return errors.New(generalErrorInfo)
+	}
+
+	return nil
+}
+
+// GetBids return all the bids of an order, the highest bid first
+func (svc *OrderService) GetBids(orderId string) ([]Bid, error) {
+	level.Debug(svc.Logger).Log("Input", "orderId", "Value", orderId)
+	bids, err := svc.Bids.FindByOrder(orderId)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Bids.FindByOrder", "Info", err)
+		return bids, errors.New(generalErrorInfo)
+	}
+
+	sortBids(bids)
+	return bids, nil
+}
+
+// sortBids order the bids by price, and the earlier one wins for the same price
+func sortBids(bids []Bid) {
+	sort.SliceStable(bids, func(i, j int) bool {
+		left, _ := strconv.ParseFloat(bids[i].PriceValue, 64)
+		right, _ := strconv.ParseFloat(bids[j].PriceValue, 64)
+		if left != right {
+			return left > right
+		}
+
+		return bids[i].ServerStartTime.Before(bids[j].ServerStartTime)
+	})
+}
+
+func (svc *OrderService) getHighestBid(orderId string) (*Bid, error) {
+	bids, err := svc.GetBids(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bids) == 0 {
+		return nil, nil
+	}
+
+	return &bids[0], nil
+}
+
+// selectWinner pick the highest bid when the auction closes. No winner is returned if there
+// is no bid or the highest bid doesn't reach the reserve price.
+func (svc *OrderService) selectWinner(order Order) (*Bid, error) {
+	highest, err := svc.getHighestBid(order.ID)
+	if err != nil || highest == nil {
+		return nil, err
+	}
+
+	if len(order.Product.ReservePrice) != 0 {
+		reserve, err := parsePrice(order.Product.ReservePrice)
+		if err != nil {
+			return nil, err
+		}
+
+		price, err := parsePrice(highest.PriceValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if price < reserve {
+			level.Debug(svc.Logger).Log("Order", order.ID, "Price", highest.PriceValue,
+				"Reserve", order.Product.ReservePrice, "Info", "reserve price isn't reached")
+			return nil, nil
+		}
+	}
+
+	return highest, nil
+}
@@ -0,0 +1,169 @@
+package OrderService
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log/level"
+)
+
+// chain event type
+const (
+	chainEventConfirm = "confirm"
+	chainEventCancel  = "cancel"
+)
+
+// headers of the chain callbacks
+const (
+	ChainAdapterHeader   = "X-Chain-Adapter"
+	ChainSignatureHeader = "X-Chain-Signature"
+)
+
+// ChainSecrets keep the shared secret of each chain adapter. CHAIN_SECRETS is in the
+// format of "adapter1:secret1;adapter2:secret2".
+var ChainSecrets = parseChainSecrets(os.Getenv("CHAIN_SECRETS"))
+
+var errChainEventInProcess = errors.New("The chain event is in process. Please try it later.")
+
+// chainEventLease is how long a request handles a chain event. The event still in process after it is taken as
+// lost with its request, e.g. the server stopped, and is resumed when it's delivered again.
+const chainEventLease = 2 * time.Minute
+
+// ChainEvent record a callback from the chain, so the redelivered event is only acknowledged. A Partial
+// event failed after it may have changed the order, and is resumed when it's delivered again, and so is the
+// event still in process after LeaseUntil.
+type ChainEvent struct {
+	EventID    string    `json:"eventId"`
+	ChainId    string    `json:"chainId"`
+	Type       string    `json:"type"`
+	Result     string    `json:"result"`
+	Done       bool      `json:"done"`
+	Partial    bool      `json:"partial"`
+	Time       time.Time `json:"time"`
+	LeaseUntil time.Time `json:"leaseUntil"`
+}
+
+func parseChainSecrets(value string) map[string]string {
+	secrets := make(map[string]string)
+	for _, item := range strings.Split(value, ";") {
+		pair := strings.SplitN(item, ":", 2)
+		if len(pair) != 2 || len(pair[0]) == 0 || len(pair[1]) == 0 {
+			continue
+		}
+
+		secrets[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+
+	return secrets
+}
+
+// SignChainEvent return the hex encoded HMAC-SHA256 of the chain id and the request body
+func SignChainEvent(secret, chainId string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(chainId))
+	mac.Write([]byte("\n"))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ChainSignatureMiddleware only accept the chain callbacks signed by a known adapter
+func ChainSignatureMiddleware(secrets map[string]string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			req := request.(NSChainRequest)
+			secret, ok := secrets[req.Adapter]
+			if !ok {
+				return nil, NSUtil.NewErrorWithStatus(http.StatusUnauthorized, "Unknown chain adapter")
+			}
+
+			expected := SignChainEvent(secret, req.ChainId, req.Payload)
+			if !hmac.Equal([]byte(expected), []byte(strings.ToLower(req.Signature))) {
+				return nil, NSUtil.NewErrorWithStatus(http.StatusUnauthorized, "Bad chain signature")
+			}
+
+			if len(req.EventId) == 0 {
+				return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Missing chain event id")
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// applyChainEvent run the handler once for each event id. The replayed event which has been
+// handled is acknowledged without running the handler again. If the handler fails, the event is kept as
+// partial, and the redelivered event runs the handler again to resume from the current status of the order.
+// The event which is still in process after its lease is resumed in the same way.
+func (svc *OrderService) applyChainEvent(event ChainEvent, handler func(resume bool) error) error {
+	if len(event.EventID) == 0 {
+		return errors.New("Missing chain event id")
+	}
+
+	// the unique event id only allows one request to handle the event
+	event.Time = time.Now()
+	event.LeaseUntil = event.Time.Add(chainEventLease)
+	event.Done = false
+	event.Partial = false
+	resume := false
+	err := svc.ChainEvents.Insert(event)
+	if err != nil {
+		if err != NSUtil.ErrDuplicated {
+			level.Error(svc.Logger).Log("API", "ChainEvents.Insert", "Info", err)
+			return errors.New(generalErrorInfo)
+		}
+
+		handled, err := svc.ChainEvents.FindByID(event.EventID)
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "ChainEvents.FindByID", "Info", err)
+			return errors.New(generalErrorInfo)
+		}
+
+		if handled.Done {
+			level.Debug(svc.Logger).Log("Event", event.EventID, "Info", "has been handled")
+			return nil
+		}
+
+		resume, err = svc.ChainEvents.Resume(event.EventID, event.Time, event.LeaseUntil)
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "ChainEvents.Resume", "Info", err)
+			return errors.New(generalErrorInfo)
+		}
+		if !resume {
+			return errChainEventInProcess
+		}
+	}
+
+	err = handler(resume)
+	if err != nil {
+		// the chain can deliver the event again
+		if err := svc.ChainEvents.MarkPartial(event.EventID); err != nil {
+			level.Error(svc.Logger).Log("API", "ChainEvents.MarkPartial", "Info", err)
+		}
+		return err
+	}
+
+	err = svc.ChainEvents.MarkDone(event.EventID)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "ChainEvents.MarkDone", "Info", err)
+	}
+
+	return nil
+}
+
+// readChainPayload keep the raw body for the signature check
+func readChainPayload(r *http.Request) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(r.Body)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,134 @@
+package OrderService
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"neural-style-chain"
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestChainSignatureMiddleware(t *testing.T) {
+	secrets := parseChainSecrets("ledger:first; remote : second;bad")
+	if len(secrets) != 2 || secrets["remote"] != "second" {
+		t.Fatalf("unexpected secrets %v", secrets)
+	}
+
+	handled := 0
+	next := func(ctx context.Context, request interface{}) (interface{}, error) {
+		handled++
+		return nil, nil
+	}
+	handler := ChainSignatureMiddleware(secrets)(next)
+
+	payload := []byte(`{"eventId":"event","result":"success"}`)
+	req := NSChainRequest{ChainId: "chain", EventId: "event", Result: "success", Adapter: "ledger", Payload: payload,
+		Signature: SignChainEvent("first", "chain", payload)}
+	if _, err := handler(context.Background(), req); err != nil || handled != 1 {
+		t.Fatalf("expected the signed request to be handled, got %v", err)
+	}
+
+	forged := req
+	forged.ChainId = "other"
+	if _, err := handler(context.Background(), forged); err == nil {
+		t.Errorf("expected the signature for another chain id to be rejected")
+	}
+
+	unknown := req
+	unknown.Adapter = "unknown"
+	if _, err := handler(context.Background(), unknown); err == nil {
+		t.Errorf("expected the unknown adapter to be rejected")
+	}
+
+	if handled != 1 {
+		t.Errorf("expected the rejected requests not to be handled")
+	}
+}
+
+type failingCloseRepository struct {
+	OrderRepository
+	failures int
+}
+
+func (repo *failingCloseRepository) InsertClosed(order Order) error {
+	if repo.failures > 0 {
+		repo.failures--
+		return errors.New("closed orders are unavailable")
+	}
+	return repo.OrderRepository.InsertClosed(order)
+}
+
+func TestChainEventResumesAfterPartialFailure(t *testing.T) {
+	store := NewMemoryStore()
+	store.Orders = &failingCloseRepository{OrderRepository: store.Orders, failures: 1}
+	svc := NewOrderSVC("", "", log.NewNopLogger(), store, "http://127.0.0.1:0", 1, ChainService.NoopChain{})
+
+	err := svc.Sell(Order{Product: ProductInfo{Id: "product", Owner: "seller", PriceValue: "10",
+		Type: strconv.Itoa(NSUtil.Digit), PriceType: strconv.Itoa(NSUtil.Fix)}})
+	if err != nil {
+		t.Fatalf("unexpected sell error %v", err)
+	}
+	order, _ := svc.GetOrderByProductId("product")
+	if err = svc.Buy(order.ID, BuyInfo{Buyer: "buyer", PriceValue: "10"}); err != nil {
+		t.Fatalf("unexpected buy error %v", err)
+	}
+
+	// the order is completed, but it fails to be closed
+	if err = svc.ApplyConfirmFromChain(order.ChainId, "event", "success"); err == nil {
+		t.Fatal("expected the close to fail")
+	}
+	if event, _ := store.ChainEvents.FindByID("event"); event.Done || !event.Partial {
+		t.Fatalf("expected a partial event, got %+v", event)
+	}
+
+	// the redelivered event resumes from the completed status, and then is only acknowledged
+	for i := 0; i < 2; i++ {
+		if err = svc.ApplyConfirmFromChain(order.ChainId, "event", "success"); err != nil {
+			t.Fatalf("delivery %d: unexpected error %v", i, err)
+		}
+	}
+	if _, err = store.Orders.FindByID(order.ID); err != NSUtil.ErrNotFound {
+		t.Errorf("expected the order to be closed, got %v", err)
+	}
+
+	events, _ := svc.GetOrderHistory(order.ID)
+	if len(events) != 3 || events[2].Event != EventChainSuccess {
+		t.Errorf("expected one chain transition, got %+v", events)
+	}
+}
+
+func TestChainEventResumesAfterLeaseExpires(t *testing.T) {
+	store := NewMemoryStore()
+	svc := NewOrderSVC("", "", log.NewNopLogger(), store, "http://127.0.0.1:0", 1, ChainService.NoopChain{})
+
+	err := svc.Sell(Order{Product: ProductInfo{Id: "product", Owner: "seller", PriceValue: "10",
+		Type: strconv.Itoa(NSUtil.Digit), PriceType: strconv.Itoa(NSUtil.Fix)}})
+	if err != nil {
+		t.Fatalf("unexpected sell error %v", err)
+	}
+	order, _ := svc.GetOrderByProductId("product")
+	if err = svc.Buy(order.ID, BuyInfo{Buyer: "buyer", PriceValue: "10"}); err != nil {
+		t.Fatalf("unexpected buy error %v", err)
+	}
+
+	// the requests stopped while the events were in process
+	now := time.Now()
+	store.ChainEvents.Insert(ChainEvent{EventID: "held", ChainId: order.ChainId, LeaseUntil: now.Add(time.Minute)})
+	store.ChainEvents.Insert(ChainEvent{EventID: "lost", ChainId: order.ChainId, LeaseUntil: now.Add(-time.Second)})
+
+	if err = svc.ApplyConfirmFromChain(order.ChainId, "held", "success"); err != errChainEventInProcess {
+		t.Errorf("expected the event in its lease to be in process, got %v", err)
+	}
+
+	if err = svc.ApplyConfirmFromChain(order.ChainId, "lost", "success"); err != nil {
+		t.Fatalf("expected the event after its lease to be resumed, got %v", err)
+	}
+	if event, _ := store.ChainEvents.FindByID("lost"); !event.Done {
+		t.Errorf("expected the resumed event to be done, got %+v", event)
+	}
+}
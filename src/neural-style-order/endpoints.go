@@ -2,6 +2,7 @@ package OrderService
 
 import (
 	"context"
+	"neural-style-util"
 	"github.com/go-kit/kit/endpoint"
 )
 
@@ -47,7 +48,11 @@ type NSBuyRequest struct {
 
 type NSChainRequest struct {
 	ChainId   string
+	EventId   string
 	Result    string
+	Adapter   string
+	Signature string
+	Payload   []byte
 }
 
 type NSExpressRequest struct {
@@ -55,15 +60,46 @@ type NSExpressRequest struct {
 	ExpressData Express
 }
 
+type NSBidRequest struct {
+	OrderId     string
+	BidData     Bid
+}
+
+type NSBidsResponse struct {
+	Bids        []Bid
+	Err         error
+}
+
+type NSOrderHistoryResponse struct {
+	Events      []OrderEvent
+	Err         error
+}
+
 type NSAskForReturnRequest struct {
 	OrderId     string
 	ReturnData  ReturnInfo
 }
 
+// withoutReserve clear the reserve price if the viewer doesn't sell the product, the bidders shouldn't know it
+func withoutReserve(order Order, viewer string) Order {
+	if order.Product.Owner != viewer {
+		order.Product.ReservePrice = ""
+	}
+	return order
+}
+
+// hideReserve clear the reserve prices of the orders which the viewer doesn't sell
+func hideReserve(orders []Order, viewer string) {
+	for i := range orders {
+		orders[i] = withoutReserve(orders[i], viewer)
+	}
+}
+
 func MakeNSGetOrdersEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSGetOrdersRequest)
 		orders, err := svc.GetOrders(req.Buyer)
+		hideReserve(orders, NSUtil.ContextUser(ctx))
 		return NSOrdersResponse{Orders: orders, Err: err}, err
 	}
 }
@@ -71,6 +107,7 @@ func MakeNSGetOrdersEndpoint(svc Service) endpoint.Endpoint {
 func MakeNSGetOrdersInTransactionEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		orders, err := svc.GetOrdersInTransaction()
+		hideReserve(orders, NSUtil.ContextUser(ctx))
 		return NSOrdersResponse{Orders: orders, Err: err}, err
 	}
 }
@@ -79,6 +116,7 @@ func MakeNSGetSellingsEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSGetSellingsRequest)
 		orders, err := svc.GetSellings(req.Seller)
+		hideReserve(orders, NSUtil.ContextUser(ctx))
 		return NSOrdersResponse{Orders: orders, Err: err}, err
 	}
 }
@@ -87,6 +125,7 @@ func MakeNSGetOrderByProductIdEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSGetOrderByProductIdRequest)
 		order, err := svc.GetOrderByProductId(req.ProductId)
+		order = withoutReserve(order, NSUtil.ContextUser(ctx))
 		return NSGetOrderByProductIdResponse{Target: order, Err: err}, err
 	}
 }
@@ -110,6 +149,8 @@ func MakeNSStopSellingEndpoint(svc Service) endpoint.Endpoint {
 func MakeNSBuyEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSBuyRequest)
+		// buy as the user of the token, not as anyone in the body
+		req.BuyData.Buyer = NSUtil.ContextUser(ctx)
 		err := svc.Buy(req.OrderId, req.BuyData)
 		return NSErrorResponse{Err: err}, err
 	}
@@ -118,7 +159,7 @@ func MakeNSBuyEndpoint(svc Service) endpoint.Endpoint {
 func MakeNSApplyConfirmFromChainEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSChainRequest)
-		err := svc.ApplyConfirmFromChain(req.ChainId, req.Result)
+		err := svc.ApplyConfirmFromChain(req.ChainId, req.EventId, req.Result)
 		return NSErrorResponse{Err: err}, err
 	}
 }
@@ -174,7 +215,33 @@ func MakeNSConfirmReturnEndpoint(svc Service) endpoint.Endpoint {
 func MakeNSApplyCancelFromChainEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSChainRequest)
-		err := svc.ApplyCancelFromChain(req.ChainId, req.Result)
+		err := svc.ApplyCancelFromChain(req.ChainId, req.EventId, req.Result)
 		return NSErrorResponse{Err: err}, err
 	}
-}
\ No newline at end of file
+}
+
+func MakeNSPlaceBidEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSBidRequest)
+		// bid as the user of the token, not as anyone in the body
+		req.BidData.Bidder = NSUtil.ContextUser(ctx)
+		err := svc.PlaceBid(req.OrderId, req.BidData)
+		return NSErrorResponse{Err: err}, err
+	}
+}
+
+func MakeNSGetBidsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSOrderIdRequest)
+		bids, err := svc.GetBids(req.OrderId)
+		return NSBidsResponse{Bids: bids, Err: err}, err
+	}
+}
+
+func MakeNSGetOrderHistoryEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSOrderIdRequest)
+		events, err := svc.GetOrderHistory(req.OrderId)
+		return NSOrderHistoryResponse{Events: events, Err: err}, err
+	}
+}
@@ -71,12 +71,12 @@ func (svc *orderService) Buy(orderId string, buyInfo BuyInfo) (err error) {
 	return svc.dataService.Buy(orderId, buyInfo)
 }
 
-func (svc *orderService) ApplyConfirmFromChain(chainId string, result string) (err error) {
+func (svc *orderService) ApplyConfirmFromChain(chainId string, eventId string, result string) (err error) {
 	defer func(begin time.Time) {
-		svc.logger.Log("method", "ApplyConfirmFromChain", "chainId", chainId, "result", result, "took", time.Since(begin), "err", err)
+		svc.logger.Log("method", "ApplyConfirmFromChain", "chainId", chainId, "eventId", eventId, "result", result, "took", time.Since(begin), "err", err)
 	}(time.Now())
 
-	return svc.dataService.ApplyConfirmFromChain(chainId, result)
+	return svc.dataService.ApplyConfirmFromChain(chainId, eventId, result)
 }
 
 func (svc *orderService) ShipProduct(orderId string, express Express) (err error) {
@@ -127,10 +127,33 @@ func (svc *orderService) ConfirmReturn(orderId string) (err error) {
 	return svc.dataService.ConfirmReturn(orderId)
 }
 
-func (svc *orderService) ApplyCancelFromChain(chainId string, result string) (err error) {
+func (svc *orderService) ApplyCancelFromChain(chainId string, eventId string, result string) (err error) {
 	defer func(begin time.Time) {
-		svc.logger.Log("method", "ApplyCancelFromChain", "chainId", chainId, "result", result, "took", time.Since(begin), "err", err)
+		svc.logger.Log("method", "ApplyCancelFromChain", "chainId", chainId, "eventId", eventId, "result", result, "took", time.Since(begin), "err", err)
 	}(time.Now())
 
-	return svc.dataService.ApplyCancelFromChain(chainId, result)
-}
\ No newline at end of file
+	return svc.dataService.ApplyCancelFromChain(chainId, eventId, result)
+}
+func (svc *orderService) PlaceBid(orderId string, bid Bid) (err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "PlaceBid", "orderId", orderId, "bidder", bid.Bidder, "price", bid.PriceValue, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.PlaceBid(orderId, bid)
+}
+
+func (svc *orderService) GetBids(orderId string) (bids []Bid, err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "GetBids", "orderId", orderId, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.GetBids(orderId)
+}
+
+func (svc *orderService) GetOrderHistory(orderId string) (events []OrderEvent, err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "GetOrderHistory", "orderId", orderId, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.GetOrderHistory(orderId)
+}
@@ -0,0 +1,348 @@
+package OrderService
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"neural-style-util"
+)
+
+// NewMemoryStore create the repositories in memory
+func NewMemoryStore() Store {
+	orders := &memoryOrderRepository{}
+	return Store{
+		Orders:      orders,
+		Bids:        &memoryBidRepository{},
+		OrderEvents: &memoryOrderEventRepository{},
+		ChainEvents: &memoryChainEventRepository{events: make(map[string]ChainEvent)},
+		Due:         &memoryDueRepository{orders: orders, records: make(map[string]DueRecord)},
+	}
+}
+
+type memoryOrderRepository struct {
+	mutex  sync.RWMutex
+	orders []Order
+	closed []Order
+}
+
+func (repo *memoryOrderRepository) find(match func(order Order) bool) []Order {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var orders []Order
+	for _, order := range repo.orders {
+		if match(order) {
+			orders = append(orders, order)
+		}
+	}
+
+	return orders
+}
+
+func (repo *memoryOrderRepository) findOne(match func(order Order) bool) (Order, error) {
+	orders := repo.find(match)
+	if len(orders) == 0 {
+		return Order{}, NSUtil.ErrNotFound
+	}
+
+	return orders[0], nil
+}
+
+func (repo *memoryOrderRepository) FindAll() ([]Order, error) {
+	return repo.find(func(order Order) bool { return true }), nil
+}
+
+func (repo *memoryOrderRepository) FindByID(orderId string) (Order, error) {
+	return repo.findOne(func(order Order) bool { return order.ID == orderId })
+}
+
+func (repo *memoryOrderRepository) FindByProductID(productId string) (Order, error) {
+	return repo.findOne(func(order Order) bool { return order.Product.Id == productId })
+}
+
+func (repo *memoryOrderRepository) FindByChainID(chainId string) (Order, error) {
+	return repo.findOne(func(order Order) bool { return order.ChainId == chainId })
+}
+
+func (repo *memoryOrderRepository) FindByBuyer(buyer string) ([]Order, error) {
+	return repo.find(func(order Order) bool { return order.BuyInfo.Buyer == buyer }), nil
+}
+
+func (repo *memoryOrderRepository) FindBySeller(seller string) ([]Order, error) {
+	return repo.find(func(order Order) bool { return order.Product.Owner == seller }), nil
+}
+
+func (repo *memoryOrderRepository) FindByStatus(status []string) ([]Order, error) {
+	return repo.find(func(order Order) bool {
+		for _, s := range status {
+			if order.Status == s {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+func (repo *memoryOrderRepository) Insert(order Order) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.orders = append(repo.orders, order)
+	return nil
+}
+
+func (repo *memoryOrderRepository) Update(current, updated Order) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for index, order := range repo.orders {
+		if order.ID == current.ID && order.Status == current.Status &&
+			order.BuyInfo.PriceValue == current.BuyInfo.PriceValue {
+			repo.orders[index] = updated
+			return nil
+		}
+	}
+
+	return NSUtil.ErrNotFound
+}
+
+func (repo *memoryOrderRepository) Remove(orderId string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for index, order := range repo.orders {
+		if order.ID == orderId {
+			repo.orders = append(repo.orders[:index], repo.orders[index+1:]...)
+			return nil
+		}
+	}
+
+	return NSUtil.ErrNotFound
+}
+
+func (repo *memoryOrderRepository) InsertClosed(order Order) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for i := range repo.closed {
+		if repo.closed[i].ID == order.ID {
+			repo.closed[i] = order
+			return nil
+		}
+	}
+
+	repo.closed = append(repo.closed, order)
+	return nil
+}
+
+type memoryBidRepository struct {
+	mutex sync.RWMutex
+	bids  []Bid
+}
+
+func (repo *memoryBidRepository) FindByOrder(orderId string) ([]Bid, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var bids []Bid
+	for _, bid := range repo.bids {
+		if bid.OrderID == orderId {
+			bids = append(bids, bid)
+		}
+	}
+
+	return bids, nil
+}
+
+func (repo *memoryBidRepository) Add(bid Bid) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	// the bids are added in the order of their placing, but a later bid may be added first
+	for index := range repo.bids {
+		if repo.bids[index].OrderID != bid.OrderID {
+			continue
+		}
+
+		if repo.bids[index].ServerStartTime.After(bid.ServerStartTime) {
+			bid.Status = BidOutbid
+		} else if repo.bids[index].Status == BidLeading {
+			repo.bids[index].Status = BidOutbid
+		}
+	}
+
+	repo.bids = append(repo.bids, bid)
+	return nil
+}
+
+func (repo *memoryBidRepository) Close(orderId, winnerId string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for index := range repo.bids {
+		if repo.bids[index].ID == winnerId && len(winnerId) != 0 {
+			repo.bids[index].Status = BidWon
+		} else if repo.bids[index].OrderID == orderId {
+			repo.bids[index].Status = BidLost
+		}
+	}
+
+	return nil
+}
+
+type memoryOrderEventRepository struct {
+	mutex  sync.RWMutex
+	events []OrderEvent
+}
+
+func (repo *memoryOrderEventRepository) Insert(event OrderEvent) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.events = append(repo.events, event)
+	return nil
+}
+
+func (repo *memoryOrderEventRepository) FindByOrder(orderId string) ([]OrderEvent, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var events []OrderEvent
+	for _, event := range repo.events {
+		if event.OrderID == orderId {
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+type memoryChainEventRepository struct {
+	mutex  sync.Mutex
+	events map[string]ChainEvent
+}
+
+func (repo *memoryChainEventRepository) Insert(event ChainEvent) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.events[event.EventID]; ok {
+		return NSUtil.ErrDuplicated
+	}
+
+	repo.events[event.EventID] = event
+	return nil
+}
+
+func (repo *memoryChainEventRepository) FindByID(eventId string) (ChainEvent, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	event, ok := repo.events[eventId]
+	if !ok {
+		return event, NSUtil.ErrNotFound
+	}
+
+	return event, nil
+}
+
+func (repo *memoryChainEventRepository) MarkDone(eventId string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	event, ok := repo.events[eventId]
+	if !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	event.Done = true
+	repo.events[eventId] = event
+	return nil
+}
+
+func (repo *memoryChainEventRepository) MarkPartial(eventId string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	event, ok := repo.events[eventId]
+	if !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	event.Partial = true
+	repo.events[eventId] = event
+	return nil
+}
+
+func (repo *memoryChainEventRepository) Resume(eventId string, now, until time.Time) (bool, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	event, ok := repo.events[eventId]
+	if !ok || event.Done || (!event.Partial && !event.LeaseUntil.Before(now)) {
+		return false, nil
+	}
+
+	event.Partial = false
+	event.LeaseUntil = until
+	repo.events[eventId] = event
+	return true, nil
+}
+
+type memoryDueRepository struct {
+	mutex   sync.Mutex
+	orders  OrderRepository
+	records map[string]DueRecord
+}
+
+func (repo *memoryDueRepository) FindOpenOrders() ([]Order, error) {
+	return repo.orders.FindByStatus(openStatus)
+}
+
+func (repo *memoryDueRepository) AcquireLease(orderId, owner string, now, until time.Time) (DueRecord, bool, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	record, ok := repo.records[orderId]
+	if ok && (record.Done || !record.LeaseUntil.Before(now)) {
+		return record, false, nil
+	}
+
+	record.OrderID = orderId
+	record.Owner = owner
+	record.LeaseUntil = until
+	repo.records[orderId] = record
+	return record, true, nil
+}
+
+func (repo *memoryDueRepository) RenewLease(orderId, owner string, until time.Time) (bool, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	record, ok := repo.records[orderId]
+	if !ok || record.Owner != owner || record.Done {
+		return false, nil
+	}
+
+	record.LeaseUntil = until
+	repo.records[orderId] = record
+	return true, nil
+}
+
+func (repo *memoryDueRepository) RecordOutcome(record DueRecord) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	current, ok := repo.records[record.OrderID]
+	if !ok || current.Owner != record.Owner {
+		return NSUtil.ErrNotFound
+	}
+
+	repo.records[record.OrderID] = record
+	return nil
+}
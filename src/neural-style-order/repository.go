@@ -0,0 +1,393 @@
+package OrderService
+
+import (
+	"strconv"
+	"time"
+
+	"neural-style-util"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// OrderRepository define the data access of the orders in transaction and the closed orders
+type OrderRepository interface {
+	FindAll() ([]Order, error)
+	// FindByID, FindByProductID and FindByChainID return NSUtil.ErrNotFound if no order matches
+	FindByID(orderId string) (Order, error)
+	FindByProductID(productId string) (Order, error)
+	FindByChainID(chainId string) (Order, error)
+	FindByBuyer(buyer string) ([]Order, error)
+	FindBySeller(seller string) ([]Order, error)
+	FindByStatus(status []string) ([]Order, error)
+	Insert(order Order) error
+	// Update replace the stored order only when it still has the status and the bid price of current.
+	// NSUtil.ErrNotFound is returned if the order has been changed by others.
+	Update(current, updated Order) error
+	Remove(orderId string) error
+	// InsertClosed replace the closed order with the same id, so an order can be closed again
+	InsertClosed(order Order) error
+}
+
+// BidRepository define the data access of the auction bids
+type BidRepository interface {
+	FindByOrder(orderId string) ([]Bid, error)
+	// Add insert the new leading bid, and the earlier leading bids are outbid. The bid is outbid at once if
+	// a later bid has been added.
+	Add(bid Bid) error
+	// Close mark the winner bid as won and the others as lost
+	Close(orderId, winnerId string) error
+}
+
+// OrderEventRepository define the data access of the order history
+type OrderEventRepository interface {
+	Insert(event OrderEvent) error
+	// FindByOrder return the events of the order in time order
+	FindByOrder(orderId string) ([]OrderEvent, error)
+}
+
+// ChainEventRepository define the data access of the handled chain callbacks
+type ChainEventRepository interface {
+	// Insert return NSUtil.ErrDuplicated if the event id exists
+	Insert(event ChainEvent) error
+	FindByID(eventId string) (ChainEvent, error)
+	MarkDone(eventId string) error
+	// MarkPartial keep the failed event, so it's resumed when it's delivered again
+	MarkPartial(eventId string) error
+	// Resume return true if the request gets the partial event, or the event still in process after its lease at
+	// the time. Only one request resumes it, and holds it until the new lease.
+	Resume(eventId string, now, until time.Time) (bool, error)
+}
+
+// DueRepository define the data access of the scheduler
+type DueRepository interface {
+	// FindOpenOrders return the orders which are still waiting for a buyer or a bid
+	FindOpenOrders() ([]Order, error)
+	// AcquireLease return true and the record with the lease if the owner gets the right to expire the order
+	AcquireLease(orderId, owner string, now, until time.Time) (DueRecord, bool, error)
+	// RenewLease extend the lease while the owner is expiring the order, it returns false if the lease is lost
+	RenewLease(orderId, owner string, until time.Time) (bool, error)
+	// RecordOutcome save the result of OrderIsDue in the record of the owner. The order isn't tried again if
+	// it's done, otherwise the lease is released at LeaseUntil, so a later scan tries it again.
+	RecordOutcome(record DueRecord) error
+}
+
+// Store group all the repositories of the order service
+type Store struct {
+	Orders      OrderRepository
+	Bids        BidRepository
+	OrderEvents OrderEventRepository
+	ChainEvents ChainEventRepository
+	Due         DueRepository
+}
+
+// NewMongoStore create the repositories on MongoDB
+func NewMongoStore(session *mgo.Session) Store {
+	return Store{
+		Orders:      &mgoOrderRepository{session: session},
+		Bids:        &mgoBidRepository{session: session},
+		OrderEvents: &mgoOrderEventRepository{session: session},
+		ChainEvents: &mgoChainEventRepository{session: session},
+		Due:         &mgoDueStore{Session: session},
+	}
+}
+
+// openStatus is the status of the orders which can be expired
+var openStatus = []string{strconv.Itoa(NSUtil.None), strconv.Itoa(NSUtil.InAuction)}
+
+type mgoOrderRepository struct {
+	session *mgo.Session
+}
+
+func (repo *mgoOrderRepository) find(query bson.M) ([]Order, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var orders []Order
+	err := session.DB("store").C("orders").Find(query).All(&orders)
+	return orders, err
+}
+
+func (repo *mgoOrderRepository) findOne(query bson.M) (Order, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var order Order
+	err := session.DB("store").C("orders").Find(query).One(&order)
+	if err == mgo.ErrNotFound {
+		return order, NSUtil.ErrNotFound
+	}
+
+	return order, err
+}
+
+func (repo *mgoOrderRepository) FindAll() ([]Order, error) {
+	return repo.find(bson.M{})
+}
+
+func (repo *mgoOrderRepository) FindByID(orderId string) (Order, error) {
+	return repo.findOne(bson.M{"id": orderId})
+}
+
+func (repo *mgoOrderRepository) FindByProductID(productId string) (Order, error) {
+	return repo.findOne(bson.M{"product.id": productId})
+}
+
+func (repo *mgoOrderRepository) FindByChainID(chainId string) (Order, error) {
+	return repo.findOne(bson.M{"chainid": chainId})
+}
+
+func (repo *mgoOrderRepository) FindByBuyer(buyer string) ([]Order, error) {
+	return repo.find(bson.M{"buyinfo.buyer": buyer})
+}
+
+func (repo *mgoOrderRepository) FindBySeller(seller string) ([]Order, error) {
+	return repo.find(bson.M{"product.owner": seller})
+}
+
+func (repo *mgoOrderRepository) FindByStatus(status []string) ([]Order, error) {
+	return repo.find(bson.M{"status": bson.M{"$in": status}})
+}
+
+func (repo *mgoOrderRepository) Insert(order Order) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("orders").Insert(order)
+}
+
+func (repo *mgoOrderRepository) Update(current, updated Order) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	selector := bson.M{"id": current.ID, "status": current.Status, "buyinfo.pricevalue": current.BuyInfo.PriceValue}
+	err := session.DB("store").C("orders").Update(selector, updated)
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoOrderRepository) Remove(orderId string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("orders").Remove(bson.M{"id": orderId})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoOrderRepository) InsertClosed(order Order) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB("store").C("closedorders").Upsert(bson.M{"id": order.ID}, order)
+	return err
+}
+
+type mgoBidRepository struct {
+	session *mgo.Session
+}
+
+func (repo *mgoBidRepository) FindByOrder(orderId string) ([]Bid, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var bids []Bid
+	err := session.DB("store").C("bids").Find(bson.M{"orderid": orderId}).All(&bids)
+	return bids, err
+}
+
+func (repo *mgoBidRepository) Add(bid Bid) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	c := session.DB("store").C("bids")
+	err := c.Insert(bid)
+	if err != nil {
+		return err
+	}
+
+	// the earlier leading bids are outbid now. The bids are placed in the order of their time, but a later bid
+	// may be added first, then this bid is outbid by it.
+	_, err = c.UpdateAll(bson.M{"orderid": bid.OrderID, "status": BidLeading,
+		"serverstarttime": bson.M{"$lt": bid.ServerStartTime}}, bson.M{"$set": bson.M{"status": BidOutbid}})
+	if err != nil {
+		return err
+	}
+
+	later, err := c.Find(bson.M{"orderid": bid.OrderID, "serverstarttime": bson.M{"$gt": bid.ServerStartTime}}).Count()
+	if err != nil || later == 0 {
+		return err
+	}
+
+	return c.Update(bson.M{"id": bid.ID}, bson.M{"$set": bson.M{"status": BidOutbid}})
+}
+
+func (repo *mgoBidRepository) Close(orderId, winnerId string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	c := session.DB("store").C("bids")
+	_, err := c.UpdateAll(bson.M{"orderid": orderId, "id": bson.M{"$ne": winnerId}},
+		bson.M{"$set": bson.M{"status": BidLost}})
+	if err != nil {
+		return err
+	}
+
+	if len(winnerId) == 0 {
+		return nil
+	}
+
+	return c.Update(bson.M{"id": winnerId}, bson.M{"$set": bson.M{"status": BidWon}})
+}
+
+type mgoOrderEventRepository struct {
+	session *mgo.Session
+}
+
+func (repo *mgoOrderEventRepository) Insert(event OrderEvent) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("order_events").Insert(event)
+}
+
+func (repo *mgoOrderEventRepository) FindByOrder(orderId string) ([]OrderEvent, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var events []OrderEvent
+	err := session.DB("store").C("order_events").Find(bson.M{"orderid": orderId}).Sort("time").All(&events)
+	return events, err
+}
+
+type mgoChainEventRepository struct {
+	session *mgo.Session
+}
+
+// Insert rely on the unique index on eventid
+func (repo *mgoChainEventRepository) Insert(event ChainEvent) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("chainevents").Insert(event)
+	if mgo.IsDup(err) {
+		return NSUtil.ErrDuplicated
+	}
+
+	return err
+}
+
+func (repo *mgoChainEventRepository) FindByID(eventId string) (ChainEvent, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var event ChainEvent
+	err := session.DB("store").C("chainevents").Find(bson.M{"eventid": eventId}).One(&event)
+	if err == mgo.ErrNotFound {
+		return event, NSUtil.ErrNotFound
+	}
+
+	return event, err
+}
+
+func (repo *mgoChainEventRepository) MarkDone(eventId string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("chainevents").Update(bson.M{"eventid": eventId}, bson.M{"$set": bson.M{"done": true}})
+}
+
+func (repo *mgoChainEventRepository) MarkPartial(eventId string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("chainevents").Update(bson.M{"eventid": eventId}, bson.M{"$set": bson.M{"partial": true}})
+}
+
+func (repo *mgoChainEventRepository) Resume(eventId string, now, until time.Time) (bool, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	selector := bson.M{"eventid": eventId, "done": false,
+		"$or": []bson.M{{"partial": true}, {"leaseuntil": bson.M{"$lt": now}}}}
+	err := session.DB("store").C("chainevents").Update(selector,
+		bson.M{"$set": bson.M{"partial": false, "leaseuntil": until}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+type mgoDueStore struct {
+	Session *mgo.Session
+}
+
+func (store *mgoDueStore) FindOpenOrders() ([]Order, error) {
+	session := store.Session.Copy()
+	defer session.Close()
+
+	c := session.DB("store").C("orders")
+
+	var orders []Order
+	err := c.Find(bson.M{"status": bson.M{"$in": openStatus}}).All(&orders)
+	return orders, err
+}
+
+func (store *mgoDueStore) AcquireLease(orderId, owner string, now, until time.Time) (DueRecord, bool, error) {
+	session := store.Session.Copy()
+	defer session.Close()
+
+	c := session.DB("store").C("orderdue")
+
+	// the unique index on orderid only allows one instance to create the lease
+	record := DueRecord{OrderID: orderId, Owner: owner, LeaseUntil: until}
+	err := c.Insert(record)
+	if err == nil {
+		return record, true, nil
+	}
+
+	if !mgo.IsDup(err) {
+		return record, false, err
+	}
+
+	// take over the lease of a crashed instance, or of a failed order after its retry delay
+	selector := bson.M{"orderid": orderId, "done": false, "leaseuntil": bson.M{"$lt": now}}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"owner": owner, "leaseuntil": until}}, ReturnNew: true}
+	_, err = c.Find(selector).Apply(change, &record)
+	if err == mgo.ErrNotFound {
+		return record, false, nil
+	}
+
+	return record, err == nil, err
+}
+
+func (store *mgoDueStore) RenewLease(orderId, owner string, until time.Time) (bool, error) {
+	session := store.Session.Copy()
+	defer session.Close()
+
+	c := session.DB("store").C("orderdue")
+	selector := bson.M{"orderid": orderId, "owner": owner, "done": false}
+	err := c.Update(selector, bson.M{"$set": bson.M{"leaseuntil": until}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (store *mgoDueStore) RecordOutcome(record DueRecord) error {
+	session := store.Session.Copy()
+	defer session.Close()
+
+	c := session.DB("store").C("orderdue")
+	updateData := bson.M{"done": record.Done, "result": record.Result, "firetime": record.FireTime,
+		"leaseuntil": record.LeaseUntil, "failures": record.Failures}
+	return c.Update(bson.M{"orderid": record.OrderID, "owner": record.Owner}, bson.M{"$set": updateData})
+}
@@ -0,0 +1,191 @@
+package OrderService
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Clock supply the current time, so the scheduler can be driven by a fake clock in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// DueRecord keep the expiration result of one order. The record is also the lease which
+// guarantees only one server instance handles the expired order. The lease is renewed while the order
+// is handled, and released if the handling fails, so a later scan tries the order again. Failures counts
+// the failed attempts in a row, and the order is Done without being expired when they reach the max, then
+// Result keeps the last error.
+type DueRecord struct {
+	OrderID    string    `json:"orderId"`
+	Owner      string    `json:"owner"`
+	LeaseUntil time.Time `json:"leaseUntil"`
+	Done       bool      `json:"done"`
+	Result     string    `json:"result"`
+	FireTime   time.Time `json:"fireTime"`
+	Failures   int       `json:"failures"`
+}
+
+// the defaults of the retries of the failed orders
+const (
+	maxDueFailures   = 10
+	maxDueRetryDelay = 6 * time.Hour
+)
+
+// Scheduler scan the orders periodically and expire the orders which are due. A failed order is tried again
+// after RetryDelay, which is doubled after each failure, and is given up after MaxFailures. The zero RetryDelay
+// tries it in the next scan, and the zero MaxFailures never gives it up.
+type Scheduler struct {
+	Store       DueRepository
+	Handler     func(orderId string) error
+	Clock       Clock
+	Interval    time.Duration
+	Lease       time.Duration
+	RetryDelay  time.Duration
+	MaxFailures int
+	Owner       string
+	Logger      log.Logger
+}
+
+// NewScheduler generate the scheduler which calls OrderIsDue for the expired orders
+func NewScheduler(svc *OrderService, interval time.Duration, logger log.Logger) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		Store:       svc.Due,
+		Handler:     svc.OrderIsDue,
+		Clock:       systemClock{},
+		Interval:    interval,
+		Lease:       5 * time.Minute,
+		RetryDelay:  interval,
+		MaxFailures: maxDueFailures,
+		Owner:       host + "-" + NSUtil.UniqueID(),
+		Logger:      logger,
+	}
+}
+
+// Start run the scheduler in background until the context is done
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				level.Debug(s.Logger).Log("API", "Scheduler", "info", "stopped")
+				return
+			case <-ticker.C:
+				s.RunOnce()
+			}
+		}
+	}()
+}
+
+// RunOnce expire all the due orders, and return how many orders are expired by this scheduler. A failed order
+// stays open, and is tried again after its retry delay.
+func (s *Scheduler) RunOnce() int {
+	orders, err := s.Store.FindOpenOrders()
+	if err != nil {
+		level.Error(s.Logger).Log("API", "FindOpenOrders", "Info", err)
+		return 0
+	}
+
+	fired := 0
+	for _, order := range orders {
+		now := s.Clock.Now()
+		if now.Before(orderDueTime(order)) {
+			continue
+		}
+
+		record, ok, err := s.Store.AcquireLease(order.ID, s.Owner, now, now.Add(s.Lease))
+		if err != nil {
+			level.Error(s.Logger).Log("API", "AcquireLease", "Order", order.ID, "Info", err)
+			continue
+		}
+
+		// another server instance is expiring the order, or it's waiting for the retry
+		if !ok {
+			continue
+		}
+
+		err = s.handle(order.ID)
+		record.FireTime = s.Clock.Now()
+		if err == nil {
+			record.Done = true
+			record.Result = "success"
+			fired++
+		} else {
+			record.Failures++
+			record.Result = err.Error()
+			record.LeaseUntil = record.FireTime.Add(s.retryDelay(record.Failures))
+			record.Done = s.MaxFailures > 0 && record.Failures >= s.MaxFailures
+			level.Error(s.Logger).Log("API", "OrderIsDue", "Order", order.ID, "Failures", record.Failures,
+				"GiveUp", record.Done, "Info", err)
+		}
+
+		err = s.Store.RecordOutcome(record)
+		if err != nil {
+			level.Error(s.Logger).Log("API", "RecordOutcome", "Order", order.ID, "Info", err)
+		}
+	}
+
+	return fired
+}
+
+// retryDelay return the delay before the next attempt after the failures in a row
+func (s *Scheduler) retryDelay(failures int) time.Duration {
+	delay := s.RetryDelay
+	for i := 1; i < failures && delay < maxDueRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxDueRetryDelay {
+		delay = maxDueRetryDelay
+	}
+	return delay
+}
+
+// handle call the Handler, and renew the lease every third of it until the Handler returns. The Handler can't
+// be stopped if the lease is lost anyway, e.g. the store is unreachable for a whole lease, then the order may be
+// handled twice, which the status transitions of the order reject.
+func (s *Scheduler) handle(orderId string) error {
+	if s.Lease < 3 {
+		return s.Handler(orderId)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(s.Lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ok, err := s.Store.RenewLease(orderId, s.Owner, s.Clock.Now().Add(s.Lease))
+				if err != nil || !ok {
+					level.Error(s.Logger).Log("API", "RenewLease", "Order", orderId, "Renewed", ok, "Info", err)
+				}
+			}
+		}
+	}()
+
+	err := s.Handler(orderId)
+	close(stop)
+	<-stopped
+	return err
+}
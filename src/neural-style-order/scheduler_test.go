@@ -0,0 +1,253 @@
+package OrderService
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+type fakeDueStore struct {
+	mutex   sync.Mutex
+	orders  []Order
+	records map[string]*DueRecord
+}
+
+func (store *fakeDueStore) FindOpenOrders() ([]Order, error) {
+	return store.orders, nil
+}
+
+func (store *fakeDueStore) AcquireLease(orderId, owner string, now, until time.Time) (DueRecord, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record, ok := store.records[orderId]
+	if !ok {
+		record = &DueRecord{OrderID: orderId, Owner: owner, LeaseUntil: until}
+		store.records[orderId] = record
+		return *record, true, nil
+	}
+
+	if record.Done || !record.LeaseUntil.Before(now) {
+		return *record, false, nil
+	}
+
+	record.Owner = owner
+	record.LeaseUntil = until
+	return *record, true, nil
+}
+
+func (store *fakeDueStore) RenewLease(orderId, owner string, until time.Time) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record := store.records[orderId]
+	if record.Owner != owner || record.Done {
+		return false, nil
+	}
+
+	record.LeaseUntil = until
+	return true, nil
+}
+
+func (store *fakeDueStore) RecordOutcome(record DueRecord) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.records[record.OrderID].Owner == record.Owner {
+		store.records[record.OrderID] = &record
+	}
+
+	return nil
+}
+
+func TestSchedulerExpiresDueOrdersOnce(t *testing.T) {
+	start := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeDueStore{
+		orders: []Order{
+			{ID: "due", Duration: "1", ServerStartTime: start, Status: strconv.Itoa(NSUtil.None)},
+			{ID: "open", Duration: "10", ServerStartTime: start, Status: strconv.Itoa(NSUtil.InAuction)},
+		},
+		records: make(map[string]*DueRecord),
+	}
+	clock := &fakeClock{now: start.Add(48 * time.Hour)}
+
+	var fired []string
+	handler := func(orderId string) error {
+		fired = append(fired, orderId)
+		return nil
+	}
+
+	first := &Scheduler{Store: store, Handler: handler, Clock: clock, Lease: time.Minute, Owner: "first", Logger: log.NewNopLogger()}
+	second := &Scheduler{Store: store, Handler: handler, Clock: clock, Lease: time.Minute, Owner: "second", Logger: log.NewNopLogger()}
+
+	if count := first.RunOnce(); count != 1 {
+		t.Errorf("expected 1 expired order, got %d", count)
+	}
+
+	if count := second.RunOnce(); count != 0 {
+		t.Errorf("expected the second scheduler not to expire orders, got %d", count)
+	}
+
+	if len(fired) != 1 || fired[0] != "due" {
+		t.Errorf("unexpected expired orders %v", fired)
+	}
+
+	if record := store.records["due"]; !record.Done || record.Result != "success" {
+		t.Errorf("unexpected due record %+v", record)
+	}
+}
+
+func TestSchedulerTakesOverExpiredLease(t *testing.T) {
+	start := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeDueStore{
+		orders:  []Order{{ID: "due", Duration: "1", ServerStartTime: start}},
+		records: make(map[string]*DueRecord),
+	}
+	clock := &fakeClock{now: start.Add(48 * time.Hour)}
+
+	// the crashed instance never records the outcome
+	store.AcquireLease("due", "crashed", clock.now, clock.now.Add(time.Minute))
+
+	fired := 0
+	scheduler := &Scheduler{Store: store, Clock: clock, Lease: time.Minute, Owner: "alive", Logger: log.NewNopLogger(),
+		Handler: func(orderId string) error {
+			fired++
+			return nil
+		}}
+
+	scheduler.RunOnce()
+	if fired != 0 {
+		t.Errorf("expected the lease of the crashed instance to be respected")
+	}
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	scheduler.RunOnce()
+	if fired != 1 || store.records["due"].Owner != "alive" {
+		t.Errorf("expected the expired lease to be taken over")
+	}
+}
+
+func TestSchedulerRetriesFailedOrder(t *testing.T) {
+	start := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeDueStore{
+		orders:  []Order{{ID: "due", Duration: "1", ServerStartTime: start}},
+		records: make(map[string]*DueRecord),
+	}
+	clock := &fakeClock{now: start.Add(48 * time.Hour)}
+
+	calls := 0
+	scheduler := &Scheduler{Store: store, Clock: clock, Lease: time.Minute, Owner: "first", Logger: log.NewNopLogger(),
+		Handler: func(orderId string) error {
+			calls++
+			if calls == 1 {
+				return errors.New("Failed to stop auction")
+			}
+			return nil
+		}}
+
+	if count := scheduler.RunOnce(); count != 0 {
+		t.Errorf("expected the failed order not to be counted, got %d", count)
+	}
+	if record := store.records["due"]; record.Done || record.LeaseUntil.After(clock.now) {
+		t.Fatalf("expected the lease of the failed order to be released, got %+v", record)
+	}
+
+	// another instance retries it in the next scan
+	other := &Scheduler{Store: store, Handler: scheduler.Handler, Clock: clock, Lease: time.Minute, Owner: "second",
+		Logger: log.NewNopLogger()}
+	clock.now = clock.now.Add(time.Second)
+	if count := other.RunOnce(); count != 1 || calls != 2 {
+		t.Errorf("expected the failed order to be retried, got %d after %d calls", count, calls)
+	}
+	if record := store.records["due"]; !record.Done || record.Result != "success" || record.Owner != "second" {
+		t.Errorf("unexpected due record %+v", record)
+	}
+}
+
+type renewCountingStore struct {
+	*fakeDueStore
+	renewals int32
+}
+
+func (store *renewCountingStore) RenewLease(orderId, owner string, until time.Time) (bool, error) {
+	atomic.AddInt32(&store.renewals, 1)
+	return store.fakeDueStore.RenewLease(orderId, owner, until)
+}
+
+func TestSchedulerRenewsLeaseOfLongHandler(t *testing.T) {
+	start := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	store := &renewCountingStore{fakeDueStore: &fakeDueStore{
+		orders:  []Order{{ID: "due", Duration: "1", ServerStartTime: start}},
+		records: make(map[string]*DueRecord),
+	}}
+	clock := &fakeClock{now: start.Add(48 * time.Hour)}
+
+	scheduler := &Scheduler{Store: store, Clock: clock, Lease: 30 * time.Millisecond, Owner: "first",
+		Logger: log.NewNopLogger(), Handler: func(orderId string) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}}
+
+	if count := scheduler.RunOnce(); count != 1 {
+		t.Fatalf("expected 1 expired order, got %d", count)
+	}
+	if renewals := atomic.LoadInt32(&store.renewals); renewals == 0 {
+		t.Errorf("expected the lease to be renewed while handling")
+	}
+	if record := store.records["due"]; !record.Done {
+		t.Errorf("unexpected due record %+v", record)
+	}
+}
+
+func TestSchedulerBacksOffAndGivesUpFailingOrder(t *testing.T) {
+	start := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeDueStore{
+		orders:  []Order{{ID: "due", Duration: "1", ServerStartTime: start}},
+		records: make(map[string]*DueRecord),
+	}
+	clock := &fakeClock{now: start.Add(48 * time.Hour)}
+
+	calls := 0
+	scheduler := &Scheduler{Store: store, Clock: clock, Lease: time.Minute, RetryDelay: time.Minute, MaxFailures: 3,
+		Owner: "first", Logger: log.NewNopLogger(), Handler: func(orderId string) error {
+			calls++
+			return errors.New("Failed to stop auction")
+		}}
+
+	scheduler.RunOnce()
+
+	// the order is tried again after 1 and 2 minutes, and given up after the third failure
+	for i, wait := range []time.Duration{time.Minute, 2 * time.Minute} {
+		clock.now = clock.now.Add(wait - time.Second)
+		if scheduler.RunOnce(); calls != i+1 {
+			t.Fatalf("expected the failed order to wait for %v, got %d calls", wait, calls)
+		}
+
+		clock.now = clock.now.Add(2 * time.Second)
+		if scheduler.RunOnce(); calls != i+2 {
+			t.Fatalf("expected the failed order to be tried after %v, got %d calls", wait, calls)
+		}
+	}
+
+	clock.now = clock.now.Add(24 * time.Hour)
+	scheduler.RunOnce()
+	if record := store.records["due"]; calls != 3 || !record.Done || record.Failures != 3 ||
+		record.Result != "Failed to stop auction" {
+		t.Errorf("expected the order to be given up, got %+v after %d calls", record, calls)
+	}
+}
@@ -12,15 +12,10 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-
-	mgo "gopkg.in/mgo.v2"
-
-	"gopkg.in/mgo.v2/bson"
 )
 
 var generalErrorInfo = "Server is busy. Please try it later."
 var maxDuration = 30
-var testDev bool = true;
 type OrderStatus struct {
 	Status            string   `json:"status"`
 }
@@ -46,6 +41,7 @@ type ProductInfo struct {
 	Type             string     `json:"type"`
 	PriceType        string     `json:"priceType"`
 	PriceValue       string     `json:"priceValue"`
+	ReservePrice     string     `json:"reservePrice"`
 }
 
 type BuyInfo struct {
@@ -79,40 +75,56 @@ type Service interface {
 	Sell(sellInfo Order) (error)
 	StopSelling(orderId string) (error)
 	Buy(orderId string, buyInfo BuyInfo) (error)
-	ApplyConfirmFromChain(chainId string, result string) (error)
+	ApplyConfirmFromChain(chainId string, eventId string, result string) (error)
 	ShipProduct(orderId string, express Express) (error)
 	ConfirmOrder(orderId string) (error)
 	AskForReturn(orderId string, returnInfo ReturnInfo) (error)
 	AgreeReturn(orderId string) (error)
 	ShipReturn(orderId string, express Express) (error)
 	ConfirmReturn(orderId string) (error)
-	ApplyCancelFromChain(chainId string, result string) (error)
+	ApplyCancelFromChain(chainId string, eventId string, result string) (error)
+	PlaceBid(orderId string, bid Bid) (error)
+	GetBids(orderId string) ([]Bid, error)
+	GetOrderHistory(orderId string) ([]OrderEvent, error)
+}
+
+// ProductFinder find the reserve price which the owner set when uploading the product, it's empty if the
+// owner set none
+type ProductFinder interface {
+	ReservePrice(productId string) (string, error)
 }
 
-// OrderService for order service
+// OrderService for order service. The orders without a reserve price take the one of the product from Products
+// if it isn't nil.
 type OrderService struct {
 	Host        string
 	Port        string
-	Session     *mgo.Session
+	Orders      OrderRepository
+	Bids        BidRepository
+	OrderEvents OrderEventRepository
+	ChainEvents ChainEventRepository
+	Due         DueRepository
 	Logger      log.Logger
-	ProductsURL string
+	ProductsURL  string
+	Products     ProductFinder
+	BidIncrement float64
+	Chain        ChainService.Chain
 }
 
-// NewUserSVC create a new user service
-func NewOrderSVC(host, port string, logger log.Logger, session *mgo.Session, productsURL string) *OrderService {
-	return &OrderService{Host: host, Port: port, Logger: logger, Session: session, ProductsURL: productsURL}
+// NewUserSVC create a new user service. The service receives the transaction results from the chain.
+func NewOrderSVC(host, port string, logger log.Logger, store Store, productsURL string, bidIncrement float64,
+	chain ChainService.Chain) *OrderService {
+	svc := &OrderService{Host: host, Port: port, Logger: logger, Orders: store.Orders, Bids: store.Bids,
+		OrderEvents: store.OrderEvents, ChainEvents: store.ChainEvents, Due: store.Due, ProductsURL: productsURL,
+		BidIncrement: bidIncrement, Chain: chain}
+	chain.SetListener(svc)
+	return svc
 }
 
 func (svc *OrderService) GetOrdersInTransaction() ([]Order, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("orders")
-
-	var orders []Order
-	err := c.Find(bson.M{}).All(&orders)
+	orders, err := svc.Orders.FindAll()
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Find", "Info", err)
+		level.Error(svc.Logger).Log("API", "Orders.FindAll", "Info", err)
 		return orders, errors.New(generalErrorInfo)
 	}
 
@@ -120,15 +132,9 @@ func (svc *OrderService) GetOrdersInTransaction() ([]Order, error) {
 }
 
 func (svc *OrderService) GetOrderByProductId(productId string) (Order, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	c := session.DB("store").C("orders")
-
-	var order Order
-	err := c.Find(bson.M{"product.id": productId}).One(&order)
+	order, err := svc.Orders.FindByProductID(productId)
 	if err != nil {
-		if err == mgo.ErrNotFound {
+		if err == NSUtil.ErrNotFound {
 			level.Debug(svc.Logger).Log("Product is in order", "false")
 			return order, nil
 		}
@@ -147,17 +153,26 @@ func (svc *OrderService) Sell(sellInfo Order) (error) {
 		return errors.New("Please change price type!")
 	}
 
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	c := session.DB("store").C("orders")
-	var order Order
-	err := c.Find(bson.M{"product.id": sellInfo.Product.Id}).One(&order)
+	_, err := svc.Orders.FindByProductID(sellInfo.Product.Id)
 	if err == nil {
 		level.Error(svc.Logger).Log("Product", sellInfo.Product.Id, "Info", "is in transaction")
 		return errors.New("The product has already been in transaction")
 	}
 
+	if len(sellInfo.Product.ReservePrice) == 0 && svc.Products != nil {
+		sellInfo.Product.ReservePrice, err = svc.Products.ReservePrice(sellInfo.Product.Id)
+		if err != nil && err != NSUtil.ErrNotFound {
+			level.Error(svc.Logger).Log("API", "Products.ReservePrice", "Error", err)
+			return errors.New(generalErrorInfo)
+		}
+	}
+	if len(sellInfo.Product.ReservePrice) != 0 {
+		if _, err = parsePrice(sellInfo.Product.ReservePrice); err != nil {
+			level.Error(svc.Logger).Log("API", "parsePrice", "Error", err)
+			return errors.New("Please set right reserve price")
+		}
+	}
+
 	sellInfo.Status = strconv.Itoa(NSUtil.None)
 	sellInfo.ID = NSUtil.UniqueID()
 	sellInfo.ServerStartTime = time.Now()
@@ -172,15 +187,30 @@ func (svc *OrderService) Sell(sellInfo Order) (error) {
 	if inputDuration > maxDuration {
 		sellInfo.Duration = strconv.Itoa(maxDuration)
 	}
-	err = c.Insert(sellInfo)
+
+	if len(sellInfo.ChainId) == 0 {
+		sellInfo.ChainId, err = svc.Chain.UploadProduct(sellInfo.Product.Id)
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "Chain.UploadProduct", "Error", err)
+			return errors.New(generalErrorInfo)
+		}
+	}
+	// the order id is used as chain id if the chain doesn't provide one
+	if len(sellInfo.ChainId) == 0 {
+		sellInfo.ChainId = sellInfo.ID
+	}
+
+	err = svc.Orders.Insert(sellInfo)
 	if err != nil {
 		level.Error(svc.Logger).Log("Insert error", err)
 		return errors.New(generalErrorInfo)
 	}
+	svc.addOrderEvent(OrderEvent{OrderID: sellInfo.ID, Actor: sellInfo.Product.Owner, Event: EventSell,
+		To: sellInfo.Status})
 
 	// sending message to chain
 	proTypeString , _ := strconv.Atoi(sellInfo.Product.Type)
-	ChainService.StartToSell(sellInfo.ChainId, sellInfo.Product.PriceValue, proTypeString)
+	svc.Chain.StartToSell(sellInfo.ChainId, sellInfo.Product.PriceValue, proTypeString)
 
 	return nil
 }
@@ -193,23 +223,24 @@ func (svc *OrderService) StopSelling(orderId string) (error) {
 		return errors.New(generalErrorInfo)
 	}
 
-	return svc.stopSelling(order)
+	return svc.stopSelling(order, order.Product.Owner)
 }
 
-func (svc *OrderService) stopSelling(order Order) (error ){
+func (svc *OrderService) stopSelling(order Order, actor string) (error) {
 	level.Debug(svc.Logger).Log("Input", "orderId", "Value", order.ID)
-	if order.Status != strconv.Itoa(NSUtil.None) {
+	order, err := svc.transit(order, EventStop, actor, "", nil)
+	if err != nil {
 		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be stopped now")
 		return errors.New("Can't cancel the order because it's in transaction")
 	}
 
-	err := svc.deleteOrder(order.ID)
+	err = svc.deleteOrder(order.ID)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "deleteOrder", "Error", err)
 		return errors.New(generalErrorInfo)
 	}
 
-	ChainService.StopSelling(order.ChainId)
+	svc.Chain.StopSelling(order.ChainId)
 	return nil
 }
 
@@ -222,70 +253,37 @@ func (svc *OrderService) Buy(orderId string, buyInfo BuyInfo) (error) {
 		return errors.New(generalErrorInfo)
 	}
 
-	// if the product can be bought
-	if order.Product.PriceType == strconv.Itoa(NSUtil.Fix) {
-		// bought by others 
-		if order.Status != strconv.Itoa(NSUtil.None) {
-			level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be bought")
-			return errors.New("The product has been sold. Please try the others.")
-		}
-	} else if order.Product.PriceType == strconv.Itoa(NSUtil.Auction) {
-		if order.Status != strconv.Itoa(NSUtil.None) && 
-		   order.Status != strconv.Itoa(NSUtil.InAuction) {
-			level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be bought")
-			return errors.New("The product has been sold. Please try the others.")
-		}
-	} else {
-		level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Info", "isn't supported now")
-		return errors.New("The product can't be bought. Please try the others.")
+	// bids of an auction are handled by the auction engine
+	if order.Product.PriceType == strconv.Itoa(NSUtil.Auction) {
+		return svc.PlaceBid(orderId, Bid{Bidder: buyInfo.Buyer, PriceValue: buyInfo.PriceValue,
+			StartTime: buyInfo.StartTime})
 	}
 
-	// get current status and send message to chain if necessary
-	var updateStatus = strconv.Itoa(NSUtil.None)
-	if order.Product.PriceType == strconv.Itoa(NSUtil.Fix) {
-		if order.Product.Type == strconv.Itoa(NSUtil.Digit) {
-			updateStatus = strconv.Itoa(NSUtil.InFix)
-
-			// send the transaction to chain
-			err = ChainService.ConfirmOrder(order.ChainId)
-			if err != nil {
-				level.Error(svc.Logger).Log("API", "Chain.ConfirmOrder", "Info", err)
-				return errors.New(generalErrorInfo)
-			}
-		} else if order.Product.Type == strconv.Itoa(NSUtil.Entity) {
-			updateStatus = strconv.Itoa(NSUtil.Unshipped)
-		}
-	} else if order.Product.PriceType == strconv.Itoa(NSUtil.Auction) {
-		updateStatus = strconv.Itoa(NSUtil.InAuction)
-
-		err = ChainService.UpdatePrice(order.ChainId, buyInfo.Buyer, order.Product.PriceValue)
-		if err != nil {
-			level.Error(svc.Logger).Log("API", "Chain.UpdatePrice", "Info", err)
-			return errors.New(generalErrorInfo)
-		}
-	} else {
-		level.Error(svc.Logger).Log("PriceType", "Chain.UpdatePrice", "Info", err)
+	if order.Product.PriceType != strconv.Itoa(NSUtil.Fix) {
+		level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Info", "isn't supported now")
 		return errors.New("The product can't be bought. Please try the others.")
 	}
 
-	// update order info
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	c := session.DB("store").C("orders")
+	// only one buyer can move the order out of the None status
 	buyInfo.ServerStartTime = time.Now()
-	updateData := bson.M{"buyinfo": buyInfo,
-						 "status": updateStatus}
-	err = c.Update(bson.M{"id": orderId}, bson.M{"$set": updateData})
+	order, err = svc.transit(order, EventBuy, buyInfo.Buyer, "", func(order *Order) {
+		order.BuyInfo = buyInfo
+	})
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Date.Update", "Info", err)
-		return errors.New(generalErrorInfo)
+		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be bought")
+		return errors.New("The product has been sold. Please try the others.")
 	}
 
-
-	if testDev {
-		if order.Product.PriceType == strconv.Itoa(NSUtil.Fix) {
-			svc.ApplyConfirmFromChain(orderId, "success");
+	if order.Product.Type == strconv.Itoa(NSUtil.Digit) {
+		// send the transaction to chain
+		err = svc.Chain.ConfirmOrder(order.ChainId)
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "Chain.ConfirmOrder", "Info", err)
+			// give the product back to the other buyers
+			svc.transit(order, EventBuyFailed, actorChain, err.Error(), func(order *Order) {
+				order.BuyInfo = BuyInfo{}
+			})
+			return errors.New(generalErrorInfo)
 		}
 	}
 
@@ -293,63 +291,45 @@ func (svc *OrderService) Buy(orderId string, buyInfo BuyInfo) (error) {
 }
 
 // transaction is successful
-func (svc *OrderService) ApplyConfirmFromChain(chainId string, result string) (error) {
+func (svc *OrderService) ApplyConfirmFromChain(chainId string, eventId string, result string) (error) {
+	event := ChainEvent{EventID: eventId, ChainId: chainId, Type: chainEventConfirm, Result: result}
+	return svc.applyChainEvent(event, func(resume bool) error {
+		return svc.applyConfirmFromChain(chainId, result, resume)
+	})
+}
+
+// applyConfirmFromChain complete or fail the order. The resumed event skips the steps which have been done.
+func (svc *OrderService) applyConfirmFromChain(chainId string, result string, resume bool) (error) {
 	// get order
-	order, err := svc.getOrderById(chainId)
-	//order, err := svc.getOrderByChainId(chainId)
+	order, err := svc.Orders.FindByChainID(chainId)
+	if err == NSUtil.ErrNotFound && resume {
+		// the order has been closed
+		return nil
+	}
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "getOrderByChainId", "Info", err)
+		level.Error(svc.Logger).Log("API", "Orders.FindByChainID", "ChainId", chainId, "Info", err)
 		return errors.New(generalErrorInfo)
 	}
 
-	// if the order can be completed
-	if order.Product.PriceType == strconv.Itoa(NSUtil.Fix) {
-		if order.Product.Type == strconv.Itoa(NSUtil.Digit) {
-			if order.Status != strconv.Itoa(NSUtil.InFix) {
-				level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Status", order.Status, "Info", "can't be completed")
-				return errors.New("Current order can't be completed")
-			}
-		} else if order.Product.Type == strconv.Itoa(NSUtil.Entity) {
-			if order.Status != strconv.Itoa(NSUtil.DispatchConfirmed) {
-				level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Status", order.Status, "Info", "can't be completed")
-				return errors.New("Current order can't be completed")
-			}
-		} else {
-			level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Info", "unsupported")
-			return errors.New("Current order isn't in a transaction")
-		}
-	} else if order.Product.PriceType == strconv.Itoa(NSUtil.Auction) {
-		if order.Product.Type == strconv.Itoa(NSUtil.Digit) {
-			if order.Status != strconv.Itoa(NSUtil.InAuction) {
-				level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Status", order.Status, "Info", "can't be completed")
-				return errors.New("Current order can't be completed")
-			}
-		} else if order.Product.Type == strconv.Itoa(NSUtil.Entity) {
-			if order.Status != strconv.Itoa(NSUtil.DispatchConfirmed) {
-				level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Status", order.Status, "Info", "can't be completed")
-				return errors.New("Current order can't be completed")
-			}
-		} else {
-			level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Info", "unsupported")
-			return errors.New("Current order isn't in a transaction")
-		}
-	} else {
-		level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Info", "unsupported")
-		return errors.New("Current order isn't in a transaction")
-	}
-
-	// get new status
-	var newStatus = NSUtil.None
+	var event string
 	if result == "fail" {
 		// post message to buyer
-		newStatus = NSUtil.Failed
+		event = EventChainFail
 	} else if result == "success" {
-		newStatus = NSUtil.Completed
+		event = EventChainSuccess
 	} else {
 		level.Error(svc.Logger).Log("ReturnValue", result, "Info", "unsupported")
 		return errors.New("unhandled return value")
 	}
-	order.Status = strconv.Itoa(newStatus)
+
+	// if the order can be completed
+	if !resume || !reachedBy(order, event) {
+		order, err = svc.transit(order, event, actorChain, result, nil)
+		if err != nil {
+			level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Status", order.Status, "Info", "can't be completed")
+			return errors.New("Current order can't be completed")
+		}
+	}
 
 	// close the order
 	err = svc.closeOrder(order)
@@ -366,22 +346,6 @@ func (svc *OrderService) ApplyConfirmFromChain(chainId string, result string) (e
 	return nil
 }
 
-func (svc *OrderService) getOrderByChainId(chainId string) (Order, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	c := session.DB("store").C("orders")
-
-	var order Order
-	err := c.Find(bson.M{"chainid": chainId}).One(&order)
-	if err != nil {
-		level.Error(svc.Logger).Log("API", "Date.find", "Info", err)
-		return order, errors.New("can't get order for the chain id " + chainId)
-	}
-
-	return order, nil
-}
-
 func (svc *OrderService) OrderIsDue(orderId string) (error) {
 	level.Debug(svc.Logger).Log("Input", "orderId", "Value", orderId)
 	order, err := svc.getOrderById(orderId)
@@ -392,29 +356,72 @@ func (svc *OrderService) OrderIsDue(orderId string) (error) {
 
 	// for fix price
 	if order.Product.PriceType == strconv.Itoa(NSUtil.Fix) {
-		return svc.stopSelling(order)
+		return svc.stopSelling(order, actorScheduler)
 	} else if order.Product.PriceType == strconv.Itoa(NSUtil.Auction) {
 		return svc.auctionIsDue(order)
 	} else {
 		level.Error(svc.Logger).Log("PriceType", order.Product.PriceType, "Info", "Unsupported")
 		return errors.New("The product isn't in transaction")
 	}
-
-	return nil
 }
 
 func (svc *OrderService) auctionIsDue(order Order) (error) {
+	winner, err := svc.selectWinner(order)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "selectWinner", "Info", err)
+		return errors.New("Failed to stop auction")
+	}
+
+	if winner == nil {
+		// nobody bids for the product
+		if order.Status == strconv.Itoa(NSUtil.None) {
+			return svc.stopSelling(order, actorScheduler)
+		}
+
+		// the reserve price isn't reached
+		err = svc.Bids.Close(order.ID, "")
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "Bids.Close", "Info", err)
+		}
+
+		order, err = svc.transit(order, EventAuctionFailed, actorScheduler, "reserve price isn't reached", nil)
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "transit", "Info", err)
+			return errors.New("Failed to stop auction")
+		}
+
+		err = svc.closeOrder(order)
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "closeOrder", "Info", err)
+			return errors.New("Failed to stop auction")
+		}
+
+		svc.Chain.StopSelling(order.ChainId)
+		return nil
+	}
+
+	err = svc.Bids.Close(order.ID, winner.ID)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Bids.Close", "Info", err)
+	}
+
+	// the order always keeps the winner as buyer
+	buyInfo := BuyInfo{Buyer: winner.Bidder, PriceValue: winner.PriceValue,
+		StartTime: winner.StartTime, ServerStartTime: winner.ServerStartTime}
+	order, err = svc.transit(order, EventAuctionWon, actorScheduler, "highest bid wins", func(order *Order) {
+		order.BuyInfo = buyInfo
+	})
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "transit", "Info", err)
+		return errors.New("Failed to stop auction")
+	}
+
 	if order.Product.Type == strconv.Itoa(NSUtil.Digit) {
-		return ChainService.ConfirmOrder(order.ChainId)
-	} else if order.Product.Type == strconv.Itoa(NSUtil.Entity) {
-		err := svc.updateOrderStatus(order.ID, NSUtil.Unshipped);
+		err = svc.Chain.ConfirmOrder(order.ChainId)
 		if err != nil {
-			level.Error(svc.Logger).Log("API", "updateOrderStatus", "Info", err)
+			level.Error(svc.Logger).Log("API", "Chain.ConfirmOrder", "Info", err)
 			return errors.New("Failed to stop auction")
 		}
-	} else {
-		level.Error(svc.Logger).Log("ProductType", order.Product.Type, "Info", "Unsupported")
-		return errors.New("unsupported product type for the order " + order.ID)
 	}
 
 	return nil
@@ -427,21 +434,13 @@ func (svc *OrderService) ShipProduct(orderId string, express Express) (error) {
 		return errors.New(generalErrorInfo)
 	}
 
-	if order.Status != strconv.Itoa(NSUtil.Unshipped) {
-		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be shipped")
-		return errors.New("Current operation isn't supported. Please check order's status.")
-	}
-
-	session := svc.Session.Copy()
-	defer session.Close()
-	
 	express.StartTime = time.Now()
-	updateData := bson.M{"express": express, "status": strconv.Itoa(NSUtil.Dispatched)}
-	c := session.DB("store").C("orders")
-	err = c.Update(bson.M{"id": orderId}, bson.M{"$set": updateData})
+	_, err = svc.transit(order, EventShip, order.Product.Owner, "", func(order *Order) {
+		order.Express = express
+	})
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Update", "Info", err)
-		return errors.New(generalErrorInfo)
+		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be shipped")
+		return err
 	}
 
 	return nil
@@ -461,24 +460,17 @@ func (svc *OrderService) ConfirmOrder(orderId string) (error) {
 		return errors.New(generalErrorInfo)
 	}
 
-	if order.Status != strconv.Itoa(NSUtil.Dispatched) {
+	_, err = svc.transit(order, EventConfirm, order.BuyInfo.Buyer, "", nil)
+	if err != nil {
 		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be confirmed")
-		return errors.New("Current operation isn't supported. Please check order's status.")
+		return err
 	}
 
-	err = svc.updateOrderStatus(orderId, NSUtil.DispatchConfirmed);
+	err = svc.Chain.ConfirmOrder(order.ChainId)
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "updateOrderStatus", "Info", err)
+		level.Error(svc.Logger).Log("API", "Chain.ConfirmOrder", "Info", err)
 		return errors.New(generalErrorInfo)
 	}
-
-	ChainService.ConfirmOrder(order.ChainId)
-
-	if testDev {
-		if order.Product.PriceType == strconv.Itoa(NSUtil.Fix) {
-			svc.ApplyConfirmFromChain(orderId, "success");
-		}
-	}
 	
 	return nil
 }
@@ -491,23 +483,20 @@ func (svc *OrderService) AskForReturn(orderId string, returnInfo ReturnInfo) (er
 		return errors.New(generalErrorInfo)
 	}
 
-	if order.Status != strconv.Itoa(NSUtil.Dispatched) {
+	// check the status before saving the images
+	if _, err = nextStatus(order, EventAskReturn); err != nil {
 		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be returned")
-		return errors.New("Current operation isn't supported. Please check order's status.")
+		return errBadStatus
 	}
 
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	askStartTime := time.Now()
 	savedReturnInfo := svc.convertReturnInfo(order.BuyInfo.Buyer, returnInfo)
-	savedReturnInfo.AskTime = askStartTime
-	updateData := bson.M{"returninfo": savedReturnInfo, "status": strconv.Itoa(NSUtil.ReturnInAgree)}
-	c := session.DB("store").C("orders")
-	err = c.Update(bson.M{"id": orderId}, bson.M{"$set": updateData})
+	savedReturnInfo.AskTime = time.Now()
+	_, err = svc.transit(order, EventAskReturn, order.BuyInfo.Buyer, returnInfo.Description, func(order *Order) {
+		order.ReturnInfo = savedReturnInfo
+	})
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Update", "Info", err)
-		return errors.New(generalErrorInfo)
+		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be returned")
+		return err
 	}
 
 	return nil
@@ -537,22 +526,14 @@ func (svc *OrderService) AgreeReturn(orderId string) (error) {
 		return errors.New(generalErrorInfo)
 	}
 
-	if order.Status != strconv.Itoa(NSUtil.ReturnInAgree) {
-		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be agreed")
-		return errors.New("Current operation isn't supported. Please check order's status.")
-	}
-
-	session := svc.Session.Copy()
-	defer session.Close()
-	
 	returnInfo := order.ReturnInfo
 	returnInfo.AgreeTime = time.Now()
-	updateData := bson.M{"returninfo": returnInfo, "status": strconv.Itoa(NSUtil.ReturnAgreed)}
-	c := session.DB("store").C("orders")
-	err = c.Update(bson.M{"id": orderId}, bson.M{"$set": updateData})
+	_, err = svc.transit(order, EventAgreeReturn, order.Product.Owner, "", func(order *Order) {
+		order.ReturnInfo = returnInfo
+	})
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Update", "Info", err)
-		return errors.New(generalErrorInfo)
+		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be agreed")
+		return err
 	}
 
 	return nil
@@ -566,23 +547,15 @@ func (svc *OrderService) ShipReturn(orderId string, express Express) (error) {
 		return errors.New(generalErrorInfo)
 	}
 
-	if order.Status != strconv.Itoa(NSUtil.ReturnAgreed) {
-		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be shipped")
-		return errors.New("Current operation isn't supported. Please check order's status.")
-	}
-
-	session := svc.Session.Copy()
-	defer session.Close()
-	
 	returnInfo := order.ReturnInfo
 	returnInfo.Express = express
 	returnInfo.Express.StartTime = time.Now()
-	updateData := bson.M{"returninfo": returnInfo, "status": strconv.Itoa(NSUtil.ReturnDispatched)}
-	c := session.DB("store").C("orders")
-	err = c.Update(bson.M{"id": orderId}, bson.M{"$set": updateData})
+	_, err = svc.transit(order, EventShipReturn, order.BuyInfo.Buyer, "", func(order *Order) {
+		order.ReturnInfo = returnInfo
+	})
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Update", "Info", err)
-		return errors.New(generalErrorInfo)
+		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be shipped")
+		return err
 	}
 
 	return nil
@@ -596,55 +569,57 @@ func (svc *OrderService) ConfirmReturn(orderId string) (error) {
 		return errors.New(generalErrorInfo)
 	}
 
-	if order.Status != strconv.Itoa(NSUtil.ReturnDispatched) {
-		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be confirmed")
-		return errors.New("Current operation isn't supported. Please check order's status.")
-	}
-
-	ChainService.CancelOrder(order.ChainId)
-
-	// update data in database
-	session := svc.Session.Copy()
-	defer session.Close()
-	
 	returnInfo := order.ReturnInfo
 	returnInfo.ConfirmTime = time.Now()
-	updateData := bson.M{"returninfo": returnInfo, "status": strconv.Itoa(NSUtil.ReturnConfirmed)}
-	c := session.DB("store").C("orders")
-	err = c.Update(bson.M{"id": orderId}, bson.M{"$set": updateData})
+	_, err = svc.transit(order, EventConfirmReturn, order.Product.Owner, "", func(order *Order) {
+		order.ReturnInfo = returnInfo
+	})
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Update", "Info", err)
-		return errors.New(generalErrorInfo)
+		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be confirmed")
+		return err
 	}
 
-	if testDev {
-		if order.Product.PriceType == strconv.Itoa(NSUtil.Fix) {
-			svc.ApplyCancelFromChain(orderId, "success");
-		}
+	err = svc.Chain.CancelOrder(order.ChainId)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Chain.CancelOrder", "Info", err)
+		return errors.New(generalErrorInfo)
 	}
 
 	return nil
 }
 
-func (svc *OrderService) ApplyCancelFromChain(chainId string, result string) (error) {
+func (svc *OrderService) ApplyCancelFromChain(chainId string, eventId string, result string) (error) {
+	event := ChainEvent{EventID: eventId, ChainId: chainId, Type: chainEventCancel, Result: result}
+	return svc.applyChainEvent(event, func(resume bool) error {
+		return svc.applyCancelFromChain(chainId, result, resume)
+	})
+}
+
+// applyCancelFromChain complete the return of the order. The resumed event skips the steps which have been done.
+func (svc *OrderService) applyCancelFromChain(chainId string, result string, resume bool) (error) {
 	level.Debug(svc.Logger).Log("Input", "chainId", "Value", chainId)
-	order, err := svc.getOrderById(chainId)
-	//order, err := svc.getOrderByChainId(chainId)
+	order, err := svc.Orders.FindByChainID(chainId)
+	if err == NSUtil.ErrNotFound && resume {
+		// the order has been closed
+		return nil
+	}
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "getOrderByChainId", "Info", err)
+		level.Error(svc.Logger).Log("API", "Orders.FindByChainID", "ChainId", chainId, "Info", err)
 		return errors.New(generalErrorInfo)
 	}
 
-	if order.Status != strconv.Itoa(NSUtil.ReturnConfirmed) {
-		level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be cancelled")
-		return errors.New("Current operation isn't supported. Please check order's status.")
-	}
-
 	if result != "success" {
 		return errors.New("unhandled return value from chain")
 	}
 
-	order.Status = strconv.Itoa(NSUtil.ReturnCompleted)
+	if !resume || !reachedBy(order, EventCancelSuccess) {
+		order, err = svc.transit(order, EventCancelSuccess, actorChain, result, nil)
+		if err != nil {
+			level.Error(svc.Logger).Log("Status", order.Status, "Info", "can't be cancelled")
+			return err
+		}
+	}
+
 	err = svc.closeOrder(order)
 	if (err != nil) {
 		level.Error(svc.Logger).Log("API", "closeOrder", "Info", err)
@@ -654,33 +629,14 @@ func (svc *OrderService) ApplyCancelFromChain(chainId string, result string) (er
 	return nil
 }
 
-func (svc *OrderService) updateOrderStatus(orderId string, orderStatus int) (error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	var currentStatus = strconv.Itoa(orderStatus);
-	c := session.DB("store").C("orders")
-	err := c.Update(bson.M{"id": orderId}, bson.M{"$set": bson.M{"status": currentStatus}})
-	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Update", "Info", err)
-		return errors.New("Failed to complete order")
-	}
-
-	return nil
-}
-
 // move the order to closed collection
 func (svc *OrderService) closeOrder(order Order) (error) {
 	level.Debug(svc.Logger).Log("func", "closeOrder")
-	session := svc.Session.Copy()
-	defer session.Close()
-	
 	order.CompleteTime = time.Now()
 
-	c := session.DB("store").C("closedorders")
-	err := c.Insert(order)
+	err := svc.Orders.InsertClosed(order)
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Insert", "Info", err)
+		level.Error(svc.Logger).Log("API", "Orders.InsertClosed", "Info", err)
 		return errors.New("Failed to close the order")
 	} else {
 		err = svc.deleteOrder(order.ID);
@@ -694,15 +650,9 @@ func (svc *OrderService) closeOrder(order Order) (error) {
 
 func (svc *OrderService) GetOrders(buyer string) ([]Order, error) {
 	level.Debug(svc.Logger).Log("Input", "buyer", "Value", buyer)
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	c := session.DB("store").C("orders")
-
-	var orders []Order
-	err := c.Find(bson.M{"buyinfo.buyer": buyer}).All(&orders)
+	orders, err := svc.Orders.FindByBuyer(buyer)
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Find", "Info", err)
+		level.Error(svc.Logger).Log("API", "Orders.FindByBuyer", "Info", err)
 		return orders, errors.New(generalErrorInfo)
 	}
 
@@ -711,15 +661,9 @@ func (svc *OrderService) GetOrders(buyer string) ([]Order, error) {
 
 func (svc *OrderService) GetSellings(seller string) ([]Order, error) {
 	level.Debug(svc.Logger).Log("Input", "seller", "Value", seller)
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	c := session.DB("store").C("orders")
-
-	var orders []Order
-	err := c.Find(bson.M{"product.owner": seller}).All(&orders)
+	orders, err := svc.Orders.FindBySeller(seller)
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Find", "Info", err)
+		level.Error(svc.Logger).Log("API", "Orders.FindBySeller", "Info", err)
 		return orders, errors.New(generalErrorInfo)
 	}
 
@@ -728,15 +672,9 @@ func (svc *OrderService) GetSellings(seller string) ([]Order, error) {
 
 func (svc *OrderService) getOrderById(orderId string) (Order, error) {
 	level.Debug(svc.Logger).Log("Func", "getOrderById")
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	c := session.DB("store").C("orders")
-
-	var order Order
-	err := c.Find(bson.M{"id": orderId}).One(&order)
+	order, err := svc.Orders.FindByID(orderId)
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Find", "Info", err)
+		level.Error(svc.Logger).Log("API", "Orders.FindByID", "Info", err)
 		return order, errors.New("Failed to get order")
 	}
 
@@ -745,14 +683,9 @@ func (svc *OrderService) getOrderById(orderId string) (Order, error) {
 
 func (svc *OrderService) deleteOrder(orderId string) (error) {
 	level.Debug(svc.Logger).Log("Func", "deleteOrder")
-	session := svc.Session.Copy()
-	defer session.Close()
-	
-	c := session.DB("store").C("orders")
-
-	err := c.Remove(bson.M{"id": orderId})
+	err := svc.Orders.Remove(orderId)
 	if err != nil {
-		level.Error(svc.Logger).Log("API", "Data.Remove", "Info", err)
+		level.Error(svc.Logger).Log("API", "Orders.Remove", "Info", err)
 		return errors.New("Failed to delete order")
 	}
 
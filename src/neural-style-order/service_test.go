@@ -0,0 +1,169 @@
+package OrderService
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"neural-style-chain"
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestBuyFixDigitProductInMemory(t *testing.T) {
+	store := NewMemoryStore()
+	svc := NewOrderSVC("", "", log.NewNopLogger(), store, "http://127.0.0.1:0", 1, ChainService.NoopChain{})
+
+	err := svc.Sell(Order{Product: ProductInfo{Id: "product", Owner: "seller", PriceValue: "10",
+		Type: strconv.Itoa(NSUtil.Digit), PriceType: strconv.Itoa(NSUtil.Fix)}})
+	if err != nil {
+		t.Fatalf("unexpected sell error %v", err)
+	}
+
+	order, err := svc.GetOrderByProductId("product")
+	if err != nil || len(order.ChainId) == 0 {
+		t.Fatalf("unexpected order %+v, error %v", order, err)
+	}
+
+	if err = svc.Buy(order.ID, BuyInfo{Buyer: "first", PriceValue: "10"}); err != nil {
+		t.Fatalf("unexpected buy error %v", err)
+	}
+
+	if err = svc.Buy(order.ID, BuyInfo{Buyer: "second", PriceValue: "10"}); err == nil {
+		t.Errorf("expected the second buyer to fail")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = svc.ApplyConfirmFromChain(order.ChainId, "event", "success"); err != nil {
+			t.Fatalf("unexpected chain confirm error %v", err)
+		}
+	}
+
+	if _, err = store.Orders.FindByID(order.ID); err != NSUtil.ErrNotFound {
+		t.Errorf("expected the completed order to be closed, got %v", err)
+	}
+
+	events, _ := svc.GetOrderHistory(order.ID)
+	if len(events) != 3 || events[1].Actor != "first" || events[2].To != strconv.Itoa(NSUtil.Completed) {
+		t.Errorf("unexpected history %+v", events)
+	}
+}
+
+type reserveFinder map[string]string
+
+func (reserves reserveFinder) ReservePrice(productId string) (string, error) {
+	return reserves[productId], nil
+}
+
+func TestAuctionTakesReserveOfProductAndBidderOfToken(t *testing.T) {
+	store := NewMemoryStore()
+	svc := NewOrderSVC("", "", log.NewNopLogger(), store, "http://127.0.0.1:0", 1, ChainService.NoopChain{})
+	svc.Products = reserveFinder{"product": "50"}
+
+	err := svc.Sell(Order{Product: ProductInfo{Id: "product", Owner: "seller", PriceValue: "10",
+		Type: strconv.Itoa(NSUtil.Digit), PriceType: strconv.Itoa(NSUtil.Auction)}})
+	if err != nil {
+		t.Fatalf("unexpected sell error %v", err)
+	}
+
+	order, _ := svc.GetOrderByProductId("product")
+	if order.Product.ReservePrice != "50" {
+		t.Fatalf("expected the reserve price of the product, got %+v", order.Product)
+	}
+
+	// the reserve price is only shown to the seller
+	getOrder := MakeNSGetOrderByProductIdEndpoint(svc)
+	for viewer, reserve := range map[string]string{"seller": "50", "bidder": "", "": ""} {
+		ctx := context.WithValue(context.Background(), NSUtil.AuthUser, viewer)
+		res, _ := getOrder(ctx, NSGetOrderByProductIdRequest{ProductId: "product"})
+		if got := res.(NSGetOrderByProductIdResponse).Target.Product.ReservePrice; got != reserve {
+			t.Errorf("viewer %q: reserve price %q", viewer, got)
+		}
+	}
+
+	// the bidder in the body is ignored
+	ctx := context.WithValue(context.Background(), NSUtil.AuthUser, "bidder")
+	_, err = MakeNSPlaceBidEndpoint(svc)(ctx, NSBidRequest{OrderId: order.ID,
+		BidData: Bid{Bidder: "someone", PriceValue: "20"}})
+	if err != nil {
+		t.Fatalf("unexpected bid error %v", err)
+	}
+
+	bids, _ := svc.GetBids(order.ID)
+	if len(bids) != 1 || bids[0].Bidder != "bidder" {
+		t.Errorf("unexpected bids %+v", bids)
+	}
+}
+
+func TestAuctionBidsAndWinner(t *testing.T) {
+	store := NewMemoryStore()
+	svc := NewOrderSVC("", "", log.NewNopLogger(), store, "http://127.0.0.1:0", 5, ChainService.NoopChain{})
+	svc.Products = reserveFinder{"low": "50", "high": "20"}
+
+	orders := map[string]string{}
+	for _, product := range []string{"low", "high"} {
+		err := svc.Sell(Order{Product: ProductInfo{Id: product, Owner: "seller", PriceValue: "10",
+			Type: strconv.Itoa(NSUtil.Digit), PriceType: strconv.Itoa(NSUtil.Auction)}})
+		if err != nil {
+			t.Fatalf("unexpected sell error %v", err)
+		}
+
+		order, _ := svc.GetOrderByProductId(product)
+		orders[product] = order.ID
+
+		// the first bid starts from the listed price, and the next ones add the increment to the highest bid
+		for _, bid := range []struct {
+			bidder, price string
+			ok            bool
+		}{{"alice", "9", false}, {"alice", "10", true}, {"bob", "14", false}, {"bob", "20", true},
+			{"alice", "24", false}} {
+			err := svc.PlaceBid(order.ID, Bid{Bidder: bid.bidder, PriceValue: bid.price})
+			if (err == nil) != bid.ok {
+				t.Errorf("%s bids %s: unexpected error %v", bid.bidder, bid.price, err)
+			}
+		}
+
+		bids, _ := svc.GetBids(order.ID)
+		if len(bids) != 2 || bids[0].Bidder != "bob" || bids[0].Status != BidLeading || bids[1].Status != BidOutbid {
+			t.Fatalf("unexpected bids %+v", bids)
+		}
+
+		if err := svc.OrderIsDue(order.ID); err != nil {
+			t.Fatalf("unexpected due error %v", err)
+		}
+	}
+
+	// the highest bid 20 doesn't reach the reserve price 50
+	bids, _ := svc.GetBids(orders["low"])
+	if bids[0].Status != BidLost || bids[1].Status != BidLost {
+		t.Errorf("expected all the bids lost, got %+v", bids)
+	}
+	events, _ := svc.GetOrderHistory(orders["low"])
+	if last := events[len(events)-1]; last.Event != EventAuctionFailed {
+		t.Errorf("expected the auction failed, got %+v", last)
+	}
+
+	// the highest bid reaches the reserve price 20
+	won, err := store.Orders.FindByID(orders["high"])
+	bids, _ = svc.GetBids(orders["high"])
+	if err != nil || won.BuyInfo.Buyer != "bob" || bids[0].Status != BidWon || bids[1].Status != BidLost {
+		t.Errorf("expected bob to win, got %+v %+v %v", won, bids, err)
+	}
+}
+
+func TestBidAddedAfterLaterBidIsOutbid(t *testing.T) {
+	bids := &memoryBidRepository{}
+	now := time.Now()
+	bids.Add(Bid{ID: "later", OrderID: "order", PriceValue: "20", ServerStartTime: now, Status: BidLeading})
+	bids.Add(Bid{ID: "earlier", OrderID: "order", PriceValue: "10", ServerStartTime: now.Add(-time.Second),
+		Status: BidLeading})
+
+	found, _ := bids.FindByOrder("order")
+	for _, bid := range found {
+		if (bid.ID == "later") != (bid.Status == BidLeading) {
+			t.Errorf("unexpected status of the bid %+v", bid)
+		}
+	}
+}
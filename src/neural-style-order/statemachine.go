@@ -0,0 +1,187 @@
+package OrderService
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// order events which move an order from one status to another
+const (
+	EventSell          = "sell"
+	EventBuy           = "buy"
+	EventBuyFailed     = "buyFailed"
+	EventBid           = "bid"
+	EventAuctionWon    = "auctionWon"
+	EventAuctionFailed = "auctionFailed"
+	EventStop          = "stop"
+	EventShip          = "ship"
+	EventConfirm       = "confirm"
+	EventChainSuccess  = "chainSuccess"
+	EventChainFail     = "chainFail"
+	EventAskReturn     = "askReturn"
+	EventAgreeReturn   = "agreeReturn"
+	EventShipReturn    = "shipReturn"
+	EventConfirmReturn = "confirmReturn"
+	EventCancelSuccess = "cancelSuccess"
+)
+
+// actors which are not a user of the platform
+const (
+	actorChain     = "chain"
+	actorScheduler = "scheduler"
+)
+
+var errStatusChanged = errors.New("The order has been changed by others. Please try it later.")
+var errBadStatus = errors.New("Current operation isn't supported. Please check order's status.")
+
+// OrderEvent define one status transition in the history of an order
+type OrderEvent struct {
+	OrderID string    `json:"orderId"`
+	Actor   string    `json:"actor"`
+	Event   string    `json:"event"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Reason  string    `json:"reason"`
+	Time    time.Time `json:"time"`
+}
+
+type transition struct {
+	From  int
+	Event string
+	To    int
+}
+
+// the delivery and return flow of an entity product after the buyer is decided
+var entityDelivery = []transition{
+	{NSUtil.Unshipped, EventShip, NSUtil.Dispatched},
+	{NSUtil.Dispatched, EventConfirm, NSUtil.DispatchConfirmed},
+	{NSUtil.DispatchConfirmed, EventChainSuccess, NSUtil.Completed},
+	{NSUtil.DispatchConfirmed, EventChainFail, NSUtil.Failed},
+	{NSUtil.Dispatched, EventAskReturn, NSUtil.ReturnInAgree},
+	{NSUtil.ReturnInAgree, EventAgreeReturn, NSUtil.ReturnAgreed},
+	{NSUtil.ReturnAgreed, EventShipReturn, NSUtil.ReturnDispatched},
+	{NSUtil.ReturnDispatched, EventConfirmReturn, NSUtil.ReturnConfirmed},
+	{NSUtil.ReturnConfirmed, EventCancelSuccess, NSUtil.ReturnCompleted},
+}
+
+// orderTransitions define the allowed transitions for each price type and product type
+var orderTransitions = map[string][]transition{
+	transitionKey(NSUtil.Fix, NSUtil.Digit): {
+		{NSUtil.None, EventStop, NSUtil.Stopped},
+		{NSUtil.None, EventBuy, NSUtil.InFix},
+		{NSUtil.InFix, EventBuyFailed, NSUtil.None},
+		{NSUtil.InFix, EventChainSuccess, NSUtil.Completed},
+		{NSUtil.InFix, EventChainFail, NSUtil.Failed},
+	},
+	transitionKey(NSUtil.Fix, NSUtil.Entity): append([]transition{
+		{NSUtil.None, EventStop, NSUtil.Stopped},
+		{NSUtil.None, EventBuy, NSUtil.Unshipped},
+	}, entityDelivery...),
+	transitionKey(NSUtil.Auction, NSUtil.Digit): {
+		{NSUtil.None, EventStop, NSUtil.Stopped},
+		{NSUtil.None, EventBid, NSUtil.InAuction},
+		{NSUtil.InAuction, EventBid, NSUtil.InAuction},
+		{NSUtil.InAuction, EventAuctionWon, NSUtil.InAuction},
+		{NSUtil.InAuction, EventAuctionFailed, NSUtil.Failed},
+		{NSUtil.InAuction, EventChainSuccess, NSUtil.Completed},
+		{NSUtil.InAuction, EventChainFail, NSUtil.Failed},
+	},
+	transitionKey(NSUtil.Auction, NSUtil.Entity): append([]transition{
+		{NSUtil.None, EventStop, NSUtil.Stopped},
+		{NSUtil.None, EventBid, NSUtil.InAuction},
+		{NSUtil.InAuction, EventBid, NSUtil.InAuction},
+		{NSUtil.InAuction, EventAuctionWon, NSUtil.Unshipped},
+		{NSUtil.InAuction, EventAuctionFailed, NSUtil.Failed},
+	}, entityDelivery...),
+}
+
+func transitionKey(priceType, productType int) string {
+	return strconv.Itoa(priceType) + "/" + strconv.Itoa(productType)
+}
+
+// nextStatus return the status after the event happens on the order
+func nextStatus(order Order, event string) (string, error) {
+	transitions, ok := orderTransitions[order.Product.PriceType+"/"+order.Product.Type]
+	if !ok {
+		return "", errors.New("unsupported price type " + order.Product.PriceType +
+			" and product type " + order.Product.Type)
+	}
+
+	for _, t := range transitions {
+		if strconv.Itoa(t.From) == order.Status && t.Event == event {
+			return strconv.Itoa(t.To), nil
+		}
+	}
+
+	return "", errors.New("event " + event + " isn't allowed in status " + order.Status)
+}
+
+// reachedBy tell whether the order is in a status which the event moves the orders to, i.e. the event may
+// have been applied to it
+func reachedBy(order Order, event string) bool {
+	for _, t := range orderTransitions[order.Product.PriceType+"/"+order.Product.Type] {
+		if t.Event == event && strconv.Itoa(t.To) == order.Status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transit move the order to the next status by the event, and record the transition in the history.
+// The update is a compare-and-set on the current status and bid price, so the transition fails if
+// the order has been changed since it was read. The order data can be changed by the update function.
+func (svc *OrderService) transit(order Order, event, actor, reason string, update func(order *Order)) (Order, error) {
+	to, err := nextStatus(order, event)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "nextStatus", "Order", order.ID, "Info", err)
+		return order, errBadStatus
+	}
+
+	updated := order
+	updated.Status = to
+	if update != nil {
+		update(&updated)
+	}
+
+	err = svc.Orders.Update(order, updated)
+	if err != nil {
+		if err == NSUtil.ErrNotFound {
+			level.Debug(svc.Logger).Log("Order", order.ID, "Event", event, "Info", "order has been changed")
+			return order, errStatusChanged
+		}
+
+		level.Error(svc.Logger).Log("API", "Orders.Update", "Info", err)
+		return order, errors.New(generalErrorInfo)
+	}
+
+	svc.addOrderEvent(OrderEvent{OrderID: order.ID, Actor: actor, Event: event,
+		From: order.Status, To: to, Reason: reason})
+
+	return updated, nil
+}
+
+func (svc *OrderService) addOrderEvent(event OrderEvent) {
+	event.Time = time.Now()
+	err := svc.OrderEvents.Insert(event)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "OrderEvents.Insert", "Order", event.OrderID, "Info", err)
+	}
+}
+
+// GetOrderHistory return all the status transitions of an order in time order
+func (svc *OrderService) GetOrderHistory(orderId string) ([]OrderEvent, error) {
+	level.Debug(svc.Logger).Log("Input", "orderId", "Value", orderId)
+	events, err := svc.OrderEvents.FindByOrder(orderId)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "OrderEvents.FindByOrder", "Info", err)
+		return events, errors.New(generalErrorInfo)
+	}
+
+	return events, nil
+}
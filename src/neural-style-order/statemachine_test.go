@@ -0,0 +1,44 @@
+package OrderService
+
+import (
+	"strconv"
+	"testing"
+
+	"neural-style-util"
+)
+
+func TestNextStatus(t *testing.T) {
+	order := func(priceType, productType, status int) Order {
+		return Order{Status: strconv.Itoa(status), Product: ProductInfo{
+			PriceType: strconv.Itoa(priceType), Type: strconv.Itoa(productType)}}
+	}
+
+	cases := []struct {
+		order Order
+		event string
+		to    int
+		ok    bool
+	}{
+		{order(NSUtil.Fix, NSUtil.Digit, NSUtil.None), EventBuy, NSUtil.InFix, true},
+		{order(NSUtil.Fix, NSUtil.Digit, NSUtil.InFix), EventBuy, 0, false},
+		{order(NSUtil.Fix, NSUtil.Entity, NSUtil.None), EventBuy, NSUtil.Unshipped, true},
+		{order(NSUtil.Fix, NSUtil.Entity, NSUtil.Unshipped), EventConfirm, 0, false},
+		{order(NSUtil.Fix, NSUtil.Entity, NSUtil.Dispatched), EventAskReturn, NSUtil.ReturnInAgree, true},
+		{order(NSUtil.Fix, NSUtil.Digit, NSUtil.Dispatched), EventAskReturn, 0, false},
+		{order(NSUtil.Auction, NSUtil.Digit, NSUtil.InAuction), EventBid, NSUtil.InAuction, true},
+		{order(NSUtil.Auction, NSUtil.Entity, NSUtil.InAuction), EventAuctionWon, NSUtil.Unshipped, true},
+		{order(NSUtil.Auction, NSUtil.Digit, NSUtil.Completed), EventBid, 0, false},
+	}
+
+	for _, c := range cases {
+		to, err := nextStatus(c.order, c.event)
+		if c.ok != (err == nil) {
+			t.Errorf("%s in status %s: unexpected error %v", c.event, c.order.Status, err)
+			continue
+		}
+
+		if c.ok && to != strconv.Itoa(c.to) {
+			t.Errorf("%s in status %s: expected %d, got %s", c.event, c.order.Status, c.to, to)
+		}
+	}
+}
@@ -20,6 +20,7 @@ type ProductQuery struct {
 }
 
 type ChainResult struct {
+	EventId     string `json:"eventId"`
 	Result      string `json:"result"`
 }
 
@@ -102,13 +103,48 @@ func decodeNSBuyRequest(_ context.Context, r *http.Request) (interface{}, error)
 	return NSBuyRequest{OrderId: orderId, BuyData: buyInfo}, nil
 }
 
+func decodeNSBidRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	orderId := vars["id"]
+
+	bid := Bid{}
+	json.NewDecoder(r.Body).Decode(&bid)
+	return NSBidRequest{OrderId: orderId, BidData: bid}, nil
+}
+
+func encodeNSBidsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	bidsRes := response.(NSBidsResponse)
+	if bidsRes.Err != nil {
+		return bidsRes.Err
+	}
+
+	w.Header().Set("context-type", "application/json, charset=utf8")
+	return json.NewEncoder(w).Encode(bidsRes.Bids)
+}
+
+func encodeNSOrderHistoryResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	historyRes := response.(NSOrderHistoryResponse)
+	if historyRes.Err != nil {
+		return historyRes.Err
+	}
+
+	w.Header().Set("context-type", "application/json, charset=utf8")
+	return json.NewEncoder(w).Encode(historyRes.Events)
+}
+
 func decodeNSChainRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	vars := mux.Vars(r)
 	chainId := vars["chainId"]
 
+	payload, err := readChainPayload(r)
+	if err != nil {
+		return nil, err
+	}
+
 	result := ChainResult{}
-	json.NewDecoder(r.Body).Decode(&result)
-	return NSChainRequest{ChainId: chainId, Result: result.Result}, nil
+	json.Unmarshal(payload, &result)
+	return NSChainRequest{ChainId: chainId, EventId: result.EventId, Result: result.Result,
+		Adapter: r.Header.Get(ChainAdapterHeader), Signature: r.Header.Get(ChainSignatureHeader), Payload: payload}, nil
 }
 
 func decodeNSExpressRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -129,11 +165,12 @@ func decodeNSAskForReturnRequest(_ context.Context, r *http.Request) (interface{
 	return NSAskForReturnRequest{OrderId: orderId, ReturnData: returnInfo}, nil
 }
 
-// MakeHTTPHandler generate the http handler for the style service handler
-func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middleware, svc Service, options ...httptransport.ServerOption) *mux.Router {
+// MakeHTTPHandler generate the http handler for the style service handler, the viewer middleware passes the
+// signed-in user to the public routes
+func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth, viewer endpoint.Middleware, svc Service, options ...httptransport.ServerOption) *mux.Router {
 	// GET /api/v1/transactionorders
 	r.Methods("GET").Path("/api/v1/transactionorders").Handler(httptransport.NewServer(
-		MakeNSGetOrdersInTransactionEndpoint(svc),
+		viewer(MakeNSGetOrdersInTransactionEndpoint(svc)),
 		decodeNSGetOrdersInTransactionRequest,
 		encodeNSOrdersResponse,
 		options...,
@@ -157,7 +194,7 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 
 	// GET /api/v1/order
 	r.Methods("GET").Path("/api/v1/order").Handler(httptransport.NewServer(
-		MakeNSGetOrderByProductIdEndpoint(svc),
+		viewer(MakeNSGetOrderByProductIdEndpoint(svc)),
 		decodeNSGetOrderByProductIdRequest,
 		encodeNSGetOrderByProductIdResponse,
 		options...,
@@ -189,9 +226,34 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 	)
 	r.Methods("POST").Path("/api/v1/orders/{id}/buy").Handler(NSUtil.AccessControl(buyHandler))
 	
+	// POST /api/v1/orders/{id}/bids
+	bidHandler := httptransport.NewServer(
+		auth(MakeNSPlaceBidEndpoint(svc)),
+		decodeNSBidRequest,
+		encodeNSErrorResponse,
+		options...,
+	)
+	r.Methods("POST").Path("/api/v1/orders/{id}/bids").Handler(NSUtil.AccessControl(bidHandler))
+
+	// GET /api/v1/orders/{id}/bids
+	r.Methods("GET").Path("/api/v1/orders/{id}/bids").Handler(httptransport.NewServer(
+		MakeNSGetBidsEndpoint(svc),
+		decodeNSOrderIdRequest,
+		encodeNSBidsResponse,
+		options...,
+	))
+
+	// GET /api/v1/orders/{id}/history
+	r.Methods("GET").Path("/api/v1/orders/{id}/history").Handler(httptransport.NewServer(
+		auth(MakeNSGetOrderHistoryEndpoint(svc)),
+		decodeNSOrderIdRequest,
+		encodeNSOrderHistoryResponse,
+		options...,
+	))
+
 	// POST /api/v1/orders/{chainId}/chainconfirm
 	chainApplyHandler := httptransport.NewServer(
-		auth(MakeNSApplyConfirmFromChainEndpoint(svc)),
+		ChainSignatureMiddleware(ChainSecrets)(MakeNSApplyConfirmFromChainEndpoint(svc)),
 		decodeNSChainRequest,
 		encodeNSErrorResponse,
 		options...,
@@ -251,7 +313,7 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 	
 	// POST /api/v1/orders/{chainId}/chaincancel
 	chainCancelHandler := httptransport.NewServer(
-		auth(MakeNSApplyCancelFromChainEndpoint(svc)),
+		ChainSignatureMiddleware(ChainSecrets)(MakeNSApplyCancelFromChainEndpoint(svc)),
 		decodeNSChainRequest,
 		encodeNSErrorResponse,
 		options...,
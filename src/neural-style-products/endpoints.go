@@ -2,6 +2,10 @@ package ProductService
 
 import (
 	"context"
+	"io"
+	"net/http"
+
+	"neural-style-util"
 
 	"github.com/go-kit/kit/endpoint"
 )
@@ -50,10 +54,38 @@ type NSGetArtistsResponse struct {
 	Err     error
 }
 
-// NSCacheGetRequest define request key
+// NSAddArtistRequest define the new artist and its transfer model
+type NSAddArtistRequest struct {
+	Artist Artist
+}
+
+// NSRetireArtistRequest define the name of the retired artist
+type NSRetireArtistRequest struct {
+	Name string
+}
+
+// NSArtistResponse only returns the error information for changing an artist
+type NSArtistResponse struct {
+	Err error
+}
+
+// NSWatermarkRequest define the owner and the new watermark profile
+type NSWatermarkRequest struct {
+	Owner   string
+	Profile WatermarkProfile
+}
+
+// NSWatermarkResponse return the watermark profile of the owner
+type NSWatermarkResponse struct {
+	Profile WatermarkProfile
+	Err     error
+}
+
+// NSCacheGetRequest define request key, Size is the variant of the picture
 type NSCacheGetRequest struct {
 	UserID  string
 	ImageID string
+	Size    string
 }
 
 // NSCacheGetResponse define the cached image data
@@ -127,6 +159,61 @@ type NSSearchResponse struct {
 	Err   error
 }
 
+// NSCreateUploadRequest define the owner and the file name of a resumable upload, the owner is the token user
+type NSCreateUploadRequest struct {
+	Owner string `json:"-"`
+	Name  string `json:"name"`
+}
+
+// NSGetUploadRequest define the id of the upload
+type NSGetUploadRequest struct {
+	ID string
+}
+
+// NSUploadChunkRequest define a numbered chunk and its hex sha256, the data is streamed from the request body
+type NSUploadChunkRequest struct {
+	ID       string
+	Index    int
+	Checksum string
+	Data     io.Reader
+}
+
+// NSCommitUploadRequest define the chunk count and the optional sha256 of the whole picture
+type NSCommitUploadRequest struct {
+	ID       string
+	Chunks   int    `json:"chunks"`
+	Checksum string `json:"sha256"`
+}
+
+// NSUploadResponse return the upload and the error information
+type NSUploadResponse struct {
+	Session UploadSession
+	Err     error
+}
+
+// withoutReserve clear the reserve price if the viewer doesn't own the product, the bidders shouldn't know it
+func withoutReserve(product Product, viewer string) Product {
+	if product.Owner != viewer {
+		product.Price.Reserve = ""
+	}
+	return product
+}
+
+// hideReserve clear the reserve prices of the products which the viewer doesn't own
+func hideReserve(products []Product, viewer string) {
+	for i := range products {
+		products[i] = withoutReserve(products[i], viewer)
+	}
+}
+
+// checkOwner reject the request if the token user isn't the owner
+func checkOwner(ctx context.Context, owner string) error {
+	if user := NSUtil.ContextUser(ctx); user == "" || user != owner {
+		return NSUtil.NewErrorWithStatus(http.StatusForbidden, "Only the owner is permitted")
+	}
+	return nil
+}
+
 // MakeNSContentUploadEndpoint upload the content file
 func MakeNSContentUploadEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
@@ -158,6 +245,7 @@ func MakeNSStylesUploadEndpoint(svc Service) endpoint.Endpoint {
 func MakeNSGetProductsEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		output, err := svc.GetProducts()
+		hideReserve(output, NSUtil.ContextUser(ctx))
 		return NSGetProductsResponse{Products: output, Err: err}, err
 	}
 }
@@ -167,10 +255,21 @@ func MakeNSGetProductByIDEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSGetProductByIDRequest)
 		prod, err := svc.GetProductsByID(req.ID)
+		prod = withoutReserve(prod, NSUtil.ContextUser(ctx))
 		return NSGetProductResponse{Target: prod, Err: err}, err
 	}
 }
 
+// MakeNSGetSimilarProductsEndpoint get the products with the similar pictures
+func MakeNSGetSimilarProductsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSGetProductByIDRequest)
+		prods, err := svc.GetSimilarProducts(req.ID)
+		hideReserve(prods, NSUtil.ContextUser(ctx))
+		return NSGetProductsResponse{Products: prods, Err: err}, err
+	}
+}
+
 // MakeNSGetArtists generate the endpoint for get hotest artists
 func MakeNSGetArtists(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
@@ -187,11 +286,29 @@ func MakeNSGetHotestArtists(svc Service) endpoint.Endpoint {
 	}
 }
 
+// MakeNSAddArtistEndpoint generate the endpoint for registering an artist model
+func MakeNSAddArtistEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSAddArtistRequest)
+		err := svc.AddArtist(req.Artist)
+		return NSArtistResponse{Err: err}, err
+	}
+}
+
+// MakeNSRetireArtistEndpoint generate the endpoint for retiring an artist model
+func MakeNSRetireArtistEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSRetireArtistRequest)
+		err := svc.RetireArtist(req.Name)
+		return NSArtistResponse{Err: err}, err
+	}
+}
+
 // MakeNSImageCacheGetEndpoint define the endpoint for image cache get
 func MakeNSImageCacheGetEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSCacheGetRequest)
-		data, mimeType, err := svc.GetImage(req.UserID, req.ImageID)
+		data, mimeType, err := svc.GetImage(req.UserID, req.ImageID, req.Size, NSUtil.ContextUser(ctx))
 		return NSCacheGetResponse{Data: data, Type: mimeType, Error: err}, err
 	}
 }
@@ -228,6 +345,7 @@ func MakeNSGetProductsByUser(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSGetProductsByUserRequest)
 		prods, err := svc.GetProductsByUser(req.User)
+		hideReserve(prods, NSUtil.ContextUser(ctx))
 		return NSGetProductsByUserResponse{Prods: prods}, err
 	}
 }
@@ -237,6 +355,7 @@ func MakeNSGetProductsByTags(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSGetProductsByTagsRequest)
 		prods, err := svc.GetProductsByTags(req.Tags)
+		hideReserve(prods, NSUtil.ContextUser(ctx))
 		return NSGetProductsByTagsResponse{Prods: prods}, err
 	}
 }
@@ -246,6 +365,70 @@ func MakeNSSearch(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(NSSearchRequest)
 		prods, err := svc.Search(req.Info)
+		hideReserve(prods, NSUtil.ContextUser(ctx))
 		return NSSearchResponse{Prods: prods, Err: err}, err
 	}
 }
+
+// MakeNSCreateUploadEndpoint start a resumable upload
+func MakeNSCreateUploadEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSCreateUploadRequest)
+		req.Owner = NSUtil.ContextUser(ctx)
+		session, err := svc.CreateUpload(req.Owner, req.Name)
+		return NSUploadResponse{Session: session, Err: err}, err
+	}
+}
+
+// MakeNSGetUploadEndpoint return the received chunks of the upload
+func MakeNSGetUploadEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSGetUploadRequest)
+		session, err := svc.GetUpload(NSUtil.ContextUser(ctx), req.ID)
+		return NSUploadResponse{Session: session, Err: err}, err
+	}
+}
+
+// MakeNSUploadChunkEndpoint upload a chunk of the picture
+func MakeNSUploadChunkEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSUploadChunkRequest)
+		err := svc.UploadChunk(NSUtil.ContextUser(ctx), req.ID, req.Index, req.Checksum, req.Data)
+		return NSUploadResponse{Err: err}, err
+	}
+}
+
+// MakeNSCommitUploadEndpoint save the uploaded picture and return its url
+func MakeNSCommitUploadEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSCommitUploadRequest)
+		session, err := svc.CommitUpload(NSUtil.ContextUser(ctx), req.ID, req.Chunks, req.Checksum)
+		return NSUploadResponse{Session: session, Err: err}, err
+	}
+}
+
+// MakeNSGetWatermarkEndpoint generate the endpoint for getting the watermark profile of an owner
+func MakeNSGetWatermarkEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSWatermarkRequest)
+		if err := checkOwner(ctx, req.Owner); err != nil {
+			return NSWatermarkResponse{Err: err}, err
+		}
+
+		profile, err := svc.GetWatermark(req.Owner)
+		return NSWatermarkResponse{Profile: profile, Err: err}, err
+	}
+}
+
+// MakeNSSetWatermarkEndpoint generate the endpoint for saving the watermark profile of an owner
+func MakeNSSetWatermarkEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSWatermarkRequest)
+		if err := checkOwner(ctx, req.Owner); err != nil {
+			return NSWatermarkResponse{Err: err}, err
+		}
+
+		err := svc.SetWatermark(req.Profile)
+		return NSWatermarkResponse{Profile: req.Profile, Err: err}, err
+	}
+}
@@ -1,6 +1,7 @@
 package ProductService
 
 import (
+	"io"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
@@ -78,6 +79,15 @@ func (svc *loggingService) GetProductsByID(id string) (prod Product, err error)
 	return svc.dataService.GetProductsByID(id)
 }
 
+func (svc *loggingService) GetSimilarProducts(id string) (prods []Product, err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "GetSimilarProducts", "id", id, "found", len(prods),
+			"took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.GetSimilarProducts(id)
+}
+
 func (svc *loggingService) GetArtists() (artists []Artist, err error) {
 	defer func(begin time.Time) {
 		level.Debug(svc.logger).Log("method", "GetArtists", "took", time.Since(begin), "err", err)
@@ -94,12 +104,30 @@ func (svc *loggingService) GetHotestArtists() (artists []Artist, err error) {
 	return svc.dataService.GetHotestArtists()
 }
 
-func (svc *loggingService) GetImage(userID, imageID string) (data []byte, info string, err error) {
+func (svc *loggingService) AddArtist(artist Artist) (err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "AddArtist", "artist", artist.Name, "model", artist.ModelName,
+			"took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.AddArtist(artist)
+}
+
+func (svc *loggingService) RetireArtist(name string) (err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "RetireArtist", "artist", name, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.RetireArtist(name)
+}
+
+func (svc *loggingService) GetImage(userID, imageID, size, viewer string) (data []byte, info string, err error) {
 	defer func(begin time.Time) {
-		level.Debug(svc.logger).Log("method", "GetImage", "user", userID, "image", imageID, "took", time.Since(begin), "err", err)
+		level.Debug(svc.logger).Log("method", "GetImage", "user", userID, "image", imageID, "size", size,
+			"viewer", viewer, "took", time.Since(begin), "err", err)
 	}(time.Now())
 
-	return svc.dataService.GetImage(userID, imageID)
+	return svc.dataService.GetImage(userID, imageID, size, viewer)
 }
 
 func (svc *loggingService) DeleteProduct(productID string) (err error) {
@@ -132,3 +160,58 @@ func (svc *loggingService) Search(keyvals map[string]interface{}) (prods []Produ
 
 	return svc.dataService.Search(keyvals)
 }
+
+func (svc *loggingService) CreateUpload(owner, name string) (session UploadSession, err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "CreateUpload", "owner", owner, "name", name, "upload", session.ID,
+			"took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.CreateUpload(owner, name)
+}
+
+func (svc *loggingService) GetUpload(owner, uploadID string) (session UploadSession, err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "GetUpload", "owner", owner, "upload", uploadID,
+			"took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.GetUpload(owner, uploadID)
+}
+
+func (svc *loggingService) UploadChunk(owner, uploadID string, index int, checksum string,
+	data io.Reader) (err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "UploadChunk", "owner", owner, "upload", uploadID, "chunk", index,
+			"took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.UploadChunk(owner, uploadID, index, checksum, data)
+}
+
+func (svc *loggingService) CommitUpload(owner, uploadID string, chunks int,
+	checksum string) (session UploadSession, err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "CommitUpload", "owner", owner, "upload", uploadID, "chunks", chunks,
+			"took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.CommitUpload(owner, uploadID, chunks, checksum)
+}
+
+func (svc *loggingService) GetWatermark(owner string) (profile WatermarkProfile, err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "GetWatermark", "owner", owner, "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.dataService.GetWatermark(owner)
+}
+
+func (svc *loggingService) SetWatermark(profile WatermarkProfile) (err error) {
+	defer func(begin time.Time) {
+		level.Debug(svc.logger).Log("method", "SetWatermark", "owner", profile.Owner, "took", time.Since(begin),
+			"err", err)
+	}(time.Now())
+
+	return svc.dataService.SetWatermark(profile)
+}
@@ -0,0 +1,182 @@
+package ProductService
+
+import (
+	"sync"
+
+	"neural-style-util"
+)
+
+type memoryRepository struct {
+	mutex      sync.RWMutex
+	products   []Product
+	artists    []Artist
+	watermarks map[string]WatermarkProfile
+}
+
+// NewMemoryRepository create the product repository in memory
+func NewMemoryRepository() Repository {
+	return &memoryRepository{watermarks: make(map[string]WatermarkProfile)}
+}
+
+func (repo *memoryRepository) indexOf(productID string) int {
+	for index, product := range repo.products {
+		if product.ID == productID {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (repo *memoryRepository) Insert(product Product) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if repo.indexOf(product.ID) >= 0 {
+		return NSUtil.ErrDuplicated
+	}
+
+	repo.products = append(repo.products, product)
+	return nil
+}
+
+func (repo *memoryRepository) Remove(productID string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	index := repo.indexOf(productID)
+	if index < 0 {
+		return NSUtil.ErrNotFound
+	}
+
+	repo.products = append(repo.products[:index], repo.products[index+1:]...)
+	return nil
+}
+
+func (repo *memoryRepository) Update(product Product) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	index := repo.indexOf(product.ID)
+	if index < 0 {
+		return NSUtil.ErrNotFound
+	}
+
+	repo.products[index] = product
+	return nil
+}
+
+func (repo *memoryRepository) UpdateOwner(productID, owner, price string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	index := repo.indexOf(productID)
+	if index < 0 {
+		return NSUtil.ErrNotFound
+	}
+
+	repo.products[index].Owner = owner
+	repo.products[index].Price.Value = price
+	return nil
+}
+
+func (repo *memoryRepository) FindByID(productID string) (Product, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	index := repo.indexOf(productID)
+	if index < 0 {
+		return Product{}, NSUtil.ErrNotFound
+	}
+
+	return repo.products[index], nil
+}
+
+func (repo *memoryRepository) Find(query map[string]interface{}) ([]Product, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var products []Product
+	for _, product := range repo.products {
+		if NSUtil.MatchFields(product, query) {
+			products = append(products, product)
+		}
+	}
+
+	return products, nil
+}
+
+func (repo *memoryRepository) FindArtists() ([]Artist, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var artists []Artist
+	for _, artist := range repo.artists {
+		if !artist.Retired {
+			artists = append(artists, artist)
+		}
+	}
+
+	return artists, nil
+}
+
+func (repo *memoryRepository) FindArtist(name string) (Artist, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for _, artist := range repo.artists {
+		if artist.Name == name {
+			return artist, nil
+		}
+	}
+
+	return Artist{}, NSUtil.ErrNotFound
+}
+
+func (repo *memoryRepository) InsertArtist(artist Artist) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, existing := range repo.artists {
+		if existing.Name == artist.Name {
+			return NSUtil.ErrDuplicated
+		}
+	}
+
+	repo.artists = append(repo.artists, artist)
+	return nil
+}
+
+func (repo *memoryRepository) RetireArtist(name string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for index := range repo.artists {
+		if repo.artists[index].Name == name {
+			repo.artists[index].Retired = true
+			return nil
+		}
+	}
+
+	return NSUtil.ErrNotFound
+}
+
+func (repo *memoryRepository) FindWatermark(owner string) (WatermarkProfile, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	profile, ok := repo.watermarks[owner]
+	if !ok {
+		return profile, NSUtil.ErrNotFound
+	}
+
+	return profile, nil
+}
+
+func (repo *memoryRepository) SaveWatermark(profile WatermarkProfile) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.watermarks[profile.Owner] = profile
+	return nil
+}
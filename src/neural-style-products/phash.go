@@ -0,0 +1,92 @@
+package ProductService
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// duplicate actions of the near-duplicate pictures
+const (
+	RejectDuplicates = "reject"
+	FlagDuplicates   = "flag"
+	IgnoreDuplicates = "off"
+)
+
+// DuplicatePolicy define how the near-duplicate style pictures are handled. Two pictures are similar if the
+// Hamming distance of their dHash is at most Threshold, the distance of the same picture resized or
+// recompressed is usually less than 10. A similar picture is rejected, or flagged by Product.SimilarTo.
+type DuplicatePolicy struct {
+	Threshold int
+	Action    string
+}
+
+// dhashSamples is the max samples in each row and column of a cell, so a large picture is hashed quickly
+const dhashSamples = 8
+
+// dHash shrink the gray picture to 9x8 cells, and set a bit if a cell is brighter than its right one
+func dHash(img image.Image) uint64 {
+	var cells [8][9]float64
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 9; col++ {
+			cells[row][col] = cellLuminance(img, bounds.Min.X+col*width/9, bounds.Min.Y+row*height/8,
+				bounds.Min.X+(col+1)*width/9, bounds.Min.Y+(row+1)*height/8)
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			hash <<= 1
+			if cells[row][col] > cells[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// cellLuminance return the average luminance of the cell from x0, y0 to x1, y1 excluded
+func cellLuminance(img image.Image, x0, y0, x1, y1 int) float64 {
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+
+	stepX := (x1 - x0 + dhashSamples - 1) / dhashSamples
+	stepY := (y1 - y0 + dhashSamples - 1) / dhashSamples
+
+	var sum float64
+	count := 0
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			count++
+		}
+	}
+
+	return sum / float64(count)
+}
+
+// hammingDistance return how many bits are different
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// formatHash keep the hash as a hex string, so it's stored without the sign problem of uint64
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func parseHash(hash string) (uint64, bool) {
+	value, err := strconv.ParseUint(hash, 16, 64)
+	return value, err == nil && hash != ""
+}
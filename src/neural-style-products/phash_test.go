@@ -0,0 +1,47 @@
+package ProductService
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func gradient(width, height int, flip bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := uint8((x*255/width + y*97/height) % 256)
+			if flip {
+				value = 255 - value
+			}
+			img.Set(x, y, color.RGBA{value, value / 2, 255 - value, 255})
+		}
+	}
+
+	return img
+}
+
+func TestDHashMatchesResizedAndRecompressedCopies(t *testing.T) {
+	original := dHash(gradient(320, 240, false))
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, gradient(160, 120, false), &jpeg.Options{Quality: 40})
+	recompressed, _, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if distance := hammingDistance(original, dHash(recompressed)); distance > 10 {
+		t.Errorf("expected the resized copy to be similar, got distance %d", distance)
+	}
+
+	if distance := hammingDistance(original, dHash(gradient(320, 240, true))); distance <= 10 {
+		t.Errorf("expected a different picture not to be similar, got distance %d", distance)
+	}
+
+	if hash, ok := parseHash(formatHash(original)); !ok || hash != original {
+		t.Errorf("expected the hash to be parsed back")
+	}
+}
@@ -0,0 +1,192 @@
+package ProductService
+
+import (
+	"neural-style-util"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Repository define the data access of the products and the artists
+type Repository interface {
+	// Insert return NSUtil.ErrDuplicated if the product id exists
+	Insert(product Product) error
+	// Remove return NSUtil.ErrNotFound if the product doesn't exist
+	Remove(productID string) error
+	// Update replace the product with the same id
+	Update(product Product) error
+	UpdateOwner(productID, owner, price string) error
+	// FindByID return NSUtil.ErrNotFound if the product doesn't exist
+	FindByID(productID string) (Product, error)
+	// Find return the products matching all the field values, an empty query returns all the products
+	Find(query map[string]interface{}) ([]Product, error)
+	// FindArtists return the artists which are not retired
+	FindArtists() ([]Artist, error)
+	// FindArtist return NSUtil.ErrNotFound if the artist doesn't exist
+	FindArtist(name string) (Artist, error)
+	// InsertArtist return NSUtil.ErrDuplicated if the artist name exists
+	InsertArtist(artist Artist) error
+	// RetireArtist return NSUtil.ErrNotFound if the artist doesn't exist
+	RetireArtist(name string) error
+	// FindWatermark return NSUtil.ErrNotFound if the owner has no watermark profile
+	FindWatermark(owner string) (WatermarkProfile, error)
+	// SaveWatermark replace the watermark profile of the owner
+	SaveWatermark(profile WatermarkProfile) error
+}
+
+type mgoRepository struct {
+	session *mgo.Session
+}
+
+// NewMongoRepository create the product repository on the "products", "artists" and "watermarks" collection
+func NewMongoRepository(session *mgo.Session) Repository {
+	return &mgoRepository{session: session}
+}
+
+func (repo *mgoRepository) Insert(product Product) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("products").Insert(product)
+	if mgo.IsDup(err) {
+		return NSUtil.ErrDuplicated
+	}
+
+	return err
+}
+
+func (repo *mgoRepository) Remove(productID string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("products").Remove(bson.M{"id": productID})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoRepository) Update(product Product) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	updateData, err := bson.Marshal(&product)
+	if err != nil {
+		return err
+	}
+	mData := bson.M{}
+	err = bson.Unmarshal(updateData, mData)
+	if err != nil {
+		return err
+	}
+
+	err = session.DB("store").C("products").Update(bson.M{"id": product.ID}, bson.M{"$set": mData})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoRepository) UpdateOwner(productID, owner, price string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	updateData := bson.M{"owner": owner, "price.value": price}
+	err := session.DB("store").C("products").Update(bson.M{"id": productID}, bson.M{"$set": updateData})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoRepository) FindByID(productID string) (Product, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var product Product
+	err := session.DB("store").C("products").Find(bson.M{"id": productID}).One(&product)
+	if err == mgo.ErrNotFound {
+		return product, NSUtil.ErrNotFound
+	}
+
+	return product, err
+}
+
+func (repo *mgoRepository) Find(query map[string]interface{}) ([]Product, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var products []Product
+	err := session.DB("store").C("products").Find(bson.M(query)).All(&products)
+	return products, err
+}
+
+func (repo *mgoRepository) FindArtists() ([]Artist, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var artists []Artist
+	err := session.DB("store").C("artists").Find(bson.M{"retired": bson.M{"$ne": true}}).All(&artists)
+	return artists, err
+}
+
+func (repo *mgoRepository) FindArtist(name string) (Artist, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var artist Artist
+	err := session.DB("store").C("artists").Find(bson.M{"name": name}).One(&artist)
+	if err == mgo.ErrNotFound {
+		return artist, NSUtil.ErrNotFound
+	}
+
+	return artist, err
+}
+
+func (repo *mgoRepository) InsertArtist(artist Artist) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("artists").Insert(artist)
+	if mgo.IsDup(err) {
+		return NSUtil.ErrDuplicated
+	}
+
+	return err
+}
+
+func (repo *mgoRepository) RetireArtist(name string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("artists").Update(bson.M{"name": name}, bson.M{"$set": bson.M{"retired": true}})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoRepository) FindWatermark(owner string) (WatermarkProfile, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var profile WatermarkProfile
+	err := session.DB("store").C("watermarks").Find(bson.M{"owner": owner}).One(&profile)
+	if err == mgo.ErrNotFound {
+		return profile, NSUtil.ErrNotFound
+	}
+
+	return profile, err
+}
+
+func (repo *mgoRepository) SaveWatermark(profile WatermarkProfile) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB("store").C("watermarks").Upsert(bson.M{"owner": profile.Owner}, profile)
+	return err
+}
@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"image"
-	"image/color"
+	"io"
 	"mime"
 	"net/http"
 	"os"
@@ -23,8 +23,6 @@ import (
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/go-kit/kit/log"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 )
 
 var memCacheServices = os.Getenv("MEMCACHE_SERVICES")
@@ -42,10 +40,12 @@ type ProductStory struct {
 //     auction
 //     onlyShow
 // )
+// Reserve is the lowest acceptable price when an auction closes, empty for no reserve price.
 type ProductPrice struct {
 	Type     string `json:"type"`
 	Value    string `json:"value"`
 	Duration string `json:"duration"`
+	Reserve  string `json:"reserve"`
 }
 
 // UploadProduct define the full information of the uploaded image product
@@ -59,6 +59,7 @@ type UploadProduct struct {
 	Maker       string       `json:"maker"`
 	Price       ProductPrice `json:"price"`
 	PicData     string       `json:"picData"`
+	PicURL      string       `json:"picUrl"`
 	StyleImgURL string       `json:"styleImageUrl"`
 	Tags        []string     `json:"tags"`
 	Story       ProductStory `json:"story"`
@@ -78,17 +79,20 @@ type BatchProducts struct {
 
 // Product define the basic elements of the product
 type Product struct {
-	ID          string       `json:"id"`
-	Owner       string       `json:"owner"`
-	Maker       string       `json:"maker"`
-	Price       ProductPrice `json:"price"`
-	Rating      float32      `json:"rating"`
-	URL         string       `json:"url"`
-	StyleImgURL string       `json:"styleImgUrl"`
-	Tags        []string     `json:"tags"`
-	Story       ProductStory `json:"story"`
-	Type        string       `json:"type"`
-	ChainId     string       `json:"chainId"`
+	ID          string            `json:"id"`
+	Owner       string            `json:"owner"`
+	Maker       string            `json:"maker"`
+	Price       ProductPrice      `json:"price"`
+	Rating      float32           `json:"rating"`
+	URL         string            `json:"url"`
+	StyleImgURL string            `json:"styleImgUrl"`
+	Tags        []string          `json:"tags"`
+	Story       ProductStory      `json:"story"`
+	Type        string            `json:"type"`
+	ChainId     string            `json:"chainId"`
+	PHash       string            `json:"phash,omitempty"`
+	SimilarTo   []string          `json:"similarTo,omitempty"`
+	Variants    map[string]string `json:"variants,omitempty"`
 }
 
 // Artist define the basic artist information
@@ -96,6 +100,7 @@ type Artist struct {
 	Name        string `json:"name"`
 	Masterpiece string `json:"masterpiece"`
 	ModelName   string `json:"modelname"`
+	Retired     bool   `json:"retired"`
 }
 
 // Service define the basic interface for the products service
@@ -108,13 +113,22 @@ type Service interface {
 	GetProductsByUser(userID string) ([]Product, error)
 	GetProductsByTags(tag []string) ([]Product, error)
 	GetProductsByID(id string) (Product, error)
+	GetSimilarProducts(id string) ([]Product, error)
 	GetArtists() ([]Artist, error)
 	GetHotestArtists() ([]Artist, error)
-	GetImage(userID, imageID string) ([]byte, string, error)
+	AddArtist(artist Artist) error
+	RetireArtist(name string) error
+	GetImage(userID, imageID, size, viewer string) ([]byte, string, error)
 	DeleteProduct(productID string) error
 	UpdateProduct(productID string, productData UploadProduct) error
 	UpdateProductAfterTransaction(productId string, newOwner string, newPrice string) error
 	Search(keyvals map[string]interface{}) ([]Product, error)
+	CreateUpload(owner, name string) (UploadSession, error)
+	GetUpload(owner, uploadID string) (UploadSession, error)
+	UploadChunk(owner, uploadID string, index int, checksum string, data io.Reader) error
+	CommitUpload(owner, uploadID string, chunks int, checksum string) (UploadSession, error)
+	GetWatermark(owner string) (WatermarkProfile, error)
+	SetWatermark(profile WatermarkProfile) error
 }
 
 // ProductService for final image style transfer
@@ -122,18 +136,21 @@ type ProductService struct {
 	OutputPath  string
 	Host        string
 	Port        string
-	Session     *mgo.Session
+	Products    Repository
 	SaveURL     string
 	FindURL     string
+	DeleteURL   string
+	UploadURL   string
 	CacheGetURL string
 	IsLocalDev  bool
+	Duplicates  DuplicatePolicy
 	CacheClient *memcache.Client
 	Logger      log.Logger
 }
 
 // NewProductSVC create a new product service
-func NewProductSVC(outputPath, host, port, saveURL, findURL, cacheGetURL string, localDev bool, logger log.Logger,
-	session *mgo.Session) *ProductService {
+func NewProductSVC(outputPath, host, port, saveURL, findURL, deleteURL, uploadURL, cacheGetURL string, localDev bool,
+	logger log.Logger, products Repository) *ProductService {
 	var client *memcache.Client
 	if !localDev {
 		var memcachedURL []string
@@ -152,8 +169,9 @@ func NewProductSVC(outputPath, host, port, saveURL, findURL, cacheGetURL string,
 		client = nil
 	}
 
-	return &ProductService{OutputPath: outputPath, Host: host, Port: port, Session: session,
-		SaveURL: saveURL, FindURL: findURL, CacheGetURL: cacheGetURL, IsLocalDev: localDev,
+	return &ProductService{OutputPath: outputPath, Host: host, Port: port, Products: products,
+		SaveURL: saveURL, FindURL: findURL, DeleteURL: deleteURL, UploadURL: uploadURL, CacheGetURL: cacheGetURL,
+		IsLocalDev: localDev, Duplicates: DuplicatePolicy{Threshold: 10, Action: RejectDuplicates},
 		Logger: logger, CacheClient: client}
 }
 
@@ -189,25 +207,11 @@ func (svc *ProductService) uploadPicture(owner, picData, picID, picFolder string
 		return newImageURL, nil
 	}
 
-	storageClient := &http.Client{}
-	storageURL := svc.SaveURL + "?userid=" + owner + "&imageid=" + outfileName
-	bodyReader := bytes.NewReader(baseData)
-	storageReq, err := http.NewRequest("POST", storageURL, bodyReader)
-	if err != nil {
-		level.Debug(svc.Logger).Log("Storage", storageURL, "err", "request construct fails")
-		return "", err
-	}
-
-	res, err := storageClient.Do(storageReq)
+	err = svc.savePicture(owner, outfileName, baseData)
 	if err != nil {
-		level.Debug(svc.Logger).Log("Storage", "request fails")
 		return "", err
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return "", errors.New("Upload fails")
-	}
-
 	imgReader := bytes.NewReader(baseData)
 	// The default image type after image.Decode is jpeg
 	img, _, err := image.Decode(imgReader)
@@ -215,7 +219,7 @@ func (svc *ProductService) uploadPicture(owner, picData, picID, picFolder string
 		return "", err
 	}
 
-	_, err = svc.waterMarkAndCache(img, "jpeg", owner+outfileName)
+	_, err = svc.waterMarkAndCache(img, owner, owner+outfileName, nil)
 	if err != nil {
 		return "", err
 	}
@@ -226,6 +230,31 @@ func (svc *ProductService) uploadPicture(owner, picData, picID, picFolder string
 	return svc.CacheGetURL + "/" + owner + "/" + outfileName, nil
 }
 
+// savePicture save the picture data in the cloud storage
+func (svc *ProductService) savePicture(owner, imageID string, data []byte) error {
+	storageClient := &http.Client{}
+	storageURL := svc.SaveURL + "?userid=" + owner + "&imageid=" + imageID
+	bodyReader := bytes.NewReader(data)
+	storageReq, err := http.NewRequest("POST", storageURL, bodyReader)
+	if err != nil {
+		level.Debug(svc.Logger).Log("Storage", storageURL, "err", "request construct fails")
+		return err
+	}
+
+	res, err := storageClient.Do(storageReq)
+	if err != nil {
+		level.Debug(svc.Logger).Log("Storage", "request fails")
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Upload fails")
+	}
+
+	return nil
+}
+
 // UploadContentFile upload content file to the cloud storage
 func (svc *ProductService) UploadContentFile(productData Product) (Product, error) {
 	imageID := NSUtil.UniqueID()
@@ -240,8 +269,19 @@ func (svc *ProductService) UploadContentFile(productData Product) (Product, erro
 	return newContent, nil
 }
 
+// newImageId hash the decoded picture data, so the same picture in the different base64 encodings
+// or data url headers has the same id. The picture url of a resumable upload is hashed as it is.
 func (svc *ProductService) newImageId(imageData string) (string, error) {
-	newId := NSUtil.GetMd5String(imageData)
+	content := imageData
+	if pos := strings.Index(imageData, ","); strings.HasPrefix(imageData, "data:") && pos > 0 {
+		// the padding and the line breaks are ignored
+		realData := strings.NewReplacer("\r", "", "\n", "", "=", "").Replace(imageData[pos+1:])
+		if decoded, err := base64.RawStdEncoding.DecodeString(realData); err == nil {
+			content = string(decoded)
+		}
+	}
+
+	newId := NSUtil.GetMd5String(content)
 	product, _ := svc.GetProductsByID(newId)
 	if product.ID == newId {
 		return "", errors.New("The product has been uploaded. Please try others.")
@@ -250,15 +290,41 @@ func (svc *ProductService) newImageId(imageData string) (string, error) {
 	return newId, nil
 }
 
-// UploadStyleFile upload style file to the cloud storage
+// UploadStyleFile upload style file to the cloud storage, the picture is the picData or the picUrl
+// committed by a resumable upload
 func (svc *ProductService) UploadStyleFile(productData UploadProduct) (Product, error) {
-	imageID, err := svc.newImageId(productData.PicData)
+	picData := productData.PicData
+	if picData == "" {
+		picData = productData.PicURL
+	}
+
+	imageID, err := svc.newImageId(picData)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "UploadStyleFile", "info", err.Error(), "owner", productData.Owner)
+		return Product{}, err
+	}
+
+	picture, err := svc.decodePicture(productData)
+	if err != nil {
+		// the picture isn't checked and has no variants
+		level.Error(svc.Logger).Log("API", "UploadStyleFile", "info", "the picture isn't decoded",
+			"owner", productData.Owner, "err", err.Error())
+		picture = nil
+	}
+
+	phash, similarTo, err := svc.checkDuplicates(productData.Owner, picture)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "UploadStyleFile", "info", err.Error(), "owner", productData.Owner)
 		return Product{}, err
 	}
 
-	newImageURL, err := svc.uploadPicture(productData.Owner, productData.PicData, imageID, "styles")
+	var newImageURL string
+	if productData.PicData == "" {
+		newImageURL, err = svc.uploadedPicture(productData.Owner, productData.PicURL)
+	} else {
+		newImageURL, err = svc.uploadPicture(productData.Owner, productData.PicData, imageID, "styles")
+	}
+
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "UploadStyleFile", "info", err.Error(), "owner", productData.Owner)
 		return Product{}, err
@@ -274,6 +340,9 @@ func (svc *ProductService) UploadStyleFile(productData UploadProduct) (Product,
 	newProduct.StyleImgURL = productData.StyleImgURL
 	newProduct.Story.Description = productData.Story.Description
 	newProduct.Type = productData.Type
+	newProduct.PHash = phash
+	newProduct.SimilarTo = similarTo
+	newProduct.Variants = svc.createVariants(newImageURL, picture)
 
 	newProduct.Story.Pictures = productData.Story.Pictures
 	for index, pic := range productData.Story.Pictures {
@@ -327,14 +396,9 @@ func (svc *ProductService) UploadStyleFiles(products BatchProducts) (string, err
 }
 
 func (svc *ProductService) addProduct(product Product) error {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("products")
-
-	err := c.Insert(product)
+	err := svc.Products.Insert(product)
 	if err != nil {
-		if mgo.IsDup(err) {
+		if err == NSUtil.ErrDuplicated {
 			level.Error(svc.Logger).Log("API", "addProduct", "info", "Insert Product fails because of duplicated data", "error", err.Error())
 			return errors.New("Book with this ISBN already exists")
 		}
@@ -347,24 +411,81 @@ func (svc *ProductService) addProduct(product Product) error {
 
 // DeleteProduct delele the product by id from the database and cloud storage
 func (svc *ProductService) DeleteProduct(productID string) error {
-	session := svc.Session.Copy()
-	defer session.Close()
+	product, err := svc.Products.FindByID(productID)
+	if err == nil {
+		err = svc.Products.Remove(productID)
+	}
 
-	c := session.DB("store").C("products")
-	err := c.Remove(bson.M{"id": productID})
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "DeleteProduct", "err", err.Error())
 		// Todo: How to return useful information for the user
 		return errors.New("Failed to delete product")
 	}
 
-	// Todo: Delete the corresponding data from the cloud storage
-	// Need owner and picture id
+	// the product has been deleted, so the pictures which fail to be deleted are only logged
+	svc.deletePicture(product.URL)
+	for _, pic := range product.Story.Pictures {
+		svc.deletePicture(pic)
+	}
 
 	level.Debug(svc.Logger).Log("API", "DeleteProduct", "info", "Delete product successfully", "productID", productID)
 	return nil
 }
 
+// deletePicture remove the picture uploaded by uploadPicture from the cloud storage and the memcached
+func (svc *ProductService) deletePicture(picURL string) {
+	if svc.IsLocalDev {
+		prefix := "http://localhost:8000/"
+		if strings.HasPrefix(picURL, prefix) {
+			os.Remove(path.Join("./data", strings.TrimPrefix(picURL, prefix)))
+		}
+		return
+	}
+
+	// the url is {CacheGetURL}/{owner}/{imageID}
+	prefix := svc.CacheGetURL + "/"
+	if !strings.HasPrefix(picURL, prefix) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(picURL, prefix), "/")
+	if len(parts) != 2 {
+		return
+	}
+	owner, imageID := parts[0], parts[1]
+	svc.deleteStoredPicture(owner, imageID)
+	for size := range variantWidths {
+		svc.deleteStoredPicture(owner, variantImageID(imageID, size))
+	}
+}
+
+// deleteStoredPicture remove the picture from the cloud storage and the memcached
+func (svc *ProductService) deleteStoredPicture(owner, imageID string) {
+	storageReq, err := http.NewRequest("DELETE", svc.DeleteURL+"/"+owner+"/"+imageID, nil)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "deletePicture", "owner", owner, "image", imageID, "err", err.Error())
+		return
+	}
+
+	res, err := http.DefaultClient.Do(storageReq)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "deletePicture", "owner", owner, "image", imageID, "err", err.Error())
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		level.Error(svc.Logger).Log("API", "deletePicture", "owner", owner, "image", imageID, "status", res.Status)
+	}
+
+	if svc.CacheClient != nil {
+		err = svc.CacheClient.Delete(owner + imageID)
+		if err != nil && err != memcache.ErrCacheMiss {
+			level.Error(svc.Logger).Log("API", "deletePicture", "info", "delete cached image", "err", err.Error())
+		}
+	}
+}
+
 // UpdateProduct update the product information by id
 func (svc *ProductService) UpdateProduct(productID string, productData UploadProduct) error {
 	// Todo: Check the necessary update data
@@ -395,27 +516,9 @@ func (svc *ProductService) UpdateProduct(productID string, productData UploadPro
 		}
 	}
 
-	updateData, err := bson.Marshal(&updateProduct)
-	if err != nil {
-		// Todo: How to add the product information
-		level.Error(svc.Logger).Log("API", "UpdateProduct", "info", "bson Marshal Failes", "error", err.Error())
-		return errors.New("Failed to update product")
-	}
-	mData := bson.M{}
-	err = bson.Unmarshal(updateData, mData)
+	err := svc.Products.Update(updateProduct)
 	if err != nil {
-		// Todo: How to add the product information
-		level.Error(svc.Logger).Log("API", "UpdateProduct", "info", "bson UnMarshal fails", "error", err.Error())
-		return errors.New("Failed to update product")
-	}
-
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("products")
-	err = c.Update(bson.M{"id": productID}, bson.M{"$set": mData})
-	if err != nil {
-		level.Error(svc.Logger).Log("API", "UpdateProduct", "info", "MongoDB update fails", "error", err.Error())
+		level.Error(svc.Logger).Log("API", "UpdateProduct", "info", "Database update fails", "error", err.Error())
 		return errors.New("Failed to update product")
 	}
 
@@ -423,12 +526,7 @@ func (svc *ProductService) UpdateProduct(productID string, productData UploadPro
 }
 
 func (svc *ProductService) UpdateProductAfterTransaction(productId string, newOwner string, newPrice string) error {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	updateData := bson.M{"owner": newOwner, "price.value": newPrice}
-	c := session.DB("store").C("products")
-	err := c.Update(bson.M{"id": productId}, bson.M{"$set": updateData})
+	err := svc.Products.UpdateOwner(productId, newOwner, newPrice)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "Date.Update", "Error", err)
 		return errors.New("Failed to update product owner")
@@ -437,8 +535,8 @@ func (svc *ProductService) UpdateProductAfterTransaction(productId string, newOw
 	return nil
 }
 
-func (svc *ProductService) getQueryBSon(keyvals ...interface{}) (bson.M, error) {
-	query := bson.M{}
+func (svc *ProductService) getQueryBSon(keyvals ...interface{}) (map[string]interface{}, error) {
+	query := map[string]interface{}{}
 	querySize := len(keyvals)
 
 	for i := 0; i < querySize-1; i += 2 {
@@ -480,13 +578,7 @@ func (svc *ProductService) getQueryBSon(keyvals ...interface{}) (bson.M, error)
 
 // GetProducts find all the generated products(images)
 func (svc *ProductService) GetProducts() ([]Product, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("products")
-
-	var products []Product
-	err := c.Find(bson.M{}).All(&products)
+	products, err := svc.Products.Find(map[string]interface{}{})
 	if err != nil {
 		level.Debug(svc.Logger).Log("API", "GetProducts", "info", err.Error())
 		return products, errors.New("Database error")
@@ -497,19 +589,13 @@ func (svc *ProductService) GetProducts() ([]Product, error) {
 
 // GetProductsByUser get all the products owner by user
 func (svc *ProductService) GetProductsByUser(userID string) ([]Product, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("products")
-
 	queryParams, err := svc.getQueryBSon("owner", userID)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetProductsByUser", "UserID", userID, "error", err.Error())
 		return nil, err
 	}
 
-	var products []Product
-	err = c.Find(queryParams).All(&products)
+	products, err := svc.Products.Find(queryParams)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetProductsByUser", "UserID", userID, "error", err.Error())
 		return nil, errors.New("Database error")
@@ -520,19 +606,13 @@ func (svc *ProductService) GetProductsByUser(userID string) ([]Product, error) {
 
 // GetProductsByTags get all the products related to the tags
 func (svc *ProductService) GetProductsByTags(tags []string) ([]Product, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("products")
-
 	queryParams, err := svc.getQueryBSon("tags", tags)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetProductsByTags", "tags", tags, "error", err.Error())
 		return nil, errors.New("Bad query params")
 	}
 
-	var products []Product
-	err = c.Find(queryParams).All(&products)
+	products, err := svc.Products.Find(queryParams)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetProductsByTags", "error", err.Error())
 		return nil, errors.New("Database error")
@@ -542,12 +622,7 @@ func (svc *ProductService) GetProductsByTags(tags []string) ([]Product, error) {
 
 // GetProductsByID find the product by id
 func (svc *ProductService) GetProductsByID(id string) (Product, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("products")
-	var product Product
-	err := c.Find(bson.M{"id": id}).One(&product)
+	product, err := svc.Products.FindByID(id)
 	if err != nil {
 		level.Debug(svc.Logger).Log("API", "GetProductsByID", "info", err.Error(), "id", id)
 		return Product{}, errors.New("Database error")
@@ -562,13 +637,7 @@ func (svc *ProductService) GetProductsByID(id string) (Product, error) {
 
 // GetArtists return all the available artists
 func (svc *ProductService) GetArtists() ([]Artist, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("artists")
-
-	var artists []Artist
-	err := c.Find(bson.M{}).All(&artists)
+	artists, err := svc.Products.FindArtists()
 	if err != nil {
 		level.Debug(svc.Logger).Log("API", "GetArtists", "info", err.Error())
 		return artists, errors.New("Database error")
@@ -580,13 +649,7 @@ func (svc *ProductService) GetArtists() ([]Artist, error) {
 
 // GetHotestArtists return the active hotest artist
 func (svc *ProductService) GetHotestArtists() ([]Artist, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("artists")
-
-	var artists []Artist
-	err := c.Find(bson.M{}).All(&artists)
+	artists, err := svc.Products.FindArtists()
 	if err != nil {
 		level.Debug(svc.Logger).Log("API", "GetHotestArtists", "info", err.Error())
 		return artists, errors.New("Database error")
@@ -596,6 +659,41 @@ func (svc *ProductService) GetHotestArtists() ([]Artist, error) {
 	return artists, nil
 }
 
+// AddArtist register the artist and the transfer model of the artist style
+func (svc *ProductService) AddArtist(artist Artist) error {
+	if artist.Name == "" || artist.ModelName == "" {
+		return NSUtil.NewErrorWithStatus(http.StatusBadRequest, "The artist name and model name are required")
+	}
+
+	artist.Retired = false
+	err := svc.Products.InsertArtist(artist)
+	if err == NSUtil.ErrDuplicated {
+		return NSUtil.NewErrorWithStatus(http.StatusConflict, "The artist "+artist.Name+" exists")
+	}
+
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "AddArtist", "info", err.Error())
+		return errors.New("Database error")
+	}
+
+	return nil
+}
+
+// RetireArtist stop the artist style transfer, and hide the artist from the artist list
+func (svc *ProductService) RetireArtist(name string) error {
+	err := svc.Products.RetireArtist(name)
+	if err == NSUtil.ErrNotFound {
+		return NSUtil.NewErrorWithStatus(http.StatusNotFound, "The artist "+name+" doesn't exist")
+	}
+
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "RetireArtist", "info", err.Error())
+		return errors.New("Database error")
+	}
+
+	return nil
+}
+
 // AddImage add an image file to the memcached
 func (svc *ProductService) AddImage(key string, img []byte) error {
 	imgItem := memcache.Item{Key: key, Value: img}
@@ -608,9 +706,17 @@ func (svc *ProductService) AddImage(key string, img []byte) error {
 	return err
 }
 
-// GetImage get an image file from the memcached
-func (svc *ProductService) GetImage(userID, imageID string) ([]byte, string, error) {
-	key := userID + imageID
+// GetImage get an image file of the variant size from the memcached, the size is empty for the full picture.
+// The picture for a viewer other than the owner has the product and the viewer in its invisible watermark.
+func (svc *ProductService) GetImage(userID, imageID, size, viewer string) ([]byte, string, error) {
+	if _, ok := variantWidths[size]; !ok && size != "" && size != FullVariant {
+		return nil, "", NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Unknown picture size "+size)
+	}
+
+	key := userID + variantImageID(imageID, size)
+	if viewer != "" && viewer != userID {
+		key = viewerKey(key, viewer)
+	}
 	mimeType := mime.TypeByExtension("." + "jpg")
 
 	//get key's value
@@ -618,45 +724,28 @@ func (svc *ProductService) GetImage(userID, imageID string) ([]byte, string, err
 
 	// re add the image again
 	if err == memcache.ErrCacheMiss {
-		startTime := time.Now()
-		storageClient := &http.Client{}
-		storageURL := svc.FindURL + "?userid=" + userID + "&imageid=" + imageID
-
-		storageReq, err := http.NewRequest("GET", storageURL, nil)
-		if err != nil {
-			level.Error(svc.Logger).Log("API", "GetCloudImage", "error", err.Error(), "url", storageURL)
-			return nil, "", err
-		}
-		res, err := storageClient.Do(storageReq)
-		if err != nil {
-			return nil, "", err
-		}
-
-		var urlData map[string]string
-		err = json.NewDecoder(res.Body).Decode(&urlData)
-		if err != nil {
-			level.Error(svc.Logger).Log("API", "GetCloudImageURL", "error", err.Error())
-			return nil, "", err
+		var payload *WaterMark.Payload
+		if viewer != "" && viewer != userID {
+			productID, err := svc.productOfPicture(userID, imageID)
+			if err != nil {
+				return nil, "", err
+			}
+			payload = &WaterMark.Payload{ProductID: productID, Buyer: viewer}
 		}
 
-		// get the image data
-		imgResponse, err := http.Get(urlData["url"])
+		startTime := time.Now()
+		img, err := svc.fetchVariant(userID, imageID, size)
 		if err != nil {
-			level.Error(svc.Logger).Log("API", "GetCloudImageData", "error", err.Error())
 			return nil, "", err
 		}
 
-		// watermark and cached data
-		img, _, err := image.Decode(imgResponse.Body)
+		level.Debug(svc.Logger).Log("API", "GetCloudImage", "info", "Get Cloud Image", "timeDelay", time.Since(startTime))
+		startTime = time.Now()
+		imgData, err := svc.waterMarkAndCache(img, userID, key, payload)
 		if err != nil {
-			level.Error(svc.Logger).Log("API", "ParseImageData", "error", err.Error())
 			return nil, "", err
 		}
 
-		level.Debug(svc.Logger).Log("API", "GetCloudImage", "info", "Get Cloud Image", "timeDelay", time.Since(startTime))
-		startTime = time.Now()
-		imgData, err := svc.waterMarkAndCache(img, "jpeg", userID+imageID)
-
 		level.Debug(svc.Logger).Log("API", "WaterMarkAndCache", "info", "Get watermark image and cache", "timeDelay", time.Since(startTime))
 
 		return imgData, mimeType, nil
@@ -674,27 +763,98 @@ func (svc *ProductService) GetImage(userID, imageID string) ([]byte, string, err
 	return it.Value, mimeType, nil
 }
 
-func (svc *ProductService) waterMarkAndCache(img image.Image, format, key string) ([]byte, error) {
-	watermarkSVC := WaterMark.Service{
-		SourceImg: img,
-		Text:      "El-force",
-		TextColor: color.RGBA{255, 255, 255, 255},
-		Scale:     1.0,
-		Format:    format,
+// productOfPicture return the id of the product with the owner's picture. The picture which isn't a product yet,
+// e.g. a committed upload, has no product id.
+func (svc *ProductService) productOfPicture(owner, imageID string) (string, error) {
+	products, err := svc.Products.Find(map[string]interface{}{"url": svc.CacheGetURL + "/" + owner + "/" + imageID})
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "productOfPicture", "owner", owner, "image", imageID, "info", err.Error())
+		return "", errors.New("Database error")
+	}
+
+	if len(products) == 0 {
+		return "", nil
+	}
+
+	return products[0].ID, nil
+}
+
+// fetchPicture download the picture without the watermark from the cloud storage
+func (svc *ProductService) fetchPicture(userID, imageID string) (image.Image, error) {
+	storageClient := &http.Client{}
+	storageURL := svc.FindURL + "?userid=" + userID + "&imageid=" + imageID
+
+	storageReq, err := http.NewRequest("GET", storageURL, nil)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "GetCloudImage", "error", err.Error(), "url", storageURL)
+		return nil, err
 	}
+	res, err := storageClient.Do(storageReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var urlData map[string]string
+	err = json.NewDecoder(res.Body).Decode(&urlData)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "GetCloudImageURL", "error", err.Error())
+		return nil, err
+	}
+
+	// get the image data
+	imgResponse, err := http.Get(urlData["url"])
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "GetCloudImageData", "error", err.Error())
+		return nil, err
+	}
+	defer imgResponse.Body.Close()
+
+	img, _, err := image.Decode(imgResponse.Body)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "ParseImageData", "error", err.Error())
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// waterMarkAndCache watermark the picture by the profile of the owner, and cache it as jpeg. The payload is
+// hidden in the picture if it isn't nil, and the picture is cached for viewerCacheSeconds.
+func (svc *ProductService) waterMarkAndCache(img image.Image, owner, key string, payload *WaterMark.Payload) ([]byte, error) {
+	watermarkSVC, err := svc.watermarkFor(owner, img)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "waterMarkAndCache", "owner", owner, "info", err.Error())
+		return nil, err
+	}
+	watermarkSVC.Invisible = payload
 
 	markedBytes := make([]byte, 0)
 	outputBuffers := bytes.NewBuffer(markedBytes)
 
-	_, err := watermarkSVC.CreateWaterMark(outputBuffers)
+	_, encoding, err := watermarkSVC.CreateWaterMark(outputBuffers)
+	if payload != nil && (err == WaterMark.ErrImageTooSmall || err == WaterMark.ErrPayloadTooLong) {
+		// e.g. the thumbnails, they are served without the payload
+		level.Error(svc.Logger).Log("API", "CreateWaterMark", "key", key, "info", err.Error())
+		watermarkSVC.Invisible = nil
+		outputBuffers.Reset()
+		_, encoding, err = watermarkSVC.CreateWaterMark(outputBuffers)
+	}
 	if err != nil {
 		level.Debug(svc.Logger).Log("API", "CreateWaterMark", "info", err.Error())
 		return nil, err
 	}
+	level.Debug(svc.Logger).Log("API", "CreateWaterMark", "key", key, "quality", encoding.Quality, "size",
+		encoding.Size, "width", encoding.Width)
 
 	// add the memecached item
-	err = svc.AddImage(key, outputBuffers.Bytes())
-	if err != nil {
+	if payload != nil {
+		err = svc.addExpiringImage(key, outputBuffers.Bytes(), viewerCacheSeconds)
+	} else {
+		err = svc.AddImage(key, outputBuffers.Bytes())
+	}
+	if err != nil && err != memcache.ErrNotStored {
+		// ErrNotStored means a concurrent request has cached the same picture
 		level.Error(svc.Logger).Log("API", "CacheImage", "info", err.Error())
 		return nil, err
 	}
@@ -704,15 +864,11 @@ func (svc *ProductService) waterMarkAndCache(img image.Image, format, key string
 
 // Search find all the available products by following the key and values
 func (svc *ProductService) Search(keyvals map[string]interface{}) ([]Product, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
 	var queryInfo []interface{}
 	for key, val := range keyvals {
 		queryInfo = append(queryInfo, key)
 		queryInfo = append(queryInfo, val)
 	}
-	c := session.DB("store").C("products")
 
 	queryParams, err := svc.getQueryBSon(queryInfo...)
 	if err != nil {
@@ -720,8 +876,7 @@ func (svc *ProductService) Search(keyvals map[string]interface{}) ([]Product, er
 		return nil, err
 	}
 
-	var prods []Product
-	err = c.Find(queryParams).All(&prods)
+	prods, err := svc.Products.Find(queryParams)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "Search", "info", "Find DB fails", "error", err.Error())
 	}
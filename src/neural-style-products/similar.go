@@ -0,0 +1,128 @@
+package ProductService
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"net/http"
+	"sort"
+	"strings"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// checkDuplicates hash the style picture, and find the products with the similar pictures by the duplicate
+// policy. It returns the hash and the flagged products. The picture which isn't decoded isn't checked.
+func (svc *ProductService) checkDuplicates(owner string, picture image.Image) (string, []string, error) {
+	if picture == nil {
+		return "", nil, nil
+	}
+
+	hash := dHash(picture)
+	if svc.Duplicates.Action == IgnoreDuplicates {
+		return formatHash(hash), nil, nil
+	}
+
+	similar, err := svc.similarProducts(hash, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(similar) == 0 {
+		return formatHash(hash), nil, nil
+	}
+
+	if svc.Duplicates.Action == RejectDuplicates {
+		return "", nil, NSUtil.NewErrorWithStatus(http.StatusConflict,
+			"The picture is similar to the product "+similar[0].ID+". Please try others.")
+	}
+
+	var similarTo []string
+	for _, product := range similar {
+		similarTo = append(similarTo, product.ID)
+	}
+
+	level.Info(svc.Logger).Log("API", "checkDuplicates", "info", "similar picture is flagged",
+		"owner", owner, "similarTo", strings.Join(similarTo, ","))
+	return formatHash(hash), similarTo, nil
+}
+
+// decodePicture decode the data url of the picture, or download the picture of a resumable upload
+func (svc *ProductService) decodePicture(productData UploadProduct) (image.Image, error) {
+	if productData.PicData == "" {
+		picURL, err := svc.uploadedPicture(productData.Owner, productData.PicURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return svc.fetchPicture(productData.Owner, picURL[strings.LastIndex(picURL, "/")+1:])
+	}
+
+	pos := strings.Index(productData.PicData, ",")
+	if pos < 0 {
+		return nil, errors.New("Bad picture data")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(productData.PicData[pos+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	picture, _, err := image.Decode(bytes.NewReader(data))
+	return picture, err
+}
+
+// similarProducts return the products whose picture is within the threshold distance of the hash, the closest
+// product is the first one
+func (svc *ProductService) similarProducts(hash uint64, excludeID string) ([]Product, error) {
+	products, err := svc.Products.Find(map[string]interface{}{})
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "similarProducts", "info", err.Error())
+		return nil, errors.New("Database error")
+	}
+
+	distances := make(map[string]int)
+	similar := []Product{}
+	for _, product := range products {
+		productHash, ok := parseHash(product.PHash)
+		if !ok || product.ID == excludeID {
+			continue
+		}
+
+		distance := hammingDistance(hash, productHash)
+		if distance <= svc.Duplicates.Threshold {
+			distances[product.ID] = distance
+			similar = append(similar, product)
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return distances[similar[i].ID] < distances[similar[j].ID]
+	})
+
+	return similar, nil
+}
+
+// GetSimilarProducts return the products whose pictures look like the picture of the product
+func (svc *ProductService) GetSimilarProducts(id string) ([]Product, error) {
+	product, err := svc.Products.FindByID(id)
+	if err == NSUtil.ErrNotFound {
+		return nil, NSUtil.NewErrorWithStatus(http.StatusNotFound, "Failed to find product for the id: "+id)
+	}
+
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "GetSimilarProducts", "info", err.Error(), "id", id)
+		return nil, errors.New("Database error")
+	}
+
+	hash, ok := parseHash(product.PHash)
+	if !ok {
+		// the product is uploaded before the pictures are hashed
+		return []Product{}, nil
+	}
+
+	return svc.similarProducts(hash, id)
+}
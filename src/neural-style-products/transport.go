@@ -91,6 +91,63 @@ func encodeNSGetArtistsResponse(ctx context.Context, w http.ResponseWriter, res
 	return json.NewEncoder(w).Encode(artistsRes.Artists)
 }
 
+func decodeNSAddArtistRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var artist Artist
+	err := json.NewDecoder(r.Body).Decode(&artist)
+	if err != nil {
+		return nil, err
+	}
+
+	return NSAddArtistRequest{Artist: artist}, nil
+}
+
+func decodeNSGetWatermarkRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	return NSWatermarkRequest{Owner: vars["owner"]}, nil
+}
+
+func decodeNSSetWatermarkRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var profile WatermarkProfile
+	err := json.NewDecoder(r.Body).Decode(&profile)
+	if err != nil {
+		return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid watermark profile")
+	}
+
+	// the owner in the path is the owner of the profile
+	vars := mux.Vars(r)
+	profile.Owner = vars["owner"]
+	return NSWatermarkRequest{Owner: profile.Owner, Profile: profile}, nil
+}
+
+func encodeNSWatermarkResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	watermarkRes := response.(NSWatermarkResponse)
+	if watermarkRes.Err != nil {
+		return watermarkRes.Err
+	}
+
+	w.Header().Set("context-type", "application/json, charset=utf8")
+	return json.NewEncoder(w).Encode(watermarkRes.Profile)
+}
+
+func decodeNSRetireArtistRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		return nil, errors.New("missing artist name")
+	}
+
+	return NSRetireArtistRequest{Name: name}, nil
+}
+
+func encodeNSArtistResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	artistRes := response.(NSArtistResponse)
+	if artistRes.Err != nil {
+		return artistRes.Err
+	}
+
+	return nil
+}
+
 func decodeNSGetProductByIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -112,8 +169,9 @@ func decodeNSCacheGetRequest(_ context.Context, r *http.Request) (interface{}, e
 	vars := mux.Vars(r)
 	userID := vars["usrid"]
 	imageID := vars["imgid"]
+	size := r.URL.Query().Get("size")
 
-	return NSCacheGetRequest{UserID: userID, ImageID: imageID}, nil
+	return NSCacheGetRequest{UserID: userID, ImageID: imageID, Size: size}, nil
 }
 
 func encodeNSCachedGetResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
@@ -246,8 +304,56 @@ func encodeNSSearchRespones(ctx context.Context, w http.ResponseWriter, response
 	return json.NewEncoder(w).Encode(productsRes.Prods)
 }
 
+func decodeNSCreateUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req NSCreateUploadRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid upload data")
+	}
+
+	return req, nil
+}
+
+func decodeNSGetUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	return NSGetUploadRequest{ID: vars["id"]}, nil
+}
+
+func decodeNSUploadChunkRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	index, err := strconv.Atoi(vars["index"])
+	if err != nil {
+		return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid chunk number")
+	}
+
+	// the body is streamed to the storage service by the endpoint
+	return NSUploadChunkRequest{ID: vars["id"], Index: index, Checksum: r.Header.Get(chunkChecksumHeader),
+		Data: r.Body}, nil
+}
+
+func decodeNSCommitUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req NSCommitUploadRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Invalid commit data")
+	}
+
+	req.ID = mux.Vars(r)["id"]
+	return req, nil
+}
+
+func encodeNSUploadResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	uploadRes := response.(NSUploadResponse)
+	if uploadRes.Err != nil {
+		return uploadRes.Err
+	}
+
+	w.Header().Set("content-type", "application/json, charset=utf8")
+	return json.NewEncoder(w).Encode(uploadRes.Session)
+}
+
 // MakeHTTPHandler generate the http handler for the style service handler
-func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middleware, svc Service, options ...httptransport.ServerOption) *mux.Router {
+func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth, admin, viewer endpoint.Middleware, svc Service, options ...httptransport.ServerOption) *mux.Router {
 	// POST /api/upload/content
 	contentUploadHandler := httptransport.NewServer(
 		auth(MakeNSContentUploadEndpoint(svc)),
@@ -275,6 +381,38 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 	)
 	r.Methods("POST").Path("/api/upload/styles").Handler(NSUtil.AccessControl(stylesUploadHandler))
 
+	// POST /api/v1/uploads
+	r.Methods("POST").Path("/api/v1/uploads").Handler(NSUtil.AccessControl(httptransport.NewServer(
+		auth(MakeNSCreateUploadEndpoint(svc)),
+		decodeNSCreateUploadRequest,
+		encodeNSUploadResponse,
+		options...,
+	)))
+
+	// GET /api/v1/uploads/{id}
+	r.Methods("GET").Path("/api/v1/uploads/{id}").Handler(NSUtil.AccessControl(httptransport.NewServer(
+		auth(MakeNSGetUploadEndpoint(svc)),
+		decodeNSGetUploadRequest,
+		encodeNSUploadResponse,
+		options...,
+	)))
+
+	// PUT /api/v1/uploads/{id}/chunks/{index}
+	r.Methods("PUT").Path("/api/v1/uploads/{id}/chunks/{index}").Handler(NSUtil.AccessControl(httptransport.NewServer(
+		auth(MakeNSUploadChunkEndpoint(svc)),
+		decodeNSUploadChunkRequest,
+		encodeNSUploadResponse,
+		options...,
+	)))
+
+	// POST /api/v1/uploads/{id}/commit
+	r.Methods("POST").Path("/api/v1/uploads/{id}/commit").Handler(NSUtil.AccessControl(httptransport.NewServer(
+		auth(MakeNSCommitUploadEndpoint(svc)),
+		decodeNSCommitUploadRequest,
+		encodeNSUploadResponse,
+		options...,
+	)))
+
 	// GET /api/artists
 	r.Methods("GET").Path("/api/artists").Handler(NSUtil.AccessControl(httptransport.NewServer(
 		auth(MakeNSGetArtists(svc)),
@@ -283,6 +421,22 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 		options...,
 	)))
 
+	// GET /api/v1/watermarks/{owner}
+	r.Methods("GET").Path("/api/v1/watermarks/{owner}").Handler(NSUtil.AccessControl(httptransport.NewServer(
+		auth(MakeNSGetWatermarkEndpoint(svc)),
+		decodeNSGetWatermarkRequest,
+		encodeNSWatermarkResponse,
+		options...,
+	)))
+
+	// PUT /api/v1/watermarks/{owner}
+	r.Methods("PUT").Path("/api/v1/watermarks/{owner}").Handler(NSUtil.AccessControl(httptransport.NewServer(
+		auth(MakeNSSetWatermarkEndpoint(svc)),
+		decodeNSSetWatermarkRequest,
+		encodeNSWatermarkResponse,
+		options...,
+	)))
+
 	// GET /api/artists/hotest
 	r.Methods("GET").Path("/api/artists/hotest").Handler(NSUtil.AccessControl(httptransport.NewServer(
 		auth(MakeNSGetHotestArtists(svc)),
@@ -291,9 +445,25 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 		options...,
 	)))
 
+	// POST /api/v1/artists
+	r.Methods("POST").Path("/api/v1/artists").Handler(httptransport.NewServer(
+		admin(MakeNSAddArtistEndpoint(svc)),
+		decodeNSAddArtistRequest,
+		encodeNSArtistResponse,
+		options...,
+	))
+
+	// POST /api/v1/artists/{name}/retire
+	r.Methods("POST").Path("/api/v1/artists/{name}/retire").Handler(httptransport.NewServer(
+		admin(MakeNSRetireArtistEndpoint(svc)),
+		decodeNSRetireArtistRequest,
+		encodeNSArtistResponse,
+		options...,
+	))
+
 	// GET api/products
 	r.Methods("GET").Path("/api/products").Handler(httptransport.NewServer(
-		MakeNSGetProductsEndpoint(svc),
+		viewer(MakeNSGetProductsEndpoint(svc)),
 		decodeNSGetProductsRequest,
 		encodeNSGetProductsResponse,
 		options...,
@@ -309,7 +479,7 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 
 	// GET api/products/{tags}
 	r.Methods("GET").Path("/api/products/tags/{tags}").Handler(httptransport.NewServer(
-		MakeNSGetProductsByTags(svc),
+		viewer(MakeNSGetProductsByTags(svc)),
 		decodeNSGetProductsByTagsRequest,
 		encodeNSGetProductsByTargsResponse,
 		options...,
@@ -317,23 +487,32 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 
 	// GET api/products/{id}
 	r.Methods("GET").Path("/api/products/{id}").Handler(httptransport.NewServer(
-		MakeNSGetProductByIDEndpoint(svc),
+		viewer(MakeNSGetProductByIDEndpoint(svc)),
 		decodeNSGetProductByIDRequest,
 		encodeNSGetProductByIDResponse,
 		options...,
 	))
 
+	// GET api/products/{id}/similar
+	r.Methods("GET").Path("/api/products/{id}/similar").Handler(httptransport.NewServer(
+		viewer(MakeNSGetSimilarProductsEndpoint(svc)),
+		decodeNSGetProductByIDRequest,
+		encodeNSGetProductsResponse,
+		options...,
+	))
+
 	// GET api/search
 	r.Methods("GET").Path("/api/search").Handler(httptransport.NewServer(
-		MakeNSSearch(svc),
+		viewer(MakeNSSearch(svc)),
 		decodeNSSearchRequest,
 		encodeNSSearchRespones,
 		options...,
 	))
 
+	// the pictures for the signed-in viewers carry the viewer in the invisible watermark
 	r.Methods("GET").Path("/api/v1/cache/get/{usrid}/{imgid}").Handler(
 		httptransport.NewServer(
-			MakeNSImageCacheGetEndpoint(svc),
+			viewer(MakeNSImageCacheGetEndpoint(svc)),
 			decodeNSCacheGetRequest,
 			encodeNSCachedGetResponse,
 			options...,
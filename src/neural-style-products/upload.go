@@ -0,0 +1,150 @@
+package ProductService
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// chunkChecksumHeader is the hex sha256 of an uploaded chunk, the storage service checks it
+const chunkChecksumHeader = "X-Chunk-SHA256"
+
+var errUploadNotFound = NSUtil.NewErrorWithStatus(http.StatusNotFound, "The upload isn't found")
+
+var errBadPictureName = NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Only jpg, png and gif pictures are supported")
+
+// UploadSession define a resumable upload of a large picture. Chunks are the numbers of the chunks
+// received by the storage service, so the client only sends the missing chunks after the connection is dropped.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	ImageName string    `json:"imageName"`
+	Created   time.Time `json:"created"`
+	Chunks    []int     `json:"chunks"`
+	URL       string    `json:"url,omitempty"`
+}
+
+// CreateUpload start a resumable upload of the owner's picture, the name is only used for its extension
+func (svc *ProductService) CreateUpload(owner, name string) (UploadSession, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
+		return UploadSession{}, errBadPictureName
+	}
+
+	storageURL := svc.UploadURL + "?userid=" + url.QueryEscape(owner) + "&imageid=" + NSUtil.UniqueID() + ext
+	return svc.uploadRequest("POST", storageURL, nil, nil)
+}
+
+// GetUpload return the owner's upload with its received chunks
+func (svc *ProductService) GetUpload(owner, uploadID string) (UploadSession, error) {
+	return svc.ownUpload(owner, uploadID)
+}
+
+// UploadChunk stream the chunk of the owner's upload to the storage service, the checksum is the hex sha256
+// of the chunk
+func (svc *ProductService) UploadChunk(owner, uploadID string, index int, checksum string, data io.Reader) error {
+	if _, err := svc.ownUpload(owner, uploadID); err != nil {
+		return err
+	}
+
+	storageURL := svc.UploadURL + "/" + uploadID + "/chunks/" + strconv.Itoa(index)
+	_, err := svc.uploadRequest("PUT", storageURL, data, map[string]string{chunkChecksumHeader: checksum})
+	return err
+}
+
+// CommitUpload save the owner's uploaded picture in the cloud storage, and return the upload with the picture
+// url which can be used as the picUrl of a new product. The checksum of the whole picture is optional.
+func (svc *ProductService) CommitUpload(owner, uploadID string, chunks int, checksum string) (UploadSession, error) {
+	if _, err := svc.ownUpload(owner, uploadID); err != nil {
+		return UploadSession{}, err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"chunks": chunks, "sha256": checksum})
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	session, err := svc.uploadRequest("POST", svc.UploadURL+"/"+uploadID+"/commit", bytes.NewReader(body), nil)
+	if err != nil {
+		return session, err
+	}
+
+	// the same url as uploadPicture, the watermarked picture is cached when it's read at the first time
+	session.URL = svc.CacheGetURL + "/" + session.UserID + "/" + session.ImageName
+	level.Debug(svc.Logger).Log("API", "CommitUpload", "info", "upload picture successfully", "url", session.URL)
+	return session, nil
+}
+
+// ownUpload return the upload if it's started by the owner, the uploads of the other users aren't found
+func (svc *ProductService) ownUpload(owner, uploadID string) (UploadSession, error) {
+	session, err := svc.uploadRequest("GET", svc.UploadURL+"/"+uploadID, nil, nil)
+	if err != nil {
+		return session, err
+	}
+
+	if owner == "" || session.UserID != owner {
+		return UploadSession{}, errUploadNotFound
+	}
+
+	return session, nil
+}
+
+// uploadedPicture check the picture url is committed by a resumable upload of the owner
+func (svc *ProductService) uploadedPicture(owner, picURL string) (string, error) {
+	prefix := svc.CacheGetURL + "/" + owner + "/"
+	name := strings.TrimPrefix(picURL, prefix)
+	if owner == "" || !strings.HasPrefix(picURL, prefix) || name == "" || strings.Contains(name, "/") {
+		return "", NSUtil.NewErrorWithStatus(http.StatusBadRequest, "The picture isn't uploaded by the owner")
+	}
+
+	return picURL, nil
+}
+
+// uploadRequest call the upload API of the storage service, and keep the status of its client errors
+func (svc *ProductService) uploadRequest(method, storageURL string, body io.Reader,
+	headers map[string]string) (UploadSession, error) {
+	var session UploadSession
+	storageReq, err := http.NewRequest(method, storageURL, body)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "uploadRequest", "url", storageURL, "err", err.Error())
+		return session, err
+	}
+
+	for key, value := range headers {
+		storageReq.Header.Set(key, value)
+	}
+
+	res, err := http.DefaultClient.Do(storageReq)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "uploadRequest", "url", storageURL, "err", err.Error())
+		return session, errors.New("Upload fails")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var errInfo map[string]string
+		json.NewDecoder(res.Body).Decode(&errInfo)
+		level.Error(svc.Logger).Log("API", "uploadRequest", "url", storageURL, "status", res.Status,
+			"err", errInfo["error"])
+
+		if res.StatusCode < http.StatusInternalServerError && errInfo["error"] != "" {
+			return session, NSUtil.NewErrorWithStatus(res.StatusCode, errInfo["error"])
+		}
+
+		return session, errors.New("Upload fails")
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&session)
+	return session, err
+}
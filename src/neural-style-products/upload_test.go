@@ -0,0 +1,42 @@
+package ProductService
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestUploadsOfOtherUsersAreNotFound(t *testing.T) {
+	var requests []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		json.NewEncoder(w).Encode(UploadSession{ID: "u1", UserID: "alice", ImageName: "u1.png"})
+	}))
+	defer storage.Close()
+
+	svc := &ProductService{UploadURL: storage.URL + "/uploads", CacheGetURL: "http://cache", Logger: log.NewNopLogger()}
+
+	if _, err := svc.GetUpload("bob", "u1"); err != errUploadNotFound {
+		t.Errorf("bob got the upload of alice: %v", err)
+	}
+	if err := svc.UploadChunk("bob", "u1", 0, "", strings.NewReader("1234")); err != errUploadNotFound {
+		t.Errorf("bob sent a chunk to the upload of alice: %v", err)
+	}
+	if _, err := svc.CommitUpload("bob", "u1", 1, ""); err != errUploadNotFound {
+		t.Errorf("bob committed the upload of alice: %v", err)
+	}
+	for _, request := range requests {
+		if request != "GET /uploads/u1" {
+			t.Errorf("unexpected storage request %q", request)
+		}
+	}
+
+	session, err := svc.CommitUpload("alice", "u1", 1, "")
+	if err != nil || session.URL != "http://cache/alice/u1.png" {
+		t.Errorf("unexpected commit %+v, %v", session, err)
+	}
+}
@@ -0,0 +1,109 @@
+package ProductService
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"path"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/image/draw"
+)
+
+// variant sizes of the style pictures, the full variant is the uploaded picture
+const (
+	ThumbnailVariant = "thumbnail"
+	MediumVariant    = "medium"
+	FullVariant      = "full"
+)
+
+// variantWidths is the max width of the variants, the smaller pictures aren't enlarged
+var variantWidths = map[string]int{
+	ThumbnailVariant: 240,
+	MediumVariant:    800,
+}
+
+// watermarkWidth is the picture width where the watermark has its original size, so the watermark covers
+// the same part of each variant
+const watermarkWidth = 1000
+
+// variantImageID return the storage name of the variant, the variants are saved as jpeg
+func variantImageID(imageID, size string) string {
+	if _, ok := variantWidths[size]; !ok {
+		return imageID
+	}
+
+	return strings.TrimSuffix(imageID, path.Ext(imageID)) + "_" + size + ".jpg"
+}
+
+// resizeVariant shrink the picture to the width and keep its aspect ratio
+func resizeVariant(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+	return resized
+}
+
+func watermarkScale(img image.Image) float64 {
+	return float64(img.Bounds().Dx()) / watermarkWidth
+}
+
+// createVariants save the resized variants of the picture whose url is {CacheGetURL}/{owner}/{imageID}, and
+// return the urls of the variants by size. A variant which fails to be saved is resized when it's read.
+func (svc *ProductService) createVariants(picURL string, picture image.Image) map[string]string {
+	prefix := svc.CacheGetURL + "/"
+	parts := strings.Split(strings.TrimPrefix(picURL, prefix), "/")
+	if picture == nil || svc.IsLocalDev || !strings.HasPrefix(picURL, prefix) || len(parts) != 2 {
+		return nil
+	}
+	owner, imageID := parts[0], parts[1]
+
+	variants := map[string]string{FullVariant: picURL}
+	for size, width := range variantWidths {
+		variants[size] = picURL + "?size=" + size
+
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, resizeVariant(picture, width), &jpeg.Options{Quality: 85})
+		if err == nil {
+			err = svc.savePicture(owner, variantImageID(imageID, size), buf.Bytes())
+		}
+
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "createVariants", "owner", owner, "image", imageID, "size", size,
+				"err", err.Error())
+		}
+	}
+
+	return variants
+}
+
+// fetchVariant download the variant from the cloud storage, the pictures uploaded before the variants are
+// resized from the full picture
+func (svc *ProductService) fetchVariant(userID, imageID, size string) (image.Image, error) {
+	width, ok := variantWidths[size]
+	if !ok {
+		return svc.fetchPicture(userID, imageID)
+	}
+
+	img, err := svc.fetchPicture(userID, variantImageID(imageID, size))
+	if err == nil {
+		return img, nil
+	}
+
+	img, err = svc.fetchPicture(userID, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return resizeVariant(img, width), nil
+}
@@ -0,0 +1,39 @@
+package ProductService
+
+import (
+	"image"
+	"testing"
+)
+
+func TestResizeVariantKeepsAspectRatio(t *testing.T) {
+	picture := image.NewRGBA(image.Rect(0, 0, 1600, 1200))
+
+	thumbnail := resizeVariant(picture, variantWidths[ThumbnailVariant])
+	if bounds := thumbnail.Bounds(); bounds.Dx() != 240 || bounds.Dy() != 180 {
+		t.Errorf("expected a 240x180 thumbnail, got %v", bounds)
+	}
+
+	small := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	if resizeVariant(small, variantWidths[MediumVariant]) != image.Image(small) {
+		t.Errorf("expected the small picture not to be enlarged")
+	}
+
+	if watermarkScale(thumbnail)*watermarkWidth != 240 {
+		t.Errorf("expected the watermark to be scaled with the width")
+	}
+}
+
+func TestVariantImageID(t *testing.T) {
+	cases := map[string]string{
+		ThumbnailVariant: "abc_thumbnail.jpg",
+		MediumVariant:    "abc_medium.jpg",
+		FullVariant:      "abc.png",
+		"":               "abc.png",
+	}
+
+	for size, expected := range cases {
+		if id := variantImageID("abc.png", size); id != expected {
+			t.Errorf("expected %s for the %q variant, got %s", expected, size, id)
+		}
+	}
+}
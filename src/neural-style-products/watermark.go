@@ -0,0 +1,239 @@
+package ProductService
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"neural-style-image-watermark"
+	"neural-style-util"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-kit/kit/log/level"
+)
+
+// maxLogoSize is the max bytes of a logo or a font of a watermark profile
+const maxLogoSize = 256 << 10
+
+// maxLogoSide is the max width and height of a logo, the png of a small file can still be a huge picture
+const maxLogoSide = 2048
+
+// viewerCacheSeconds is how long the pictures marked for a viewer are cached. They can't be found by the owner,
+// so they aren't evicted when the profile changes, and expire instead.
+const viewerCacheSeconds = 3600
+
+// WatermarkProfile define how the pictures of an owner are watermarked. The mark is the png Logo if it
+// isn't empty, otherwise the Text in the Color "#rrggbb" or "#rrggbbaa". The text is drawn in the FontData
+// if it isn't empty, otherwise in the builtin Font: goregular, gobold, gomono or the bitmap inconsolata if
+// it's empty. Margin is the part of the shorter side of the picture, and Rotation is in degrees.
+type WatermarkProfile struct {
+	Owner    string  `json:"owner"`
+	Text     string  `json:"text"`
+	Color    string  `json:"color"`
+	Logo     []byte  `json:"logo,omitempty"`
+	Font     string  `json:"font"`
+	FontData []byte  `json:"fontData,omitempty"`
+	Position string  `json:"position"`
+	Margin   float64 `json:"margin"`
+	Opacity  float64 `json:"opacity"`
+	Rotation float64 `json:"rotation"`
+	Tile     bool    `json:"tile"`
+}
+
+// defaultWatermark is used for the owners without a profile
+var defaultWatermark = WatermarkProfile{
+	Text:     "El-force",
+	Color:    "#ffffff",
+	Position: WaterMark.BottomRight,
+	Margin:   0.01,
+	Opacity:  1,
+}
+
+// GetWatermark return the watermark profile of the owner, or the default profile
+func (svc *ProductService) GetWatermark(owner string) (WatermarkProfile, error) {
+	profile, err := svc.Products.FindWatermark(owner)
+	if err == NSUtil.ErrNotFound {
+		profile = defaultWatermark
+		profile.Owner = owner
+		return profile, nil
+	}
+
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "GetWatermark", "owner", owner, "info", err.Error())
+		return profile, errors.New("Database error")
+	}
+
+	return profile, nil
+}
+
+// SetWatermark save the watermark profile of the owner, the cached pictures of the owner are removed so
+// they are watermarked again by the profile
+func (svc *ProductService) SetWatermark(profile WatermarkProfile) error {
+	_, err := profile.service(nil)
+	if err != nil {
+		return NSUtil.NewErrorWithStatus(http.StatusBadRequest, err.Error())
+	}
+
+	err = svc.Products.SaveWatermark(profile)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "SetWatermark", "owner", profile.Owner, "info", err.Error())
+		return errors.New("Database error")
+	}
+
+	svc.evictPictures(profile.Owner)
+	return nil
+}
+
+// watermarkFor return the watermark of the picture by the profile of the owner
+func (svc *ProductService) watermarkFor(owner string, img image.Image) (WaterMark.Service, error) {
+	profile, err := svc.GetWatermark(owner)
+	if err != nil {
+		return WaterMark.Service{}, err
+	}
+
+	return profile.service(img)
+}
+
+// viewerKey return the cache key of the picture marked for the viewer, the viewer is hashed since the memcached
+// keys can't have spaces
+func viewerKey(key, viewer string) string {
+	sum := sha256.Sum256([]byte(viewer))
+	return key + "_" + hex.EncodeToString(sum[:8])
+}
+
+// addExpiringImage add an image file to the memcached, which expires after the seconds
+func (svc *ProductService) addExpiringImage(key string, img []byte, seconds int32) error {
+	err := svc.CacheClient.Add(&memcache.Item{Key: key, Value: img, Expiration: seconds})
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "addExpiringImage", "key", key, "error", err.Error())
+	}
+
+	return err
+}
+
+// evictPictures remove the cached pictures and variants of the owner's products
+func (svc *ProductService) evictPictures(owner string) {
+	if svc.CacheClient == nil {
+		return
+	}
+
+	products, err := svc.Products.Find(map[string]interface{}{"owner": owner})
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "evictPictures", "owner", owner, "info", err.Error())
+		return
+	}
+
+	prefix := svc.CacheGetURL + "/" + owner + "/"
+	for _, product := range products {
+		imageID := strings.TrimPrefix(product.URL, prefix)
+		if imageID == product.URL || strings.Contains(imageID, "/") {
+			continue
+		}
+
+		keys := []string{owner + imageID}
+		for size := range variantWidths {
+			keys = append(keys, owner+variantImageID(imageID, size))
+		}
+
+		for _, key := range keys {
+			err := svc.CacheClient.Delete(key)
+			if err != nil && err != memcache.ErrCacheMiss {
+				level.Error(svc.Logger).Log("API", "evictPictures", "key", key, "info", err.Error())
+			}
+		}
+	}
+}
+
+// service check the profile and create the watermark of the picture, the watermark is scaled with the picture
+func (profile WatermarkProfile) service(img image.Image) (WaterMark.Service, error) {
+	wm := WaterMark.Service{
+		SourceImg: img,
+		Text:      profile.Text,
+		Format:    "jpeg",
+		Position:  profile.Position,
+		Margin:    profile.Margin,
+		Opacity:   profile.Opacity,
+		Rotation:  profile.Rotation,
+		Tile:      profile.Tile,
+		Scale:     1,
+	}
+
+	if img != nil {
+		wm.Scale = watermarkScale(img)
+	}
+
+	if err := WaterMark.ValidPosition(profile.Position); err != nil {
+		return wm, err
+	}
+
+	if profile.Margin < 0 || profile.Margin > 0.5 || profile.Opacity < 0 || profile.Opacity > 1 {
+		return wm, errors.New("The margin must be in [0, 0.5] and the opacity in [0, 1]")
+	}
+
+	if len(profile.Logo) > maxLogoSize || len(profile.FontData) > maxLogoSize {
+		return wm, errors.New("The logo and the font must be smaller than " + strconv.Itoa(maxLogoSize>>10) + "KB")
+	}
+
+	textColor, err := parseColor(profile.Color)
+	if err != nil {
+		return wm, err
+	}
+	wm.TextColor = textColor
+
+	if len(profile.Logo) != 0 {
+		config, err := png.DecodeConfig(bytes.NewReader(profile.Logo))
+		if err != nil {
+			return wm, errors.New("The logo must be a png picture")
+		}
+
+		if config.Width > maxLogoSide || config.Height > maxLogoSide {
+			return wm, errors.New("The logo must be at most " + strconv.Itoa(maxLogoSide) + "x" +
+				strconv.Itoa(maxLogoSide))
+		}
+
+		wm.Logo, err = png.Decode(bytes.NewReader(profile.Logo))
+		if err != nil {
+			return wm, errors.New("The logo must be a png picture")
+		}
+
+		return wm, nil
+	}
+
+	if profile.Text == "" {
+		return wm, errors.New("The watermark needs a text or a logo")
+	}
+
+	switch {
+	case len(profile.FontData) != 0:
+		wm.Font, err = WaterMark.LoadFont(profile.FontData)
+	case profile.Font != "":
+		wm.Font, err = WaterMark.BuiltinFont(profile.Font)
+	}
+
+	return wm, err
+}
+
+// parseColor parse "#rrggbb" or "#rrggbbaa", the empty color is white
+func parseColor(hex string) (color.Color, error) {
+	if hex == "" {
+		return color.White, nil
+	}
+
+	value, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil || !strings.HasPrefix(hex, "#") || (len(hex) != 7 && len(hex) != 9) {
+		return nil, errors.New("Invalid color " + hex)
+	}
+
+	if len(hex) == 7 {
+		value = value<<8 | 0xff
+	}
+
+	return color.NRGBA{R: uint8(value >> 24), G: uint8(value >> 16), B: uint8(value >> 8), A: uint8(value)}, nil
+}
@@ -0,0 +1,70 @@
+package ProductService
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestParseColor(t *testing.T) {
+	c, err := parseColor("#ff800080")
+	if err != nil || c != (color.NRGBA{R: 0xff, G: 0x80, B: 0x00, A: 0x80}) {
+		t.Fatalf("unexpected color %v, %v", c, err)
+	}
+
+	c, err = parseColor("#102030")
+	if err != nil || c != (color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff}) {
+		t.Fatalf("unexpected color %v, %v", c, err)
+	}
+
+	for _, hex := range []string{"102030", "#12345", "#zzzzzz"} {
+		if _, err := parseColor(hex); err == nil {
+			t.Errorf("color %q should be invalid", hex)
+		}
+	}
+}
+
+func TestWatermarkProfileValidation(t *testing.T) {
+	if _, err := defaultWatermark.service(nil); err != nil {
+		t.Fatalf("default profile is invalid: %v", err)
+	}
+
+	// a wide logo is a small file but a huge picture
+	var wide bytes.Buffer
+	if err := png.Encode(&wide, image.NewGray(image.Rect(0, 0, maxLogoSide+1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []func(*WatermarkProfile){
+		func(p *WatermarkProfile) { p.Position = "middle" },
+		func(p *WatermarkProfile) { p.Opacity = 1.5 },
+		func(p *WatermarkProfile) { p.Margin = 0.8 },
+		func(p *WatermarkProfile) { p.Font = "comic" },
+		func(p *WatermarkProfile) { p.Text = "" },
+		func(p *WatermarkProfile) { p.Logo = []byte("not a png") },
+		func(p *WatermarkProfile) { p.Logo = wide.Bytes() },
+	}
+	for i, change := range invalid {
+		profile := defaultWatermark
+		change(&profile)
+		if _, err := profile.service(nil); err == nil {
+			t.Errorf("profile %d should be invalid", i)
+		}
+	}
+}
+
+func TestProductOfPicture(t *testing.T) {
+	products := NewMemoryRepository()
+	products.Insert(Product{ID: "product", Owner: "alice", URL: "http://cache/alice/a.png"})
+	svc := &ProductService{Products: products, CacheGetURL: "http://cache", Logger: log.NewNopLogger()}
+
+	for imageID, productID := range map[string]string{"a.png": "product", "uploaded.png": ""} {
+		if got, err := svc.productOfPicture("alice", imageID); err != nil || got != productID {
+			t.Errorf("%s: product %q, %v", imageID, got, err)
+		}
+	}
+}
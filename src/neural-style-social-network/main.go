@@ -10,6 +10,9 @@ import (
 	"syscall"
 	"time"
 
+	"neural-style-util"
+
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/rs/cors"
@@ -21,6 +24,10 @@ var (
 	serverPort   = flag.String("port", "8001", "neural style server port")
 	dbServerURL  = flag.String("dbserver", "0.0.0.0", "style products server url")
 	dbServerPort = flag.String("dbport", "9000", "style products port url")
+	storeType    = flag.String("store", "mongo", "data store: mongo or memory")
+	jwksURL      = flag.String("jwks", "", "JWKS url of the data server, the changes need a token if it's set")
+	revocationDB = flag.String("revocationDB", "", "mongodb url of the revoked tokens of the data server, default is "+
+		"the mongo store. With the memory store and no url, a revoked token is accepted until it expires")
 )
 
 func ensureIndex(s *mgo.Session) {
@@ -48,16 +55,6 @@ func main() {
 	ctx := context.Background()
 	errChan := make(chan error)
 
-	session, err := mgo.Dial(*dbServerURL + ":" + *dbServerPort)
-	if err != nil {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		errChan <- fmt.Errorf("%s", <-c)
-	}
-	defer session.Close()
-	session.SetMode(mgo.Monotonic, true)
-	ensureIndex(session)
-
 	// Logging domain.
 	var logger log.Logger
 	{
@@ -66,7 +63,44 @@ func main() {
 		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 	}
 
-	r := makeHTTPHandler(ctx, session, logger)
+	var svc Service
+	var revocations NSUtil.RevocationList
+	if *storeType == NSUtil.MemoryStore {
+		svc = newSocialSVC(logger, &memoryReviewRepository{}, &memoryFolloweeRepository{}, memoryProductRepository{})
+	} else {
+		session, err := mgo.Dial(*dbServerURL + ":" + *dbServerPort)
+		if err != nil {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+			errChan <- fmt.Errorf("%s", <-c)
+		}
+		defer session.Close()
+		session.SetMode(mgo.Monotonic, true)
+		ensureIndex(session)
+
+		svc = newSocialSVC(logger, &mgoReviewRepository{session: session}, &mgoFolloweeRepository{session: session},
+			&mgoProductRepository{session: session})
+		revocations = NSUtil.NewReadOnlyRevocationList(session)
+	}
+	if *revocationDB != "" {
+		session, err := mgo.Dial(*revocationDB)
+		if err != nil {
+			level.Error(logger).Log("API", "mgo.Dial", "info", err)
+			return
+		}
+		defer session.Close()
+		session.SetMode(mgo.Monotonic, true)
+		revocations = NSUtil.NewReadOnlyRevocationList(session)
+	}
+
+	// the tokens are verified by the public keys of the data server, and checked in its revoked tokens
+	auth := func(next endpoint.Endpoint) endpoint.Endpoint { return next }
+	if *jwksURL != "" {
+		NSUtil.Keys = NSUtil.NewRemoteKeySet(*jwksURL)
+		auth = NSUtil.AuthMiddleware(logger, revocations)
+	}
+
+	r := makeHTTPHandler(ctx, svc, auth, logger)
 
 	r = cors.AllowAll().Handler(r)
 
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+
+	"neural-style-util"
+)
+
+type memoryReviewRepository struct {
+	mutex   sync.RWMutex
+	reviews []Review
+}
+
+type memoryFolloweeRepository struct {
+	mutex     sync.RWMutex
+	followees []Followee
+}
+
+// memoryProductRepository has no product, because the products are kept by the data server
+type memoryProductRepository struct{}
+
+func (repo *memoryReviewRepository) FindByProduct(productID string) ([]Review, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var reviews []Review
+	for _, review := range repo.reviews {
+		if review.ProductID == productID {
+			reviews = append(reviews, review)
+		}
+	}
+
+	return reviews, nil
+}
+
+func (repo *memoryReviewRepository) Exists(productID, user string) (bool, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for _, review := range repo.reviews {
+		if review.ProductID == productID && review.User == user {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (repo *memoryReviewRepository) Insert(review Review) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.reviews = append(repo.reviews, review)
+	return nil
+}
+
+func (repo *memoryFolloweeRepository) find(match func(followee Followee) bool) []Followee {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var followees []Followee
+	for _, followee := range repo.followees {
+		if match(followee) {
+			followees = append(followees, followee)
+		}
+	}
+
+	return followees
+}
+
+func (repo *memoryFolloweeRepository) FindByProduct(productID string) ([]Followee, error) {
+	return repo.find(func(followee Followee) bool { return followee.ProductID == productID }), nil
+}
+
+func (repo *memoryFolloweeRepository) FindByUser(user string) ([]Followee, error) {
+	return repo.find(func(followee Followee) bool { return followee.User == user }), nil
+}
+
+func (repo *memoryFolloweeRepository) CountByProduct(productID string) (int, error) {
+	followees, err := repo.FindByProduct(productID)
+	return len(followees), err
+}
+
+func (repo *memoryFolloweeRepository) Exists(productID, user string) (bool, error) {
+	followees := repo.find(func(followee Followee) bool {
+		return followee.ProductID == productID && followee.User == user
+	})
+	return len(followees) != 0, nil
+}
+
+func (repo *memoryFolloweeRepository) Insert(followee Followee) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.followees = append(repo.followees, followee)
+	return nil
+}
+
+func (repo *memoryFolloweeRepository) Remove(productID, user string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for index, followee := range repo.followees {
+		if followee.ProductID == productID && followee.User == user {
+			repo.followees = append(repo.followees[:index], repo.followees[index+1:]...)
+			return nil
+		}
+	}
+
+	return NSUtil.ErrNotFound
+}
+
+func (memoryProductRepository) FindByIDs(ids []string) ([]FollowingProduct, error) {
+	return nil, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"neural-style-util"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ReviewRepository define the data access of the reviews
+type ReviewRepository interface {
+	FindByProduct(productID string) ([]Review, error)
+	Exists(productID, user string) (bool, error)
+	Insert(review Review) error
+}
+
+// FolloweeRepository define the data access of the followees
+type FolloweeRepository interface {
+	FindByProduct(productID string) ([]Followee, error)
+	FindByUser(user string) ([]Followee, error)
+	CountByProduct(productID string) (int, error)
+	Exists(productID, user string) (bool, error)
+	Insert(followee Followee) error
+	// Remove return NSUtil.ErrNotFound if the user doesn't follow the product
+	Remove(productID, user string) error
+}
+
+// ProductRepository define the read access of the products owned by the product service
+type ProductRepository interface {
+	FindByIDs(ids []string) ([]FollowingProduct, error)
+}
+
+type mgoReviewRepository struct {
+	session *mgo.Session
+}
+
+type mgoFolloweeRepository struct {
+	session *mgo.Session
+}
+
+type mgoProductRepository struct {
+	session *mgo.Session
+}
+
+func (repo *mgoReviewRepository) FindByProduct(productID string) ([]Review, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var reviews []Review
+	err := session.DB("store").C("reviews").Find(bson.M{"productid": productID}).All(&reviews)
+	return reviews, err
+}
+
+func (repo *mgoReviewRepository) Exists(productID, user string) (bool, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	count, err := session.DB("store").C("reviews").Find(bson.M{"productid": productID, "user": user}).Limit(1).Count()
+	return count != 0, err
+}
+
+func (repo *mgoReviewRepository) Insert(review Review) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("reviews").Insert(review)
+}
+
+func (repo *mgoFolloweeRepository) FindByProduct(productID string) ([]Followee, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var followees []Followee
+	err := session.DB("store").C("followees").Find(bson.M{"productid": productID}).All(&followees)
+	return followees, err
+}
+
+func (repo *mgoFolloweeRepository) FindByUser(user string) ([]Followee, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var followees []Followee
+	err := session.DB("store").C("followees").Find(bson.M{"user": user}).All(&followees)
+	return followees, err
+}
+
+func (repo *mgoFolloweeRepository) CountByProduct(productID string) (int, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("followees").Find(bson.M{"productid": productID}).Count()
+}
+
+func (repo *mgoFolloweeRepository) Exists(productID, user string) (bool, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	count, err := session.DB("store").C("followees").Find(bson.M{"productid": productID, "user": user}).Limit(1).Count()
+	return count != 0, err
+}
+
+func (repo *mgoFolloweeRepository) Insert(followee Followee) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("followees").Insert(followee)
+}
+
+func (repo *mgoFolloweeRepository) Remove(productID, user string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("followees").Remove(bson.M{"productid": productID, "user": user})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoProductRepository) FindByIDs(ids []string) ([]FollowingProduct, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var prods []FollowingProduct
+	err := session.DB("store").C("products").Find(bson.M{"id": bson.M{"$in": ids}}).Select(bson.M{"id": 1, "url": 1}).All(&prods)
+	return prods, err
+}
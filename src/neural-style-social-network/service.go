@@ -5,8 +5,6 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 )
 
 // Review define the basic elements of the review
@@ -52,24 +50,19 @@ type Service interface {
 
 // SocialService define implementation of the social service
 type SocialService struct {
-	Session *mgo.Session
-	Logger  log.Logger
+	Reviews   ReviewRepository
+	Followees FolloweeRepository
+	Products  ProductRepository
+	Logger    log.Logger
 }
 
-func newSocialSVC(logger log.Logger, session *mgo.Session) Service {
-	return &SocialService{Session: session, Logger: logger}
+func newSocialSVC(logger log.Logger, reviews ReviewRepository, followees FolloweeRepository, products ProductRepository) Service {
+	return &SocialService{Reviews: reviews, Followees: followees, Products: products, Logger: logger}
 }
 
 // GetReviewsByProductID find the
 func (svc *SocialService) GetReviewsByProductID(id string) ([]Review, error) {
-
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("reviews")
-
-	var reviews []Review
-	err := c.Find(bson.M{"productid": id}).All(&reviews)
+	reviews, err := svc.Reviews.FindByProduct(id)
 	if err != nil {
 		// Add log information here
 		level.Debug(svc.Logger).Log("API", "GetReviewsByProductID", "info", err.Error(), "id", id)
@@ -86,25 +79,18 @@ func (svc *SocialService) GetReviewsByProductID(id string) ([]Review, error) {
 
 // AddReviewByProductID add review data to the product id
 func (svc *SocialService) AddReviewByProductID(review Review) error {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("reviews")
-
-	existQuery := c.Find(bson.M{"productid": review.ProductID, "user": review.User}).Limit(1)
-	if existQuery != nil {
-		reviewCount, err := existQuery.Count()
-		if err != nil {
-			level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", review.User, "productid", review.ProductID, "info", err.Error())
-			return err
-		}
-
-		if reviewCount != 0 {
-			level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", review.User, "productid", review.ProductID, "info", "Duplicated review")
-			return errors.New("Duplicated user: " + review.User + " for " + review.ProductID)
-		}
+	exists, err := svc.Reviews.Exists(review.ProductID, review.User)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", review.User, "productid", review.ProductID, "info", err.Error())
+		return err
+	}
+
+	if exists {
+		level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", review.User, "productid", review.ProductID, "info", "Duplicated review")
+		return errors.New("Duplicated user: " + review.User + " for " + review.ProductID)
 	}
-	err := c.Insert(review)
+
+	err = svc.Reviews.Insert(review)
 
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetReviewsByProductID", "info", err.Error())
@@ -116,13 +102,7 @@ func (svc *SocialService) AddReviewByProductID(review Review) error {
 
 // GetFolloweesByProductID get all the followees for a given product id
 func (svc *SocialService) GetFolloweesByProductID(id string) ([]Followee, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("followees")
-
-	var followees []Followee
-	err := c.Find(bson.M{"productid": id}).All(&followees)
+	followees, err := svc.Followees.FindByProduct(id)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetFolloweesByProductID", "productid", id, "info", err.Error())
 		return nil, err
@@ -138,26 +118,18 @@ func (svc *SocialService) GetFolloweesByProductID(id string) ([]Followee, error)
 
 // AddFolloweesByProductID add new followees to a given product id
 func (svc *SocialService) AddFolloweesByProductID(info Followee) error {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("followees")
-
-	existQuery := c.Find(bson.M{"productid": info.ProductID, "user": info.User}).Limit(1)
-	if existQuery != nil {
-		followwCount, err := existQuery.Count()
-		if err != nil {
-			level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", info.User, "productid", info.ProductID, "info", err.Error())
-			return err
-		}
-
-		if followwCount != 0 {
-			level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", info.User, "productid", info.ProductID, "info", "Duplicated followee")
-			return errors.New("Duplicated user: " + info.User + " for " + info.ProductID)
-		}
+	exists, err := svc.Followees.Exists(info.ProductID, info.User)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", info.User, "productid", info.ProductID, "info", err.Error())
+		return err
+	}
+
+	if exists {
+		level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", info.User, "productid", info.ProductID, "info", "Duplicated followee")
+		return errors.New("Duplicated user: " + info.User + " for " + info.ProductID)
 	}
 
-	err := c.Insert(info)
+	err = svc.Followees.Insert(info)
 
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "AddFolloweesByProductID", "user", info.User, "productid", info.ProductID, "info", err.Error())
@@ -170,12 +142,7 @@ func (svc *SocialService) AddFolloweesByProductID(info Followee) error {
 
 // DeleteFolloweeByID remove the followee information for a given product id
 func (svc *SocialService) DeleteFolloweeByID(productID, User string) error {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("followees")
-
-	err := c.Remove(bson.M{"productid": productID, "user": User})
+	err := svc.Followees.Remove(productID, User)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "DeleteFolloweeByIDProductID", "productid", productID, "user", User, "info", err.Error())
 		return err
@@ -187,33 +154,24 @@ func (svc *SocialService) DeleteFolloweeByID(productID, User string) error {
 
 // GetSummaryByID aggregate the summary information from the 'reviews' and 'followees' collection
 func (svc *SocialService) GetSummaryByID(id string) (SocialSummary, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
 	info := SocialSummary{}
 
-	c := session.DB("store").C("followees")
-	count, err := c.Find(bson.M{"productid": id}).Count()
+	count, err := svc.Followees.CountByProduct(id)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetSummaryByID", "productid", id, "info", "GetFollowees", "error", err.Error())
 		return info, err
 	}
 	info.FolloweeCount = uint32(count)
 
-	c = session.DB("store").C("reviews")
-	var ratings []struct {
-		Rating uint8 `json:"rating"`
-	}
-
-	err = c.Find(bson.M{"productid": id}).Select(bson.M{"rating": 1}).All(&ratings)
+	reviews, err := svc.Reviews.FindByProduct(id)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetSummaryByID", "productid", id, "info", "GetReviews", "error", err.Error())
 		return info, err
 	}
-	info.CommentCount = uint32(len(ratings))
+	info.CommentCount = uint32(len(reviews))
 
 	totalRating := 0
-	for _, ratingInfo := range ratings {
+	for _, ratingInfo := range reviews {
 		totalRating += int(ratingInfo.Rating)
 	}
 
@@ -228,33 +186,22 @@ func (svc *SocialService) GetSummaryByID(id string) (SocialSummary, error) {
 
 // GetFollowingProductsByUserID get all the following product for a given user name
 func (svc *SocialService) GetFollowingProductsByUserID(user string) ([]FollowingProduct, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("followees")
-
-	var prodIds []struct {
-		ProductID string `json:"productid"`
-	}
-
-	err := c.Find(bson.M{"user": user}).Select(bson.M{"productid": 1}).All(&prodIds)
+	followees, err := svc.Followees.FindByUser(user)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetFollowingProductsByUserID", "user", user, "info", err.Error())
 		return nil, err
 	}
 
-	if len(prodIds) == 0 {
+	if len(followees) == 0 {
 		return nil, nil
 	}
 
 	var idArray []string
-	for _, info := range prodIds {
+	for _, info := range followees {
 		idArray = append(idArray, info.ProductID)
 	}
 	// aggregate all the following product infor from the products collection
-	var prods []FollowingProduct
-	prodC := session.DB("store").C("products")
-	err = prodC.Find(bson.M{"id": bson.M{"$in": idArray}}).Select(bson.M{"id": 1, "url": 1}).All(&prods)
+	prods, err := svc.Products.FindByIDs(idArray)
 	if err != nil {
 		level.Error(svc.Logger).Log("API", "GetFollowingProductsByUserID", "user", user, "info", err.Error())
 		return nil, err
@@ -7,10 +7,10 @@ import (
 
 	"neural-style-util"
 
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
-	mgo "gopkg.in/mgo.v2"
 )
 
 func decodeNSGetReviewsByIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -122,7 +122,7 @@ func encodeGetFolloweeProductsByUserResponse(_ context.Context, w http.ResponseW
 	return json.NewEncoder(w).Encode(followeeResponse.Prods)
 }
 
-func makeHTTPHandler(context context.Context, session *mgo.Session, logger log.Logger) http.Handler {
+func makeHTTPHandler(context context.Context, svc Service, auth endpoint.Middleware, logger log.Logger) http.Handler {
 	r := mux.NewRouter()
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorLogger(logger),
@@ -130,7 +130,6 @@ func makeHTTPHandler(context context.Context, session *mgo.Session, logger log.L
 		httptransport.ServerBefore(NSUtil.ParseToken),
 	}
 
-	svc := newSocialSVC(logger, session)
 	svc = newLoggingService(logger, svc)
 
 	// GET api/social/v1/{id}/reviews
@@ -143,7 +142,7 @@ func makeHTTPHandler(context context.Context, session *mgo.Session, logger log.L
 
 	// POST api/social/v1/{id}/reviews/add
 	r.Methods("POST").Path("/api/social/v1/{id}/reviews/add").Handler(httptransport.NewServer(
-		makeNSAddReviewByIDEndpoint(svc),
+		auth(makeNSAddReviewByIDEndpoint(svc)),
 		decodeAddReviewByIDRequest,
 		encodeSocialResponse,
 		options...,
@@ -159,7 +158,7 @@ func makeHTTPHandler(context context.Context, session *mgo.Session, logger log.L
 
 	// POST api/social/v1/{id}/followees/add
 	r.Methods("POST").Path("/api/social/v1/{id}/followees/add").Handler(httptransport.NewServer(
-		makeNSAddFolloweebyIDEndpoint(svc),
+		auth(makeNSAddFolloweebyIDEndpoint(svc)),
 		decodeAddFolloweeByIDRequest,
 		encodeSocialResponse,
 		options...,
@@ -167,7 +166,7 @@ func makeHTTPHandler(context context.Context, session *mgo.Session, logger log.L
 
 	// DELETE api/social/v1/{productid}/{userid}/followees/delete
 	r.Methods("DELETE").Path("/api/social/v1/{productid}/{userid}/followees/delete").Handler(httptransport.NewServer(
-		makeNSDeleteFolloweeByIDEndpoint(svc),
+		auth(makeNSDeleteFolloweeByIDEndpoint(svc)),
 		decodeDeleteFolloweeByIDRequest,
 		encodeSocialResponse,
 		options...,
@@ -0,0 +1,57 @@
+package StyleService
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// ErrArtistNotFound denotes the artist is unknown or retired
+var ErrArtistNotFound = NSUtil.NewErrorWithStatus(http.StatusNotFound, "The artist doesn't exist")
+
+// ArtistModel define the transfer model of an artist style
+type ArtistModel struct {
+	Name        string
+	Masterpiece string
+	ModelName   string
+}
+
+// ModelRegistry resolve the artist to the transfer model
+type ModelRegistry interface {
+	// FindModel return NSUtil.ErrNotFound if the artist doesn't exist or is retired
+	FindModel(artist string) (ArtistModel, error)
+}
+
+// ArtistStyleTransfer apply the style of the artist to the content image by the model of the artist
+func (svc *NeuralTransferService) ArtistStyleTransfer(content, artist string) (string, error) {
+	model, err := svc.Models.FindModel(artist)
+	if err == NSUtil.ErrNotFound {
+		return "", ErrArtistNotFound
+	}
+
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Models.FindModel", "artist", artist, "info", err)
+		return "", errors.New("Failed to find the artist model")
+	}
+
+	_, contentName := path.Split(content)
+	outputName := contentName + "_" + model.ModelName + ".png"
+	output := svc.OutputPath + "data/outputs/" + outputName
+
+	err = svc.Backend.ArtistTransfer(context.Background(), content, model, output)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Backend.ArtistTransfer", "content", content, "model", model.ModelName, "info", err)
+	}
+
+	if _, err := os.Stat(output); os.IsNotExist(err) {
+		return "", errors.New("Artist Style Transfer fails")
+	}
+
+	return svc.Host + ":" + svc.Port + "/outputs/" + outputName, nil
+}
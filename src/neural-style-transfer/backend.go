@@ -0,0 +1,80 @@
+package StyleService
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// transfer backend type
+const (
+	PythonBackendType = "python"
+	RemoteBackendType = "remote"
+	FilterBackendType = "filter"
+)
+
+// StyleBackend apply the style image to the content image, and write the result to the output file.
+// The content and style are local paths or http urls. The backend must stop when the context is canceled.
+type StyleBackend interface {
+	Transfer(ctx context.Context, content, style string, iterations int, output string) error
+	Preview(ctx context.Context, content, style string, output string) error
+	ArtistTransfer(ctx context.Context, content string, artist ArtistModel, output string) error
+}
+
+// PythonBackend run the neural style python scripts on the local machine
+type PythonBackend struct {
+	NetworkPath        string
+	PreviewNetworkPath string
+	ScriptPath         string
+}
+
+// NewPythonBackend generate the python backend, the scripts are searched in the working directory if scriptPath is empty
+func NewPythonBackend(networkPath, previewNetworkPath, scriptPath string) *PythonBackend {
+	if scriptPath == "" {
+		scriptPath, _ = os.Getwd()
+	}
+
+	return &PythonBackend{NetworkPath: networkPath, PreviewNetworkPath: previewNetworkPath, ScriptPath: scriptPath}
+}
+
+// Transfer run neural_style.py with the VGG network
+func (backend *PythonBackend) Transfer(ctx context.Context, content, style string, iterations int, output string) error {
+	return backend.run(ctx, "/neural_style.py", "content="+content, "styles="+style, "output="+output,
+		"iterations="+strconv.Itoa(iterations), "network="+backend.NetworkPath+"imagenet-vgg-verydeep-19.mat")
+}
+
+// Preview run neural_style_preview.py
+func (backend *PythonBackend) Preview(ctx context.Context, content, style string, output string) error {
+	return backend.run(ctx, "/neural_style_preview.py", "content="+content, "styles="+style, "output="+output)
+}
+
+// ArtistTransfer run artist_style.py with the artist model under the network path
+func (backend *PythonBackend) ArtistTransfer(ctx context.Context, content string, artist ArtistModel, output string) error {
+	return backend.run(ctx, "/artist_style.py", "content="+content, "output="+output,
+		"model="+backend.NetworkPath+artist.ModelName)
+}
+
+func (backend *PythonBackend) run(ctx context.Context, script string, env ...string) error {
+	python, err := exec.LookPath("python")
+	if err != nil {
+		return errors.New("No python installed")
+	}
+
+	cmd := exec.CommandContext(ctx, python, backend.ScriptPath+script)
+	cmd.Env = env
+
+	var out bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return errors.New(err.Error() + ": " + stderr.String())
+	}
+
+	return nil
+}
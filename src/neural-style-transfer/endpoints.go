@@ -3,6 +3,8 @@ package StyleService
 import (
 	"context"
 
+	"neural-style-util"
+
 	"github.com/go-kit/kit/endpoint"
 )
 
@@ -19,6 +21,12 @@ type NSPreviewRequest struct {
 	Style   string
 }
 
+// NSArtistRequest parameters for the artist style transfer
+type NSArtistRequest struct {
+	Artist  string
+	Content string `json:"content"`
+}
+
 // NSResponse error information for the style transfer
 type NSResponse struct {
 	Err    error  `json:"err"`
@@ -34,6 +42,15 @@ func MakeNSEndpoint(svc *NeuralTransferService) endpoint.Endpoint {
 	}
 }
 
+// MakeNSArtistEndpoint generate the artist style transfer endpoint
+func MakeNSArtistEndpoint(svc *NeuralTransferService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSArtistRequest)
+		output, err := svc.ArtistStyleTransfer(req.Content, req.Artist)
+		return NSResponse{Err: err, Output: output}, err
+	}
+}
+
 // MakeNSPreviewEndpoint generate the style transfer preview endpoint
 func MakeNSPreviewEndpoint(svc *NeuralTransferService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
@@ -42,3 +59,50 @@ func MakeNSPreviewEndpoint(svc *NeuralTransferService) endpoint.Endpoint {
 		return NSResponse{Err: err, Output: output}, err
 	}
 }
+
+// NSJobRequest parameters for the style transfer job
+type NSJobRequest struct {
+	Content    string `json:"content"`
+	Style      string `json:"style"`
+	Iterations int    `json:"iterations"`
+}
+
+// NSJobIDRequest parameter for querying or canceling the style transfer job
+type NSJobIDRequest struct {
+	JobID string
+}
+
+// NSJobResponse return the style transfer job and error information
+type NSJobResponse struct {
+	Job Job   `json:"job"`
+	Err error `json:"err"`
+}
+
+func (r NSJobResponse) error() error { return r.Err }
+
+// MakeNSSubmitJobEndpoint generate the endpoint for queuing a style transfer job
+func MakeNSSubmitJobEndpoint(svc *NeuralTransferService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSJobRequest)
+		job, err := svc.SubmitTransferJob(NSUtil.ContextUser(ctx), req.Content, req.Style, req.Iterations)
+		return NSJobResponse{Job: job, Err: err}, nil
+	}
+}
+
+// MakeNSGetJobEndpoint generate the endpoint for polling the style transfer job
+func MakeNSGetJobEndpoint(svc *NeuralTransferService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSJobIDRequest)
+		job, err := svc.GetTransferJob(NSUtil.ContextUser(ctx), req.JobID)
+		return NSJobResponse{Job: job, Err: err}, nil
+	}
+}
+
+// MakeNSCancelJobEndpoint generate the endpoint for canceling the style transfer job
+func MakeNSCancelJobEndpoint(svc *NeuralTransferService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSJobIDRequest)
+		job, err := svc.CancelTransferJob(NSUtil.ContextUser(ctx), req.JobID)
+		return NSJobResponse{Job: job, Err: err}, nil
+	}
+}
@@ -0,0 +1,202 @@
+package StyleService
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+
+	// decoders of the content and style images
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// previewSize is the max width or height of the preview output
+const previewSize = 256
+
+// FilterBackend match the color histogram of the content image to the style image. It needs neither
+// python nor GPU, and always generates the same output for the same inputs, so it fits dev and CI.
+type FilterBackend struct{}
+
+// NewFilterBackend generate the color transfer backend
+func NewFilterBackend() *FilterBackend {
+	return &FilterBackend{}
+}
+
+// Transfer write the histogram matched content image, the iterations are ignored
+func (backend *FilterBackend) Transfer(ctx context.Context, content, style string, iterations int, output string) error {
+	return backend.transfer(ctx, content, style, output, 0)
+}
+
+// Preview write the histogram matched content image scaled down to previewSize
+func (backend *FilterBackend) Preview(ctx context.Context, content, style string, output string) error {
+	return backend.transfer(ctx, content, style, output, previewSize)
+}
+
+// ArtistTransfer use the masterpiece of the artist as the style image
+func (backend *FilterBackend) ArtistTransfer(ctx context.Context, content string, artist ArtistModel, output string) error {
+	return backend.transfer(ctx, content, artist.Masterpiece, output, 0)
+}
+
+func (backend *FilterBackend) transfer(ctx context.Context, content, style, output string, maxSize int) error {
+	contentImg, err := loadImage(ctx, content)
+	if err != nil {
+		return err
+	}
+
+	styleImg, err := loadImage(ctx, style)
+	if err != nil {
+		return err
+	}
+
+	if maxSize > 0 {
+		contentImg = scaleDown(contentImg, maxSize)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	outputFile, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	return png.Encode(outputFile, MatchHistogram(contentImg, styleImg))
+}
+
+// MatchHistogram map each RGB channel of the content image, so its cumulative histogram is
+// the same as the one of the style image. The alpha channel of the content is kept.
+func MatchHistogram(content, style image.Image) *image.NRGBA {
+	var contentHist, styleHist [3][256]float64
+	fillHistogram(content, &contentHist)
+	fillHistogram(style, &styleHist)
+
+	var lookup [3][256]uint8
+	for c := 0; c < 3; c++ {
+		contentCDF := cumulate(contentHist[c])
+		styleCDF := cumulate(styleHist[c])
+
+		target := 0
+		for value := 0; value < 256; value++ {
+			for target < 255 && styleCDF[target] < contentCDF[value] {
+				target++
+			}
+			lookup[c][value] = uint8(target)
+		}
+	}
+
+	bounds := content.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(content.At(x, y)).(color.NRGBA)
+			result.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.NRGBA{
+				R: lookup[0][pixel.R],
+				G: lookup[1][pixel.G],
+				B: lookup[2][pixel.B],
+				A: pixel.A,
+			})
+		}
+	}
+
+	return result
+}
+
+func fillHistogram(img image.Image, hist *[3][256]float64) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			hist[0][pixel.R]++
+			hist[1][pixel.G]++
+			hist[2][pixel.B]++
+		}
+	}
+}
+
+// cumulate return the normalized cumulative histogram
+func cumulate(hist [256]float64) [256]float64 {
+	var cdf [256]float64
+	total := 0.0
+	for value, count := range hist {
+		total += count
+		cdf[value] = total
+	}
+
+	if total > 0 {
+		for value := range cdf {
+			cdf[value] /= total
+		}
+	}
+
+	return cdf
+}
+
+// scaleDown resize the image by the nearest neighbor, so the longer side is at most maxSize
+func scaleDown(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+
+	newWidth, newHeight := maxSize, height*maxSize/width
+	if height > width {
+		newWidth, newHeight = width*maxSize/height, maxSize
+	}
+
+	if newWidth == 0 {
+		newWidth = 1
+	}
+
+	if newHeight == 0 {
+		newHeight = 1
+	}
+
+	scaled := image.NewNRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			scaled.Set(x, y, img.At(bounds.Min.X+x*width/newWidth, bounds.Min.Y+y*height/newHeight))
+		}
+	}
+
+	return scaled
+}
+
+// loadImage decode the image from a local path or an http url
+func loadImage(ctx context.Context, location string) (image.Image, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		req, err := http.NewRequest("GET", location, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, errors.New("fail to get " + location + ": " + res.Status)
+		}
+
+		img, _, err := image.Decode(res.Body)
+		return img, err
+	}
+
+	file, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
@@ -0,0 +1,33 @@
+package StyleService
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMatchHistogram(t *testing.T) {
+	content := image.NewGray(image.Rect(0, 0, 4, 1))
+	for x := 0; x < 4; x++ {
+		content.SetGray(x, 0, color.Gray{Y: uint8(x * 10)})
+	}
+
+	// the style only has two colors
+	style := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	style.SetNRGBA(0, 0, color.NRGBA{R: 100, G: 0, B: 50, A: 255})
+	style.SetNRGBA(1, 0, color.NRGBA{R: 200, G: 255, B: 150, A: 255})
+
+	result := MatchHistogram(content, style)
+	expected := []color.NRGBA{
+		{R: 100, G: 0, B: 50, A: 255},
+		{R: 100, G: 0, B: 50, A: 255},
+		{R: 200, G: 255, B: 150, A: 255},
+		{R: 200, G: 255, B: 150, A: 255},
+	}
+
+	for x, want := range expected {
+		if got := result.NRGBAAt(x, 0); got != want {
+			t.Errorf("pixel %d: expected %v, got %v", x, want, got)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package StyleService
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// job status
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+var (
+	// ErrQueueFull denotes too many jobs are waiting for the workers
+	ErrQueueFull = NSUtil.NewErrorWithStatus(503, "Too many style transfer jobs. Please try it later.")
+
+	// ErrJobNotFound denotes the job id is unknown
+	ErrJobNotFound = NSUtil.NewErrorWithStatus(404, "The style transfer job doesn't exist")
+
+	// ErrJobFinished denotes the job can't be canceled any more
+	ErrJobFinished = errors.New("The style transfer job has been finished")
+)
+
+// Job define one style transfer request of the owner which runs in background
+type Job struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner"`
+	Content    string    `json:"content"`
+	Style      string    `json:"style"`
+	Iterations int       `json:"iterations"`
+	Status     string    `json:"status"`
+	Output     string    `json:"output"`
+	Error      string    `json:"error"`
+	CreateTime time.Time `json:"createTime"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+}
+
+// JobRunner run the style transfer of the job, and return the output url.
+// The runner must stop when the context is canceled.
+type JobRunner func(ctx context.Context, job Job) (string, error)
+
+// JobQueue run the jobs by a bounded worker pool
+type JobQueue struct {
+	Jobs    JobRepository
+	Runner  JobRunner
+	Workers int
+	Logger  log.Logger
+
+	queue   chan string
+	mutex   sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// NewJobQueue generate the job queue with the workers and the max waiting jobs
+func NewJobQueue(jobs JobRepository, runner JobRunner, workers, queueSize int, logger log.Logger) *JobQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	return &JobQueue{Jobs: jobs, Runner: runner, Workers: workers, Logger: logger,
+		queue: make(chan string, queueSize), cancels: make(map[string]context.CancelFunc)}
+}
+
+// Start run the workers until the context is done. The unfinished jobs before the restart are run again.
+func (q *JobQueue) Start(ctx context.Context) {
+	for i := 0; i < q.Workers; i++ {
+		go q.work(ctx)
+	}
+
+	jobs, err := q.Jobs.FindByStatus([]string{JobQueued, JobRunning})
+	if err != nil {
+		level.Error(q.Logger).Log("API", "Jobs.FindByStatus", "info", err)
+		return
+	}
+
+	go func() {
+		for _, job := range jobs {
+			if job.Status == JobRunning {
+				job.Status = JobQueued
+				q.Jobs.Update(job)
+			}
+
+			select {
+			case q.queue <- job.ID:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Submit save the job and queue it for the workers
+func (q *JobQueue) Submit(job Job) (Job, error) {
+	job.ID = NSUtil.UniqueID()
+	job.Status = JobQueued
+	job.CreateTime = time.Now()
+
+	err := q.Jobs.Insert(job)
+	if err != nil {
+		level.Error(q.Logger).Log("API", "Jobs.Insert", "info", err)
+		return job, errors.New("Server is busy. Please try it later.")
+	}
+
+	select {
+	case q.queue <- job.ID:
+		return job, nil
+	default:
+		q.finish(job, "", ErrQueueFull, JobFailed)
+		return job, ErrQueueFull
+	}
+}
+
+// Get return the current status of the job
+func (q *JobQueue) Get(jobID string) (Job, error) {
+	job, err := q.Jobs.FindByID(jobID)
+	if err == NSUtil.ErrNotFound {
+		return job, ErrJobNotFound
+	}
+
+	return job, err
+}
+
+// Cancel stop the job. The subprocess of a running job is killed.
+func (q *JobQueue) Cancel(jobID string) (Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, err := q.Get(jobID)
+	if err != nil {
+		return job, err
+	}
+
+	if cancel, ok := q.cancels[jobID]; ok {
+		// the worker records the canceled status
+		cancel()
+		job.Status = JobCanceled
+		return job, nil
+	}
+
+	if job.Status != JobQueued {
+		return job, ErrJobFinished
+	}
+
+	job.Status = JobCanceled
+	job.EndTime = time.Now()
+	return job, q.Jobs.Update(job)
+}
+
+func (q *JobQueue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case jobID := <-q.queue:
+			q.run(ctx, jobID)
+		}
+	}
+}
+
+func (q *JobQueue) run(ctx context.Context, jobID string) {
+	q.mutex.Lock()
+	job, err := q.Jobs.FindByID(jobID)
+	if err != nil || job.Status != JobQueued {
+		// the job is canceled before it starts
+		q.mutex.Unlock()
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	q.cancels[jobID] = cancel
+	job.Status = JobRunning
+	job.StartTime = time.Now()
+	err = q.Jobs.Update(job)
+	q.mutex.Unlock()
+	if err != nil {
+		level.Error(q.Logger).Log("API", "Jobs.Update", "job", jobID, "info", err)
+	}
+
+	output, err := q.Runner(jobCtx, job)
+
+	q.mutex.Lock()
+	delete(q.cancels, jobID)
+	q.mutex.Unlock()
+
+	status := JobSucceeded
+	if jobCtx.Err() == context.Canceled {
+		status = JobCanceled
+	} else if err != nil {
+		status = JobFailed
+	}
+
+	q.finish(job, output, err, status)
+}
+
+func (q *JobQueue) finish(job Job, output string, err error, status string) {
+	job.Status = status
+	job.Output = output
+	job.EndTime = time.Now()
+	if err != nil && status != JobCanceled {
+		job.Error = err.Error()
+	}
+
+	if err := q.Jobs.Update(job); err != nil {
+		level.Error(q.Logger).Log("API", "Jobs.Update", "job", job.ID, "info", err)
+	}
+}
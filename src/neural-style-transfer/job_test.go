@@ -0,0 +1,103 @@
+package StyleService
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func waitJobStatus(t *testing.T, q *JobQueue, jobID, status string) Job {
+	for i := 0; i < 100; i++ {
+		job, _ := q.Get(jobID)
+		if job.Status == status {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	job, _ := q.Get(jobID)
+	t.Fatalf("expected job %s to be %s, got %s", jobID, status, job.Status)
+	return job
+}
+
+func TestJobQueueRunsAndCancelsJobs(t *testing.T) {
+	started := make(chan string, 2)
+	runner := func(ctx context.Context, job Job) (string, error) {
+		if job.Content == "slow" {
+			started <- job.ID
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "outputs/" + job.ID + ".png", nil
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	q := NewJobQueue(NewMemoryJobRepository(), runner, 1, 10, log.NewNopLogger())
+	q.Start(ctx)
+
+	done, err := q.Submit(Job{Content: "fast", Style: "style"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := waitJobStatus(t, q, done.ID, JobSucceeded)
+	if job.Output != "outputs/"+done.ID+".png" {
+		t.Errorf("unexpected output %s", job.Output)
+	}
+
+	slow, _ := q.Submit(Job{Content: "slow", Style: "style"})
+	<-started
+	if _, err := q.Cancel(slow.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, q, slow.ID, JobCanceled)
+
+	if _, err := q.Cancel(done.ID); err != ErrJobFinished {
+		t.Errorf("expected finished job not to be canceled, got %v", err)
+	}
+}
+
+func TestJobQueueResumesUnfinishedJobs(t *testing.T) {
+	jobs := NewMemoryJobRepository()
+	jobs.Insert(Job{ID: "interrupted", Status: JobRunning})
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	q := NewJobQueue(jobs, func(ctx context.Context, job Job) (string, error) {
+		return "output", nil
+	}, 1, 10, log.NewNopLogger())
+	q.Start(ctx)
+
+	waitJobStatus(t, q, "interrupted", JobSucceeded)
+}
+
+func TestJobsOfOtherUsersAreNotFound(t *testing.T) {
+	svc := &NeuralTransferService{Jobs: NewJobQueue(NewMemoryJobRepository(), func(ctx context.Context,
+		job Job) (string, error) {
+		return "output", nil
+	}, 1, 10, log.NewNopLogger())}
+
+	job, err := svc.SubmitTransferJob("alice", "content", "style", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.GetTransferJob("bob", job.ID); err != ErrJobNotFound {
+		t.Errorf("bob got the job of alice: %v", err)
+	}
+	if _, err := svc.CancelTransferJob("bob", job.ID); err != ErrJobNotFound {
+		t.Errorf("bob canceled the job of alice: %v", err)
+	}
+
+	if job, err = svc.CancelTransferJob("alice", job.ID); err != nil || job.Status != JobCanceled {
+		t.Errorf("unexpected cancel %+v, %v", job, err)
+	}
+	if job, err = svc.GetTransferJob("alice", job.ID); err != nil || job.Owner != "alice" {
+		t.Errorf("unexpected job %+v, %v", job, err)
+	}
+}
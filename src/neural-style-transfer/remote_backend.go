@@ -0,0 +1,75 @@
+package StyleService
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RemoteBackend call the standalone transfer server, which returns the output image in the response body
+type RemoteBackend struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewRemoteBackend generate the backend for the transfer server, e.g. http://0.0.0.0:9090
+func NewRemoteBackend(serverURL string, timeout time.Duration) *RemoteBackend {
+	return &RemoteBackend{URL: serverURL, Client: &http.Client{Timeout: timeout}}
+}
+
+// Transfer call GET /styleTransfer with the base64 encoded content and style
+func (backend *RemoteBackend) Transfer(ctx context.Context, content, style string, iterations int, output string) error {
+	query := url.Values{}
+	query.Set("content", base64.StdEncoding.EncodeToString([]byte(content)))
+	query.Set("style", base64.StdEncoding.EncodeToString([]byte(style)))
+	query.Set("iterations", strconv.Itoa(iterations))
+	return backend.fetch(ctx, "/styleTransfer", query, output)
+}
+
+// Preview call GET /styleTransfer without iterations, so the server uses its fast default
+func (backend *RemoteBackend) Preview(ctx context.Context, content, style string, output string) error {
+	query := url.Values{}
+	query.Set("content", base64.StdEncoding.EncodeToString([]byte(content)))
+	query.Set("style", base64.StdEncoding.EncodeToString([]byte(style)))
+	return backend.fetch(ctx, "/styleTransfer", query, output)
+}
+
+// ArtistTransfer call GET /artistStyle with the base64 encoded content and the model name
+func (backend *RemoteBackend) ArtistTransfer(ctx context.Context, content string, artist ArtistModel, output string) error {
+	query := url.Values{}
+	query.Set("content", base64.StdEncoding.EncodeToString([]byte(content)))
+	query.Set("artist", artist.ModelName)
+	return backend.fetch(ctx, "/artistStyle", query, output)
+}
+
+func (backend *RemoteBackend) fetch(ctx context.Context, router string, query url.Values, output string) error {
+	req, err := http.NewRequest("GET", backend.URL+router+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := backend.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("transfer server returns " + res.Status)
+	}
+
+	outputFile, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	_, err = io.Copy(outputFile, res.Body)
+	return err
+}
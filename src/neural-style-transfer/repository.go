@@ -0,0 +1,132 @@
+package StyleService
+
+import (
+	"sync"
+
+	"neural-style-util"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// JobRepository define the data access of the style transfer jobs
+type JobRepository interface {
+	Insert(job Job) error
+	// Update replace the job with the same id
+	Update(job Job) error
+	// FindByID return NSUtil.ErrNotFound if the job doesn't exist
+	FindByID(jobID string) (Job, error)
+	FindByStatus(status []string) ([]Job, error)
+}
+
+type mgoJobRepository struct {
+	session *mgo.Session
+}
+
+// NewMongoJobRepository create the job repository on the "transferjobs" collection
+func NewMongoJobRepository(session *mgo.Session) JobRepository {
+	return &mgoJobRepository{session: session}
+}
+
+func (repo *mgoJobRepository) Insert(job Job) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("transferjobs").Insert(job)
+}
+
+func (repo *mgoJobRepository) Update(job Job) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("transferjobs").Update(bson.M{"id": job.ID}, job)
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoJobRepository) FindByID(jobID string) (Job, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var job Job
+	err := session.DB("store").C("transferjobs").Find(bson.M{"id": jobID}).One(&job)
+	if err == mgo.ErrNotFound {
+		return job, NSUtil.ErrNotFound
+	}
+
+	return job, err
+}
+
+func (repo *mgoJobRepository) FindByStatus(status []string) ([]Job, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var jobs []Job
+	err := session.DB("store").C("transferjobs").Find(bson.M{"status": bson.M{"$in": status}}).Sort("createtime").All(&jobs)
+	return jobs, err
+}
+
+type memoryJobRepository struct {
+	mutex sync.RWMutex
+	jobs  []Job
+}
+
+// NewMemoryJobRepository create the job repository in memory
+func NewMemoryJobRepository() JobRepository {
+	return &memoryJobRepository{}
+}
+
+func (repo *memoryJobRepository) Insert(job Job) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.jobs = append(repo.jobs, job)
+	return nil
+}
+
+func (repo *memoryJobRepository) Update(job Job) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for index := range repo.jobs {
+		if repo.jobs[index].ID == job.ID {
+			repo.jobs[index] = job
+			return nil
+		}
+	}
+
+	return NSUtil.ErrNotFound
+}
+
+func (repo *memoryJobRepository) FindByID(jobID string) (Job, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for _, job := range repo.jobs {
+		if job.ID == jobID {
+			return job, nil
+		}
+	}
+
+	return Job{}, NSUtil.ErrNotFound
+}
+
+func (repo *memoryJobRepository) FindByStatus(status []string) ([]Job, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var jobs []Job
+	for _, job := range repo.jobs {
+		for _, s := range status {
+			if job.Status == s {
+				jobs = append(jobs, job)
+				break
+			}
+		}
+	}
+
+	return jobs, nil
+}
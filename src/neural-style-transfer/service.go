@@ -1,64 +1,94 @@
 package StyleService
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"os"
-	"os/exec"
 	"path"
-	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 )
 
 // NeuralTransferService for final image style transfer
 type NeuralTransferService struct {
-	NetworkPath        string
-	PreviewNetworkPath string
-	OutputPath         string
-	Host               string
-	Port               string
+	Backend    StyleBackend
+	Models     ModelRegistry
+	OutputPath string
+	Host       string
+	Port       string
+	Jobs       *JobQueue
+	Logger     log.Logger
 }
 
-// NewNeuralTransferSVC generate a transfer service
-func NewNeuralTransferSVC(networkPath, previewNetworkPath, outputPath, host, port string) *NeuralTransferService {
-	return &NeuralTransferService{NetworkPath: networkPath, PreviewNetworkPath: previewNetworkPath,
-		OutputPath: outputPath, Host: host, Port: port}
+// NewNeuralTransferSVC generate a transfer service, the jobs are run by the workers after Jobs.Start is called
+func NewNeuralTransferSVC(backend StyleBackend, models ModelRegistry, outputPath, host, port string, jobs JobRepository,
+	workers, queueSize int, logger log.Logger) *NeuralTransferService {
+	svc := &NeuralTransferService{Backend: backend, Models: models, OutputPath: outputPath, Host: host, Port: port,
+		Logger: logger}
+	svc.Jobs = NewJobQueue(jobs, svc.runJob, workers, queueSize, logger)
+	return svc
 }
 
 // StyleTransfer for applying the style image to the content image, and generated it as output image
 func (svc *NeuralTransferService) StyleTransfer(content, style string, iterations int) (string, error) {
-	python, err := exec.LookPath("python")
-	if err != nil {
-		return "", errors.New("No python installed")
+	_, contentName := path.Split(content)
+	_, styleName := path.Split(style)
+
+	return svc.transfer(context.Background(), content, style, iterations, contentName+"_"+styleName+".png")
+}
+
+// SubmitTransferJob queue the style transfer of the owner, and return the job immediately
+func (svc *NeuralTransferService) SubmitTransferJob(owner, content, style string, iterations int) (Job, error) {
+	return svc.Jobs.Submit(Job{Owner: owner, Content: content, Style: style, Iterations: iterations})
+}
+
+// GetTransferJob return the status of the owner's style transfer job
+func (svc *NeuralTransferService) GetTransferJob(owner, jobID string) (Job, error) {
+	return svc.ownJob(owner, jobID)
+}
+
+// CancelTransferJob stop the owner's queued or running style transfer job
+func (svc *NeuralTransferService) CancelTransferJob(owner, jobID string) (Job, error) {
+	if _, err := svc.ownJob(owner, jobID); err != nil {
+		return Job{}, err
 	}
 
-	targetEnv := "content=" + content
-	styleEnv := "styles=" + style
+	return svc.Jobs.Cancel(jobID)
+}
 
-	_, contentName := path.Split(content)
-	_, styleName := path.Split(style)
+// ownJob return the job if it's submitted by the owner, the jobs of the other users aren't found
+func (svc *NeuralTransferService) ownJob(owner, jobID string) (Job, error) {
+	job, err := svc.Jobs.Get(jobID)
+	if err != nil {
+		return Job{}, err
+	}
 
-	outputName := contentName + "_" + styleName + ".png"
-	output := svc.OutputPath + "data/outputs/" + outputName
-	outputEnv := "output=" + output
+	if owner == "" || job.Owner != owner {
+		return Job{}, ErrJobNotFound
+	}
 
-	iterationsEnv := "iterations=" + strconv.Itoa(iterations)
-	networkPathEnv := "network=" + svc.NetworkPath + "imagenet-vgg-verydeep-19.mat"
+	return job, nil
+}
 
-	fmt.Println("The content path is " + content)
-	fmt.Println("The style path is " + style)
+// runJob write the output of each job to its own file, so the same images can be transferred concurrently
+func (svc *NeuralTransferService) runJob(ctx context.Context, job Job) (string, error) {
+	return svc.transfer(ctx, job.Content, job.Style, job.Iterations, job.ID+".png")
+}
 
-	wd, _ := os.Getwd()
-	pyfiles := wd + "/neural_style.py"
-	cmd := exec.Command(python, pyfiles)
-	cmd.Env = []string{targetEnv, styleEnv, outputEnv, iterationsEnv, networkPathEnv}
+// transfer run the backend, and remove the partial output when the context is canceled
+func (svc *NeuralTransferService) transfer(ctx context.Context, content, style string, iterations int, outputName string) (string, error) {
+	output := svc.OutputPath + "data/outputs/" + outputName
 
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
+	err := svc.Backend.Transfer(ctx, content, style, iterations, output)
+	if ctx.Err() != nil {
+		os.Remove(output)
+		return "", ctx.Err()
+	}
 
-	err = cmd.Run()
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Backend.Transfer", "content", content, "style", style, "info", err)
+	}
 
 	if _, err := os.Stat(output); os.IsNotExist(err) {
 		return "", errors.New("Style Transfer fails")
@@ -68,32 +98,16 @@ func (svc *NeuralTransferService) StyleTransfer(content, style string, iteration
 
 // StyleTransferPreview for applying the style image to the content image, and generated it as output image
 func (svc *NeuralTransferService) StyleTransferPreview(content, style string) (string, error) {
-	python, err := exec.LookPath("python")
-	if err != nil {
-		return "", errors.New("No python installed")
-	}
-
-	targetEnv := "content=" + content
-	styleEnv := "styles=" + style
-
 	_, contentName := path.Split(content)
 	_, styleName := path.Split(style)
 
 	outputName := contentName + "_" + styleName + "_" + "preview" + ".png"
 	output := svc.OutputPath + "data/outputs/" + outputName
-	outputEnv := "output=" + output
 
-	wd, _ := os.Getwd()
-	pyfiles := wd + "/neural_style_preview.py"
-	cmd := exec.Command(python, pyfiles)
-	cmd.Env = []string{targetEnv, styleEnv, outputEnv}
-
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-
-	err = cmd.Run()
+	err := svc.Backend.Preview(context.Background(), content, style, output)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Backend.Preview", "content", content, "style", style, "info", err)
+	}
 
 	if _, err := os.Stat(output); os.IsNotExist(err) {
 		return "", errors.New("Style Transfer Preview fails")
@@ -56,9 +56,84 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 		options...,
 	))
 
+	//POST /api/v1/transfer/artist/{name}
+	r.Methods("POST").Path("/api/v1/transfer/artist/{name}").Handler(httptransport.NewServer(
+		auth(MakeNSArtistEndpoint(svc)),
+		decodeNSArtistRequest,
+		encodeNSResponse,
+		options...,
+	))
+
+	//POST /api/v1/transfer/jobs
+	r.Methods("POST").Path("/api/v1/transfer/jobs").Handler(httptransport.NewServer(
+		auth(MakeNSSubmitJobEndpoint(svc)),
+		decodeNSJobRequest,
+		encodeNSResponse,
+		options...,
+	))
+
+	//GET /api/v1/transfer/jobs/{id}
+	r.Methods("GET").Path("/api/v1/transfer/jobs/{id}").Handler(httptransport.NewServer(
+		auth(MakeNSGetJobEndpoint(svc)),
+		decodeNSJobIDRequest,
+		encodeNSResponse,
+		options...,
+	))
+
+	//POST /api/v1/transfer/jobs/{id}/cancel
+	r.Methods("POST").Path("/api/v1/transfer/jobs/{id}/cancel").Handler(httptransport.NewServer(
+		auth(MakeNSCancelJobEndpoint(svc)),
+		decodeNSJobIDRequest,
+		encodeNSResponse,
+		options...,
+	))
+
 	return r
 }
 
+func decodeNSArtistRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+
+	var req NSArtistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if req.Content == "" {
+		return nil, errors.New("content is required")
+	}
+
+	req.Artist = name
+	return req, nil
+}
+
+func decodeNSJobRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req NSJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if req.Content == "" || req.Style == "" {
+		return nil, errors.New("content and style are required")
+	}
+
+	return req, nil
+}
+
+func decodeNSJobIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+
+	return NSJobIDRequest{JobID: id}, nil
+}
+
 func decodeNSPreviewRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	vars := mux.Vars(r)
 
@@ -139,6 +214,11 @@ func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
 	} */
 
 	w.Header().Set("context-type", "application/json,charset=utf8")
+	if sc, ok := err.(httptransport.StatusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"error": err.Error(),
 	})
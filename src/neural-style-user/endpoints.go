@@ -42,6 +42,22 @@ type NSUpdateUserInfoResponse struct {
 	Err    error
 }
 
+// NSRefreshRequest define the refresh token
+type NSRefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// NSLogoutRequest define the access token in the authorization, and the refresh token of the session
+type NSLogoutRequest struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// NSLogoutResponse returns logout result
+type NSLogoutResponse struct {
+	Err error
+}
+
 // MakeNSRegisterEndpoint generate the endpoint for new user register
 func MakeNSRegisterEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
@@ -75,3 +91,21 @@ func MakeNSUpdateUserInfoEndpoint(svc Service) endpoint.Endpoint {
 		return NSUpdateUserInfoResponse{Portrait: result, Err: err}, err
 	}
 }
+
+// MakeNSRefreshEndpoint generate the endpoint for rotating the refresh token
+func MakeNSRefreshEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSRefreshRequest)
+		token, err := svc.Refresh(req.RefreshToken)
+		return NSLoginResponse{Target: token, Err: err}, err
+	}
+}
+
+// MakeNSLogoutEndpoint generate the endpoint for revoking the tokens of the session
+func MakeNSLogoutEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(NSLogoutRequest)
+		err := svc.Logout(req.AccessToken, req.RefreshToken)
+		return NSLogoutResponse{Err: err}, err
+	}
+}
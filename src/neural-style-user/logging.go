@@ -48,3 +48,19 @@ func (svc *loggingService) UpdateUserInfo(userData UserInfo) (newPortrait string
 	return svc.loginService.UpdateUserInfo(userData)
 }
 
+
+func (svc *loggingService) Refresh(refreshToken string) (token UserToken, err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "Refresh", "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.loginService.Refresh(refreshToken)
+}
+
+func (svc *loggingService) Logout(accessToken, refreshToken string) (err error) {
+	defer func(begin time.Time) {
+		svc.logger.Log("method", "Logout", "took", time.Since(begin), "err", err)
+	}(time.Now())
+
+	return svc.loginService.Logout(accessToken, refreshToken)
+}
@@ -0,0 +1,132 @@
+package UserService
+
+import (
+	"sync"
+	"time"
+
+	"neural-style-util"
+)
+
+type memoryRepository struct {
+	mutex sync.RWMutex
+	users map[string]UserInfo
+}
+
+// NewMemoryRepository create the user repository in memory
+func NewMemoryRepository() Repository {
+	return &memoryRepository{users: make(map[string]UserInfo)}
+}
+
+func (repo *memoryRepository) FindByName(name string) (UserInfo, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	user, ok := repo.users[name]
+	if !ok {
+		return user, NSUtil.ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (repo *memoryRepository) Insert(user UserInfo) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.users[user.Name]; ok {
+		return NSUtil.ErrDuplicated
+	}
+
+	repo.users[user.Name] = user
+	return nil
+}
+
+func (repo *memoryRepository) Update(user UserInfo) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.users[user.Name]; !ok {
+		return NSUtil.ErrNotFound
+	}
+
+	repo.users[user.Name] = user
+	return nil
+}
+
+type memoryTokenRepository struct {
+	mutex  sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+// NewMemoryTokenRepository create the refresh token repository in memory
+func NewMemoryTokenRepository() RefreshTokenRepository {
+	return &memoryTokenRepository{tokens: make(map[string]RefreshToken)}
+}
+
+func (repo *memoryTokenRepository) FindToken(hash string) (RefreshToken, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	token, ok := repo.tokens[hash]
+	if !ok {
+		return token, NSUtil.ErrNotFound
+	}
+
+	return token, nil
+}
+
+func (repo *memoryTokenRepository) InsertToken(token RefreshToken) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	// drop the expired tokens
+	now := time.Now()
+	for hash, saved := range repo.tokens {
+		if saved.ExpiresAt.Before(now) {
+			delete(repo.tokens, hash)
+		}
+	}
+
+	if _, ok := repo.tokens[token.Hash]; ok {
+		return NSUtil.ErrDuplicated
+	}
+
+	repo.tokens[token.Hash] = token
+	return nil
+}
+
+func (repo *memoryTokenRepository) UseToken(hash string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	token, ok := repo.tokens[hash]
+	if !ok || token.Used || token.Revoked {
+		return NSUtil.ErrNotFound
+	}
+
+	token.Used = true
+	repo.tokens[hash] = token
+	return nil
+}
+
+func (repo *memoryTokenRepository) RevokeFamily(family string) error {
+	return repo.revoke(func(token RefreshToken) bool { return token.Family == family })
+}
+
+func (repo *memoryTokenRepository) RevokeTokens(user string) error {
+	return repo.revoke(func(token RefreshToken) bool { return token.User == user })
+}
+
+func (repo *memoryTokenRepository) revoke(match func(RefreshToken) bool) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for hash, token := range repo.tokens {
+		if match(token) {
+			token.Revoked = true
+			repo.tokens[hash] = token
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package UserService
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"neural-style-util"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// password character classes of the policy
+const (
+	ClassLetter = "letter"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// bcrypt only uses the first 72 bytes of the password
+const maxPasswordBytes = 72
+
+// PasswordPolicy define the length and the character classes which the new passwords need
+type PasswordPolicy struct {
+	MinLength int
+	Classes   []string
+}
+
+// DefaultPasswordPolicy needs 8 characters with letters and digits
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8, Classes: []string{ClassLetter, ClassDigit}}
+
+// ParseClasses split the classes separated by ',', and check them
+func ParseClasses(classes string) ([]string, error) {
+	var result []string
+	for _, class := range strings.Split(classes, ",") {
+		class = strings.TrimSpace(class)
+		switch class {
+		case "":
+			continue
+		case ClassLetter, ClassUpper, ClassDigit, ClassSymbol:
+			result = append(result, class)
+		default:
+			return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Unknown password class "+class)
+		}
+	}
+
+	return result, nil
+}
+
+// Check return a 400 error if the password doesn't meet the policy
+func (policy PasswordPolicy) Check(password string) error {
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		return NSUtil.NewErrorWithStatus(http.StatusBadRequest,
+			"The password needs at least "+strconv.Itoa(policy.MinLength)+" characters")
+	}
+	if len(password) > maxPasswordBytes {
+		return NSUtil.NewErrorWithStatus(http.StatusBadRequest,
+			"The password is longer than "+strconv.Itoa(maxPasswordBytes)+" bytes")
+	}
+
+	for _, class := range policy.Classes {
+		if strings.IndexFunc(password, classMatcher(class)) < 0 {
+			return NSUtil.NewErrorWithStatus(http.StatusBadRequest,
+				"The password needs at least one "+class+" character")
+		}
+	}
+
+	return nil
+}
+
+func classMatcher(class string) func(rune) bool {
+	switch class {
+	case ClassLetter:
+		return unicode.IsLetter
+	case ClassUpper:
+		return unicode.IsUpper
+	case ClassDigit:
+		return unicode.IsDigit
+	}
+	return func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}
+}
+
+// hashPassword hash the password by bcrypt
+func hashPassword(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	return string(hash), err
+}
+
+// isHashed tell the bcrypt hashes from the plain-text passwords saved before they were hashed
+func isHashed(stored string) bool {
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
+
+// checkPassword compare the password with the saved one in constant time. It also returns whether the saved
+// password is in plain text, which should be hashed after the login.
+func checkPassword(stored, password string) (ok bool, plain bool) {
+	if isHashed(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+
+	// the digests have the same length, so the time doesn't depend on the length of the passwords either
+	storedSum := sha256.Sum256([]byte(stored))
+	passwordSum := sha256.Sum256([]byte(password))
+	return stored != "" && subtle.ConstantTimeCompare(storedSum[:], passwordSum[:]) == 1, true
+}
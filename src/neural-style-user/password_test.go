@@ -0,0 +1,90 @@
+package UserService
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestService() *UserService {
+	svc := NewUserSVC("localhost", "8000", log.NewNopLogger(), NewMemoryRepository(), NewMemoryTokenRepository(),
+		NSUtil.NewMemoryRevocationList())
+	svc.HashCost = bcrypt.MinCost
+	return svc
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, Classes: []string{ClassLetter, ClassUpper, ClassDigit, ClassSymbol}}
+	for _, password := range []string{"Ab1!", "abcdefg1!", "ABCDEFGH!", "Abcdefgh1", strings.Repeat("Ab1!", 20)} {
+		if policy.Check(password) == nil {
+			t.Errorf("password %q should be rejected", password)
+		}
+	}
+	if err := policy.Check("Abcdefg1!"); err != nil {
+		t.Errorf("password should be accepted: %v", err)
+	}
+
+	if _, err := ParseClasses("letter, digit,emoji"); err == nil {
+		t.Error("unknown class should be rejected")
+	}
+}
+
+func TestRegisterHashesPassword(t *testing.T) {
+	svc := newTestService()
+	if _, err := svc.Register(UserInfo{Name: "alice", Password: "short"}); err == nil {
+		t.Fatal("weak password should be rejected")
+	}
+	if _, err := svc.Register(UserInfo{Name: "alice", Password: "secret123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _ := svc.Users.FindByName("alice")
+	if saved.Password == "secret123" || !isHashed(saved.Password) {
+		t.Fatalf("password is saved as %q", saved.Password)
+	}
+
+	if _, err := svc.Login(UserInfo{Name: "alice", Password: "secret124"}); err == nil {
+		t.Fatal("wrong password should be rejected")
+	}
+	if _, err := svc.Login(UserInfo{Name: "alice", Password: "secret123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := svc.GetUserInfo("alice")
+	if err != nil || user.Password != "" {
+		t.Fatalf("password is returned: %q, %v", user.Password, err)
+	}
+
+	data, _ := json.Marshal(saved)
+	if strings.Contains(string(data), "password") {
+		t.Fatalf("password is serialized: %s", data)
+	}
+}
+
+func TestLoginMigratesPlainPassword(t *testing.T) {
+	svc := newTestService()
+	svc.Users.Insert(UserInfo{Name: "bob", Password: "legacy"})
+
+	if _, err := svc.Login(UserInfo{Name: "bob", Password: "wrong"}); err == nil {
+		t.Fatal("wrong password should be rejected")
+	}
+	if saved, _ := svc.Users.FindByName("bob"); saved.Password != "legacy" {
+		t.Fatal("password is migrated after a failed login")
+	}
+
+	if _, err := svc.Login(UserInfo{Name: "bob", Password: "legacy"}); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := svc.Users.FindByName("bob")
+	if !isHashed(saved.Password) {
+		t.Fatalf("password isn't migrated: %q", saved.Password)
+	}
+	if _, err := svc.Login(UserInfo{Name: "bob", Password: "legacy"}); err != nil {
+		t.Fatalf("login after the migration: %v", err)
+	}
+}
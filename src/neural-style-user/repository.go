@@ -0,0 +1,161 @@
+package UserService
+
+import (
+	"time"
+
+	"neural-style-util"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Repository define the data access of the users
+type Repository interface {
+	// FindByName return NSUtil.ErrNotFound if the user doesn't exist
+	FindByName(name string) (UserInfo, error)
+	// Insert return NSUtil.ErrDuplicated if the user name exists
+	Insert(user UserInfo) error
+	// Update replace the user with the same name
+	Update(user UserInfo) error
+}
+
+type mgoRepository struct {
+	session *mgo.Session
+}
+
+// NewMongoRepository create the user repository on the "users" collection
+func NewMongoRepository(session *mgo.Session) Repository {
+	return &mgoRepository{session: session}
+}
+
+func (repo *mgoRepository) FindByName(name string) (UserInfo, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var user UserInfo
+	err := session.DB("store").C("users").Find(bson.M{"name": name}).One(&user)
+	if err == mgo.ErrNotFound {
+		return user, NSUtil.ErrNotFound
+	}
+
+	return user, err
+}
+
+func (repo *mgoRepository) Insert(user UserInfo) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	err := session.DB("store").C("users").Insert(user)
+	if mgo.IsDup(err) {
+		return NSUtil.ErrDuplicated
+	}
+
+	return err
+}
+
+func (repo *mgoRepository) Update(user UserInfo) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	updateData, err := bson.Marshal(&user)
+	if err != nil {
+		return err
+	}
+	mData := bson.M{}
+	err = bson.Unmarshal(updateData, mData)
+	if err != nil {
+		return err
+	}
+
+	err = session.DB("store").C("users").Update(bson.M{"name": user.Name}, bson.M{"$set": mData})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+// RefreshToken is saved by the sha256 of the token, the token itself is only known by the client. The tokens
+// rotated from the same login are in the same Family.
+type RefreshToken struct {
+	Hash      string    `bson:"_id"`
+	Family    string    `bson:"family"`
+	User      string    `bson:"user"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+	Used      bool      `bson:"used"`
+	Revoked   bool      `bson:"revoked"`
+}
+
+// RefreshTokenRepository define the data access of the refresh tokens
+type RefreshTokenRepository interface {
+	// FindToken return NSUtil.ErrNotFound if the token doesn't exist
+	FindToken(hash string) (RefreshToken, error)
+	InsertToken(token RefreshToken) error
+	// UseToken mark the token used, it returns NSUtil.ErrNotFound if the token is already used or revoked
+	UseToken(hash string) error
+	// RevokeFamily revoke all the tokens of the family
+	RevokeFamily(family string) error
+	// RevokeTokens revoke all the tokens of the user
+	RevokeTokens(user string) error
+}
+
+type mgoTokenRepository struct {
+	session *mgo.Session
+}
+
+// NewMongoTokenRepository create the refresh token repository on the "refresh_tokens" collection
+func NewMongoTokenRepository(session *mgo.Session) RefreshTokenRepository {
+	return &mgoTokenRepository{session: session}
+}
+
+func (repo *mgoTokenRepository) FindToken(hash string) (RefreshToken, error) {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	var token RefreshToken
+	err := session.DB("store").C("refresh_tokens").FindId(hash).One(&token)
+	if err == mgo.ErrNotFound {
+		return token, NSUtil.ErrNotFound
+	}
+
+	return token, err
+}
+
+func (repo *mgoTokenRepository) InsertToken(token RefreshToken) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	return session.DB("store").C("refresh_tokens").Insert(token)
+}
+
+func (repo *mgoTokenRepository) UseToken(hash string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	// only one of the concurrent refreshes with the same token matches
+	err := session.DB("store").C("refresh_tokens").Update(bson.M{"_id": hash, "used": false, "revoked": false},
+		bson.M{"$set": bson.M{"used": true}})
+	if err == mgo.ErrNotFound {
+		return NSUtil.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *mgoTokenRepository) RevokeFamily(family string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB("store").C("refresh_tokens").UpdateAll(bson.M{"family": family},
+		bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+func (repo *mgoTokenRepository) RevokeTokens(user string) error {
+	session := repo.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB("store").C("refresh_tokens").UpdateAll(bson.M{"user": user},
+		bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
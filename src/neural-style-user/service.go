@@ -2,6 +2,7 @@ package UserService
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"neural-style-util"
 	"os"
@@ -10,17 +11,10 @@ import (
 	"strings"
 	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/log/level"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/go-kit/kit/log"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
-)
-
-var (
-	// SecretKey define the private key for generate the JWT token
-	SecretKey = os.Getenv("TOKEN_KEY")
 )
 
 // UserInfo define the basic user information
@@ -31,18 +25,21 @@ type UserInfo struct {
 	TelegramID string `json:"telgramid"`
 	Profession uint32 `json:"profession"`
 	Name       string `json:"username"`
-	Password   string `json:"password"`
+	Password   string `json:"password,omitempty"`
 	Phone      string `json:"phone"`
 	Email      string `json:"email"`
 	Portrait   string `json:"headPortraitUrl"`
 }
 
-// UserToken define the authorization information
+// UserToken define the authorization information. The Token expires in ExpiresIn seconds, and the RefreshToken
+// gets a new one by POST /api/v1/token/refresh.
 type UserToken struct {
-	ID       string `json:"id"`
-	Name     string `json:"username"`
-	Token    string `json:"token"`
-	Portrait string `json:"headPortraitUrl"`
+	ID           string `json:"id"`
+	Name         string `json:"username"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	Portrait     string `json:"headPortraitUrl"`
 }
 
 // Service define the basic login interface
@@ -51,100 +48,115 @@ type Service interface {
 	Login(loginData UserInfo) (UserToken, error)
 	GetUserInfo(userName string) (UserInfo, error)
 	UpdateUserInfo(userData UserInfo) (string, error)
+	Refresh(refreshToken string) (UserToken, error)
+	Logout(accessToken, refreshToken string) error
+}
+
+// MarshalJSON never write the password to the clients
+func (user UserInfo) MarshalJSON() ([]byte, error) {
+	type userJSON UserInfo
+	data := userJSON(user)
+	data.Password = ""
+	return json.Marshal(data)
 }
 
-// UserService for user login service
+// UserService for user login service. The new passwords need to meet the Policy, and they are hashed by bcrypt
+// in the HashCost. The access tokens live for AccessTTL, and the refresh tokens for RefreshTTL.
 type UserService struct {
-	Host    string
-	Port    string
-	Session *mgo.Session
-	Logger  log.Logger
+	Host        string
+	Port        string
+	Users       Repository
+	Tokens      RefreshTokenRepository
+	Revocations NSUtil.RevocationList
+	Logger      log.Logger
+	Policy      PasswordPolicy
+	HashCost    int
+	AccessTTL   time.Duration
+	RefreshTTL  time.Duration
 }
 
 // NewUserSVC create a new user service
-func NewUserSVC(host, port string, logger log.Logger, session *mgo.Session) *UserService {
-	return &UserService{Host: host, Port: port, Logger: logger, Session: session}
+func NewUserSVC(host, port string, logger log.Logger, users Repository, tokens RefreshTokenRepository,
+	revocations NSUtil.RevocationList) *UserService {
+	return &UserService{Host: host, Port: port, Logger: logger, Users: users, Tokens: tokens,
+		Revocations: revocations, Policy: DefaultPasswordPolicy, HashCost: bcrypt.DefaultCost,
+		AccessTTL: DefaultAccessTTL, RefreshTTL: DefaultRefreshTTL}
 }
 
 // Register create a new user
 func (svc *UserService) Register(userData UserInfo) (string, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
 	// if the user name exists
-	var currentUser UserInfo
-	c := session.DB("store").C("users")
-	err := c.Find(bson.M{"name": userData.Name}).One(&currentUser)
+	_, err := svc.Users.FindByName(userData.Name)
 	if err == nil {
 		return "", errors.New("User with this name already exists")
 	}
 
+	err = svc.Policy.Check(userData.Password)
+	if err != nil {
+		return "fail", err
+	}
+
+	userData.Password, err = hashPassword(userData.Password, svc.HashCost)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Register", "info", err.Error())
+		return "fail", errors.New("Server is busy. Please try later.")
+	}
+
 	userData.ID = NSUtil.UniqueID()
 	result := "Success"
-	err = c.Insert(userData)
+	err = svc.Users.Insert(userData)
 	if err != nil {
 		result = "fail"
 		return result, errors.New("Server is busy. Please try later.")
 	}
 
-	level.Debug(svc.Logger).Log("API", "Register", "info", userData)
+	level.Debug(svc.Logger).Log("API", "Register", "user", userData.Name)
 	return result, err
 }
 
-// CreateToken create time-limited token
-func CreateToken(userName string, log log.Logger) string {
-	claims := make(jwt.MapClaims)
-	claims["username"] = userName
-	claims["exp"] = time.Now().Add(time.Hour * 72).Unix() //72小时有效期，过期需要重新登录获取token
-	claims["iat"] = time.Now().Unix()
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(SecretKey))
-	if err != nil {
-		level.Error(log).Log("API", "CeateToken", "info", "Failed to sign with token", "err", err.Error())
-		return ""
-	}
-
-	return tokenString
-}
-
 // Login login the style transfer platform
 func (svc *UserService) Login(loginData UserInfo) (UserToken, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("users")
-
-	var user UserInfo
-	err := c.Find(bson.M{"name": loginData.Name}).One(&user)
+	user, err := svc.Users.FindByName(loginData.Name)
 	if err != nil {
 		return UserToken{}, errors.New("This user name is wrong")
 	}
 
-	if user.Password != loginData.Password {
+	ok, plain := checkPassword(user.Password, loginData.Password)
+	if !ok {
 		return UserToken{}, errors.New("This password is wrong")
 	}
 
-	var userToken UserToken
-	userToken.Name = user.Name
-	userToken.ID = user.ID
-	userToken.Token = CreateToken(loginData.Name, svc.Logger)
-	userToken.Portrait = user.Portrait
-	return userToken, err
+	// hash the plain-text password saved before the passwords were hashed
+	if plain {
+		svc.migratePassword(user, loginData.Password)
+	}
+
+	// every login starts a new family of refresh tokens
+	return svc.issueTokens(user, NSUtil.UniqueID())
 }
 
-func (svc *UserService) GetUserInfo(userName string) (UserInfo, error) {
-	session := svc.Session.Copy()
-	defer session.Close()
+// migratePassword save the hash of the plain-text password, the login goes on if it fails
+func (svc *UserService) migratePassword(user UserInfo, password string) {
+	hash, err := hashPassword(password, svc.HashCost)
+	if err == nil {
+		user.Password = hash
+		err = svc.Users.Update(user)
+	}
 
-	c := session.DB("store").C("users")
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Login", "user", user.Name, "info", "Failed to hash the password",
+			"err", err.Error())
+	}
+}
 
-	var user UserInfo
-	err := c.Find(bson.M{"name": userName}).One(&user)
+// GetUserInfo return the user without the password
+func (svc *UserService) GetUserInfo(userName string) (UserInfo, error) {
+	user, err := svc.Users.FindByName(userName)
 	if err != nil {
 		return user, errors.New("can't get info for the user " + userName)
 	}
 
+	user.Password = ""
 	return user, nil
 }
 
@@ -159,32 +171,37 @@ func (svc *UserService) UpdateUserInfo(userData UserInfo) (string, error) {
 		userData.Portrait = newImageURL
 	}
 
-	if userData.Password == "" {
-		user, err := svc.GetUserInfo(userData.Name)
+	passwordChanged := userData.Password != ""
+	if !passwordChanged {
+		user, err := svc.Users.FindByName(userData.Name)
 		if err != nil {
 			return "", errors.New("Server is busy. Please try it later.")
 		}
 		userData.Password = user.Password
-	}
-
-	session := svc.Session.Copy()
-	defer session.Close()
-
-	c := session.DB("store").C("users")
+	} else {
+		err := svc.Policy.Check(userData.Password)
+		if err != nil {
+			return "", err
+		}
 
-	updateData, err := bson.Marshal(&userData)
-	if err != nil {
-		return "", errors.New("Server is busy. Please try it later.")
+		userData.Password, err = hashPassword(userData.Password, svc.HashCost)
+		if err != nil {
+			level.Error(svc.Logger).Log("API", "UpdateUserInfo", "info", err.Error())
+			return "", errors.New("Server is busy. Please try it later.")
+		}
 	}
-	mData := bson.M{}
-	err = bson.Unmarshal(updateData, mData)
+
+	err := svc.Users.Update(userData)
 	if err != nil {
 		return "", errors.New("Server is busy. Please try it later.")
 	}
 
-	err = c.Update(bson.M{"name": userData.Name}, bson.M{"$set": mData})
-	if err != nil {
-		return "", errors.New("Server is busy. Please try it later.")
+	// the sessions with the old password are logged out
+	if passwordChanged {
+		err = svc.revokeUser(userData.Name)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	return userData.Portrait, nil
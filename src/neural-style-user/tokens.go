@@ -0,0 +1,175 @@
+package UserService
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"neural-style-util"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// default lifetime of the tokens
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+var (
+	errInvalidRefreshToken = NSUtil.NewErrorWithStatus(http.StatusUnauthorized, "Invalid refresh token")
+	errReusedRefreshToken  = NSUtil.NewErrorWithStatus(http.StatusUnauthorized, "Refresh token is reused")
+	errServerBusy          = NSUtil.NewErrorWithStatus(http.StatusServiceUnavailable, "Server is busy. Please try later.")
+)
+
+// CreateToken create time-limited access token. The "jti" claim is used to revoke it, and the "iat" claim has
+// a fraction of seconds, so the tokens issued right after a password change aren't revoked with the old ones.
+func CreateToken(userName string, ttl time.Duration, log log.Logger) string {
+	now := time.Now()
+	claims := make(jwt.MapClaims)
+	claims["username"] = userName
+	claims["jti"] = NSUtil.UniqueID()
+	claims["exp"] = now.Add(ttl).Unix()
+	claims["iat"] = float64(now.UnixNano()) / 1e9
+
+	tokenString, err := NSUtil.SignToken(claims)
+	if err != nil {
+		level.Error(log).Log("API", "CeateToken", "info", "Failed to sign with token", "err", err.Error())
+		return ""
+	}
+
+	return tokenString
+}
+
+// issueTokens create the access token and a refresh token in the family
+func (svc *UserService) issueTokens(user UserInfo, family string) (UserToken, error) {
+	accessToken := CreateToken(user.Name, svc.AccessTTL, svc.Logger)
+	refreshToken, err := newRefreshToken()
+	if accessToken == "" || err != nil {
+		return UserToken{}, errServerBusy
+	}
+
+	err = svc.Tokens.InsertToken(RefreshToken{Hash: hashToken(refreshToken), Family: family, User: user.Name,
+		ExpiresAt: time.Now().Add(svc.RefreshTTL)})
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "InsertToken", "user", user.Name, "info", err.Error())
+		return UserToken{}, errServerBusy
+	}
+
+	return UserToken{ID: user.ID, Name: user.Name, Token: accessToken, RefreshToken: refreshToken,
+		ExpiresIn: int64(svc.AccessTTL / time.Second), Portrait: user.Portrait}, nil
+}
+
+// Refresh rotate the refresh token, and return a new access token. A refresh token is used only once, and if
+// a used token comes again, it's regarded as stolen and all the tokens of its family are revoked.
+func (svc *UserService) Refresh(refreshToken string) (UserToken, error) {
+	token, err := svc.Tokens.FindToken(hashToken(refreshToken))
+	if err == NSUtil.ErrNotFound {
+		return UserToken{}, errInvalidRefreshToken
+	}
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Refresh", "info", err.Error())
+		return UserToken{}, errServerBusy
+	}
+
+	if token.Used {
+		return UserToken{}, svc.revokeReused(token)
+	}
+	if token.Revoked || time.Now().After(token.ExpiresAt) {
+		return UserToken{}, errInvalidRefreshToken
+	}
+
+	err = svc.Tokens.UseToken(token.Hash)
+	if err == NSUtil.ErrNotFound {
+		// used by a concurrent refresh
+		return UserToken{}, svc.revokeReused(token)
+	}
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Refresh", "info", err.Error())
+		return UserToken{}, errServerBusy
+	}
+
+	user, err := svc.Users.FindByName(token.User)
+	if err != nil {
+		return UserToken{}, errInvalidRefreshToken
+	}
+
+	return svc.issueTokens(user, token.Family)
+}
+
+func (svc *UserService) revokeReused(token RefreshToken) error {
+	level.Error(svc.Logger).Log("API", "Refresh", "user", token.User, "family", token.Family,
+		"info", "Refresh token is reused, the family is revoked")
+	err := svc.Tokens.RevokeFamily(token.Family)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "RevokeFamily", "info", err.Error())
+	}
+
+	return errReusedRefreshToken
+}
+
+// Logout revoke the access token until it expires, and the family of the refresh token
+func (svc *UserService) Logout(accessToken, refreshToken string) error {
+	claims, err := NSUtil.ParseAccessToken(accessToken, svc.Logger)
+	if err != nil {
+		return NSUtil.NewErrorWithStatus(http.StatusUnauthorized, err.Error())
+	}
+
+	err = svc.Revocations.Revoke(claims.ID, claims.ExpiresAt)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Logout", "user", claims.User, "info", err.Error())
+		return errServerBusy
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	token, err := svc.Tokens.FindToken(hashToken(refreshToken))
+	if err != nil || token.User != claims.User {
+		return errInvalidRefreshToken
+	}
+
+	err = svc.Tokens.RevokeFamily(token.Family)
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "Logout", "user", claims.User, "info", err.Error())
+		return errServerBusy
+	}
+
+	return nil
+}
+
+// revokeUser revoke all the access and refresh tokens of the user issued until now
+func (svc *UserService) revokeUser(user string) error {
+	err := svc.Revocations.RevokeUser(user, time.Now())
+	if err == nil {
+		err = svc.Tokens.RevokeTokens(user)
+	}
+
+	if err != nil {
+		level.Error(svc.Logger).Log("API", "revokeUser", "user", user, "info", err.Error())
+		return errServerBusy
+	}
+
+	return nil
+}
+
+func newRefreshToken() (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
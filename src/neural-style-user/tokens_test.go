@@ -0,0 +1,85 @@
+package UserService
+
+import (
+	"context"
+	"testing"
+
+	"neural-style-util"
+
+	"github.com/go-kit/kit/log"
+)
+
+func authorized(svc *UserService, token string) error {
+	auth := NSUtil.AuthMiddleware(log.NewNopLogger(), svc.Revocations)
+	next := func(ctx context.Context, request interface{}) (interface{}, error) { return nil, nil }
+	ctx := context.WithValue(context.Background(), NSUtil.AuthToken, "Bearer "+token)
+	_, err := auth(next)(ctx, nil)
+	return err
+}
+
+func loggedIn(t *testing.T, svc *UserService) UserToken {
+	if _, err := svc.Register(UserInfo{Name: "carol", Password: "secret123"}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := svc.Login(UserInfo{Name: "carol", Password: "secret123"})
+	if err != nil || token.RefreshToken == "" || authorized(svc, token.Token) != nil {
+		t.Fatalf("unexpected login %+v, %v", token, err)
+	}
+	return token
+}
+
+func TestRefreshRotatesAndDetectsReuse(t *testing.T) {
+	svc := newTestService()
+	first := loggedIn(t, svc)
+
+	second, err := svc.Refresh(first.RefreshToken)
+	if err != nil || second.RefreshToken == first.RefreshToken || authorized(svc, second.Token) != nil {
+		t.Fatalf("unexpected refresh %+v, %v", second, err)
+	}
+
+	// the stolen first token is replayed, the whole family is revoked
+	if _, err := svc.Refresh(first.RefreshToken); err != errReusedRefreshToken {
+		t.Fatalf("expected the reuse error, got %v", err)
+	}
+	if _, err := svc.Refresh(second.RefreshToken); err == nil {
+		t.Fatal("the family should be revoked")
+	}
+
+	if _, err := svc.Refresh("unknown"); err != errInvalidRefreshToken {
+		t.Fatalf("expected the invalid error, got %v", err)
+	}
+}
+
+func TestLogoutAndPasswordChangeRevokeTokens(t *testing.T) {
+	svc := newTestService()
+	session := loggedIn(t, svc)
+	other, _ := svc.Login(UserInfo{Name: "carol", Password: "secret123"})
+
+	if err := svc.Logout("Bearer "+session.Token, session.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if authorized(svc, session.Token) == nil {
+		t.Fatal("the access token should be revoked by the logout")
+	}
+	if _, err := svc.Refresh(session.RefreshToken); err == nil {
+		t.Fatal("the refresh token should be revoked by the logout")
+	}
+	if authorized(svc, other.Token) != nil {
+		t.Fatal("the other session should be kept")
+	}
+
+	if _, err := svc.UpdateUserInfo(UserInfo{Name: "carol", Password: "newSecret456", Portrait: "http://p"}); err != nil {
+		t.Fatal(err)
+	}
+	if authorized(svc, other.Token) == nil {
+		t.Fatal("the access token should be revoked by the password change")
+	}
+	if _, err := svc.Refresh(other.RefreshToken); err == nil {
+		t.Fatal("the refresh token should be revoked by the password change")
+	}
+
+	fresh, err := svc.Login(UserInfo{Name: "carol", Password: "newSecret456"})
+	if err != nil || authorized(svc, fresh.Token) != nil {
+		t.Fatalf("the new login should be authorized: %v", err)
+	}
+}
@@ -79,6 +79,35 @@ func encodeNSUpdateUserInfoResponse(ctx context.Context, w http.ResponseWriter,
 	return json.NewEncoder(w).Encode(updateRes.Portrait)
 }
 
+func decodeNSRefreshRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req NSRefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		return nil, NSUtil.NewErrorWithStatus(http.StatusBadRequest, "Missing refresh token")
+	}
+
+	return req, nil
+}
+
+func decodeNSLogoutRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var body NSRefreshRequest
+	json.NewDecoder(r.Body).Decode(&body)
+
+	// the access token is parsed from the authorization header by NSUtil.ParseToken
+	accessToken, _ := ctx.Value(NSUtil.AuthToken).(string)
+	return NSLogoutRequest{AccessToken: accessToken, RefreshToken: body.RefreshToken}, nil
+}
+
+func encodeNSLogoutResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	logoutRes := response.(NSLogoutResponse)
+	if logoutRes.Err != nil {
+		return logoutRes.Err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 // MakeHTTPHandler generate the http handler for the style service handler
 func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middleware, svc Service, options ...httptransport.ServerOption) *mux.Router {
 	// Register
@@ -99,6 +128,22 @@ func MakeHTTPHandler(ctx context.Context, r *mux.Router, auth endpoint.Middlewar
 	)
 	r.Methods("POST").Path("/api/v1/authenticate").Handler(NSUtil.AccessControl(loginHandler))
 
+	// POST /api/v1/token/refresh
+	r.Methods("POST").Path("/api/v1/token/refresh").Handler(NSUtil.AccessControl(httptransport.NewServer(
+		MakeNSRefreshEndpoint(svc),
+		decodeNSRefreshRequest,
+		encodeNSLoginResponse,
+		options...,
+	)))
+
+	// POST /api/v1/logout
+	r.Methods("POST").Path("/api/v1/logout").Handler(NSUtil.AccessControl(httptransport.NewServer(
+		auth(MakeNSLogoutEndpoint(svc)),
+		decodeNSLogoutRequest,
+		encodeNSLogoutResponse,
+		options...,
+	)))
+
 	// GET /api/v1/users/{username}
 	r.Methods("GET").Path("/api/v1/users/{username}").Handler(httptransport.NewServer(
 		auth(MakeNSGetUserInfoEndpoint(svc)),
@@ -3,9 +3,11 @@ package NSUtil
 import (
 	"context"
 	"errors"
+	"math"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/endpoint"
@@ -13,12 +15,18 @@ import (
 	"github.com/go-kit/kit/log/level"
 )
 
-// SecretKey used encode
+// SecretKey is the HS256 key of the tokens if the Keys aren't set
 var (
 	SecretKey = os.Getenv("TOKEN_KEY")
 
+	// AdminUsers are the users separated by ';' who can call the admin APIs
+	AdminUsers = os.Getenv("ADMIN_USERS")
+
 	AuthToken = "Token"
 
+	// AuthUser is the context key of the user of the checked token
+	AuthUser = "AuthUser"
+
 	// ErrTokenContextMissing denotes a token was not passed into the parsing
 	// middleware's context.
 	ErrTokenContextMissing = errors.New("token up for parsing was not passed through the context")
@@ -36,36 +44,133 @@ var (
 	// future.
 	ErrTokenNotActive = errors.New("token is not valid yet")
 
+	// ErrTokenRevoked denotes the token is revoked by logout or the password change.
+	ErrTokenRevoked = errors.New("JWT Token is revoked")
+
 	// ErrUnexpectedSigningMethod denotes a token was signed with an unexpected
 	// signing method.
 	ErrUnexpectedSigningMethod = errors.New("unexpected signing method")
 )
 
-// CheckToken validate the token
-func CheckToken(authString string, log log.Logger) (string, error) {
+// AccessClaims are the claims of an access token. ID is the "jti" claim, which is used to revoke the token.
+type AccessClaims struct {
+	User      string
+	ID        string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// ParseAccessToken validate the "Bearer" authorization, and return the claims of the token
+func ParseAccessToken(authString string, log log.Logger) (AccessClaims, error) {
 	authList := strings.Split(authString, " ")
 	if len(authList) != 2 || authList[0] != "Bearer" {
 		level.Error(log).Log("API", "CheckToken", "info", "No authorization info")
-		return "", errors.New("Unkown authorization info")
+		return AccessClaims{}, errors.New("Unkown authorization info")
 	}
 
 	tokenString := authList[1]
-	token, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
-		return []byte(SecretKey), nil
-	})
+	token, err := parseToken(tokenString)
 	if err != nil {
 		level.Error(log).Log("API", "CheckToken", "info", "Token parse error", "err", err.Error())
-		return "", errors.New("Bad Token")
+		return AccessClaims{}, err
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		level.Error(log).Log("API", "CheckToken", "info", "Can't access claims", "err", err.Error())
-		return "", errors.New("No access claims")
+	user, userOk := claims["username"].(string)
+	if !ok || !userOk {
+		level.Error(log).Log("API", "CheckToken", "info", "Can't access claims")
+		return AccessClaims{}, errors.New("No access claims")
+	}
+
+	accessClaims := AccessClaims{User: user}
+	accessClaims.ID, _ = claims["jti"].(string)
+	accessClaims.IssuedAt = claimTime(claims["iat"])
+	accessClaims.ExpiresAt = claimTime(claims["exp"])
+	return accessClaims, nil
+}
+
+// parseToken only accept RS256 and ES256 if the Keys are set, otherwise only HS256
+func parseToken(tokenString string) (*jwt.Token, error) {
+	if Keys != nil {
+		parser := jwt.Parser{ValidMethods: []string{RS256, ES256}}
+		return parser.Parse(tokenString, Keys.keyFunc)
+	}
+
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}}
+	return parser.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
+		return []byte(SecretKey), nil
+	})
+}
+
+// SignToken sign the claims by the Keys if they are set, otherwise by HS256 with SecretKey
+func SignToken(claims jwt.MapClaims) (string, error) {
+	if Keys != nil {
+		return Keys.SignToken(claims)
 	}
-	user := claims["username"].(string)
 
-	return user, nil
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SecretKey))
+}
+
+// claimTime convert the NumericDate claim, which has a fraction of seconds in the new tokens
+func claimTime(value interface{}) time.Time {
+	seconds, _ := value.(float64)
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9))
+}
+
+// CheckToken validate the token
+func CheckToken(authString string, log log.Logger) (string, error) {
+	claims, err := ParseAccessToken(authString, log)
+	if err != nil {
+		if _, ok := err.(*jwt.ValidationError); ok {
+			return "", errors.New("Bad Token")
+		}
+		return "", err
+	}
+
+	return claims.User, nil
+}
+
+// checkAccess validate the token and check it against the revocation list
+func checkAccess(ctx context.Context, log log.Logger, revocations RevocationList) (AccessClaims, error) {
+	tokenString, ok := ctx.Value(AuthToken).(string)
+	if !ok || len(tokenString) == 0 {
+		return AccessClaims{}, NewErrorWithStatus(http.StatusNonAuthoritativeInfo, "Missing Authorization token")
+	}
+
+	claims, err := ParseAccessToken(tokenString, log)
+	if err != nil {
+		if e, ok := err.(*jwt.ValidationError); ok {
+			switch {
+			case e.Errors&jwt.ValidationErrorMalformed != 0:
+				err = ErrTokenMalformed
+			case e.Errors&jwt.ValidationErrorExpired != 0:
+				// Token is expired, the client refreshes it
+				err = ErrTokenExpired
+			case e.Errors&jwt.ValidationErrorNotValidYet != 0:
+				// Token is not active yet
+				err = ErrTokenNotActive
+			case e.Inner != nil:
+				// report e.Inner
+				err = e.Inner
+			}
+		}
+
+		return AccessClaims{}, NewErrorWithStatus(http.StatusUnauthorized, err.Error())
+	}
+
+	if revocations != nil {
+		revoked, err := revocations.IsRevoked(claims.ID, claims.User, claims.IssuedAt)
+		if err != nil {
+			level.Error(log).Log("API", "IsRevoked", "user", claims.User, "info", err.Error())
+			return AccessClaims{}, NewErrorWithStatus(http.StatusServiceUnavailable, "Server is busy. Please try later.")
+		}
+		if revoked {
+			return AccessClaims{}, NewErrorWithStatus(http.StatusUnauthorized, ErrTokenRevoked.Error())
+		}
+	}
+
+	return claims, nil
 }
 
 // GetUsername get parse user name
@@ -99,37 +204,67 @@ func ParseToken(ctx context.Context, req *http.Request) context.Context {
 	return context.WithValue(ctx, AuthToken, authString)
 }
 
-// AuthMiddleware support authorization
-func AuthMiddleware(log log.Logger) endpoint.Middleware {
+// ContextUser return the user of the token checked by the middlewares, or "" if there is none
+func ContextUser(ctx context.Context) string {
+	user, _ := ctx.Value(AuthUser).(string)
+	return user
+}
+
+// AuthMiddleware support authorization, the revoked tokens are rejected if revocations isn't nil
+func AuthMiddleware(log log.Logger, revocations RevocationList) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-			tokenString, ok := ctx.Value(AuthToken).(string)
-			if !ok || len(tokenString) == 0 {
-				return nil, NewErrorWithStatus(http.StatusNonAuthoritativeInfo, "Missing Authorization token")
+			claims, err := checkAccess(ctx, log, revocations)
+			if err != nil {
+				return nil, err
 			}
 
-			err = GetUsername(tokenString, log)
+			return next(context.WithValue(ctx, AuthUser, claims.User), request)
+		}
+	}
+}
+
+// ViewerMiddleware pass the user of a valid token like AuthMiddleware, but the requests without a valid token
+// go on anonymously
+func ViewerMiddleware(log log.Logger, revocations RevocationList) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			claims, err := checkAccess(ctx, log, revocations)
 			if err != nil {
-				if e, ok := err.(*jwt.ValidationError); ok {
-					switch {
-					case e.Errors&jwt.ValidationErrorMalformed != 0:
-						err = ErrTokenMalformed
-					case e.Errors&jwt.ValidationErrorExpired != 0:
-						// Token is expired
-						err = ErrTokenMalformed
-					case e.Errors&jwt.ValidationErrorNotValidYet != 0:
-						// Token is not active yet
-						err = ErrTokenNotActive
-					case e.Inner != nil:
-						// report e.Inner
-						err = e.Inner
-					}
-				}
-
-				return nil, NewErrorWithStatus(http.StatusUnauthorized, err.Error())
+				return next(ctx, request)
 			}
 
-			return next(ctx, request)
+			return next(context.WithValue(ctx, AuthUser, claims.User), request)
+		}
+	}
+}
+
+// IsAdmin check whether the user is in AdminUsers
+func IsAdmin(user string) bool {
+	for _, admin := range strings.Split(AdminUsers, ";") {
+		if admin != "" && admin == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AdminMiddleware only allow the users in AdminUsers
+func AdminMiddleware(log log.Logger, revocations RevocationList) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			claims, err := checkAccess(ctx, log, revocations)
+			if err != nil {
+				return nil, err
+			}
+
+			if !IsAdmin(claims.User) {
+				level.Error(log).Log("API", "AdminMiddleware", "user", claims.User, "info", "not an admin")
+				return nil, NewErrorWithStatus(http.StatusForbidden, "Admin permission is required")
+			}
+
+			return next(context.WithValue(ctx, AuthUser, claims.User), request)
 		}
 	}
 }
@@ -138,7 +273,7 @@ func AuthMiddleware(log log.Logger) endpoint.Middleware {
 func AccessControl(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
 
 		h.ServeHTTP(w, r)
 	})
@@ -0,0 +1,38 @@
+package NSUtil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+)
+
+func TestViewerMiddlewarePassesTheValidUser(t *testing.T) {
+	revocations := NewMemoryRevocationList()
+	viewer := ViewerMiddleware(log.NewNopLogger(), revocations)(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return ContextUser(ctx), nil
+	})
+
+	sign := func(id string) string {
+		token, err := SignToken(jwt.MapClaims{"username": "alice", "jti": id,
+			"exp": time.Now().Add(time.Minute).Unix(), "iat": float64(time.Now().Unix())})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+	valid, revoked := sign("valid"), sign("revoked")
+	revocations.Revoke("revoked", time.Now().Add(time.Minute))
+
+	for _, test := range []struct {
+		auth string
+		user string
+	}{{valid, "alice"}, {revoked, ""}, {"Bearer bad", ""}, {"", ""}} {
+		user, err := viewer(context.WithValue(context.Background(), AuthToken, test.auth), nil)
+		if err != nil || user != test.user {
+			t.Errorf("%q: user %v, %v", test.auth, user, err)
+		}
+	}
+}
@@ -28,6 +28,7 @@ const (
     ReturnConfirmed         // seller receives returned product
     ReturnCompleted         // return is completed
     Failed                  // transaction fails
+    Stopped                 // selling is stopped by the seller or expired
 )
 
 type TransactionUpdateData struct {
@@ -0,0 +1,476 @@
+package NSUtil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// signing algorithms of the key sets
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// Keys sign and verify the tokens if it isn't nil, otherwise the tokens are signed by HS256 with SecretKey
+var Keys *KeySet
+
+// the unknown key ids fetch the JWKS again, but not more often than jwksRefetch
+const jwksRefetch = time.Minute
+
+var (
+	// ErrUnknownKey denotes the token is signed by a key which isn't in the key set.
+	ErrUnknownKey = errors.New("unknown signing key")
+
+	// ErrNoSigningKey denotes the key set has only public keys.
+	ErrNoSigningKey = errors.New("no signing key")
+)
+
+type verifyKey struct {
+	alg     string
+	public  crypto.PublicKey
+	created time.Time
+}
+
+// KeySet keep the RS256 and ES256 keys by their ids. The keys of a directory are the "{kid}.pem" files, and the
+// private key with the greatest kid signs the new tokens, so the kids sort by their creation time. The other keys
+// only verify the tokens signed before the rotation. A remote key set only has the public keys of a JWKS url.
+type KeySet struct {
+	mutex      sync.RWMutex
+	dir        string
+	jwksURL    string
+	fetched    time.Time
+	signingKID string
+	signingKey crypto.Signer
+	keys       map[string]verifyKey
+}
+
+// OpenKeyDir load the keys of the directory, a new key of the alg is generated if there is no private key
+func OpenKeyDir(dir, alg string) (*KeySet, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &KeySet{dir: dir}
+	err = set.Reload()
+	if err == ErrNoSigningKey {
+		_, err = set.Rotate(alg, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// NewRemoteKeySet verify the tokens by the public keys of the JWKS url, which are fetched at the first use
+func NewRemoteKeySet(jwksURL string) *KeySet {
+	return &KeySet{jwksURL: jwksURL, keys: make(map[string]verifyKey)}
+}
+
+// Reload read the keys of the directory again, e.g. after another server rotates them
+func (set *KeySet) Reload() error {
+	files, err := filepath.Glob(filepath.Join(set.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	keys := make(map[string]verifyKey)
+	var signingKID string
+	var signingKey crypto.Signer
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		private, public, err := readKeyFile(file)
+		if err != nil {
+			return errors.New("Bad key " + file + ": " + err.Error())
+		}
+
+		alg, err := keyAlg(public)
+		if err != nil {
+			return errors.New("Bad key " + file + ": " + err.Error())
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		keys[kid] = verifyKey{alg: alg, public: public, created: info.ModTime()}
+		if private != nil {
+			signingKID, signingKey = kid, private
+		}
+	}
+
+	if signingKey == nil {
+		return ErrNoSigningKey
+	}
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	set.keys, set.signingKID, set.signingKey = keys, signingKID, signingKey
+	return nil
+}
+
+// Rotate generate a new signing key of the alg. The retired keys are removed after they are retired for longer
+// than retain, which should be longer than the lifetime of the tokens. It returns the kid of the new key.
+func (set *KeySet) Rotate(alg string, retain time.Duration) (string, error) {
+	kid, err := generateKeyFile(set.dir, alg)
+	if err != nil {
+		return "", err
+	}
+
+	err = set.Reload()
+	if err != nil {
+		return "", err
+	}
+
+	if retain > 0 {
+		set.prune(retain)
+	}
+	return kid, nil
+}
+
+// prune remove the keys retired for longer than retain, a key is retired when the next key is created
+func (set *KeySet) prune(retain time.Duration) {
+	set.mutex.RLock()
+	kids := make([]string, 0, len(set.keys))
+	for kid := range set.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	var expired []string
+	for i := 0; i+1 < len(kids); i++ {
+		if time.Since(set.keys[kids[i+1]].created) > retain {
+			expired = append(expired, kids[i])
+		}
+	}
+	set.mutex.RUnlock()
+
+	for _, kid := range expired {
+		os.Remove(filepath.Join(set.dir, kid+".pem"))
+	}
+	if len(expired) > 0 {
+		set.Reload()
+	}
+}
+
+// StartRotation check the keys every minute until ctx is done. The keys are reloaded, and a new signing key is
+// generated when the current one is older than interval.
+func (set *KeySet) StartRotation(ctx context.Context, alg string, interval, retain time.Duration, logger log.Logger) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := set.Reload()
+			if err != nil {
+				level.Error(logger).Log("API", "RotateKeys", "info", err.Error())
+				continue
+			}
+
+			set.mutex.RLock()
+			age := time.Since(set.keys[set.signingKID].created)
+			set.mutex.RUnlock()
+			if age < interval {
+				set.prune(retain)
+				continue
+			}
+
+			kid, err := set.Rotate(alg, retain)
+			if err != nil {
+				level.Error(logger).Log("API", "RotateKeys", "info", err.Error())
+				continue
+			}
+			level.Info(logger).Log("API", "RotateKeys", "kid", kid)
+		}
+	}()
+}
+
+// SignToken sign the claims by the signing key, and put its id in the "kid" header
+func (set *KeySet) SignToken(claims jwt.MapClaims) (string, error) {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	if set.signingKey == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(set.keys[set.signingKID].alg), claims)
+	token.Header["kid"] = set.signingKID
+	return token.SignedString(set.signingKey)
+}
+
+// keyFunc find the public key of the "kid" header. The token should be signed in the alg of the key, so a
+// public key is never used as a HMAC secret.
+func (set *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := set.find(kid)
+	if !ok && set.jwksURL != "" && set.refetch() == nil {
+		key, ok = set.find(kid)
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.alg {
+		return nil, ErrUnexpectedSigningMethod
+	}
+	return key.public, nil
+}
+
+func (set *KeySet) find(kid string) (verifyKey, bool) {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	key, ok := set.keys[kid]
+	return key, ok
+}
+
+// refetch fetch the JWKS again if the last fetch is earlier than jwksRefetch
+func (set *KeySet) refetch() error {
+	set.mutex.Lock()
+	if time.Since(set.fetched) < jwksRefetch {
+		set.mutex.Unlock()
+		return nil
+	}
+	set.fetched = time.Now()
+	set.mutex.Unlock()
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(set.jwksURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("JWKS returns " + resp.Status)
+	}
+
+	var keySet JSONWebKeySet
+	err = json.NewDecoder(resp.Body).Decode(&keySet)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]verifyKey)
+	for _, jwk := range keySet.Keys {
+		public, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = verifyKey{alg: jwk.Alg, public: public}
+	}
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	set.keys = keys
+	return nil
+}
+
+// JSONWebKey is a public key of RFC 7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the document of /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS return the public keys of the key set
+func (set *KeySet) JWKS() JSONWebKeySet {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	keySet := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for kid, key := range set.keys {
+		jwk := JSONWebKey{Kid: kid, Use: "sig", Alg: key.alg}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeInt(public.N.Bytes())
+			jwk.E = encodeInt(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.Kty, jwk.Crv = "EC", "P-256"
+			jwk.X = encodeInt(public.X.FillBytes(make([]byte, 32)))
+			jwk.Y = encodeInt(public.Y.FillBytes(make([]byte, 32)))
+		}
+		keySet.Keys = append(keySet.Keys, jwk)
+	}
+
+	sort.Slice(keySet.Keys, func(i, j int) bool { return keySet.Keys[i].Kid < keySet.Keys[j].Kid })
+	return keySet
+}
+
+// JWKSHandler serve the public keys for /.well-known/jwks.json
+func JWKSHandler(set *KeySet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(set.JWKS())
+	})
+}
+
+func (jwk JSONWebKey) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case jwk.Kty == "RSA" && jwk.Alg == RS256:
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case jwk.Kty == "EC" && jwk.Crv == "P-256" && jwk.Alg == ES256:
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("the point isn't on P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+
+	return nil, errors.New("unsupported key " + jwk.Kty + " " + jwk.Alg)
+}
+
+func encodeInt(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// keyAlg return RS256 for the RSA keys and ES256 for the P-256 keys
+func keyAlg(public crypto.PublicKey) (string, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return RS256, nil
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return ES256, nil
+		}
+	}
+	return "", errors.New("only RSA and P-256 keys are supported")
+}
+
+// readKeyFile read a private key, or a public key which only verifies the tokens
+func readKeyFile(file string) (crypto.Signer, crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM block")
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = errors.New("unsupported PEM block " + block.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if signer, ok := key.(crypto.Signer); ok {
+		return signer, signer.Public(), nil
+	}
+	return nil, key, nil
+}
+
+// generateKeyFile write a new private key, whose kid is the UTC time
+func generateKeyFile(dir, alg string) (string, error) {
+	var block *pem.Block
+	switch alg {
+	case RS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case ES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		data, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: data}
+	default:
+		return "", errors.New("The key algorithm should be RS256 or ES256")
+	}
+
+	kid := time.Now().UTC().Format("20060102T150405.000Z")
+	path := filepath.Join(dir, kid+".pem")
+	temp := path + ".tmp"
+	err := ioutil.WriteFile(temp, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	return kid, os.Rename(temp, path)
+}
@@ -0,0 +1,114 @@
+package NSUtil
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+)
+
+func signedBy(t *testing.T, keys *KeySet, user string) string {
+	token, err := keys.SignToken(jwt.MapClaims{"username": user, "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func verify(keys *KeySet, token string) (string, error) {
+	Keys = keys
+	defer func() { Keys = nil }()
+
+	claims, err := ParseAccessToken("Bearer "+token, log.NewNopLogger())
+	return claims.User, err
+}
+
+func TestKeyRotationAndJWKS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys, err := OpenKeyDir(dir, ES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := signedBy(t, keys, "alice")
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := keys.Rotate(RS256, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	fresh := signedBy(t, keys, "bob")
+
+	// the retired key still verifies the old tokens, also by the JWKS of another service
+	server := httptest.NewServer(JWKSHandler(keys))
+	defer server.Close()
+	remote := NewRemoteKeySet(server.URL)
+	for _, set := range []*KeySet{keys, remote} {
+		if user, err := verify(set, old); err != nil || user != "alice" {
+			t.Fatalf("old token: %v, %v", user, err)
+		}
+		if user, err := verify(set, fresh); err != nil || user != "bob" {
+			t.Fatalf("fresh token: %v, %v", user, err)
+		}
+	}
+	if jwks := keys.JWKS(); len(jwks.Keys) != 2 || jwks.Keys[0].Alg != ES256 || jwks.Keys[1].Alg != RS256 {
+		t.Fatalf("unexpected JWKS %+v", jwks)
+	}
+
+	// the retired key is removed after the retention
+	time.Sleep(10 * time.Millisecond)
+	if _, err := keys.Rotate(ES256, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verify(keys, old); err == nil {
+		t.Fatal("the removed key shouldn't verify the tokens")
+	}
+}
+
+func TestTokensNeedTheAlgOfTheKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys, err := OpenKeyDir(dir, RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a HS256 token which uses the public key as the secret
+	public, _ := x509.MarshalPKIXPublicKey(keys.keys[keys.signingKID].public)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"username": "mallory"})
+	forged.Header["kid"] = keys.signingKID
+	forgedString, _ := forged.SignedString(public)
+	if _, err := verify(keys, forgedString); err == nil {
+		t.Fatal("HS256 token should be rejected")
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"username": "mallory"})
+	unsignedString, _ := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err := verify(keys, unsignedString); err == nil {
+		t.Fatal("unsigned token should be rejected")
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"username": "mallory"})
+	unknown.Header["kid"] = "missing"
+	if _, err := verify(keys, signedBy(t, keys, "alice")); err != nil {
+		t.Fatal(err)
+	}
+	keys.mutex.Lock()
+	unknownString, _ := unknown.SignedString(keys.signingKey)
+	keys.mutex.Unlock()
+	if _, err := verify(keys, unknownString); err == nil {
+		t.Fatal("unknown kid should be rejected")
+	}
+}
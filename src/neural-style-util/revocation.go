@@ -0,0 +1,145 @@
+package NSUtil
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// RevocationList keep the revoked access tokens until they expire, and the time before which all the tokens of
+// a user are revoked, e.g. after the password is changed
+type RevocationList interface {
+	// Revoke revoke the token with the id until it expires
+	Revoke(tokenID string, expires time.Time) error
+	// RevokeUser revoke all the tokens of the user issued before the time
+	RevokeUser(user string, before time.Time) error
+	// IsRevoked check the token id, and the user of the token issued at the time
+	IsRevoked(tokenID, user string, issuedAt time.Time) (bool, error)
+}
+
+type revokedToken struct {
+	ID      string    `bson:"_id"`
+	Expires time.Time `bson:"expires"`
+}
+
+type revokedUser struct {
+	User   string    `bson:"_id"`
+	Before time.Time `bson:"before"`
+}
+
+type mgoRevocationList struct {
+	session *mgo.Session
+}
+
+// NewMongoRevocationList keep the revoked tokens in the "revoked_tokens" collection, and the revoked users in
+// the "revoked_users" collection. The expired tokens are removed by the TTL index on "expires".
+func NewMongoRevocationList(session *mgo.Session) RevocationList {
+	return &mgoRevocationList{session: session}
+}
+
+func (list *mgoRevocationList) Revoke(tokenID string, expires time.Time) error {
+	session := list.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB("store").C("revoked_tokens").UpsertId(tokenID, revokedToken{ID: tokenID, Expires: expires})
+	return err
+}
+
+func (list *mgoRevocationList) RevokeUser(user string, before time.Time) error {
+	session := list.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB("store").C("revoked_users").UpsertId(user, revokedUser{User: user, Before: before})
+	return err
+}
+
+func (list *mgoRevocationList) IsRevoked(tokenID, user string, issuedAt time.Time) (bool, error) {
+	session := list.session.Copy()
+	defer session.Close()
+
+	count, err := session.DB("store").C("revoked_tokens").FindId(tokenID).Count()
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	var revoked revokedUser
+	err = session.DB("store").C("revoked_users").FindId(user).One(&revoked)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return issuedAt.Before(revoked.Before), nil
+}
+
+// ErrReadOnlyRevocations the revocation list only checks the tokens
+var ErrReadOnlyRevocations = errors.New("the revocation list is read only")
+
+type readOnlyRevocationList struct {
+	RevocationList
+}
+
+// NewReadOnlyRevocationList share the revoked tokens of the data server with the other services, they check the
+// tokens but never revoke them
+func NewReadOnlyRevocationList(session *mgo.Session) RevocationList {
+	return readOnlyRevocationList{NewMongoRevocationList(session)}
+}
+
+func (list readOnlyRevocationList) Revoke(tokenID string, expires time.Time) error {
+	return ErrReadOnlyRevocations
+}
+
+func (list readOnlyRevocationList) RevokeUser(user string, before time.Time) error {
+	return ErrReadOnlyRevocations
+}
+
+type memoryRevocationList struct {
+	mutex  sync.RWMutex
+	tokens map[string]time.Time
+	users  map[string]time.Time
+}
+
+// NewMemoryRevocationList keep the revoked tokens in memory
+func NewMemoryRevocationList() RevocationList {
+	return &memoryRevocationList{tokens: make(map[string]time.Time), users: make(map[string]time.Time)}
+}
+
+func (list *memoryRevocationList) Revoke(tokenID string, expires time.Time) error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	// drop the expired tokens, they fail the validation anyway
+	now := time.Now()
+	for id, expiry := range list.tokens {
+		if expiry.Before(now) {
+			delete(list.tokens, id)
+		}
+	}
+
+	list.tokens[tokenID] = expires
+	return nil
+}
+
+func (list *memoryRevocationList) RevokeUser(user string, before time.Time) error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	list.users[user] = before
+	return nil
+}
+
+func (list *memoryRevocationList) IsRevoked(tokenID, user string, issuedAt time.Time) (bool, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+
+	if _, ok := list.tokens[tokenID]; ok {
+		return true, nil
+	}
+
+	before, ok := list.users[user]
+	return ok && issuedAt.Before(before), nil
+}
@@ -0,0 +1,95 @@
+package NSUtil
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// store type
+const (
+	MongoStore  = "mongo"
+	MemoryStore = "memory"
+)
+
+var (
+	// ErrNotFound denotes no data matches the query in the repository
+	ErrNotFound = errors.New("data is not found")
+
+	// ErrDuplicated denotes the data breaks a unique key of the repository
+	ErrDuplicated = errors.New("data is duplicated")
+)
+
+// MatchFields check the document against the equality query in the same way as MongoDB does.
+// The keys are the lower case field names, and "a.b" matches the nested field. An array field
+// matches if any element equals to the query value.
+func MatchFields(doc interface{}, query map[string]interface{}) bool {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return false
+	}
+
+	fields := bson.M{}
+	err = bson.Unmarshal(data, fields)
+	if err != nil {
+		return false
+	}
+
+	for key, expected := range query {
+		if !matchField(fields, strings.Split(key, "."), expected) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchField(fields bson.M, path []string, expected interface{}) bool {
+	value, ok := fields[path[0]]
+	if !ok {
+		return expected == nil
+	}
+
+	if len(path) > 1 {
+		nested, ok := value.(bson.M)
+		return ok && matchField(nested, path[1:], expected)
+	}
+
+	if values, ok := value.([]interface{}); ok {
+		for _, item := range values {
+			if equalValue(item, expected) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return equalValue(value, expected)
+}
+
+func equalValue(value, expected interface{}) bool {
+	left, leftOk := toFloat(value)
+	right, rightOk := toFloat(expected)
+	if leftOk && rightOk {
+		return left == right
+	}
+
+	return reflect.DeepEqual(value, expected)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	"neural-style-image-watermark"
+)
+
+var (
+	minConfidence = flag.Float64("confidence", 0.2, "min confidence to report a payload")
+)
+
+// check the suspicious pictures for the invisible watermark, and print the product and the buyer
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-confidence 0.2] picture...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	found := false
+	for _, path := range flag.Args() {
+		payload, confidence, err := detect(path)
+		switch {
+		case err != nil:
+			fmt.Printf("%s\terror: %v\n", path, err)
+		case confidence < *minConfidence:
+			fmt.Printf("%s\tno watermark\n", path)
+		default:
+			found = true
+			fmt.Printf("%s\tproduct=%s\tbuyer=%s\tconfidence=%.2f\n", path, payload.ProductID, payload.Buyer,
+				confidence)
+		}
+	}
+
+	if !found {
+		os.Exit(1)
+	}
+}
+
+func detect(path string) (WaterMark.Payload, float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return WaterMark.Payload{}, 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return WaterMark.Payload{}, 0, err
+	}
+
+	payload, confidence := WaterMark.Detect(img)
+	return payload, confidence, nil
+}